@@ -0,0 +1,376 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	version "github.com/hashicorp/go-version"
+	"gopkg.in/yaml.v2"
+)
+
+// lockFile records the chart versions resolved from the version constraints used in the desired state.
+// The apps of desired states defining clusters are keyed by their cluster and their name, see lockKey.
+type lockFile struct {
+	Apps map[string]lockedChart `yaml:"apps"`
+}
+
+// lockedChart represents the chart version resolved for an app
+type lockedChart struct {
+	Chart      string `yaml:"chart"`
+	Constraint string `yaml:"constraint"`
+	Version    string `yaml:"version"`
+}
+
+// lockUpdate represents the changes to the lock file of a run: the apps whose versions are resolved again
+// with --update-lock are dropped, then the newly resolved versions are added
+type lockUpdate struct {
+	dropped  []string
+	resolved map[string]lockedChart
+}
+
+const lockFileHeader = "# This file is generated by Helmsman. It records the chart versions resolved from version constraints.\n" +
+	"# Use --update-lock to resolve the constraints again.\n"
+
+var (
+	exactVersion      = regexp.MustCompile(`^v?\d+\.\d+\.\d+(-[0-9A-Za-z.-]+)?(\+[0-9A-Za-z.-]+)?$`)
+	hyphenRange       = regexp.MustCompile(`^\s*(\S+)\s+-\s+(\S+)\s*$`)
+	constraintOperand = regexp.MustCompile(`^(=|!=|>|<|>=|<=|~>|~|\^)$`)
+	wildcards         = map[string]bool{"x": true, "X": true, "*": true}
+)
+
+// readLockFile reads the lock file if it exists. An empty lock is returned otherwise.
+func readLockFile(file string) (*lockFile, error) {
+	lock := &lockFile{Apps: map[string]lockedChart{}}
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return lock, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, lock); err != nil {
+		return nil, fmt.Errorf("invalid lock file [ %s ]: %w", file, err)
+	}
+	if lock.Apps == nil {
+		lock.Apps = map[string]lockedChart{}
+	}
+	return lock, nil
+}
+
+// write saves the lock file
+func (l *lockFile) write(file string) error {
+	d, err := yaml.Marshal(l)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, append([]byte(lockFileHeader), d...), 0644)
+}
+
+// lockKey returns the key of an app in the lock file, which is prefixed with the cluster of the state if it has one
+// as the apps of each cluster can have their own version constraints
+func lockKey(s *state, app string) string {
+	if s.cluster == "" {
+		return app
+	}
+	return s.cluster + "/" + app
+}
+
+// isExactVersion checks if a version from the desired state is a full semantic version rather than a constraint
+func isExactVersion(v string) bool {
+	return exactVersion.MatchString(strings.TrimSpace(v))
+}
+
+// resolveChartVersions replaces the version constraints (e.g. ^1.2, ~1.2.3 or >=2 <3) of the apps to run
+// with the latest chart versions matching them.
+// Versions recorded in the lock file are reused as long as the app's chart and constraint have not changed,
+// unless --update-lock is used, which only resolves again the versions of the apps to run.
+// Newly resolved versions are only written back to the lock file by writeLockFile, once the plan is applied.
+func resolveChartVersions(s *state) error {
+	s.lockFileMutex.Lock()
+	defer s.lockFileMutex.Unlock()
+
+	lock, err := readLockFile(s.opts.LockFile)
+	if err != nil {
+		return err
+	}
+
+	var (
+		fail   bool
+		update = &lockUpdate{resolved: map[string]lockedChart{}}
+		mutex  = &sync.Mutex{}
+		wg     = sync.WaitGroup{}
+		sem    = make(chan struct{}, resourcePool)
+	)
+	s.lockUpdate = update
+	if s.opts.UpdateLock {
+		// the versions of the apps which are not targeted, or of the other clusters, stay locked
+		resolved := map[string]bool{}
+		for app, r := range s.Apps {
+			if r.isConsideredToRun(s) {
+				resolved[lockKey(s, app)] = true
+			}
+		}
+		apps := make(map[string]lockedChart, len(lock.Apps))
+		for key, locked := range lock.Apps {
+			if resolved[key] {
+				update.dropped = append(update.dropped, key)
+				continue
+			}
+			apps[key] = locked
+		}
+		lock.Apps = apps
+	}
+	for app, r := range s.Apps {
+		if !r.isConsideredToRun(s) || isExactVersion(r.Version) {
+			continue
+		}
+		if locked, ok := lock.Apps[lockKey(s, app)]; ok && locked.Chart == r.Chart && locked.Constraint == r.Version {
+			s.log.Verbose("Using locked version [ " + locked.Version + " ] of chart [ " + r.Chart + " ] for app [ " + app + " ]")
+			r.Version = locked.Version
+			continue
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(r *release, app string) {
+			defer func() {
+				wg.Done()
+				<-sem
+			}()
+			constraint := r.Version
+			resolved, msg := r.getChartVersion(s.context())
+			mutex.Lock()
+			defer mutex.Unlock()
+			if msg != "" {
+				fail = true
+				s.log.Error(msg)
+				return
+			}
+			s.log.Info("Chart [ " + r.Chart + " ] version constraint [ " + constraint + " ] of app [ " + app + " ] resolved to [ " + resolved + " ]")
+			r.Version = resolved
+			update.resolved[lockKey(s, app)] = lockedChart{Chart: r.Chart, Constraint: constraint, Version: resolved}
+		}(r, app)
+	}
+	wg.Wait()
+
+	if fail {
+		return errors.New("chart version resolution failed")
+	}
+	if update.changed() && (!s.opts.Apply || s.opts.DryRun) {
+		s.log.Info("The resolved chart versions are only written to the lock file [ " + s.opts.LockFile + " ] when the plan is applied")
+	}
+	return nil
+}
+
+// changed checks if the lock file is changed by the update
+func (u *lockUpdate) changed() bool {
+	return u != nil && (len(u.dropped) > 0 || len(u.resolved) > 0)
+}
+
+// writeLockFile writes the changes to the lock file made by resolving the chart versions of the desired state.
+// It is only called once the plan was applied successfully, and does nothing for dry runs and destroys.
+// The lock file is read again as the plans of the other clusters may have been applied in the meantime.
+func (s *state) writeLockFile() error {
+	if !s.lockUpdate.changed() || s.opts.DryRun || s.opts.Destroy {
+		return nil
+	}
+	s.lockFileMutex.Lock()
+	defer s.lockFileMutex.Unlock()
+
+	lock, err := readLockFile(s.opts.LockFile)
+	if err != nil {
+		return err
+	}
+	apps := make(map[string]lockedChart, len(lock.Apps))
+	for key, locked := range lock.Apps {
+		if !stringInSlice(key, s.lockUpdate.dropped) {
+			apps[key] = locked
+		}
+	}
+	for key, locked := range s.lockUpdate.resolved {
+		apps[key] = locked
+	}
+	lock.Apps = apps
+
+	s.log.Info("Writing resolved chart versions to lock file [ " + s.opts.LockFile + " ]")
+	if err := lock.write(s.opts.LockFile); err != nil {
+		return fmt.Errorf("failed to write lock file [ %s ]: %w", s.opts.LockFile, err)
+	}
+	return nil
+}
+
+// searchChartVersions returns all the versions of a chart found in the helm repositories
+func searchChartVersions(ctx context.Context, chart string) ([]string, error) {
+	cmd := helmCmd([]string{"search", "repo", chart, "-l", "-o", "json"}, "Listing versions of chart [ "+chart+" ]")
+
+	result := cmd.exec(ctx)
+	if result.code != 0 {
+		return nil, errors.New(result.errors)
+	}
+
+	chartVersions := make([]chartVersion, 0)
+	if err := json.Unmarshal([]byte(result.output), &chartVersions); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal Helm CLI output: %w", err)
+	}
+
+	var versions []string
+	for _, c := range chartVersions {
+		if c.Name == chart {
+			versions = append(versions, c.Version)
+		}
+	}
+	return versions, nil
+}
+
+// getLocalChartVersion returns the version of a local chart
+func getLocalChartVersion(ctx context.Context, chart string) (string, error) {
+	cmd := helmCmd([]string{"show", "chart", chart}, "Getting version of local chart [ "+chart+" ]")
+
+	result := cmd.exec(ctx)
+	if result.code != 0 {
+		return "", errors.New(strings.TrimSpace(result.errors))
+	}
+	matches := versionExtractor.FindStringSubmatch(result.output)
+	if len(matches) != 2 {
+		return "", errors.New("no version found in Chart.yaml")
+	}
+	return strings.Trim(matches[1], `'"`), nil
+}
+
+// latestMatchingVersion returns the highest of the given versions satisfying a version constraint.
+// An empty string is returned if none of them does.
+func latestMatchingVersion(versions []string, constraint string) (string, error) {
+	constraints, err := parseVersionConstraint(constraint)
+	if err != nil {
+		return "", err
+	}
+
+	var latest *version.Version
+	var latestRaw string
+	for _, raw := range versions {
+		v, err := version.NewVersion(raw)
+		if err != nil {
+			continue
+		}
+		if latest != nil && !v.GreaterThan(latest) {
+			continue
+		}
+		for _, c := range constraints {
+			if c.Check(v) {
+				latest, latestRaw = v, raw
+				break
+			}
+		}
+	}
+	return latestRaw, nil
+}
+
+// parseVersionConstraint translates a semver constraint as used by helm into go-version constraints.
+// The returned constraints are alternatives (||), each of them is a set of conditions that all have to match.
+// Supported forms: exact versions, comparisons (>=2 <3 or >=2, <3), caret (^1.2), tilde (~1.2.3),
+// wildcards (1.2.x, 1.*, *), partial versions (1.2 is the same as 1.2.x) and hyphen ranges (1.2 - 1.4.5).
+func parseVersionConstraint(constraint string) ([]version.Constraints, error) {
+	var result []version.Constraints
+	for _, alternative := range strings.Split(constraint, "||") {
+		var conditions []string
+		if m := hyphenRange.FindStringSubmatch(alternative); m != nil {
+			conditions = []string{">=" + m[1], "<=" + m[2]}
+		} else {
+			fields := strings.Fields(strings.Replace(alternative, ",", " ", -1))
+			for i := 0; i < len(fields); i++ {
+				term := fields[i]
+				// join operators separated from their version by spaces, e.g. ">= 2"
+				if constraintOperand.MatchString(term) && i+1 < len(fields) {
+					i++
+					term += fields[i]
+				}
+				translated, err := translateConstraintTerm(term)
+				if err != nil {
+					return nil, fmt.Errorf("invalid version constraint [ %s ]: %w", constraint, err)
+				}
+				conditions = append(conditions, translated...)
+			}
+		}
+		if len(conditions) == 0 {
+			conditions = []string{">=0.0.0"}
+		}
+		c, err := version.NewConstraint(strings.Join(conditions, ", "))
+		if err != nil {
+			return nil, fmt.Errorf("invalid version constraint [ %s ]: %w", constraint, err)
+		}
+		result = append(result, c)
+	}
+	return result, nil
+}
+
+// translateConstraintTerm translates a single constraint term into go-version conditions
+func translateConstraintTerm(term string) ([]string, error) {
+	switch {
+	case strings.HasPrefix(term, "^"):
+		segments, count, err := versionSegments(term[1:])
+		if err != nil {
+			return nil, err
+		}
+		upper := fmt.Sprintf("<%d.0.0", segments[0]+1)
+		if segments[0] == 0 && count > 1 {
+			if segments[1] > 0 || count == 2 {
+				upper = fmt.Sprintf("<0.%d.0", segments[1]+1)
+			} else {
+				upper = fmt.Sprintf("<0.0.%d", segments[2]+1)
+			}
+		}
+		return []string{">=" + term[1:], upper}, nil
+	case strings.HasPrefix(term, "~") && !strings.HasPrefix(term, "~>"):
+		segments, count, err := versionSegments(term[1:])
+		if err != nil {
+			return nil, err
+		}
+		if count == 1 {
+			return []string{">=" + term[1:], fmt.Sprintf("<%d.0.0", segments[0]+1)}, nil
+		}
+		return []string{">=" + term[1:], fmt.Sprintf("<%d.%d.0", segments[0], segments[1]+1)}, nil
+	case strings.IndexAny(term[:1], "=!<>~") == 0:
+		return []string{term}, nil
+	}
+
+	// a bare version, possibly partial or with wildcards
+	var fixed []int
+	for _, part := range strings.Split(strings.TrimPrefix(term, "v"), ".") {
+		if wildcards[part] {
+			break
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			// not a plain number, e.g. a pre-release version. Let go-version deal with it.
+			return []string{"=" + term}, nil
+		}
+		fixed = append(fixed, n)
+	}
+	switch len(fixed) {
+	case 0:
+		return []string{">=0.0.0"}, nil
+	case 1:
+		return []string{fmt.Sprintf(">=%d.0.0", fixed[0]), fmt.Sprintf("<%d.0.0", fixed[0]+1)}, nil
+	case 2:
+		return []string{fmt.Sprintf(">=%d.%d.0", fixed[0], fixed[1]), fmt.Sprintf("<%d.%d.0", fixed[0], fixed[1]+1)}, nil
+	default:
+		return []string{"=" + term}, nil
+	}
+}
+
+// versionSegments parses a (possibly partial) version and returns its major, minor and patch segments
+// as well as how many of them were specified
+func versionSegments(v string) ([]int, int, error) {
+	ver, err := version.NewVersion(v)
+	if err != nil {
+		return nil, 0, err
+	}
+	core := strings.SplitN(strings.SplitN(strings.TrimPrefix(v, "v"), "-", 2)[0], "+", 2)[0]
+	return ver.Segments(), len(strings.Split(core, ".")), nil
+}
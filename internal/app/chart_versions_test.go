@@ -0,0 +1,209 @@
+package app
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func Test_isExactVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		want    bool
+	}{
+		{version: "1.2.3", want: true},
+		{version: "v0.5.2", want: true},
+		{version: "1.3.0-1", want: true},
+		{version: "1.3.0+meta.info", want: true},
+		{version: "1.2", want: false},
+		{version: "~1.2", want: false},
+		{version: ">=2 <3", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			if got := isExactVersion(tt.version); got != tt.want {
+				t.Errorf("isExactVersion() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_latestMatchingVersion(t *testing.T) {
+	versions := []string{"0.1.0", "0.1.5", "0.2.0", "1.1.0", "1.2.0", "1.2.7", "1.3.1", "1.4.0-rc.1", "2.0.0", "2.5.1", "3.0.0"}
+	tests := []struct {
+		constraint string
+		want       string
+	}{
+		{constraint: "1.2.0", want: "1.2.0"},
+		{constraint: "^1.2", want: "1.3.1"},
+		{constraint: "^0.1.2", want: "0.1.5"},
+		{constraint: "~1.2.3", want: "1.2.7"},
+		{constraint: "~1", want: "1.3.1"},
+		{constraint: ">=2 <3", want: "2.5.1"},
+		{constraint: ">= 2, < 3", want: "2.5.1"},
+		{constraint: "1.x", want: "1.3.1"},
+		{constraint: "1.2", want: "1.2.7"},
+		{constraint: "*", want: "3.0.0"},
+		{constraint: "1.1 - 1.2.5", want: "1.2.0"},
+		{constraint: "^0.2 || ~1.1", want: "1.1.0"},
+		{constraint: "^4", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.constraint, func(t *testing.T) {
+			got, err := latestMatchingVersion(versions, tt.constraint)
+			if err != nil {
+				t.Fatalf("latestMatchingVersion() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("latestMatchingVersion() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseVersionConstraint_invalid(t *testing.T) {
+	if _, err := parseVersionConstraint("^abc"); err == nil {
+		t.Errorf("parseVersionConstraint() = nil, want error")
+	}
+}
+
+func Test_lockFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "helmsman.lock")
+
+	lock, err := readLockFile(file)
+	if err != nil || len(lock.Apps) != 0 {
+		t.Fatalf("readLockFile() = %v, %v, want an empty lock", lock, err)
+	}
+
+	lock.Apps["jenkins"] = lockedChart{Chart: "stable/jenkins", Constraint: "~1.2", Version: "1.2.7"}
+	if err := lock.write(file); err != nil {
+		t.Fatalf("write() error = %v", err)
+	}
+	got, err := readLockFile(file)
+	if err != nil {
+		t.Fatalf("readLockFile() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, lock) {
+		t.Errorf("readLockFile() = %v, want %v", got, lock)
+	}
+}
+
+func Test_resolveChartVersions_lock(t *testing.T) {
+	// the charts of the helm repos, listed by helm search repo <chart> -l -o json
+	fakeTool(t, "helm", `case "$3" in
+  repo/web) echo '[{"name":"repo/web","version":"1.0.0"},{"name":"repo/web","version":"1.2.0"},{"name":"repo/web","version":"2.0.0"}]';;
+  *) echo '[]';;
+esac`)
+	locked := map[string]lockedChart{
+		"prod/web":    {Chart: "repo/web", Constraint: "^1", Version: "1.0.0"},
+		"prod/api":    {Chart: "repo/api", Constraint: "^2", Version: "2.0.0"},
+		"staging/web": {Chart: "repo/web", Constraint: "^1", Version: "1.1.0"},
+	}
+	// written by the plan of another cluster applied in the meantime
+	otherCluster := lockedChart{Chart: "repo/web", Constraint: "^2", Version: "2.0.0"}
+	tests := []struct {
+		name string
+		opts Options
+		want map[string]lockedChart
+	}{
+		{
+			name: "update the targeted apps of the cluster",
+			opts: Options{Apply: true, UpdateLock: true},
+			want: map[string]lockedChart{
+				"prod/web":    {Chart: "repo/web", Constraint: "^1", Version: "1.2.0"},
+				"prod/api":    locked["prod/api"],
+				"staging/web": locked["staging/web"],
+				"eu/web":      otherCluster,
+			},
+		}, {
+			name: "dry run",
+			opts: Options{Apply: true, DryRun: true, UpdateLock: true},
+			want: map[string]lockedChart{
+				"prod/web":    locked["prod/web"],
+				"prod/api":    locked["prod/api"],
+				"staging/web": locked["staging/web"],
+				"eu/web":      otherCluster,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "helmsman.lock")
+			if err := (&lockFile{Apps: locked}).write(file); err != nil {
+				t.Fatal(err)
+			}
+			tt.opts.LockFile = file
+			s := &state{
+				cluster: "prod",
+				opts:    tt.opts,
+				Apps: map[string]*release{
+					"web": {Name: "web", Chart: "repo/web", Version: "^1"},
+					"api": {Name: "api", Chart: "repo/api", Version: "^2"},
+				},
+				TargetMap:     map[string]bool{"web": true},
+				lockFileMutex: &sync.Mutex{},
+			}
+			if err := resolveChartVersions(s); err != nil {
+				t.Fatalf("resolveChartVersions() error = %v", err)
+			}
+			if s.Apps["web"].Version != "1.2.0" {
+				t.Errorf("resolveChartVersions() resolved the targeted app to %s, want 1.2.0", s.Apps["web"].Version)
+			}
+			if s.Apps["api"].Version != "^2" {
+				t.Errorf("resolveChartVersions() resolved the untargeted app to %s", s.Apps["api"].Version)
+			}
+			if got, _ := readLockFile(file); !reflect.DeepEqual(got.Apps, locked) {
+				t.Fatalf("resolveChartVersions() wrote the lock file before the plan was applied: %v", got.Apps)
+			}
+
+			apps := map[string]lockedChart{"eu/web": otherCluster}
+			for key, l := range locked {
+				apps[key] = l
+			}
+			if err := (&lockFile{Apps: apps}).write(file); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.writeLockFile(); err != nil {
+				t.Fatalf("writeLockFile() error = %v", err)
+			}
+			got, err := readLockFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got.Apps, tt.want) {
+				t.Errorf("lock file = %v, want %v", got.Apps, tt.want)
+			}
+		})
+	}
+}
+
+func Test_resolveChartVersions_OCIConstraint(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "helmsman.lock")
+	s := &state{
+		opts: Options{Apply: true, LockFile: file},
+		Apps: map[string]*release{
+			"web": {Name: "web", Chart: "oci://registry.example.com/web", Version: "^1"},
+		},
+		lockFileMutex: &sync.Mutex{},
+	}
+	if err := resolveChartVersions(s); err == nil {
+		t.Fatal("resolveChartVersions() error = nil, want the OCI version constraint to fail")
+	}
+	if s.Apps["web"].Version != "^1" || s.lockUpdate.changed() {
+		t.Errorf("resolveChartVersions() locked the unresolved version: %v", s.lockUpdate)
+	}
+	if err := s.writeLockFile(); err != nil {
+		t.Fatalf("writeLockFile() error = %v", err)
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("writeLockFile() wrote %s, want no lock file", file)
+	}
+}
@@ -1,13 +1,14 @@
 package app
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
 	"strings"
 
 	version "github.com/hashicorp/go-version"
-	"github.com/imdario/mergo"
 	"github.com/joho/godotenv"
 )
 
@@ -34,81 +35,99 @@ func (i *stringArray) Set(value string) error {
 	return nil
 }
 
+// cli holds the command line flags. Most of them are the options of the run, the other ones
+// only change how the command line tool behaves.
 type cli struct {
-	debug                 bool
-	files                 stringArray
-	envFiles              stringArray
-	target                stringArray
-	group                 stringArray
-	kubeconfig            string
-	apply                 bool
-	destroy               bool
-	dryRun                bool
-	verbose               bool
-	noBanner              bool
-	noColors              bool
-	noFancy               bool
-	noNs                  bool
-	nsOverride            string
-	contextOverride       string
-	skipValidation        bool
-	keepUntrackedReleases bool
-	showDiff              bool
-	diffContext           int
-	noEnvSubst            bool
-	substEnvValues        bool
-	noSSMSubst            bool
-	substSSMValues        bool
-	updateDeps            bool
-	forceUpgrades         bool
-	version               bool
-	noCleanup             bool
-	migrateContext        bool
+	Options
+	envFiles   stringArray
+	kubeconfig string
+	noBanner   bool
+	noFancy    bool
+	version    bool
+	noCleanup  bool
+	outdated   bool
+	output     string
+	// secretsAction and secretsFile are the action of the secrets command and the file it applies to
+	secretsAction string
+	secretsFile   string
+	// log is the logger of the command line tool, which its engine shares
+	log *Logger
 }
 
-func printUsage() {
-	fmt.Print(banner)
-	fmt.Printf("Helmsman version: " + appVersion + "\n")
-	fmt.Printf("Helmsman is a Helm Charts as Code tool which allows you to automate the deployment/management of your Helm charts.")
-	fmt.Printf("")
-	fmt.Printf("Usage: helmsman [options]\n")
-	flag.PrintDefaults()
+func printUsage(fs *flag.FlagSet) func() {
+	return func() {
+		fmt.Print(banner)
+		fmt.Printf("Helmsman version: " + appVersion + "\n")
+		fmt.Printf("Helmsman is a Helm Charts as Code tool which allows you to automate the deployment/management of your Helm charts.")
+		fmt.Printf("")
+		fmt.Printf("Usage: helmsman [options]\n")
+		fmt.Printf("       helmsman outdated [options]    report newer chart versions available for the apps\n")
+		fmt.Printf("       helmsman secrets edit|encrypt|decrypt|rotate [options] <file>    manage a secrets file with the secrets backend of the desired state\n")
+		fmt.Printf("       helmsman secrets check [options]    check that the secrets files of the apps are encrypted\n")
+		fs.PrintDefaults()
+	}
 }
 
-// Cli parses cmd flags, validates them and performs some initializations
-func (c *cli) parse() {
+// parse parses the command line arguments, validates them and performs some initializations
+func (c *cli) parse(args []string) {
+	defaults := DefaultOptions()
+	fs := flag.NewFlagSet("helmsman", flag.ExitOnError)
+
 	//parsing command line flags
-	flag.Var(&c.files, "f", "desired state file name(s), may be supplied more than once to merge state files")
-	flag.Var(&c.envFiles, "e", "file(s) to load environment variables from (default .env), may be supplied more than once")
-	flag.Var(&c.target, "target", "limit execution to specific app.")
-	flag.Var(&c.group, "group", "limit execution to specific group of apps.")
-	flag.IntVar(&c.diffContext, "diff-context", -1, "number of lines of context to show around changes in helm diff output")
-	flag.StringVar(&c.kubeconfig, "kubeconfig", "", "path to the kubeconfig file to use for CLI requests")
-	flag.StringVar(&c.nsOverride, "ns-override", "", "override defined namespaces with this one")
-	flag.StringVar(&c.contextOverride, "context-override", "", "override releases context defined in release state with this one")
-	flag.BoolVar(&c.apply, "apply", false, "apply the plan directly")
-	flag.BoolVar(&c.dryRun, "dry-run", false, "apply the dry-run option for helm commands.")
-	flag.BoolVar(&c.destroy, "destroy", false, "delete all deployed releases.")
-	flag.BoolVar(&c.version, "v", false, "show the version")
-	flag.BoolVar(&c.debug, "debug", false, "show the debug execution logs and actual helm/kubectl commands. This can log secrets and should only be used for debugging purposes.")
-	flag.BoolVar(&c.verbose, "verbose", false, "show verbose execution logs.")
-	flag.BoolVar(&c.noBanner, "no-banner", false, "don't show the banner")
-	flag.BoolVar(&c.noColors, "no-color", false, "don't use colors")
-	flag.BoolVar(&c.noFancy, "no-fancy", false, "don't display the banner and don't use colors")
-	flag.BoolVar(&c.noNs, "no-ns", false, "don't create namespaces")
-	flag.BoolVar(&c.skipValidation, "skip-validation", false, "skip desired state validation")
-	flag.BoolVar(&c.keepUntrackedReleases, "keep-untracked-releases", false, "keep releases that are managed by Helmsman from the used DSFs in the command, and are no longer tracked in your desired state.")
-	flag.BoolVar(&c.showDiff, "show-diff", false, "show helm diff results. Can expose sensitive information.")
-	flag.BoolVar(&c.noEnvSubst, "no-env-subst", false, "turn off environment substitution globally")
-	flag.BoolVar(&c.substEnvValues, "subst-env-values", false, "turn on environment substitution in values files.")
-	flag.BoolVar(&c.noSSMSubst, "no-ssm-subst", false, "turn off SSM parameter substitution globally")
-	flag.BoolVar(&c.substSSMValues, "subst-ssm-values", false, "turn on SSM parameter substitution in values files.")
-	flag.BoolVar(&c.updateDeps, "update-deps", false, "run 'helm dep up' for local chart")
-	flag.BoolVar(&c.forceUpgrades, "force-upgrades", false, "use --force when upgrading helm releases. May cause resources to be recreated.")
-	flag.BoolVar(&c.noCleanup, "no-cleanup", false, "keeps any credentials files that has been downloaded on the host where helmsman runs.")
-	flag.BoolVar(&c.migrateContext, "migrate-context", false, "Updates the context name for all apps defined in the DSF and applies Helmsman labels. Using this flag is required if you want to change context name after it has been set.")
-	flag.Usage = printUsage
-	flag.Parse()
+	fs.Var((*stringArray)(&c.Files), "f", "desired state file name(s), may be supplied more than once to merge state files")
+	fs.Var(&c.envFiles, "e", "file(s) to load environment variables from (default .env), may be supplied more than once")
+	fs.Var((*stringArray)(&c.Targets), "target", "limit execution to specific app.")
+	fs.Var((*stringArray)(&c.Groups), "group", "limit execution to specific group of apps.")
+	fs.IntVar(&c.DiffContext, "diff-context", defaults.DiffContext, "number of lines of context to show around changes in helm diff output")
+	fs.StringVar(&c.DiffReport, "diff-report", "", "write the diff of each release and a summary of the changes to this directory. Can expose sensitive information.")
+	fs.StringVar(&c.DiffReportFormat, "diff-report-format", defaults.DiffReportFormat, "format of the summary of the diff report: markdown or html")
+	fs.StringVar(&c.kubeconfig, "kubeconfig", "", "path to the kubeconfig file to use for CLI requests")
+	fs.StringVar(&c.NsOverride, "ns-override", "", "override defined namespaces with this one")
+	fs.StringVar(&c.ContextOverride, "context-override", "", "override releases context defined in release state with this one")
+	fs.BoolVar(&c.Apply, "apply", false, "apply the plan directly")
+	fs.BoolVar(&c.DryRun, "dry-run", false, "apply the dry-run option for helm commands.")
+	fs.BoolVar(&c.Destroy, "destroy", false, "delete all deployed releases.")
+	fs.BoolVar(&c.version, "v", false, "show the version")
+	fs.BoolVar(&c.Debug, "debug", false, "show the debug execution logs and actual helm/kubectl commands. This can log secrets and should only be used for debugging purposes.")
+	fs.BoolVar(&c.Verbose, "verbose", false, "show verbose execution logs.")
+	fs.BoolVar(&c.noBanner, "no-banner", false, "don't show the banner")
+	fs.BoolVar(&c.NoColors, "no-color", false, "don't use colors")
+	fs.BoolVar(&c.noFancy, "no-fancy", false, "don't display the banner and don't use colors")
+	fs.BoolVar(&c.NoNs, "no-ns", false, "don't create namespaces")
+	fs.BoolVar(&c.SkipValidation, "skip-validation", false, "skip desired state validation")
+	fs.BoolVar(&c.KeepUntrackedReleases, "keep-untracked-releases", false, "keep releases that are managed by Helmsman from the used DSFs in the command, and are no longer tracked in your desired state.")
+	fs.BoolVar(&c.ShowDiff, "show-diff", false, "show helm diff results. Can expose sensitive information.")
+	fs.BoolVar(&c.NoEnvSubst, "no-env-subst", false, "turn off environment substitution globally")
+	fs.BoolVar(&c.SubstEnvValues, "subst-env-values", false, "turn on environment substitution in values files.")
+	fs.BoolVar(&c.NoSSMSubst, "no-ssm-subst", false, "turn off SSM parameter substitution globally")
+	fs.BoolVar(&c.SubstSSMValues, "subst-ssm-values", false, "turn on SSM parameter substitution in values files.")
+	fs.BoolVar(&c.UpdateDeps, "update-deps", false, "run 'helm dep up' for local chart")
+	fs.BoolVar(&c.ForceUpgrades, "force-upgrades", false, "use --force when upgrading helm releases. May cause resources to be recreated.")
+	fs.BoolVar(&c.AllowPolicyViolations, "allow-policy-violations", false, "apply the plan even if it violates the policies of the desired state")
+	fs.BoolVar(&c.noCleanup, "no-cleanup", false, "keeps any credentials files that has been downloaded on the host where helmsman runs.")
+	fs.StringVar(&c.LockFile, "lock-file", defaults.LockFile, "file where chart versions resolved from version constraints are recorded")
+	fs.BoolVar(&c.UpdateLock, "update-lock", false, "resolve the chart version constraints of the targeted apps again and refresh the lock file")
+	fs.DurationVar(&c.Timeout, "timeout", 0, "stop the run if it takes longer than this duration, e.g. 30m. No timeout by default")
+	fs.DurationVar(&c.RepoTimeout, "repo-timeout", 0, "stop any command adding or updating helm repos which takes longer than this duration")
+	fs.DurationVar(&c.StateTimeout, "state-timeout", 0, "stop any command reading the current state of the cluster which takes longer than this duration")
+	fs.DurationVar(&c.DiffTimeout, "diff-timeout", 0, "stop any helm diff which takes longer than this duration")
+	fs.DurationVar(&c.ApplyTimeout, "apply-timeout", 0, "stop any command of the plan which takes longer than this duration")
+	fs.StringVar(&c.output, "output", "table", "output format of the outdated report: table or json")
+	fs.BoolVar(&c.MigrateContext, "migrate-context", false, "Updates the context name for all apps defined in the DSF and applies Helmsman labels. Using this flag is required if you want to change context name after it has been set.")
+	fs.Usage = printUsage(fs)
+
+	secrets := len(args) > 0 && args[0] == "secrets"
+	if len(args) > 0 && args[0] == "outdated" {
+		c.outdated = true
+		args = args[1:]
+	} else if secrets {
+		args = args[1:]
+		if len(args) > 0 {
+			c.secretsAction = args[0]
+			args = args[1:]
+		}
+	}
+	_ = fs.Parse(args)
 
 	if c.version {
 		fmt.Println("Helmsman version: " + appVersion)
@@ -116,45 +135,56 @@ func (c *cli) parse() {
 	}
 
 	if c.noFancy {
-		c.noColors = true
+		c.NoColors = true
 		c.noBanner = true
 	}
-	verbose := c.verbose || c.debug
-	initLogs(verbose, c.noColors)
+	// the secrets command prints decrypted files, which the banner would get mixed with
+	if secrets {
+		c.noBanner = true
+	}
+	c.log = newLogger(c.Options)
 
 	if !c.noBanner {
 		fmt.Printf("%s version: %s\n%s", banner, appVersion, slogan)
 	}
 
-	if c.dryRun && c.apply {
-		log.Fatal("--apply and --dry-run can't be used together.")
+	if err := c.Options.validate(); err != nil {
+		c.log.Fatal(err.Error())
 	}
 
-	if c.destroy && c.apply {
-		log.Fatal("--destroy and --apply can't be used together.")
+	if c.output != "table" && c.output != "json" {
+		c.log.Fatal("--output must be either table or json.")
 	}
 
-	if len(c.target) > 0 && len(c.group) > 0 {
-		log.Fatal("--target and --group can't be used together.")
+	if c.outdated && (c.Apply || c.DryRun || c.Destroy) {
+		c.log.Fatal("outdated can't be used together with --apply, --dry-run or --destroy.")
 	}
 
-	helmVersion := strings.TrimSpace(getHelmVersion())
-	extractedHelmVersion := helmVersion
-	if !strings.HasPrefix(helmVersion, "v") {
-		extractedHelmVersion = strings.TrimSpace(strings.Split(helmVersion, ":")[1])
-	}
-	log.Verbose("Helm client version: " + extractedHelmVersion)
-	v1, _ := version.NewVersion(extractedHelmVersion)
-	jsonConstraint, _ := version.NewConstraint(">=3.0.0")
-	if !jsonConstraint.Check(v1) {
-		log.Fatal("this version of Helmsman does not work with helm releases older than 3.0.0")
+	if secrets {
+		if !stringInSlice(c.secretsAction, secretsActions) {
+			c.log.Fatal("secrets must be followed by one of: " + strings.Join(secretsActions, ", ") + ".")
+		}
+		if c.Apply || c.DryRun || c.Destroy {
+			c.log.Fatal("secrets can't be used together with --apply, --dry-run or --destroy.")
+		}
+		if c.secretsAction == secretsCheck {
+			if len(c.Files) == 0 {
+				c.log.Fatal("secrets check needs the desired state files whose secrets files are checked, given with -f.")
+			}
+			if fs.NArg() > 0 {
+				c.log.Fatal("secrets check does not take a file, it checks the secrets files of the desired state.")
+			}
+			return
+		}
+		if fs.NArg() != 1 {
+			c.log.Fatal("secrets " + c.secretsAction + " needs a single secrets file, given after the options.")
+		}
+		c.secretsFile = fs.Arg(0)
+		return
 	}
 
-	kubectlVersion := strings.TrimSpace(strings.SplitN(getKubectlClientVersion(), ": ", 2)[1])
-	log.Verbose("kubectl client version: " + kubectlVersion)
-
-	if len(c.files) == 0 {
-		log.Info("No desired state files provided.")
+	if len(c.Files) == 0 {
+		c.log.Info("No desired state files provided.")
 		os.Exit(0)
 	}
 
@@ -162,40 +192,27 @@ func (c *cli) parse() {
 		os.Setenv("KUBECONFIG", c.kubeconfig)
 	}
 
-	if !toolExists("kubectl") {
-		log.Fatal("kubectl is not installed/configured correctly. Aborting!")
-	}
-
-	if !toolExists(helmBin) {
-		log.Fatal("" + helmBin + " is not installed/configured correctly. Aborting!")
-	}
-
-	if !helmPluginExists("diff") {
-		log.Fatal("helm diff plugin is not installed/configured correctly. Aborting!")
-	}
-
-	if !c.noEnvSubst {
-		log.Verbose("Substitution of env variables enabled")
-		if c.substEnvValues {
-			log.Verbose("Substitution of env variables in values enabled")
+	if !c.NoEnvSubst {
+		c.log.Verbose("Substitution of env variables enabled")
+		if c.SubstEnvValues {
+			c.log.Verbose("Substitution of env variables in values enabled")
 		}
 	}
-	if !c.noSSMSubst {
-		log.Verbose("Substitution of SSM variables enabled")
-		if c.substSSMValues {
-			log.Verbose("Substitution of SSM variables in values enabled")
+	if !c.NoSSMSubst {
+		c.log.Verbose("Substitution of SSM variables enabled")
+		if c.SubstSSMValues {
+			c.log.Verbose("Substitution of SSM variables in values enabled")
 		}
 	}
 }
 
-// readState gets the desired state from files
-func (c *cli) readState(s *state) {
-	// read the env file
+// loadEnvFiles loads the env files given with -e, or the .env file if there is one
+func (c *cli) loadEnvFiles() {
 	if len(c.envFiles) == 0 {
 		if _, err := os.Stat(".env"); err == nil {
 			err = godotenv.Load()
 			if err != nil {
-				log.Fatal("Error loading .env file")
+				c.log.Fatal("Error loading .env file")
 			}
 		}
 	}
@@ -203,92 +220,46 @@ func (c *cli) readState(s *state) {
 	for _, e := range c.envFiles {
 		err := godotenv.Load(e)
 		if err != nil {
-			log.Fatal("Error loading " + e + " env file")
-		}
-	}
-
-	// wipe & create a temporary directory
-	os.RemoveAll(tempFilesDir)
-	_ = os.MkdirAll(tempFilesDir, 0755)
-
-	// read the TOML/YAML desired state file
-	var fileState state
-	for _, f := range c.files {
-
-		result, msg := fileState.fromFile(f)
-		if result {
-			log.Info(msg)
-		} else {
-			log.Fatal(msg)
-		}
-		// Merge Apps that already existed in the state
-		for appName, app := range fileState.Apps {
-			if _, ok := s.Apps[appName]; ok {
-				if err := mergo.Merge(s.Apps[appName], app, mergo.WithAppendSlice, mergo.WithOverride); err != nil {
-					log.Fatal("Failed to merge " + appName + " from desired state file" + f)
-				}
-			}
-		}
-
-		// Merge the remaining Apps
-		if err := mergo.Merge(&s.Apps, &fileState.Apps); err != nil {
-			log.Fatal("Failed to merge desired state file" + f)
-		}
-		// All the apps are already merged, make fileState.Apps empty to avoid conflicts in the final merge
-		fileState.Apps = make(map[string]*release)
-
-		if err := mergo.Merge(s, &fileState, mergo.WithAppendSlice, mergo.WithOverride); err != nil {
-			log.Fatal("Failed to merge desired state file" + f)
+			c.log.Fatal("Error loading " + e + " env file")
 		}
 	}
+}
 
-	if len(c.target) > 0 {
-		s.TargetMap = map[string]bool{}
-		for _, v := range c.target {
-			s.TargetMap[v] = true
-		}
+// checkTools checks that the versions of helm and kubectl are supported and that the helm plugins Helmsman needs are installed
+func checkTools(ctx context.Context) error {
+	log := loggerFrom(ctx)
+	helmVersion, err := getHelmVersion(ctx)
+	if err != nil {
+		return err
 	}
-
-	if len(c.group) > 0 {
-		s.GroupMap = map[string]bool{}
-		for _, v := range c.group {
-			s.GroupMap[v] = true
-		}
+	helmVersion = strings.TrimSpace(helmVersion)
+	extractedHelmVersion := helmVersion
+	if !strings.HasPrefix(helmVersion, "v") {
+		extractedHelmVersion = strings.TrimSpace(strings.Split(helmVersion, ":")[1])
 	}
-
-	if c.debug {
-		s.print()
+	log.Verbose("Helm client version: " + extractedHelmVersion)
+	v1, _ := version.NewVersion(extractedHelmVersion)
+	jsonConstraint, _ := version.NewConstraint(">=3.0.0")
+	if !jsonConstraint.Check(v1) {
+		return errors.New("this version of Helmsman does not work with helm releases older than 3.0.0")
 	}
 
-	if !c.skipValidation {
-		// validate the desired state content
-		if len(c.files) > 0 {
-			log.Info("Validating desired state definition...")
-			if err := s.validate(); err != nil { // syntax validation
-				log.Fatal(err.Error())
-			}
-		}
-	} else {
-		log.Info("Desired state validation is skipped.")
+	kubectlVersion, err := getKubectlClientVersion(ctx)
+	if err != nil {
+		return err
 	}
+	log.Verbose("kubectl client version: " + kubectlVersion)
 
-	if s.Settings.StorageBackend != "" {
-		os.Setenv("HELM_DRIVER", s.Settings.StorageBackend)
-	} else {
-		// set default storage background to secret if not set by user
-		s.Settings.StorageBackend = "secret"
+	if !toolExists("kubectl") {
+		return errors.New("kubectl is not installed/configured correctly. Aborting!")
 	}
 
-	// if there is no user-defined context name in the DSF(s), use the default context name
-	if s.Context == "" {
-		s.Context = defaultContextName
+	if !toolExists(helmBin) {
+		return errors.New("" + helmBin + " is not installed/configured correctly. Aborting!")
 	}
-}
 
-// getDryRunFlags returns dry-run flag
-func (c *cli) getDryRunFlags() []string {
-	if c.dryRun {
-		return []string{"--dry-run", "--debug"}
+	if !helmPluginExists("diff") {
+		return errors.New("helm diff plugin is not installed/configured correctly. Aborting!")
 	}
-	return []string{}
+	return nil
 }
@@ -2,11 +2,6 @@ package app
 
 import "testing"
 
-var _ = func() bool {
-	testing.Init()
-	return true
-}()
-
 func Test_toolExists(t *testing.T) {
 	type args struct {
 		tool string
@@ -0,0 +1,110 @@
+package app
+
+import (
+	"errors"
+	"strings"
+)
+
+// providers of the short-lived credentials used to connect to a cluster
+const (
+	eksAuth  = "eks"
+	gkeAuth  = "gke"
+	aksAuth  = "aks"
+	execAuth = "exec"
+)
+
+const (
+	// execCredentialAPIVersion is the version of the credentials returned by the exec plugins
+	execCredentialAPIVersion = "client.authentication.k8s.io/v1beta1"
+	// aksServerID is the application ID of the Azure Kubernetes Service AAD server, the audience of AKS tokens
+	aksServerID = "6dae42f8-4368-4678-94ff-3960e28e3630"
+	// aksDefaultLogin lets CI jobs authenticate to AKS with their workload identity
+	aksDefaultLogin = "workloadidentity"
+)
+
+var authProviders = []string{eksAuth, gkeAuth, aksAuth, execAuth}
+
+// clusterAuth type represents how Helmsman gets short-lived credentials to connect to a cluster,
+// instead of a password or a long-lived bearer token. The kube context created by Helmsman runs
+// the command of the provider to get a token whenever kubectl or helm need one.
+type clusterAuth struct {
+	Provider string `yaml:"provider"`
+	// ClusterName, RoleARN and Region are used by eks
+	ClusterName string `yaml:"clusterName"`
+	RoleARN     string `yaml:"roleARN"`
+	Region      string `yaml:"region"`
+	// ServerID and Login are used by aks
+	ServerID string `yaml:"serverID"`
+	Login    string `yaml:"login"`
+	// Command, Args and Env are used by exec
+	Command string            `yaml:"command"`
+	Args    []string          `yaml:"args"`
+	Env     map[string]string `yaml:"env"`
+}
+
+// execConfig is the exec plugin of a user in a kubeconfig
+type execConfig struct {
+	APIVersion         string              `yaml:"apiVersion"`
+	Command            string              `yaml:"command"`
+	Args               []string            `yaml:"args,omitempty"`
+	Env                []map[string]string `yaml:"env,omitempty"`
+	ProvideClusterInfo bool                `yaml:"provideClusterInfo,omitempty"`
+}
+
+// validate checks the authentication settings of a cluster
+func (a *clusterAuth) validate() error {
+	if !stringInSlice(a.Provider, authProviders) {
+		return errors.New("provider must be one of: " + strings.Join(authProviders, ", "))
+	}
+	switch a.Provider {
+	case eksAuth:
+		if a.ClusterName == "" {
+			return errors.New("clusterName is required by the eks provider")
+		}
+	case execAuth:
+		if a.Command == "" {
+			return errors.New("command is required by the exec provider")
+		}
+	}
+	if a.Provider != execAuth && (a.Command != "" || len(a.Args) > 0 || len(a.Env) > 0) {
+		return errors.New("command, args and env can only be used with the exec provider")
+	}
+	return nil
+}
+
+// execConfig returns the exec plugin getting a token from the provider
+func (a *clusterAuth) execConfig() *execConfig {
+	switch a.Provider {
+	case eksAuth:
+		args := []string{"eks", "get-token", "--cluster-name", a.ClusterName}
+		if a.RoleARN != "" {
+			args = append(args, "--role-arn", a.RoleARN)
+		}
+		if a.Region != "" {
+			args = append(args, "--region", a.Region)
+		}
+		return &execConfig{APIVersion: execCredentialAPIVersion, Command: "aws", Args: args}
+	case gkeAuth:
+		return &execConfig{
+			APIVersion:         execCredentialAPIVersion,
+			Command:            "gke-gcloud-auth-plugin",
+			Args:               []string{"--use_application_default_credentials"},
+			ProvideClusterInfo: true,
+		}
+	case aksAuth:
+		serverID, login := a.ServerID, a.Login
+		if serverID == "" {
+			serverID = aksServerID
+		}
+		if login == "" {
+			login = aksDefaultLogin
+		}
+		return &execConfig{APIVersion: execCredentialAPIVersion, Command: "kubelogin", Args: []string{"get-token", "--login", login, "--server-id", serverID}}
+	default:
+		ec := &execConfig{APIVersion: execCredentialAPIVersion, Command: a.Command, Args: a.Args}
+		for _, name := range sortedKeys(a.Env) {
+			ec.Env = append(ec.Env, map[string]string{"name": name, "value": a.Env[name]})
+		}
+		return ec
+	}
+}
@@ -0,0 +1,100 @@
+package app
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_clusterAuth_validate(t *testing.T) {
+	tests := []struct {
+		name string
+		auth clusterAuth
+		want string
+	}{
+		{
+			name: "eks",
+			auth: clusterAuth{Provider: eksAuth, ClusterName: "prod", RoleARN: "arn:aws:iam::123456789012:role/deployer"},
+			want: "",
+		}, {
+			name: "eks without cluster name",
+			auth: clusterAuth{Provider: eksAuth},
+			want: "clusterName is required by the eks provider",
+		}, {
+			name: "exec without command",
+			auth: clusterAuth{Provider: execAuth, Args: []string{"token"}},
+			want: "command is required by the exec provider",
+		}, {
+			name: "command of another provider",
+			auth: clusterAuth{Provider: gkeAuth, Command: "gcloud"},
+			want: "command, args and env can only be used with the exec provider",
+		}, {
+			name: "unknown provider",
+			auth: clusterAuth{Provider: "openshift"},
+			want: "provider must be one of: eks, gke, aks, exec",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ""
+			if err := tt.auth.validate(); err != nil {
+				got = err.Error()
+			}
+			if got != tt.want {
+				t.Errorf("validate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_clusterAuth_execConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		auth clusterAuth
+		want execConfig
+	}{
+		{
+			name: "eks",
+			auth: clusterAuth{Provider: eksAuth, ClusterName: "prod", RoleARN: "arn:aws:iam::123456789012:role/deployer", Region: "eu-west-1"},
+			want: execConfig{APIVersion: execCredentialAPIVersion, Command: "aws",
+				Args: []string{"eks", "get-token", "--cluster-name", "prod", "--role-arn", "arn:aws:iam::123456789012:role/deployer", "--region", "eu-west-1"}},
+		}, {
+			name: "gke",
+			auth: clusterAuth{Provider: gkeAuth},
+			want: execConfig{APIVersion: execCredentialAPIVersion, Command: "gke-gcloud-auth-plugin", Args: []string{"--use_application_default_credentials"}, ProvideClusterInfo: true},
+		}, {
+			name: "aks",
+			auth: clusterAuth{Provider: aksAuth, Login: "azurecli"},
+			want: execConfig{APIVersion: execCredentialAPIVersion, Command: "kubelogin", Args: []string{"get-token", "--login", "azurecli", "--server-id", aksServerID}},
+		}, {
+			name: "exec",
+			auth: clusterAuth{Provider: execAuth, Command: "vault", Args: []string{"read", "-field=token", "k8s/creds/deployer"}, Env: map[string]string{"VAULT_ADDR": "https://vault", "A": "b"}},
+			want: execConfig{APIVersion: execCredentialAPIVersion, Command: "vault", Args: []string{"read", "-field=token", "k8s/creds/deployer"},
+				Env: []map[string]string{{"name": "A", "value": "b"}, {"name": "VAULT_ADDR", "value": "https://vault"}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.auth.execConfig(); !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("execConfig() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_kubeconfigManifest_auth(t *testing.T) {
+	s := &state{}
+	s.Settings.KubeContext = "prod"
+	s.Settings.ClusterURI = "https://prod.eks.amazonaws.com"
+	s.Settings.Username = "helmsman"
+	s.Settings.Auth = &clusterAuth{Provider: eksAuth, ClusterName: "prod"}
+
+	got, err := kubeconfigManifest(s, kubeconfigCredentials{caCrt: "/tmp/k/ca.crt"})
+	if err != nil {
+		t.Fatalf("kubeconfigManifest() error = %v", err)
+	}
+	want := "    exec:\n      apiVersion: client.authentication.k8s.io/v1beta1\n      command: aws\n      args:\n      - eks\n      - get-token\n      - --cluster-name\n      - prod\n"
+	if !strings.Contains(got, want) || strings.Contains(got, "token:") {
+		t.Errorf("kubeconfigManifest() = \n%s\nwant it to contain\n%s", got, want)
+	}
+}
@@ -0,0 +1,229 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/imdario/mergo"
+)
+
+// cluster type represents a k8s cluster, reached through a kube context, which the apps of the desired state are deployed to.
+// Apps contains per-cluster overrides of the apps definitions.
+// Canary clusters are deployed to (and their health checks must pass) before the other clusters.
+type cluster struct {
+	Name        string              `yaml:"name"`
+	KubeContext string              `yaml:"kubeContext"`
+	Canary      bool                `yaml:"canary"`
+	Apps        map[string]*release `yaml:"apps"`
+}
+
+// getReleaseDefinitions returns the apps of the desired state as well as the per-cluster app overrides
+func (s *state) getReleaseDefinitions() []*release {
+	var releases []*release
+	for _, r := range s.Apps {
+		releases = append(releases, r)
+	}
+	for _, c := range s.Clusters {
+		for _, r := range c.Apps {
+			if r != nil {
+				releases = append(releases, r)
+			}
+		}
+	}
+	return releases
+}
+
+// validateClusters validates the clusters of the desired state and the apps definitions resulting from their overrides
+func (s *state) validateClusters() error {
+	seen := map[string]bool{}
+	for _, c := range s.Clusters {
+		if c.Name == "" {
+			return errors.New("clusters validation failed -- cluster name can't be empty")
+		}
+		if seen[c.Name] {
+			return errors.New("clusters validation failed -- cluster [ " + c.Name + " ] is defined more than once")
+		}
+		seen[c.Name] = true
+		if c.KubeContext == "" {
+			return errors.New("clusters validation failed -- cluster [ " + c.Name + " ] needs a kubeContext")
+		}
+		for appLabel := range c.Apps {
+			r, ok := s.Apps[appLabel]
+			if !ok {
+				return errors.New("clusters validation failed -- cluster [ " + c.Name + " ] overrides app [ " + appLabel + " ] which is not defined in the apps section")
+			}
+			if r.Cluster != "" && r.Cluster != c.Name {
+				return errors.New("clusters validation failed -- cluster [ " + c.Name + " ] overrides app [ " + appLabel + " ] which is only deployed to cluster [ " + r.Cluster + " ]")
+			}
+		}
+	}
+
+	for appLabel, r := range s.Apps {
+		if r.Cluster != "" && !seen[r.Cluster] {
+			return errors.New("clusters validation failed -- app [ " + appLabel + " ] is deployed to cluster [ " + r.Cluster + " ] which is not defined in the clusters section")
+		}
+	}
+	for name, ns := range s.Namespaces {
+		if ns.Cluster != "" && !seen[ns.Cluster] {
+			return errors.New("clusters validation failed -- namespace [ " + name + " ] is created in cluster [ " + ns.Cluster + " ] which is not defined in the clusters section")
+		}
+	}
+
+	for _, c := range s.Clusters {
+		cs, err := s.forCluster(c)
+		if err != nil {
+			return err
+		}
+		names := make(map[string]map[string]bool)
+		for appLabel, r := range cs.Apps {
+			if err := r.validate(appLabel, names, cs); err != nil {
+				return fmt.Errorf("apps validation failed -- for app ["+appLabel+" ] in cluster [ "+c.Name+" ]. %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// getRolloutStages returns the groups of clusters deployed to at the same time, in order:
+// the canary clusters first, then the other ones.
+func (s *state) getRolloutStages() [][]cluster {
+	var canaries, others []cluster
+	for _, c := range s.Clusters {
+		if c.Canary {
+			canaries = append(canaries, c)
+		} else {
+			others = append(others, c)
+		}
+	}
+	var stages [][]cluster
+	for _, stage := range [][]cluster{canaries, others} {
+		if len(stage) > 0 {
+			stages = append(stages, stage)
+		}
+	}
+	return stages
+}
+
+// deployToClusters deploys the desired state to each of its clusters with a separate current state and plan.
+// The clusters of a rollout stage are deployed to concurrently. The next stage only starts once all the clusters
+// of the previous one are up-to-date and healthy, and as long as the context is not done.
+// The error of each cluster of a stage is collected and returned once all of them are done.
+func deployToClusters(ctx context.Context, s *state) error {
+	stages := s.getRolloutStages()
+	for i, stage := range stages {
+		if ctx.Err() != nil {
+			var skipped []string
+			for _, next := range stages[i:] {
+				for _, c := range next {
+					skipped = append(skipped, c.Name)
+				}
+			}
+			return errors.New(stopReason(ctx, s) + " before deploying to clusters [ " + strings.Join(skipped, ", ") + " ]")
+		}
+		var (
+			errs errorList
+			wg   = sync.WaitGroup{}
+		)
+		for _, c := range stage {
+			wg.Add(1)
+			go func(c cluster) {
+				defer wg.Done()
+				errs.add(deployToCluster(ctx, s, c))
+			}(c)
+		}
+		wg.Wait()
+
+		if err := errs.err(); err != nil {
+			return errors.New("deployment failed: " + err.Error())
+		}
+		if i < len(stages)-1 && s.opts.Apply {
+			s.log.Info("Canary clusters are up-to-date and healthy, continuing with the remaining clusters")
+		}
+	}
+	return nil
+}
+
+// deployToCluster deploys the desired state to one of its clusters, and returns the error it failed with, naming the cluster
+func deployToCluster(ctx context.Context, s *state, c cluster) error {
+	s.log.Info("Deploying to cluster [ " + c.Name + " ] using kube context [ " + c.KubeContext + " ]...")
+	cs, err := s.forCluster(c)
+	if err == nil {
+		err = deploy(ctx, cs, c.Name)
+	}
+	if err != nil {
+		return errors.New("cluster [ " + c.Name + " ]: " + err.Error())
+	}
+	return nil
+}
+
+// forCluster returns a copy of the desired state targeting a cluster. It only contains the apps and namespaces
+// which are not restricted to another cluster, and the cluster's app overrides are applied.
+// Overrides follow the same rules as merging desired state files: only the values set in the override are changed.
+func (s *state) forCluster(c cluster) (*state, error) {
+	cs := *s
+	// the run is stopped by the state it was loaded with, not by the states of its clusters
+	cs.stopRun = nil
+	cs.cluster = c.Name
+	cs.Settings.KubeContext = c.KubeContext
+	cs.Clusters = nil
+	cs.Apps = make(map[string]*release, len(s.Apps))
+	cs.Namespaces = make(map[string]namespace, len(s.Namespaces))
+	for name, ns := range s.Namespaces {
+		if ns.Cluster == "" || ns.Cluster == c.Name {
+			cs.Namespaces[name] = ns
+		}
+	}
+	for appLabel, r := range s.Apps {
+		if r.Cluster != "" && r.Cluster != c.Name {
+			continue
+		}
+		app := r.clone()
+		app.kubeContext = c.KubeContext
+		if override, ok := c.Apps[appLabel]; ok && override != nil {
+			if err := mergo.Merge(app, override.clone(), mergo.WithOverride); err != nil {
+				return nil, fmt.Errorf("failed to apply the overrides of app [ %s ] for cluster [ %s ]: %w", appLabel, c.Name, err)
+			}
+		}
+		cs.Apps[appLabel] = app
+	}
+	if len(cs.TargetMap) > 0 {
+		cs.TargetApps = cs.getAppsInTargetsOnly()
+		cs.TargetNamespaces = cs.getNamespacesInTargetsOnly()
+	}
+	return &cs, nil
+}
+
+// clone returns a copy of a release which does not share any slice or map with it
+func (r *release) clone() *release {
+	c := *r
+	c.ValuesFiles = append([]string(nil), r.ValuesFiles...)
+	c.SecretsFiles = append([]string(nil), r.SecretsFiles...)
+	c.HelmFlags = append([]string(nil), r.HelmFlags...)
+	c.HealthChecks = append([]healthCheck(nil), r.HealthChecks...)
+	if r.Set != nil {
+		c.Set = make(map[string]string, len(r.Set))
+		for k, v := range r.Set {
+			c.Set[k] = v
+		}
+	}
+	if r.SetString != nil {
+		c.SetString = make(map[string]string, len(r.SetString))
+		for k, v := range r.SetString {
+			c.SetString[k] = v
+		}
+	}
+	if r.Hooks != nil {
+		c.Hooks = make(map[string]hook, len(r.Hooks))
+		for k, v := range r.Hooks {
+			c.Hooks[k] = v
+		}
+	}
+	if r.Retry != nil {
+		retry := *r.Retry
+		c.Retry = &retry
+	}
+	return &c
+}
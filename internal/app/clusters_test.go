@@ -0,0 +1,215 @@
+package app
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_state_getRolloutStages(t *testing.T) {
+	tests := []struct {
+		name     string
+		clusters []cluster
+		want     [][]string
+	}{
+		{
+			name:     "canary clusters first",
+			clusters: []cluster{{Name: "eu"}, {Name: "canary", Canary: true}, {Name: "us"}},
+			want:     [][]string{{"canary"}, {"eu", "us"}},
+		}, {
+			name:     "no canary",
+			clusters: []cluster{{Name: "eu"}, {Name: "us"}},
+			want:     [][]string{{"eu", "us"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &state{Clusters: tt.clusters}
+			var got [][]string
+			for _, stage := range s.getRolloutStages() {
+				var names []string
+				for _, c := range stage {
+					names = append(names, c.Name)
+				}
+				got = append(got, names)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getRolloutStages() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_state_forCluster(t *testing.T) {
+	s := &state{
+		Settings: config{KubeContext: "default"},
+		Apps: map[string]*release{
+			"api": {
+				Name:        "api",
+				Namespace:   "production",
+				Chart:       "repo/api",
+				Version:     "1.0.0",
+				ValuesFiles: []string{"values.yaml"},
+				Set:         map[string]string{"replicas": "2", "region": "us"},
+			},
+			"web": {Name: "web", Namespace: "production", Chart: "repo/web", Version: "2.0.0"},
+		},
+		Clusters: []cluster{{
+			Name:        "eu",
+			KubeContext: "gke-eu",
+			Apps: map[string]*release{
+				"api": {
+					Version:     "1.1.0",
+					ValuesFiles: []string{"values-eu.yaml"},
+					Set:         map[string]string{"region": "eu"},
+				},
+			},
+		}},
+	}
+
+	cs, err := s.forCluster(s.Clusters[0])
+	if err != nil {
+		t.Fatalf("forCluster() unexpected error: %v", err)
+	}
+	if cs.Settings.KubeContext != "gke-eu" || s.Settings.KubeContext != "default" {
+		t.Errorf("forCluster() kube context = %s, original = %s", cs.Settings.KubeContext, s.Settings.KubeContext)
+	}
+	if len(cs.Clusters) != 0 {
+		t.Errorf("forCluster() state must not define clusters")
+	}
+
+	api := cs.Apps["api"]
+	if api.Version != "1.1.0" || !reflect.DeepEqual(api.ValuesFiles, []string{"values-eu.yaml"}) {
+		t.Errorf("forCluster() api = version %s, values %v", api.Version, api.ValuesFiles)
+	}
+	if want := map[string]string{"replicas": "2", "region": "eu"}; !reflect.DeepEqual(api.Set, want) {
+		t.Errorf("forCluster() api set = %v, want %v", api.Set, want)
+	}
+	if s.Apps["api"].Version != "1.0.0" || s.Apps["api"].Set["region"] != "us" || s.Apps["api"].ValuesFiles[0] != "values.yaml" {
+		t.Errorf("forCluster() must not change the apps of the original state")
+	}
+	if cs.Apps["web"] == s.Apps["web"] || cs.Apps["web"].Version != "2.0.0" {
+		t.Errorf("forCluster() must copy the apps without overrides")
+	}
+	if api.kubeContext != "gke-eu" || s.Apps["api"].kubeContext != "" {
+		t.Errorf("forCluster() api kube context = %q, original = %q", api.kubeContext, s.Apps["api"].kubeContext)
+	}
+}
+
+func Test_state_forCluster_restrictedAppsAndNamespaces(t *testing.T) {
+	s := &state{
+		Namespaces: map[string]namespace{
+			"shared":  {},
+			"eu-only": {Cluster: "eu"},
+		},
+		Apps: map[string]*release{
+			"shared": {Name: "shared", Namespace: "shared"},
+			"gdpr":   {Name: "gdpr", Namespace: "eu-only", Cluster: "eu"},
+		},
+		Clusters: []cluster{{Name: "eu", KubeContext: "eu"}, {Name: "us", KubeContext: "us"}},
+	}
+	tests := []struct {
+		cluster        cluster
+		wantApps       int
+		wantNamespaces int
+	}{
+		{cluster: s.Clusters[0], wantApps: 2, wantNamespaces: 2},
+		{cluster: s.Clusters[1], wantApps: 1, wantNamespaces: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.cluster.Name, func(t *testing.T) {
+			cs, err := s.forCluster(tt.cluster)
+			if err != nil {
+				t.Fatalf("forCluster() unexpected error: %v", err)
+			}
+			if len(cs.Apps) != tt.wantApps || len(cs.Namespaces) != tt.wantNamespaces {
+				t.Errorf("forCluster() = %d apps and %d namespaces, want %d and %d", len(cs.Apps), len(cs.Namespaces), tt.wantApps, tt.wantNamespaces)
+			}
+		})
+	}
+}
+
+func Test_state_validateClusters(t *testing.T) {
+	apps := map[string]*release{
+		"api": {Name: "api", Namespace: "production", Enabled: true, Chart: "repo/api", Version: "1.0.0"},
+	}
+	namespaces := map[string]namespace{"production": {}}
+	tests := []struct {
+		name     string
+		clusters []cluster
+		apps     map[string]*release
+		want     string
+	}{
+		{
+			name:     "valid clusters",
+			clusters: []cluster{{Name: "canary", KubeContext: "canary", Canary: true}, {Name: "eu", KubeContext: "eu"}},
+			want:     "",
+		}, {
+			name:     "missing name",
+			clusters: []cluster{{KubeContext: "eu"}},
+			want:     "clusters validation failed -- cluster name can't be empty",
+		}, {
+			name:     "duplicate name",
+			clusters: []cluster{{Name: "eu", KubeContext: "eu"}, {Name: "eu", KubeContext: "eu-2"}},
+			want:     "clusters validation failed -- cluster [ eu ] is defined more than once",
+		}, {
+			name:     "missing kube context",
+			clusters: []cluster{{Name: "eu"}},
+			want:     "clusters validation failed -- cluster [ eu ] needs a kubeContext",
+		}, {
+			name:     "override of an unknown app",
+			clusters: []cluster{{Name: "eu", KubeContext: "eu", Apps: map[string]*release{"web": {Version: "1.0.0"}}}},
+			want:     "clusters validation failed -- cluster [ eu ] overrides app [ web ] which is not defined in the apps section",
+		}, {
+			name:     "app deployed to an unknown cluster",
+			clusters: []cluster{{Name: "eu", KubeContext: "eu"}},
+			apps:     map[string]*release{"api": {Name: "api", Namespace: "production", Chart: "repo/api", Version: "1.0.0", Cluster: "us"}},
+			want:     "clusters validation failed -- app [ api ] is deployed to cluster [ us ] which is not defined in the clusters section",
+		}, {
+			name:     "override of an app deployed to another cluster",
+			clusters: []cluster{{Name: "eu", KubeContext: "eu"}, {Name: "us", KubeContext: "us", Apps: map[string]*release{"api": {Version: "1.1.0"}}}},
+			apps:     map[string]*release{"api": {Name: "api", Namespace: "production", Chart: "repo/api", Version: "1.0.0", Cluster: "eu"}},
+			want:     "clusters validation failed -- cluster [ us ] overrides app [ api ] which is only deployed to cluster [ eu ]",
+		}, {
+			name:     "invalid override",
+			clusters: []cluster{{Name: "eu", KubeContext: "eu", Apps: map[string]*release{"api": {Namespace: "staging"}}}},
+			want: "apps validation failed -- for app [api ] in cluster [ eu ]. release api is using namespace [ staging ] which is not defined in the Namespaces section of your desired state file." +
+				" Release [ api ] can't be installed in that Namespace until its defined.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &state{Namespaces: namespaces, Apps: apps, Clusters: tt.clusters}
+			if tt.apps != nil {
+				s.Apps = tt.apps
+			}
+			got := ""
+			if err := s.validateClusters(); err != nil {
+				got = err.Error()
+			}
+			if got != tt.want {
+				t.Errorf("validateClusters() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_deployToClusters_collectsErrors(t *testing.T) {
+	// a kubectl which knows no kube context, so that deploying to any cluster fails
+	fakeTool(t, "kubectl", "exit 1")
+
+	s := &state{
+		Clusters: []cluster{{Name: "eu", KubeContext: "gke-eu"}, {Name: "us", KubeContext: "gke-us"}},
+		opts:     DefaultOptions(),
+	}
+	err := deployToClusters(context.Background(), s)
+	if err == nil {
+		t.Fatal("deployToClusters() error = nil, want the errors of both clusters")
+	}
+	for _, want := range []string{"cluster [ eu ]: kube context [ gke-eu ]", "cluster [ us ]: kube context [ gke-us ]"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("deployToClusters() error = %v, want it to contain %q", err, want)
+		}
+	}
+}
@@ -2,9 +2,13 @@ package app
 
 import (
 	"bytes"
+	"context"
+	"fmt"
+	"os"
 	"os/exec"
 	"strings"
 	"syscall"
+	"time"
 )
 
 // command type representing all executable commands Helmsman needs
@@ -12,21 +16,73 @@ import (
 type command struct {
 	Cmd         string
 	Args        []string
+	Env         []string
 	Description string
+	// Timeout stops the command if it runs for longer, 0 means no timeout
+	Timeout time.Duration
+	// Stdin is written to the standard input of the command, e.g. a password which must not be passed in its arguments.
+	// It is not logged.
+	Stdin string
 }
 
 type exitStatus struct {
 	code   int
 	errors string
 	output string
+	// stopped is set when the command was stopped at its timeout or because its context was done
+	stopped bool
 }
 
 func (c *command) String() string {
+	if len(c.Env) > 0 {
+		return strings.Join(c.Env, " ") + " " + c.Cmd + " " + strings.Join(c.Args, " ")
+	}
 	return c.Cmd + " " + strings.Join(c.Args, " ")
 }
 
-// exec executes the executable command and returns the exit code and execution result
-func (c *command) exec() exitStatus {
+// inKubeContext returns a copy of the command targeting the given kube context rather than the current one.
+// helm and kubectl get the context as a flag, other commands (e.g. hooks) get it in the HELMSMAN_KUBE_CONTEXT env variable.
+func (c command) inKubeContext(kctx string) command {
+	if kctx == "" {
+		return c
+	}
+	switch c.Cmd {
+	case helmBin:
+		c.Args = concat(c.Args, []string{"--kube-context", kctx})
+	case "kubectl":
+		c.Args = concat(c.Args, []string{"--context", kctx})
+	default:
+		c.Env = concat(c.Env, []string{"HELMSMAN_KUBE_CONTEXT=" + kctx})
+	}
+	return c
+}
+
+// inCluster returns a copy of the command run against the cluster of a desired state through the given kube context,
+// with the kubeconfig Helmsman created for the state, if any.
+// Commands other than kubectl get the storage backend of the helm releases in the HELM_DRIVER env variable,
+// which is set on each command rather than on Helmsman so that the engines of different desired states don't clash.
+func (c command) inCluster(s *state, kctx string) command {
+	c = c.inKubeContext(kctx).withKubeconfig(s.kubeconfig)
+	if s.Settings.StorageBackend != "" && c.Cmd != "kubectl" {
+		c.Env = concat(c.Env, []string{"HELM_DRIVER=" + s.Settings.StorageBackend})
+	}
+	return c
+}
+
+// withTimeout returns a copy of the command which is stopped if it runs for longer than the given timeout.
+// A zero timeout leaves the command as it is.
+func (c command) withTimeout(timeout time.Duration) command {
+	if timeout > 0 {
+		c.Timeout = timeout
+	}
+	return c
+}
+
+// exec executes the executable command and returns the exit code and execution result.
+// A command running for longer than its timeout, or still running when the context is done, is killed
+// together with the processes it started.
+func (c *command) exec(ctx context.Context) exitStatus {
+	log := loggerFrom(ctx)
 	// Only use non-empty string args
 	args := []string{}
 	for _, str := range c.Args {
@@ -39,6 +95,14 @@ func (c *command) exec() exitStatus {
 	log.Debug(c.String())
 
 	cmd := exec.Command(c.Cmd, args...)
+	// keep the command running when Helmsman is interrupted from a terminal, see handleSignals
+	setProcessGroup(cmd)
+	if len(c.Env) > 0 {
+		cmd.Env = append(os.Environ(), c.Env...)
+	}
+	if c.Stdin != "" {
+		cmd.Stdin = strings.NewReader(c.Stdin)
+	}
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
@@ -51,7 +115,42 @@ func (c *command) exec() exitStatus {
 		}
 	}
 
-	if err := cmd.Wait(); err != nil {
+	parent := ctx
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	waited := make(chan error, 1)
+	go func() {
+		waited <- cmd.Wait()
+	}()
+
+	var err error
+	select {
+	case err = <-waited:
+	case <-ctx.Done():
+		killProcess(cmd.Process)
+		<-waited
+		name := c.Cmd
+		if len(args) > 0 {
+			name += " " + args[0]
+		}
+		msg := fmt.Sprintf("command [ %s ] timed out after [ %s ] and was stopped while: %s", name, c.Timeout, c.Description)
+		if parent.Err() == context.DeadlineExceeded {
+			msg = fmt.Sprintf("command [ %s ] was stopped at the run timeout while: %s", name, c.Description)
+		} else if parent.Err() != nil {
+			msg = fmt.Sprintf("command [ %s ] was stopped as the run was interrupted while: %s", name, c.Description)
+		}
+		return exitStatus{
+			code:    1,
+			output:  stdout.String(),
+			errors:  msg,
+			stopped: true,
+		}
+	}
+
+	if err != nil {
 		if exiterr, ok := err.(*exec.ExitError); ok {
 			if status, ok := exiterr.Sys().(syscall.WaitStatus); ok {
 				return exitStatus{
@@ -60,8 +159,11 @@ func (c *command) exec() exitStatus {
 					errors: stderr.String(),
 				}
 			}
-		} else {
-			log.Fatal("cmd.Wait: " + err.Error())
+		}
+		return exitStatus{
+			code:   1,
+			output: stdout.String(),
+			errors: "cmd.Wait: " + err.Error(),
 		}
 	}
 	return exitStatus{
@@ -71,6 +173,23 @@ func (c *command) exec() exitStatus {
 	}
 }
 
+// execInteractive executes the command attached to the terminal of Helmsman, for commands such as editors
+// which interact with the user. Its output is not captured.
+// The context only gives the logger of the run: the command is left to the user, who may interrupt it.
+func (c *command) execInteractive(ctx context.Context) error {
+	log := loggerFrom(ctx)
+	log.Verbose(c.Description)
+	log.Debug(c.String())
+	cmd := exec.Command(c.Cmd, c.Args...)
+	if len(c.Env) > 0 {
+		cmd.Env = append(os.Environ(), c.Env...)
+	}
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
 // toolExists returns true if the tool is present in the environment and false otherwise.
 // It takes as input the tool's command to check if it is recognizable or not. e.g. helm or kubectl
 func toolExists(tool string) bool {
@@ -80,7 +199,7 @@ func toolExists(tool string) bool {
 		Description: "Validating that [ " + tool + " ] is installed",
 	}
 
-	result := cmd.exec()
+	result := cmd.exec(context.Background())
 
 	return result.code == 0
 }
@@ -1,8 +1,11 @@
 package app
 
 import (
+	"context"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 func Test_command_exec(t *testing.T) {
@@ -44,7 +47,7 @@ func Test_command_exec(t *testing.T) {
 				Args:        tt.fields.Args,
 				Description: tt.fields.Description,
 			}
-			got := c.exec()
+			got := c.exec(context.Background())
 			if got.code != tt.want {
 				t.Errorf("command.exec() got = %v, want %v", got.code, tt.want)
 			}
@@ -54,3 +57,112 @@ func Test_command_exec(t *testing.T) {
 		})
 	}
 }
+
+func Test_command_inKubeContext(t *testing.T) {
+	tests := []struct {
+		name     string
+		cmd      command
+		kctx     string
+		wantArgs []string
+		wantEnv  []string
+	}{
+		{
+			name:     "helm",
+			cmd:      command{Cmd: helmBin, Args: []string{"list"}},
+			kctx:     "eu",
+			wantArgs: []string{"list", "--kube-context", "eu"},
+		}, {
+			name:     "kubectl",
+			cmd:      command{Cmd: "kubectl", Args: []string{"get", "ns"}},
+			kctx:     "eu",
+			wantArgs: []string{"get", "ns", "--context", "eu"},
+		}, {
+			name:     "other command",
+			cmd:      command{Cmd: "sh", Args: []string{"-c", "true"}},
+			kctx:     "eu",
+			wantArgs: []string{"-c", "true"},
+			wantEnv:  []string{"HELMSMAN_KUBE_CONTEXT=eu"},
+		}, {
+			name:     "current context",
+			cmd:      command{Cmd: "kubectl", Args: []string{"get", "ns"}},
+			wantArgs: []string{"get", "ns"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.cmd.inKubeContext(tt.kctx)
+			if !reflect.DeepEqual(got.Args, tt.wantArgs) || !reflect.DeepEqual(got.Env, tt.wantEnv) {
+				t.Errorf("inKubeContext() = %v %v, want %v %v", got.Args, got.Env, tt.wantArgs, tt.wantEnv)
+			}
+		})
+	}
+}
+
+func Test_command_inCluster(t *testing.T) {
+	s := &state{Settings: config{StorageBackend: "configmap"}}
+	helm := command{Cmd: helmBin, Args: []string{"list"}}.inCluster(s, "eu")
+	if !reflect.DeepEqual(helm.Args, []string{"list", "--kube-context", "eu"}) || !reflect.DeepEqual(helm.Env, []string{"HELM_DRIVER=configmap"}) {
+		t.Errorf("inCluster() = %v %v, want the kube context flag and the HELM_DRIVER env variable", helm.Args, helm.Env)
+	}
+	hook := command{Cmd: "sh", Args: []string{"-c", "true"}}.inCluster(s, "eu")
+	if !reflect.DeepEqual(hook.Env, []string{"HELMSMAN_KUBE_CONTEXT=eu", "HELM_DRIVER=configmap"}) {
+		t.Errorf("inCluster() env = %v, want the kube context and the HELM_DRIVER env variables", hook.Env)
+	}
+	if kubectl := (command{Cmd: "kubectl"}).inCluster(s, "eu"); len(kubectl.Env) > 0 {
+		t.Errorf("inCluster() env = %v, want none for kubectl", kubectl.Env)
+	}
+}
+
+func Test_command_exec_timeout(t *testing.T) {
+	c := command{
+		Cmd:         "sh",
+		Args:        []string{"-c", "sleep 30"},
+		Description: "waiting for a release",
+	}
+	c = c.withTimeout(100 * time.Millisecond)
+
+	start := time.Now()
+	result := c.exec(context.Background())
+	if time.Since(start) > 10*time.Second {
+		t.Errorf("exec() did not stop the command at its timeout")
+	}
+	want := "command [ sh -c ] timed out after [ 100ms ] and was stopped while: waiting for a release"
+	if result.code == 0 || result.errors != want {
+		t.Errorf("exec() = [ %d ] %q, want a failure with %q", result.code, result.errors, want)
+	}
+}
+
+func Test_command_exec_cancelled(t *testing.T) {
+	c := command{
+		Cmd:         "sh",
+		Args:        []string{"-c", "sleep 30"},
+		Description: "waiting for a release",
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	result := c.exec(ctx)
+	if time.Since(start) > 10*time.Second {
+		t.Errorf("exec() did not stop the command when its context was cancelled")
+	}
+	want := "command [ sh -c ] was stopped as the run was interrupted while: waiting for a release"
+	if result.code == 0 || !result.stopped || result.errors != want {
+		t.Errorf("exec() = [ %d ] %q, want a failure with %q", result.code, result.errors, want)
+	}
+}
+
+func Test_command_exec_stdin(t *testing.T) {
+	c := command{
+		Cmd:         "sh",
+		Args:        []string{"-c", "cat"},
+		Description: "reading a password",
+		Stdin:       "s3cr3t",
+	}
+	if result := c.exec(context.Background()); result.code != 0 || result.output != "s3cr3t" {
+		t.Errorf("exec() = [ %d ] %q, want the stdin of the command", result.code, result.output)
+	}
+	if strings.Contains(c.String(), "s3cr3t") {
+		t.Errorf("String() = %q, want the stdin left out", c.String())
+	}
+}
@@ -1,7 +1,7 @@
 package app
 
 import (
-	"os"
+	"errors"
 	"regexp"
 	"strings"
 	"sync"
@@ -10,7 +10,14 @@ import (
 type currentState struct {
 	sync.Mutex
 	releases map[string]helmRelease
-	plan     *plan
+	// namespaces holds the namespaces of the cluster, it is nil when Helmsman does not manage namespaces
+	namespaces map[string]namespaceState
+	// namespaceResources holds the LimitRanges, ResourceQuotas, network policies, role bindings and service accounts created by Helmsman, per namespace
+	namespaceResources map[string]map[namespaceResource]bool
+	// namespaceSecrets holds the secrets created by Helmsman, per namespace and secret name
+	namespaceSecrets map[string]map[string]liveSecret
+	plan             *plan
+	context          string
 }
 
 func newCurrentState() *currentState {
@@ -20,14 +27,27 @@ func newCurrentState() *currentState {
 }
 
 // buildState builds the currentState map containing information about all releases existing in a k8s cluster
-func buildState(s *state) *currentState {
-	log.Info("Acquiring current Helm state from cluster...")
+func buildState(s *state) (*currentState, error) {
+	s.log.Info("Acquiring current Helm state from cluster...")
 
 	cs := newCurrentState()
-	rel := getHelmReleases(s)
+	cs.context = s.Context
+	if !s.opts.NoNs {
+		var err error
+		if cs.namespaces, err = getNamespaces(s); err != nil {
+			return nil, err
+		}
+		cs.namespaceResources = getNamespaceResources(s)
+		cs.namespaceSecrets = getNamespaceSecrets(s)
+	}
+	rel, err := getHelmReleases(s)
+	if err != nil {
+		return nil, err
+	}
 
 	wg := sync.WaitGroup{}
 	sem := make(chan struct{}, resourcePool)
+	var errs errorList
 
 	for _, r := range rel {
 		// aquire
@@ -41,28 +61,46 @@ func buildState(s *state) *currentState {
 				// release
 				<-sem
 			}()
-			if flags.contextOverride == "" {
-				r.HelmsmanContext = getReleaseContext(r.Name, r.Namespace)
+			if s.opts.ContextOverride == "" {
+				rctx, err := getReleaseContext(r.Name, r.Namespace, s)
+				if err != nil {
+					errs.add(err)
+					return
+				}
+				r.HelmsmanContext = rctx
 			} else {
-				log.Info("Overriding Helmsman context for " + r.Name + " as " + flags.contextOverride)
-				r.HelmsmanContext = flags.contextOverride
+				s.log.Info("Overriding Helmsman context for " + r.Name + " as " + s.opts.ContextOverride)
+				r.HelmsmanContext = s.opts.ContextOverride
 			}
 			cs.releases[r.key()] = r
 		}(r)
 	}
 	wg.Wait()
-	return cs
+	if err := errs.err(); err != nil {
+		return nil, err
+	}
+	return cs, nil
 }
 
 // makePlan creates a plan of the actions needed to make the desired state come true.
-func (cs *currentState) makePlan(s *state) *plan {
+func (cs *currentState) makePlan(s *state) (*plan, error) {
 	p := createPlan()
+	p.log = s.log
+	if s.Settings.StuckReleasePolicy == stuckReleaseWait && !s.opts.Destroy {
+		if err := cs.waitForPendingReleases(s); err != nil {
+			return nil, err
+		}
+	}
 
 	wg := sync.WaitGroup{}
 	sem := make(chan struct{}, resourcePool)
+	var errs errorList
 
 	for _, r := range s.Apps {
-		r.checkChartDepUpdate()
+		if err := r.checkChartDepUpdate(s); err != nil {
+			wg.Wait()
+			return nil, err
+		}
 		sem <- struct{}{}
 		wg.Add(1)
 		go func(r *release) {
@@ -70,28 +108,31 @@ func (cs *currentState) makePlan(s *state) *plan {
 				wg.Done()
 				<-sem
 			}()
-			cs.decide(r, s, p)
+			errs.add(cs.decide(r, s, p))
 		}(r)
 	}
 	wg.Wait()
 
-	return p
+	if err := errs.err(); err != nil {
+		return nil, err
+	}
+	return p, nil
 }
 
 // decide makes a decision about what commands (actions) need to be executed
 // to make a release section of the desired state come true.
-func (cs *currentState) decide(r *release, s *state, p *plan) {
+func (cs *currentState) decide(r *release, s *state, p *plan) error {
 	// check for presence in defined targets or groups
 	if !r.isConsideredToRun(s) {
 		p.addDecision("Release [ "+r.Name+" ] ignored", r.Priority, ignored)
-		return
+		return nil
 	}
 
-	if flags.destroy {
+	if s.opts.Destroy {
 		if ok := cs.releaseExists(r, ""); ok {
-			r.uninstall(p)
+			r.uninstall(s, p)
 		}
-		return
+		return nil
 	}
 
 	if !r.Enabled {
@@ -99,61 +140,61 @@ func (cs *currentState) decide(r *release, s *state, p *plan) {
 			if r.isProtected(cs, s) {
 				p.addDecision("Release [ "+r.Name+" ] in namespace [ "+r.Namespace+" ] is PROTECTED. Operations are not allowed on this release until "+
 					"protection is removed.", r.Priority, noop)
-				return
+				return nil
 			}
-			r.uninstall(p)
-			return
+			r.uninstall(s, p)
+			return nil
 		}
 		p.addDecision("Release [ "+r.Name+" ] disabled", r.Priority, noop)
-		return
+		return nil
 	}
 
 	if ok := cs.releaseExists(r, helmStatusDeployed); ok {
 		if !r.isProtected(cs, s) {
-			cs.inspectUpgradeScenario(r, p) // upgrade or move
+			return cs.inspectUpgradeScenario(r, s, p) // upgrade or move
 		} else {
 			p.addDecision("Release [ "+r.Name+" ] in namespace [ "+r.Namespace+" ] is PROTECTED. Operations are not allowed on this release until "+
 				"you remove its protection.", r.Priority, noop)
 		}
-	} else if ok := cs.releaseExists(r, helmStatusDeleted); ok {
+	} else if ok := cs.releaseExists(r, helmStatusUninstalled); ok {
 		if !r.isProtected(cs, s) {
-			r.rollback(cs, p) // rollback
+			return r.rollback(cs, s, p) // rollback
 		} else {
 			p.addDecision("Release [ "+r.Name+" ] in namespace [ "+r.Namespace+" ] is PROTECTED. Operations are not allowed on this release until "+
 				"you remove its protection.", r.Priority, noop)
 		}
 	} else if ok := cs.releaseExists(r, helmStatusFailed); ok {
 		if !r.isProtected(cs, s) {
-			p.addDecision("Release [ "+r.Name+" ] in namespace [ "+r.Namespace+" ] is in FAILED state. Upgrade is scheduled!", r.Priority, change)
-			r.upgrade(p)
+			p.addDecisionFor(r.Namespace, r.Name, "Release [ "+r.Name+" ] in namespace [ "+r.Namespace+" ] is in FAILED state. Upgrade is scheduled!", r.Priority, change)
+			return r.upgrade(s, p)
 		} else {
 			p.addDecision("Release [ "+r.Name+" ] in namespace [ "+r.Namespace+" ] is PROTECTED. Operations are not allowed on this release until "+
 				"you remove its protection.", r.Priority, noop)
 		}
-	} else if ok := cs.releaseExists(r, helmStatusPending); ok {
-		log.Error("Release [ " + r.Name + " ] in namespace [ " + r.Namespace + " ] is in pending-upgrade state. " +
-			"This means application is being upgraded outside of this Helmsman invocation's scope." +
-			"Exiting, as this may cause issues when continuing...")
-		os.Exit(1)
+	} else if ok := cs.releaseExists(r, ""); ok && isPending(cs.releases[r.key()].Status) {
+		return cs.recoverStuckRelease(r, s, p)
+	} else if ok := cs.releaseExists(r, ""); ok {
+		return errors.New("release [ " + r.Name + " ] in namespace [ " + r.Namespace + " ] is in [ " + cs.releases[r.key()].Status + " ] state, " +
+			"which Helmsman does not know how to handle. Check it with helm before running Helmsman again")
 	} else {
 		// If there is no release in the cluster with this name and in this namespace, then install it!
 		if _, ok := cs.releases[r.key()]; !ok {
-			r.install(p)
-		} else {
-			// A release with the same name and in the same namespace exists, but it has a different context label (managed by another DSF)
-			log.Fatal("Release [ " + r.Name + " ] in namespace [ " + r.Namespace + " ] already exists but is not managed by the" +
-				" current context: [ " + s.Context + " ]. Applying changes will likely cause conflicts. Change the release name or namespace.")
+			return r.install(s, p)
 		}
+		// A release with the same name and in the same namespace exists, but it has a different context label (managed by another DSF)
+		return errors.New("release [ " + r.Name + " ] in namespace [ " + r.Namespace + " ] already exists but is not managed by the" +
+			" current context: [ " + s.Context + " ]. Applying changes will likely cause conflicts. Change the release name or namespace")
 	}
+	return nil
 }
 
 // releaseExists checks if a Helm release is/was deployed in a k8s cluster.
 // It searches the Current State for releases.
 // The key format for releases uniqueness is:  <release name - release namespace>
-// If status is provided as an input [deployed, deleted, failed], then the search will verify the release status matches the search status.
+// If status is provided as an input [deployed, uninstalled, failed], then the search will verify the release status matches the search status.
 func (cs *currentState) releaseExists(r *release, status string) bool {
 	v, ok := cs.releases[r.key()]
-	if !ok || v.HelmsmanContext != curContext {
+	if !ok || v.HelmsmanContext != cs.context {
 		return false
 	}
 
@@ -169,12 +210,12 @@ var releaseNameExtractor = regexp.MustCompile(`sh\.helm\.release\.v\d+\.`)
 // getHelmsmanReleases returns a map of all releases that are labeled with "MANAGED-BY=HELMSMAN"
 // The releases are categorized by the namespaces in which they are deployed
 // The returned map format is: map[<namespace>:map[<helmRelease>:true]]
-func (cs *currentState) getHelmsmanReleases(s *state) map[string]map[string]bool {
+func (cs *currentState) getHelmsmanReleases(s *state) (map[string]map[string]bool, error) {
 	var (
 		wg    sync.WaitGroup
 		mutex = &sync.Mutex{}
+		errs  errorList
 	)
-	const outputFmt = "custom-columns=NAME:.metadata.name,CTX:.metadata.labels.HELMSMAN_CONTEXT"
 	releases := make(map[string]map[string]bool)
 	sem := make(chan struct{}, resourcePool)
 	namespaces := make(map[string]namespace)
@@ -183,41 +224,23 @@ func (cs *currentState) getHelmsmanReleases(s *state) map[string]map[string]bool
 	} else {
 		namespaces = s.Namespaces
 	}
-	storageBackend := s.Settings.StorageBackend
-
 	for ns := range namespaces {
 		// acquire
 		sem <- struct{}{}
 		wg.Add(1)
 		go func(ns string) {
-			var lines []string
 			defer func() {
 				wg.Done()
 				// release
 				<-sem
 			}()
 
-			cmd := kubectl([]string{"get", storageBackend, "-n", ns, "-l", "MANAGED-BY=HELMSMAN", "-o", outputFmt, "--no-headers"}, "Getting Helmsman-managed releases")
-			result := cmd.exec()
-			if result.code != 0 {
-				log.Fatal(result.errors)
-			}
-
-			if !strings.EqualFold("No resources found.", strings.TrimSpace(result.output)) {
-				lines = strings.Split(result.output, "\n")
+			contexts, err := getHelmsmanReleaseContexts(ns, s)
+			if err != nil {
+				errs.add(err)
+				return
 			}
-
-			for _, line := range lines {
-				if line == "" {
-					continue
-				}
-				flds := strings.Fields(line)
-				name := resourceNameExtractor.ReplaceAllString(flds[0], "")
-				name = releaseNameExtractor.ReplaceAllString(name, "")
-				rctx := defaultContextName
-				if len(flds) > 1 {
-					rctx = flds[1]
-				}
+			for name, rctx := range contexts {
 				if len(s.TargetMap) > 0 {
 					if use, ok := s.TargetMap[name]; !ok || !use {
 						continue
@@ -243,11 +266,47 @@ func (cs *currentState) getHelmsmanReleases(s *state) map[string]map[string]bool
 				}
 				mutex.Unlock()
 			}
-
 		}(ns)
 	}
 	wg.Wait()
-	return releases
+	if err := errs.err(); err != nil {
+		return nil, err
+	}
+	return releases, nil
+}
+
+// getHelmsmanReleaseContexts returns the context of each release of a namespace which is labeled with "MANAGED-BY=HELMSMAN".
+// Releases which are not in the map were not installed by Helmsman.
+func getHelmsmanReleaseContexts(ns string, s *state) (map[string]string, error) {
+	const outputFmt = "custom-columns=NAME:.metadata.name,CTX:.metadata.labels.HELMSMAN_CONTEXT"
+	cmd := kubectl([]string{"get", s.Settings.StorageBackend, "-n", ns, "-l", "MANAGED-BY=HELMSMAN", "-o", outputFmt, "--no-headers"}, "Getting Helmsman-managed releases").inCluster(s, s.Settings.KubeContext).withTimeout(s.commandTimeout(statePhase))
+	result := cmd.retryExec(s.context(), s.getRetryPolicy(nil), s.retries)
+	if result.code != 0 {
+		return nil, errors.New("failed to get the Helmsman-managed releases of namespace [ " + ns + " ]: " + result.errors)
+	}
+	return parseHelmsmanReleaseContexts(result.output), nil
+}
+
+// parseHelmsmanReleaseContexts extracts the release names and contexts from the storage objects listed by getHelmsmanReleaseContexts
+func parseHelmsmanReleaseContexts(output string) map[string]string {
+	contexts := make(map[string]string)
+	if strings.EqualFold("No resources found.", strings.TrimSpace(output)) {
+		return contexts
+	}
+	for _, line := range strings.Split(output, "\n") {
+		flds := strings.Fields(line)
+		if len(flds) == 0 {
+			continue
+		}
+		name := resourceNameExtractor.ReplaceAllString(flds[0], "")
+		name = releaseNameExtractor.ReplaceAllString(name, "")
+		rctx := defaultContextName
+		if len(flds) > 1 {
+			rctx = flds[1]
+		}
+		contexts[name] = rctx
+	}
+	return contexts
 }
 
 // cleanUntrackedReleases checks for any releases that are managed by Helmsman and are no longer tracked by the desired state
@@ -255,22 +314,27 @@ func (cs *currentState) getHelmsmanReleases(s *state) map[string]map[string]bool
 // For all untracked releases found, a decision is made to uninstall them and is added to the Helmsman plan
 // NOTE: Untracked releases don't benefit from either namespace or application protection.
 // NOTE: Removing/Commenting out an app from the desired state makes it untracked.
-func (cs *currentState) cleanUntrackedReleases(s *state, p *plan) {
+func (cs *currentState) cleanUntrackedReleases(s *state, p *plan) error {
 	toDelete := 0
-	log.Info("Checking if any Helmsman managed releases are no longer tracked by your desired state ...")
-	for ns, hr := range cs.getHelmsmanReleases(s) {
+	s.log.Info("Checking if any Helmsman managed releases are no longer tracked by your desired state ...")
+	releases, err := cs.getHelmsmanReleases(s)
+	if err != nil {
+		return err
+	}
+	for ns, hr := range releases {
 		for name, tracked := range hr {
 			if !tracked {
 				toDelete++
 				r := cs.releases[name+"-"+ns]
-				p.addDecision("Untracked release [ "+r.Name+" ] found and it will be deleted", -800, delete)
-				r.uninstall(p)
+				p.addDecisionFor(r.Namespace, r.Name, "Untracked release [ "+r.Name+" ] found and it will be deleted", -800, delete)
+				r.uninstall(s, p)
 			}
 		}
 	}
 	if toDelete == 0 {
-		log.Info("No untracked releases found")
+		s.log.Info("No untracked releases found")
 	}
+	return nil
 }
 
 // inspectUpgradeScenario evaluates if a release should be upgraded.
@@ -280,48 +344,60 @@ func (cs *currentState) cleanUntrackedReleases(s *state, p *plan) {
 // it will be purge deleted and installed in the same namespace using the new chart.
 // - If the release is NOT in the same namespace specified in the input,
 // it will be purge deleted and installed in the new namespace.
-func (cs *currentState) inspectUpgradeScenario(r *release, p *plan) {
+func (cs *currentState) inspectUpgradeScenario(r *release, s *state, p *plan) error {
 
 	rs, ok := cs.releases[r.key()]
 	if !ok {
-		return
+		return nil
 	}
 
 	if r.Namespace == rs.Namespace {
-
-		version, msg := r.getChartVersion()
-		if msg != "" {
-			log.Fatal(msg)
-			return
+		chartName, err := extractChartName(s.context(), r.Chart)
+		if err != nil {
+			return err
 		}
-		r.Version = version
 
-		if extractChartName(r.Chart) == rs.getChartName() && r.Version != rs.getChartVersion() {
+		if chartName == rs.getChartName() && r.Version != rs.getChartVersion() {
 			// upgrade
-			r.diff()
-			r.upgrade(p)
-			p.addDecision("Release [ "+r.Name+" ] will be updated", r.Priority, change)
+			if _, err := r.diff(s); err != nil {
+				return err
+			}
+			if err := r.upgrade(s, p); err != nil {
+				return err
+			}
+			p.addDecisionFor(r.Namespace, r.Name, "Release [ "+r.Name+" ] will be updated", r.Priority, change)
 
-		} else if extractChartName(r.Chart) != rs.getChartName() {
-			r.reInstall(p)
-			p.addDecision("Release [ "+r.Name+" ] is desired to use a new chart [ "+r.Chart+
+		} else if chartName != rs.getChartName() {
+			if err := r.reInstall(s, p); err != nil {
+				return err
+			}
+			p.addDecisionFor(r.Namespace, r.Name, "Release [ "+r.Name+" ] is desired to use a new chart [ "+r.Chart+
 				" ]. Delete of the current release will be planned and new chart will be installed in namespace [ "+
 				r.Namespace+" ]", r.Priority, change)
 		} else {
-			if diff := r.diff(); diff != "" {
-				r.upgrade(p)
-				p.addDecision("Release [ "+r.Name+" ] will be updated", r.Priority, change)
+			diff, err := r.diff(s)
+			if err != nil {
+				return err
+			}
+			if diff != "" {
+				if err := r.upgrade(s, p); err != nil {
+					return err
+				}
+				p.addDecisionFor(r.Namespace, r.Name, "Release [ "+r.Name+" ] will be updated", r.Priority, change)
 			} else {
 				p.addDecision("Release [ "+r.Name+" ] installed and up-to-date", r.Priority, noop)
 			}
 		}
 	} else {
-		r.reInstall(p)
-		p.addDecision("Release [ "+r.Name+" ] is desired to be enabled in a new namespace [ "+r.Namespace+
+		if err := r.reInstall(s, p); err != nil {
+			return err
+		}
+		p.addDecisionFor(r.Namespace, r.Name, "Release [ "+r.Name+" ] is desired to be enabled in a new namespace [ "+r.Namespace+
 			" ]. Uninstall of the current release from namespace [ "+rs.Namespace+" ] will be performed "+
 			"and then installation in namespace [ "+r.Namespace+" ] will take place", r.Priority, change)
-		p.addDecision("WARNING: moving release [ "+r.Name+" ] from [ "+rs.Namespace+" ] to [ "+r.Namespace+
+		p.addDecisionFor(r.Namespace, r.Name, "WARNING: moving release [ "+r.Name+" ] from [ "+rs.Namespace+" ] to [ "+r.Namespace+
 			" ] might not correctly connect existing volumes. Check https://github.com/Praqma/helmsman/blob/master/docs/how_to/move_charts_across_namespaces.md"+
 			" for details if this release uses PV and PVC.", r.Priority, change)
 	}
+	return nil
 }
@@ -68,7 +68,11 @@ func Test_getValuesFiles(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := tt.args.r.getValuesFiles(); !reflect.DeepEqual(got, tt.want) {
+			got, err := tt.args.r.getValuesFiles(&state{})
+			if err != nil {
+				t.Fatalf("getValuesFiles() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("getValuesFiles() = %v, want %v", got, tt.want)
 			}
 		})
@@ -111,7 +115,9 @@ func Test_inspectUpgradeScenario(t *testing.T) {
 			cs := currentState{releases: *tt.args.s}
 
 			// Act
-			cs.inspectUpgradeScenario(tt.args.r, &outcome)
+			if err := cs.inspectUpgradeScenario(tt.args.r, &state{opts: DefaultOptions()}, &outcome); err != nil {
+				t.Fatalf("inspectUpgradeScenario() error = %v", err)
+			}
 			got := outcome.Decisions[0].Type
 			t.Log(outcome.Decisions[0].Description)
 
@@ -211,7 +217,9 @@ func Test_decide(t *testing.T) {
 			}
 			outcome := plan{}
 			// Act
-			cs.decide(tt.args.r, tt.args.s, &outcome)
+			if err := cs.decide(tt.args.r, tt.args.s, &outcome); err != nil {
+				t.Fatalf("decide() error = %v", err)
+			}
 			got := outcome.Decisions[0].Type
 			t.Log(outcome.Decisions[0].Description)
 
@@ -285,17 +293,13 @@ func Test_decide_group(t *testing.T) {
 	}
 }
 
-// String allows for pretty printing decisionType const
-func (dt decisionType) String() string {
-	switch dt {
-	case create:
-		return "create"
-	case change:
-		return "change"
-	case delete:
-		return "delete"
-	case noop:
-		return "noop"
+func Test_parseHelmsmanReleaseContexts(t *testing.T) {
+	output := "sh.helm.release.v1.api.v1   staging\nsh.helm.release.v1.api.v2   staging\nsh.helm.release.v1.web.v1   <none>\n"
+	want := map[string]string{"api": "staging", "web": "<none>"}
+	if got := parseHelmsmanReleaseContexts(output); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseHelmsmanReleaseContexts() = %v, want %v", got, want)
+	}
+	if got := parseHelmsmanReleaseContexts("No resources found.\n"); len(got) != 0 {
+		t.Errorf("parseHelmsmanReleaseContexts() of no resources = %v, want none", got)
 	}
-	return "unknown"
 }
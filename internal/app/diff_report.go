@@ -0,0 +1,322 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// formats of the diff report index
+const (
+	diffReportMarkdown = "markdown"
+	diffReportHTML     = "html"
+)
+
+// change types of the resources of a release diff
+const (
+	resourceAdded    = "added"
+	resourceModified = "modified"
+	resourceRemoved  = "removed"
+)
+
+// actions taken on the releases of a diff report. Only upgrades are diffed by helm diff.
+const (
+	actionUpgrade   = "upgrade"
+	actionInstall   = "install"
+	actionReinstall = "reinstall"
+	actionDelete    = "delete"
+)
+
+// diffHeader matches the header helm diff prints before the diff of each resource,
+// e.g. "staging, api, Deployment (apps) has changed:"
+var diffHeader = regexp.MustCompile(`(?m)^(\S+), (\S+), (\S+) \(([^)]*)\) (has changed|has been added|has been removed):`)
+
+// ansiCodes matches the color codes of helm diff outputs
+var ansiCodes = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+
+// resourceChange is a resource of a release diff and how it changes
+type resourceChange struct {
+	Namespace string
+	Name      string
+	Kind      string
+	Change    string
+}
+
+// releaseDiff is the diff of a release, as reported by helm diff, and the action planned for it.
+// File is the path of the diff relative to the report directory, with a directory per cluster and namespace.
+type releaseDiff struct {
+	Cluster   string
+	Name      string
+	Namespace string
+	Action    string
+	File      string
+	Diff      string
+	Changes   []resourceChange
+}
+
+// Diffed checks if helm diff was run for the action of the release diff
+func (rd releaseDiff) Diffed() bool {
+	return rd.Action == actionUpgrade
+}
+
+// Link returns the link to the file of the diff from the index of the report
+func (rd releaseDiff) Link() string {
+	return (&url.URL{Path: rd.File}).EscapedPath()
+}
+
+// count returns how many resources of the release diff have the given change type
+func (rd releaseDiff) count(change string) int {
+	n := 0
+	for _, c := range rd.Changes {
+		if c.Change == change {
+			n++
+		}
+	}
+	return n
+}
+
+// Added returns how many resources are added by the release diff
+func (rd releaseDiff) Added() int { return rd.count(resourceAdded) }
+
+// Modified returns how many resources are modified by the release diff
+func (rd releaseDiff) Modified() int { return rd.count(resourceModified) }
+
+// Removed returns how many resources are removed by the release diff
+func (rd releaseDiff) Removed() int { return rd.count(resourceRemoved) }
+
+// ByKind describes the changes of the release diff per kind, e.g. "Deployment: 1 modified, Service: 1 added"
+func (rd releaseDiff) ByKind() string {
+	var kinds []string
+	for _, kc := range countByKind([]releaseDiff{rd}) {
+		kinds = append(kinds, kc.String())
+	}
+	return strings.Join(kinds, ", ")
+}
+
+// kindChanges counts the changes of the resources of a kind
+type kindChanges struct {
+	Kind                     string
+	Added, Modified, Removed int
+}
+
+func (kc kindChanges) String() string {
+	var counts []string
+	for _, c := range []struct {
+		n      int
+		change string
+	}{{kc.Added, resourceAdded}, {kc.Modified, resourceModified}, {kc.Removed, resourceRemoved}} {
+		if c.n > 0 {
+			counts = append(counts, fmt.Sprintf("%d %s", c.n, c.change))
+		}
+	}
+	return kc.Kind + ": " + strings.Join(counts, ", ")
+}
+
+// countByKind counts the changes of the given release diffs per resource kind, sorted by kind
+func countByKind(diffs []releaseDiff) []kindChanges {
+	byKind := map[string]*kindChanges{}
+	for _, rd := range diffs {
+		for _, c := range rd.Changes {
+			kc, ok := byKind[c.Kind]
+			if !ok {
+				kc = &kindChanges{Kind: c.Kind}
+				byKind[c.Kind] = kc
+			}
+			switch c.Change {
+			case resourceAdded:
+				kc.Added++
+			case resourceModified:
+				kc.Modified++
+			case resourceRemoved:
+				kc.Removed++
+			}
+		}
+	}
+	kinds := make([]kindChanges, 0, len(byKind))
+	for _, kc := range byKind {
+		kinds = append(kinds, *kc)
+	}
+	sort.Slice(kinds, func(i, j int) bool { return kinds[i].Kind < kinds[j].Kind })
+	return kinds
+}
+
+// parseDiff extracts the changed resources from a helm diff output
+func parseDiff(output string) []resourceChange {
+	var changes []resourceChange
+	for _, m := range diffHeader.FindAllStringSubmatch(ansiCodes.ReplaceAllString(output, ""), -1) {
+		change := resourceModified
+		switch m[5] {
+		case "has been added":
+			change = resourceAdded
+		case "has been removed":
+			change = resourceRemoved
+		}
+		changes = append(changes, resourceChange{Namespace: m[1], Name: m[2], Kind: m[3], Change: change})
+	}
+	return changes
+}
+
+// diffReport collects the diffs of the releases of a run to write them to disk.
+// The desired states of all the clusters of a run share the same report.
+type diffReport struct {
+	sync.Mutex
+	diffs []releaseDiff
+}
+
+// add records the action planned for a release and its diff, which is empty when there is none.
+// Nothing is recorded in a nil report.
+func (dr *diffReport) add(cluster string, name string, namespace string, action string, output string) {
+	if dr == nil {
+		return
+	}
+	output = ansiCodes.ReplaceAllString(output, "")
+	// the names are escaped so that each of them is a single path element, whatever it contains
+	file := path.Join(url.PathEscape(namespace), url.PathEscape(name)+".diff")
+	if cluster != "" {
+		file = path.Join(url.PathEscape(cluster), file)
+	}
+	dr.Lock()
+	defer dr.Unlock()
+	dr.diffs = append(dr.diffs, releaseDiff{
+		Cluster:   cluster,
+		Name:      name,
+		Namespace: namespace,
+		Action:    action,
+		File:      file,
+		Diff:      output,
+		Changes:   parseDiff(output),
+	})
+}
+
+// write writes the diff of each release to its own file in dir, and an index summing up the changes
+// in the given format. Files written by a previous call are overwritten.
+func (dr *diffReport) write(dir string, format string) error {
+	dr.Lock()
+	defer dr.Unlock()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("while creating the diff report directory: %w", err)
+	}
+
+	diffs := append([]releaseDiff(nil), dr.diffs...)
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].File < diffs[j].File })
+	for _, rd := range diffs {
+		if rd.Diff == "" {
+			continue
+		}
+		file := filepath.Join(dir, filepath.FromSlash(rd.File))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return fmt.Errorf("while writing the diff of release [ %s ]: %w", rd.Name, err)
+		}
+		if err := writeStringToFile(file, rd.Diff); err != nil {
+			return fmt.Errorf("while writing the diff of release [ %s ]: %w", rd.Name, err)
+		}
+	}
+
+	data := struct {
+		Created time.Time
+		Diffs   []releaseDiff
+		Kinds   []kindChanges
+	}{time.Now().UTC(), diffs, countByKind(diffs)}
+
+	var (
+		index   string
+		execute func(io.Writer) error
+	)
+	switch format {
+	case diffReportHTML:
+		index = "index.html"
+		execute = func(w io.Writer) error { return htmlDiffIndex.Execute(w, data) }
+	case diffReportMarkdown, "":
+		index = "index.md"
+		execute = func(w io.Writer) error { return markdownDiffIndex.Execute(w, data) }
+	default:
+		return errors.New("unknown diff report format [ " + format + " ]")
+	}
+	f, err := os.Create(filepath.Join(dir, index))
+	if err != nil {
+		return fmt.Errorf("while writing the diff report index: %w", err)
+	}
+	defer f.Close()
+	if err := execute(f); err != nil {
+		return fmt.Errorf("while writing the diff report index: %w", err)
+	}
+	return nil
+}
+
+// writeDiffReport writes the diffs of the releases of the run to the diff report directory, if one is set in the options
+func (s *state) writeDiffReport() error {
+	if s.opts.DiffReport == "" || s.diffs == nil {
+		return nil
+	}
+	if err := s.diffs.write(s.opts.DiffReport, s.opts.DiffReportFormat); err != nil {
+		return err
+	}
+	s.log.Info("Diff report written to [ " + s.opts.DiffReport + " ]")
+	return nil
+}
+
+var markdownDiffIndex = template.Must(template.New("index.md").Parse(`# Helmsman diff report
+
+Generated on {{ .Created.Format "2006-01-02 15:04:05 MST" }}.
+{{ if .Diffs }}
+| Release | Namespace | Cluster | Action | Added | Modified | Removed | Changes by kind | Diff |
+|---------|-----------|---------|--------|-------|----------|---------|-----------------|------|
+{{ range .Diffs }}| {{ .Name }} | {{ .Namespace }} | {{ .Cluster }} | {{ .Action }} | {{ .Added }} | {{ .Modified }} | {{ .Removed }} | {{ .ByKind }} | {{ if .Diff }}[{{ .File }}]({{ .Link }}){{ else if .Diffed }}no changes{{ else }}not diffed{{ end }} |
+{{ end }}{{ if .Kinds }}
+## Changes by kind
+
+| Kind | Added | Modified | Removed |
+|------|-------|----------|---------|
+{{ range .Kinds }}| {{ .Kind }} | {{ .Added }} | {{ .Modified }} | {{ .Removed }} |
+{{ end }}{{ end }}{{ else }}
+No release was diffed or changed.
+{{ end }}`))
+
+var htmlDiffIndex = htmltemplate.Must(htmltemplate.New("index.html").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Helmsman diff report</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+pre { background: #f6f8fa; padding: 8px; overflow-x: auto; }
+</style>
+</head>
+<body>
+<h1>Helmsman diff report</h1>
+<p>Generated on {{ .Created.Format "2006-01-02 15:04:05 MST" }}.</p>
+{{ if .Diffs }}
+<table>
+<tr><th>Release</th><th>Namespace</th><th>Cluster</th><th>Action</th><th>Added</th><th>Modified</th><th>Removed</th><th>Changes by kind</th><th>Diff</th></tr>
+{{ range .Diffs }}<tr><td>{{ .Name }}</td><td>{{ .Namespace }}</td><td>{{ .Cluster }}</td><td>{{ .Action }}</td><td>{{ .Added }}</td><td>{{ .Modified }}</td><td>{{ .Removed }}</td><td>{{ .ByKind }}</td><td>{{ if .Diff }}<a href="{{ .Link }}">{{ .File }}</a>{{ else if .Diffed }}no changes{{ else }}not diffed{{ end }}</td></tr>
+{{ end }}</table>
+{{ if .Kinds }}
+<h2>Changes by kind</h2>
+<table>
+<tr><th>Kind</th><th>Added</th><th>Modified</th><th>Removed</th></tr>
+{{ range .Kinds }}<tr><td>{{ .Kind }}</td><td>{{ .Added }}</td><td>{{ .Modified }}</td><td>{{ .Removed }}</td></tr>
+{{ end }}</table>
+{{ end }}
+{{ range .Diffs }}{{ if .Diff }}
+<h2 id="{{ .File }}">{{ .Name }} in {{ .Namespace }}{{ if .Cluster }} ({{ .Cluster }}){{ end }}</h2>
+<pre>{{ .Diff }}</pre>
+{{ end }}{{ end }}
+{{ else }}
+<p>No release was diffed or changed.</p>
+{{ end }}
+</body>
+</html>
+`))
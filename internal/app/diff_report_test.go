@@ -0,0 +1,139 @@
+package app
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testDiffOutput = "staging, api, Deployment (apps) has changed:\n" +
+	"  # Source: api/templates/deployment.yaml\n" +
+	"-         image: api:1.0\n" +
+	"+         image: api:1.1\n" +
+	"\x1b[33mstaging, api, Service (v1) has been added:\x1b[0m\n" +
+	"+ kind: Service\n" +
+	"staging, api-config, ConfigMap (v1) has been removed:\n" +
+	"- kind: ConfigMap\n" +
+	"staging, api-worker, Deployment (apps) has been added:\n" +
+	"+ kind: Deployment\n"
+
+func Test_parseDiff(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   []resourceChange
+	}{
+		{
+			name:   "no changes",
+			output: "",
+			want:   nil,
+		}, {
+			name:   "changes",
+			output: testDiffOutput,
+			want: []resourceChange{
+				{Namespace: "staging", Name: "api", Kind: "Deployment", Change: resourceModified},
+				{Namespace: "staging", Name: "api", Kind: "Service", Change: resourceAdded},
+				{Namespace: "staging", Name: "api-config", Kind: "ConfigMap", Change: resourceRemoved},
+				{Namespace: "staging", Name: "api-worker", Kind: "Deployment", Change: resourceAdded},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseDiff(tt.output); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseDiff() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_releaseDiff_counts(t *testing.T) {
+	rd := releaseDiff{Changes: parseDiff(testDiffOutput)}
+	if rd.Added() != 2 || rd.Modified() != 1 || rd.Removed() != 1 {
+		t.Errorf("added = %d, modified = %d, removed = %d, want 2, 1 and 1", rd.Added(), rd.Modified(), rd.Removed())
+	}
+	want := "ConfigMap: 1 removed, Deployment: 1 added, 1 modified, Service: 1 added"
+	if got := rd.ByKind(); got != want {
+		t.Errorf("ByKind() = %q, want %q", got, want)
+	}
+}
+
+func Test_diffReport_write(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		index  string
+		want   []string
+	}{
+		{
+			name:   "markdown",
+			format: diffReportMarkdown,
+			index:  "index.md",
+			want: []string{
+				"| api | staging | prod | upgrade | 2 | 1 | 1 | ConfigMap: 1 removed, Deployment: 1 added, 1 modified, Service: 1 added | [prod/staging/api.diff](prod/staging/api.diff) |",
+				"| web | staging | prod | upgrade | 0 | 0 | 0 |  | no changes |",
+				"| db | staging | prod | delete | 0 | 0 | 0 |  | not diffed |",
+				"| api | staging | eu/west | install | 0 | 0 | 0 |  | not diffed |",
+				"| Deployment | 3 | 3 | 0 |",
+			},
+		}, {
+			name:   "html",
+			format: diffReportHTML,
+			index:  "index.html",
+			want: []string{
+				`<a href="prod/staging/api.diff">prod/staging/api.diff</a>`,
+				`<a href="eu%252Fwest/staging/worker.diff">eu%2Fwest/staging/worker.diff</a>`,
+				"<tr><td>Deployment</td><td>3</td><td>3</td><td>0</td></tr>",
+				"-         image: api:1.0",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "diff-report")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			dr := &diffReport{}
+			dr.add("prod", "api", "staging", actionUpgrade, testDiffOutput)
+			dr.add("prod", "web", "staging", actionUpgrade, "")
+			dr.add("prod", "db", "staging", actionDelete, "")
+			dr.add("eu/west", "api", "staging", actionInstall, "")
+			// would collide with the diff of release api in namespace staging if the names were joined with dashes
+			dr.add("prod-staging", "api", "", actionUpgrade, testDiffOutput)
+			dr.add("eu/west", "worker", "staging", actionUpgrade, testDiffOutput)
+			if err := dr.write(dir, tt.format); err != nil {
+				t.Fatalf("write() error = %v", err)
+			}
+
+			for _, file := range []string{"prod/staging/api.diff", "prod-staging/api.diff", "eu%2Fwest/staging/worker.diff"} {
+				diff, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(file)))
+				if err != nil {
+					t.Fatalf("diff [ %s ] not written: %v", file, err)
+				}
+				if strings.Contains(string(diff), "\x1b[") {
+					t.Errorf("diff [ %s ] has color codes", file)
+				}
+			}
+			for _, file := range []string{"prod/staging/web.diff", "prod/staging/db.diff"} {
+				if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(file))); !os.IsNotExist(err) {
+					t.Errorf("diff [ %s ] of a release without changes written", file)
+				}
+			}
+			index, err := ioutil.ReadFile(filepath.Join(dir, tt.index))
+			if err != nil {
+				t.Fatalf("index not written: %v", err)
+			}
+			for _, w := range tt.want {
+				if !strings.Contains(string(index), w) {
+					t.Errorf("index does not contain %q:\n%s", w, index)
+				}
+			}
+		})
+	}
+}
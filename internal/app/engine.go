@@ -0,0 +1,189 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+)
+
+// Engine makes and applies the plans bringing a cluster to the desired state defined in the desired state files of its options.
+// It is what the helmsman command runs, and can be embedded in other programs through the pkg/helmsman package.
+type Engine struct {
+	ctx context.Context
+	// force stops the commands applying the plan, it is only done when the helmsman command is forced to exit
+	force context.Context
+	opts  Options
+	// log is the logger of the engine, configured from its options
+	log *Logger
+	// tempDir holds the files the engine writes: downloaded and substituted files, decrypted secrets and manifests.
+	// It is not shared with the other engines, and is deleted by Cleanup.
+	tempDir string
+}
+
+// State is a desired state read from desired state files
+type State struct {
+	s       *state
+	cluster string
+}
+
+// CurrentState holds the releases currently deployed to a cluster
+type CurrentState struct {
+	cs *currentState
+}
+
+// Plan holds the decisions made to bring a cluster to the desired state, and the commands carrying them out
+type Plan struct {
+	p *plan
+	s *state
+}
+
+// Decision is a change, or the absence of one, planned for an app or a release
+type Decision struct {
+	Description string
+	Priority    int
+	// Type is one of create, change, delete, noop or ignored
+	Type string
+}
+
+// NewEngine checks the options and the tools Helmsman needs, and returns an engine using them.
+// The context is checked before each step of the engine, which stops as soon as it is done.
+// Cancelling it is the way to stop an engine gracefully.
+// Each engine has its own logger, configured from its options, and its own temp dir.
+func NewEngine(ctx context.Context, opts Options) (*Engine, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	log := newLogger(opts)
+	if err := checkTools(withLogger(ctx, log)); err != nil {
+		return nil, err
+	}
+	return newEngine(ctx, opts, log)
+}
+
+// newEngine returns an engine logging with the given logger, without checking its options and the tools it needs
+func newEngine(ctx context.Context, opts Options, log *Logger) (*Engine, error) {
+	dir, err := ioutil.TempDir("", "helmsman-")
+	if err != nil {
+		return nil, err
+	}
+	return &Engine{ctx: ctx, force: context.Background(), opts: opts, log: log, tempDir: dir}, nil
+}
+
+// LoadState reads, merges and validates the desired state files. The run timeout of the options starts here.
+func (e *Engine) LoadState() (*State, error) {
+	if err := e.ctx.Err(); err != nil {
+		return nil, err
+	}
+	s, err := e.loadState()
+	if err != nil {
+		return nil, err
+	}
+	e.log.slackWebhook = s.Settings.SlackWebhook
+	return &State{s: s}, nil
+}
+
+// ForCluster returns the desired state of one of the clusters defined in a desired state
+func (e *Engine) ForCluster(s *State, cluster string) (*State, error) {
+	for _, c := range s.s.Clusters {
+		if c.Name == cluster {
+			cs, err := s.s.forCluster(c)
+			if err != nil {
+				return nil, err
+			}
+			return &State{s: cs, cluster: c.Name}, nil
+		}
+	}
+	return nil, errors.New("cluster [ " + cluster + " ] is not defined in the desired state")
+}
+
+// BuildCurrentState sets up helm and kubectl for the cluster targeted by a desired state,
+// then reads the releases currently deployed to it and its namespaces.
+func (e *Engine) BuildCurrentState(s *State) (*CurrentState, error) {
+	if err := e.ctx.Err(); err != nil {
+		return nil, err
+	}
+	if len(s.s.Clusters) > 0 {
+		return nil, errors.New("the desired state defines clusters, use ForCluster to get the desired state of one of them")
+	}
+	if err := setupHelm(s.s); err != nil {
+		return nil, err
+	}
+	if err := prepare(s.s, s.cluster); err != nil {
+		return nil, err
+	}
+	cs, err := buildState(s.s)
+	if err != nil {
+		return nil, err
+	}
+	return &CurrentState{cs: cs}, nil
+}
+
+// MakePlan makes the plan bringing the current state of a cluster to the desired state.
+// The diff report of the options, if any, is written once the plan is made.
+func (e *Engine) MakePlan(s *State, cs *CurrentState) (*Plan, error) {
+	if err := e.ctx.Err(); err != nil {
+		return nil, err
+	}
+	p, err := makePlan(s.s, cs.cs, s.cluster)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.s.writeDiffReport(); err != nil {
+		return nil, err
+	}
+	return &Plan{p: p, s: s.s}, nil
+}
+
+// Apply executes the commands of a plan in order. It stops at the first command which fails.
+// Nothing is applied if the plan violates the policies of the desired state, unless the options allow it.
+// Once the context of the engine is done, or the run timeout of its options is reached, the command being executed
+// is left to finish (or to reach its own timeout) but no new one is started.
+// The chart versions resolved from version constraints are written to the lock file once the plan is applied.
+func (e *Engine) Apply(p *Plan) error {
+	if err := e.ctx.Err(); err != nil {
+		return err
+	}
+	if err := p.s.enforcePolicies(p.p, true); err != nil {
+		return err
+	}
+	if err := p.p.exec(p.s.context(), p.s); err != nil {
+		return err
+	}
+	return p.s.writeLockFile()
+}
+
+// Cleanup deletes the temp dir of the engine, with the decrypted secrets files of a desired state
+// and the kubeconfig created to connect to its cluster, if any
+func (e *Engine) Cleanup(s *State) {
+	s.s.cleanup()
+	os.RemoveAll(e.tempDir)
+}
+
+// Decisions returns the decisions of the plan, in the order of their priorities
+func (p *Plan) Decisions() []Decision {
+	decisions := make([]Decision, 0, len(p.p.Decisions))
+	for _, d := range p.p.Decisions {
+		decisions = append(decisions, Decision{Description: d.Description, Priority: d.Priority, Type: d.Type.String()})
+	}
+	return decisions
+}
+
+// Commands returns the description of the commands of the plan, in the order they are executed
+func (p *Plan) Commands() []string {
+	commands := make([]string, 0, len(p.p.Commands))
+	for _, c := range p.p.Commands {
+		commands = append(commands, c.Command.Description)
+	}
+	return commands
+}
+
+// PolicyViolations returns the violations of the policies of the desired state by the plan
+func (p *Plan) PolicyViolations() []string {
+	violations := p.s.checkPolicies(p.p)
+	messages := make([]string, 0, len(violations))
+	for _, v := range violations {
+		messages = append(messages, v.String())
+	}
+	return messages
+}
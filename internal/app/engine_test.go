@@ -0,0 +1,58 @@
+package app
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func Test_Plan_Decisions(t *testing.T) {
+	p := createPlan()
+	p.addDecision("Release [ api ] will be updated", 1, change)
+	p.addDecision("Release [ web ] ignored", 0, ignored)
+	p.addCommand(command{Cmd: helmBin, Description: "Upgrade release [ api ]"}, 1, nil)
+	p.sort()
+
+	plan := &Plan{p: p, s: &state{}}
+	want := []Decision{
+		{Description: "Release [ web ] ignored", Priority: 0, Type: "ignored"},
+		{Description: "Release [ api ] will be updated", Priority: 1, Type: "change"},
+	}
+	if got := plan.Decisions(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Decisions() = %v, want %v", got, want)
+	}
+	if got := plan.Commands(); !reflect.DeepEqual(got, []string{"Upgrade release [ api ]"}) {
+		t.Errorf("Commands() = %v", got)
+	}
+}
+
+func Test_Engine_ForCluster(t *testing.T) {
+	e := &Engine{ctx: context.Background(), opts: DefaultOptions()}
+	s := &State{s: &state{
+		Apps:     map[string]*release{"api": {Name: "api", Namespace: "production"}},
+		Clusters: []cluster{{Name: "eu", KubeContext: "gke-eu"}},
+	}}
+
+	cs, err := e.ForCluster(s, "eu")
+	if err != nil {
+		t.Fatalf("ForCluster() unexpected error: %v", err)
+	}
+	if cs.cluster != "eu" || cs.s.Settings.KubeContext != "gke-eu" {
+		t.Errorf("ForCluster() = cluster %s, kube context %s", cs.cluster, cs.s.Settings.KubeContext)
+	}
+	if _, err := e.ForCluster(s, "us"); err == nil {
+		t.Errorf("ForCluster() of an unknown cluster must fail")
+	}
+	if _, err := e.BuildCurrentState(s); err == nil {
+		t.Errorf("BuildCurrentState() of a desired state with clusters must fail")
+	}
+}
+
+func Test_Engine_cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	e := &Engine{ctx: ctx, opts: DefaultOptions()}
+	if _, err := e.LoadState(); err != context.Canceled {
+		t.Errorf("LoadState() = %v, want %v", err, context.Canceled)
+	}
+}
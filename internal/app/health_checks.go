@@ -0,0 +1,159 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultHealthCheckTimeout  = 300
+	defaultHealthCheckInterval = 10
+)
+
+var rolloutKinds = []string{"deployment", "statefulset", "daemonset"}
+
+// healthCheck type represents a readiness check which must pass after a release is installed or upgraded
+// before the execution of the plan continues.
+// Exactly one of http, rollout, job or command must be set.
+type healthCheck struct {
+	HTTP     string `yaml:"http"`
+	Rollout  string `yaml:"rollout"`
+	Job      string `yaml:"job"`
+	Command  string `yaml:"command"`
+	Timeout  int    `yaml:"timeout"`
+	Interval int    `yaml:"interval"`
+}
+
+// validate checks that exactly one check is defined and that it is valid
+func (hc healthCheck) validate() error {
+	checks := 0
+	for _, c := range []string{hc.HTTP, hc.Rollout, hc.Job, hc.Command} {
+		if c != "" {
+			checks++
+		}
+	}
+	if checks != 1 {
+		return errors.New("exactly one of http, rollout, job or command must be set")
+	}
+	if hc.HTTP != "" {
+		if u, err := url.ParseRequestURI(hc.HTTP); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return errors.New("http must be a valid HTTP(S) URL")
+		}
+	}
+	if hc.Rollout != "" {
+		parts := strings.Split(hc.Rollout, "/")
+		if len(parts) != 2 || parts[1] == "" || !stringInSlice(strings.ToLower(parts[0]), rolloutKinds) {
+			return errors.New("rollout must be of the format kind/name where kind is one of: " + strings.Join(rolloutKinds, ", "))
+		}
+	}
+	if hc.Timeout < 0 || hc.Interval < 0 {
+		return errors.New("timeout and interval can't be negative")
+	}
+	return nil
+}
+
+// getTimeout returns how long the check is retried before it is considered failed
+func (hc healthCheck) getTimeout() time.Duration {
+	if hc.Timeout == 0 {
+		return defaultHealthCheckTimeout * time.Second
+	}
+	return time.Duration(hc.Timeout) * time.Second
+}
+
+// getInterval returns how long to wait between two attempts of the check
+func (hc healthCheck) getInterval() time.Duration {
+	if hc.Interval == 0 {
+		return defaultHealthCheckInterval * time.Second
+	}
+	return time.Duration(hc.Interval) * time.Second
+}
+
+// getCommand returns the command running a single attempt of the check for a release of the given desired state.
+// Rollout and job checks wait up to one interval for the resource to be ready.
+func (hc healthCheck) getCommand(r *release, s *state) command {
+	interval := strconv.Itoa(int(hc.getInterval().Seconds())) + "s"
+	desc := "Health check [ " + hc.describe() + " ] of release [ " + r.Name + " ] in namespace [ " + r.Namespace + " ]"
+	switch {
+	case hc.HTTP != "":
+		return command{
+			Cmd:         "curl",
+			Args:        []string{"--silent", "--show-error", "--fail", "--output", "/dev/null", "--max-time", strconv.Itoa(int(hc.getInterval().Seconds())), hc.HTTP},
+			Description: desc,
+		}
+	case hc.Rollout != "":
+		return kubectl([]string{"rollout", "status", hc.Rollout, "-n", r.Namespace, "--timeout", interval}, desc)
+	case hc.Job != "":
+		return kubectl([]string{"wait", "--for=condition=complete", "job/" + hc.Job, "-n", r.Namespace, "--timeout", interval}, desc)
+	default:
+		e := hookEvent{
+			Hook:      "healthCheck",
+			Action:    "check",
+			Context:   s.Context,
+			Release:   r.Name,
+			Namespace: r.Namespace,
+			Version:   r.Version,
+		}
+		return command{
+			Cmd:         "sh",
+			Args:        []string{"-c", hc.Command},
+			Env:         e.env(),
+			Description: desc,
+		}
+	}
+}
+
+// describe returns a short description of what the check does
+func (hc healthCheck) describe() string {
+	switch {
+	case hc.HTTP != "":
+		return "GET " + hc.HTTP
+	case hc.Rollout != "":
+		return "rollout of " + hc.Rollout
+	case hc.Job != "":
+		return "completion of job/" + hc.Job
+	default:
+		return hc.Command
+	}
+}
+
+// await runs the command of the check until it succeeds, the check times out or the context is done
+func (hc healthCheck) await(ctx context.Context, cmd command) exitStatus {
+	deadline := time.Now().Add(hc.getTimeout())
+	for {
+		result := cmd.exec(ctx)
+		if result.code == 0 {
+			return result
+		}
+		if time.Now().Add(hc.getInterval()).After(deadline) {
+			result.errors = fmt.Sprintf("health check did not pass within %s: %s", hc.getTimeout(), strings.TrimSpace(result.errors))
+			return result
+		}
+		loggerFrom(ctx).Verbose(cmd.Description + " did not pass yet, retrying in " + hc.getInterval().String())
+		select {
+		case <-ctx.Done():
+			result.errors = "health check was interrupted before passing: " + strings.TrimSpace(result.errors)
+			return result
+		case <-time.After(hc.getInterval()):
+		}
+	}
+}
+
+// addHealthChecks adds the health checks of a release to the plan, right after the commands installing or upgrading it.
+// Since the plan is executed in order, the commands coming after them wait for the release to be healthy.
+// Health checks are not run in dry-run mode as nothing gets deployed.
+func (r *release) addHealthChecks(s *state, p *plan) {
+	for _, hc := range r.HealthChecks {
+		cmd := hc.getCommand(r, s).inCluster(s, r.kubeContext)
+		if s.opts.DryRun {
+			p.addDecision(cmd.Description+" -- skipped in dry-run mode", r.Priority, noop)
+			continue
+		}
+		p.addHealthCheck(cmd, r.Priority, hc)
+		p.addDecision(cmd.Description+" must pass within "+hc.getTimeout().String()+" before continuing", r.Priority, noop)
+	}
+}
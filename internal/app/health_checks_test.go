@@ -0,0 +1,161 @@
+package app
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_healthCheck_validate(t *testing.T) {
+	tests := []struct {
+		name string
+		hc   healthCheck
+		want string
+	}{
+		{
+			name: "http check",
+			hc:   healthCheck{HTTP: "http://ingress.example.com/healthz", Timeout: 60, Interval: 5},
+			want: "",
+		}, {
+			name: "rollout check",
+			hc:   healthCheck{Rollout: "deployment/ingress-nginx-controller"},
+			want: "",
+		}, {
+			name: "job check",
+			hc:   healthCheck{Job: "db-migrate"},
+			want: "",
+		}, {
+			name: "no check",
+			hc:   healthCheck{Timeout: 10},
+			want: "exactly one of http, rollout, job or command must be set",
+		}, {
+			name: "several checks",
+			hc:   healthCheck{Job: "db-migrate", Command: "true"},
+			want: "exactly one of http, rollout, job or command must be set",
+		}, {
+			name: "invalid url",
+			hc:   healthCheck{HTTP: "ingress/healthz"},
+			want: "http must be a valid HTTP(S) URL",
+		}, {
+			name: "rollout without kind",
+			hc:   healthCheck{Rollout: "ingress-nginx-controller"},
+			want: "rollout must be of the format kind/name where kind is one of: deployment, statefulset, daemonset",
+		}, {
+			name: "rollout of unsupported kind",
+			hc:   healthCheck{Rollout: "pod/ingress"},
+			want: "rollout must be of the format kind/name where kind is one of: deployment, statefulset, daemonset",
+		}, {
+			name: "negative timeout",
+			hc:   healthCheck{Command: "true", Timeout: -1},
+			want: "timeout and interval can't be negative",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ""
+			if err := tt.hc.validate(); err != nil {
+				got = err.Error()
+			}
+			if got != tt.want {
+				t.Errorf("validate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_healthCheck_getCommand(t *testing.T) {
+	r := &release{Name: "ingress", Namespace: "infra", Version: "1.0.0"}
+	tests := []struct {
+		name     string
+		hc       healthCheck
+		wantCmd  string
+		wantArgs []string
+	}{
+		{
+			name:     "http check",
+			hc:       healthCheck{HTTP: "https://ingress.example.com/healthz"},
+			wantCmd:  "curl",
+			wantArgs: []string{"--silent", "--show-error", "--fail", "--output", "/dev/null", "--max-time", "10", "https://ingress.example.com/healthz"},
+		}, {
+			name:     "rollout check",
+			hc:       healthCheck{Rollout: "deployment/controller", Interval: 5},
+			wantCmd:  "kubectl",
+			wantArgs: []string{"rollout", "status", "deployment/controller", "-n", "infra", "--timeout", "5s"},
+		}, {
+			name:     "job check",
+			hc:       healthCheck{Job: "migrate"},
+			wantCmd:  "kubectl",
+			wantArgs: []string{"wait", "--for=condition=complete", "job/migrate", "-n", "infra", "--timeout", "10s"},
+		}, {
+			name:     "command check",
+			hc:       healthCheck{Command: "./check.sh"},
+			wantCmd:  "sh",
+			wantArgs: []string{"-c", "./check.sh"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.hc.getCommand(r, &state{})
+			if got.Cmd != tt.wantCmd || !reflect.DeepEqual(got.Args, tt.wantArgs) {
+				t.Errorf("getCommand() = %s, want %s %s", got.String(), tt.wantCmd, strings.Join(tt.wantArgs, " "))
+			}
+		})
+	}
+}
+
+func Test_healthCheck_await(t *testing.T) {
+	hc := healthCheck{Timeout: 1, Interval: 1}
+
+	if result := hc.await(context.Background(), command{Cmd: "sh", Args: []string{"-c", "true"}}); result.code != 0 {
+		t.Errorf("await() of a passing check returned exit code %d", result.code)
+	}
+
+	result := hc.await(context.Background(), command{Cmd: "sh", Args: []string{"-c", "echo not ready >&2; exit 3"}})
+	if result.code != 3 {
+		t.Errorf("await() of a failing check returned exit code %d, want 3", result.code)
+	}
+	if want := "health check did not pass within 1s: not ready"; result.errors != want {
+		t.Errorf("await() of a failing check returned error %q, want %q", result.errors, want)
+	}
+}
+
+func Test_healthCheck_await_cancelled(t *testing.T) {
+	hc := healthCheck{Timeout: 60, Interval: 30}
+	ctx, cancel := context.WithCancel(context.Background())
+	// cancelled while waiting for the next check
+	time.AfterFunc(500*time.Millisecond, cancel)
+
+	start := time.Now()
+	result := hc.await(ctx, command{Cmd: "sh", Args: []string{"-c", "echo not ready >&2; exit 3"}})
+	if time.Since(start) > 10*time.Second {
+		t.Errorf("await() with a cancelled context waited for the next check")
+	}
+	if want := "health check was interrupted before passing: not ready"; result.code == 0 || result.errors != want {
+		t.Errorf("await() with a cancelled context returned [ %d ] %q, want a failure with %q", result.code, result.errors, want)
+	}
+}
+
+func Test_release_healthChecksAfterInstall(t *testing.T) {
+	r := &release{
+		Name:         "ingress",
+		Namespace:    "infra",
+		Chart:        "repo/ingress",
+		Version:      "1.0.0",
+		Priority:     -5,
+		HealthChecks: []healthCheck{{Rollout: "deployment/controller"}},
+	}
+	p := createPlan()
+	r.install(&state{}, p)
+
+	if len(p.Commands) != 2 {
+		t.Fatalf("install() added %d commands, want 2", len(p.Commands))
+	}
+	if p.Commands[0].healthCheck != nil || p.Commands[1].healthCheck == nil {
+		t.Errorf("install() health check must come right after the install command")
+	}
+	if p.Commands[1].Priority != r.Priority {
+		t.Errorf("install() health check has priority %d, want %d", p.Commands[1].Priority, r.Priority)
+	}
+}
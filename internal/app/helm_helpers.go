@@ -1,10 +1,13 @@
 package app
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/url"
+	"os"
+	"path"
 	"strings"
 
 	"github.com/Praqma/helmsman/internal/gcs"
@@ -25,12 +28,17 @@ func helmCmd(args []string, desc string) command {
 }
 
 // extractChartName extracts the Helm chart name from full chart name in the desired state.
-func extractChartName(releaseChart string) string {
+// The name of an OCI chart is the last part of its repository path, so no lookup is needed for it.
+func extractChartName(ctx context.Context, releaseChart string) (string, error) {
+	if isOCIChart(releaseChart) {
+		return path.Base(releaseChart), nil
+	}
+
 	cmd := helmCmd([]string{"show", "chart", releaseChart}, "Show chart information")
 
-	result := cmd.exec()
+	result := cmd.exec(ctx)
 	if result.code != 0 {
-		log.Fatal("While getting chart information: " + result.errors)
+		return "", errors.New("while getting chart information: " + result.errors)
 	}
 
 	name := ""
@@ -42,18 +50,18 @@ func extractChartName(releaseChart string) string {
 		}
 	}
 
-	return name
+	return name, nil
 }
 
 // getHelmClientVersion returns Helm client Version
-func getHelmVersion() string {
+func getHelmVersion(ctx context.Context) (string, error) {
 	cmd := helmCmd([]string{"version", "--short", "-c"}, "Checking Helm version")
 
-	result := cmd.exec()
+	result := cmd.exec(ctx)
 	if result.code != 0 {
-		log.Fatal("While checking helm version: " + result.errors)
+		return "", errors.New("while checking helm version: " + result.errors)
 	}
-	return result.output
+	return result.output, nil
 }
 
 // helmPluginExists returns true if the plugin is present in the environment and false otherwise.
@@ -61,7 +69,7 @@ func getHelmVersion() string {
 func helmPluginExists(plugin string) bool {
 	cmd := helmCmd([]string{"plugin", "list"}, "Validating that [ "+plugin+" ] is installed")
 
-	result := cmd.exec()
+	result := cmd.exec(context.Background())
 
 	if result.code != 0 {
 		return false
@@ -71,27 +79,67 @@ func helmPluginExists(plugin string) bool {
 }
 
 // updateChartDep updates dependencies for a local chart
-func updateChartDep(chartPath string) error {
+func updateChartDep(ctx context.Context, chartPath string) error {
 	cmd := helmCmd([]string{"dependency", "update", chartPath}, "Updating dependency for local chart [ "+chartPath+" ]")
 
-	result := cmd.exec()
+	result := cmd.exec(ctx)
 	if result.code != 0 {
 		return errors.New(result.errors)
 	}
 	return nil
 }
 
+// loginOCIRegistries logs in to the OCI registries hosting the charts of the apps to run.
+// Credentials are taken from the ociRegistries stanza of the DSF or, for registries not defined there,
+// from the HELM_REGISTRY_USERNAME and HELM_REGISTRY_PASSWORD env variables.
+// Registries without credentials are assumed to be public or already logged in to.
+func loginOCIRegistries(s *state) error {
+	hosts := make(map[string]bool)
+	for _, r := range s.Apps {
+		if isOCIChart(r.Chart) && r.isConsideredToRun(s) {
+			hosts[ociRegistryHost(r.Chart)] = true
+		}
+	}
+
+	for host := range hosts {
+		reg, ok := s.OCIRegistries[host]
+		if !ok {
+			reg = ociRegistry{Username: os.Getenv("HELM_REGISTRY_USERNAME"), Password: os.Getenv("HELM_REGISTRY_PASSWORD")}
+		}
+		if reg.Username == "" || reg.Password == "" {
+			s.log.Verbose("No credentials found for OCI registry [ " + host + " ], assuming it does not need a login")
+			continue
+		}
+
+		// the password is passed on stdin to keep it out of the process list and the debug logs
+		args := []string{"registry", "login", host, "--username", reg.Username, "--password-stdin"}
+		if reg.Insecure {
+			args = append(args, "--insecure")
+		}
+		cmd := helmCmd(args, "Logging in to OCI registry [ "+host+" ]").withTimeout(s.commandTimeout(reposPhase))
+		cmd.Stdin = reg.Password
+		if result := cmd.exec(s.context()); result.code != 0 {
+			return fmt.Errorf("while logging in to OCI registry [ %s ]: %s", host, result.errors)
+		}
+	}
+	return nil
+}
+
 // addHelmRepos adds repositories to Helm if they don't exist already.
 // Helm does not mind if a repo with the same name exists. It treats it as an update.
-func addHelmRepos(repos map[string]string) error {
+// The helm commands are stopped if they run for longer than the repo timeout, and retried if they fail with a transient error.
+func addHelmRepos(s *state) error {
 	var helmRepos []helmRepo
+	repos := s.HelmRepos
+	timeout := s.commandTimeout(reposPhase)
+	ctx := s.context()
 	existingRepos := make(map[string]string)
 
 	// get existing helm repositories
-	cmdList := helmCmd(concat([]string{"repo", "list", "--output", "json"}), "Listing helm repositories")
-	if reposResult := cmdList.exec(); reposResult.code == 0 {
+	cmdList := helmCmd(concat([]string{"repo", "list", "--output", "json"}), "Listing helm repositories").withTimeout(timeout)
+	if reposResult := cmdList.retryExec(ctx, s.getRetryPolicy(nil), s.retries); reposResult.code == 0 {
 		if err := json.Unmarshal([]byte(reposResult.output), &helmRepos); err != nil {
-			log.Fatal(fmt.Sprintf("failed to unmarshal Helm CLI output: %s", err))
+			return fmt.Errorf("failed to unmarshal Helm CLI output: %w", err)
 		}
 		// create map of existing repositories
 		for _, repo := range helmRepos {
@@ -109,43 +157,43 @@ func addHelmRepos(repos map[string]string) error {
 		// failed auth would not throw an error here, as it is possible that the repo is public and does not need authentication
 		if strings.HasPrefix(repoLink, "gs://") {
 			if !helmPluginExists("gcs") {
-				log.Fatal(fmt.Sprintf("repository %s can't be used: helm-gcs plugin is missing", repoLink))
+				return fmt.Errorf("repository %s can't be used: helm-gcs plugin is missing", repoLink)
 			}
 			msg, err := gcs.Auth()
 			if err != nil {
-				log.Fatal(msg)
+				return errors.New(msg)
 			}
 		}
 
 		u, err := url.Parse(repoLink)
 		if err != nil {
-			log.Fatal("failed to add helm repo:  " + err.Error())
+			return errors.New("failed to add helm repo: " + err.Error())
 		}
 		if u.User != nil {
 			p, ok := u.User.Password()
 			if !ok {
-				log.Fatal("helm repo " + repoName + " has incomplete basic auth info. Missing the password!")
+				return errors.New("helm repo " + repoName + " has incomplete basic auth info. Missing the password!")
 			}
 			basicAuthArgs = append(basicAuthArgs, "--username", u.User.Username(), "--password", p)
 
 		}
 
-		cmd := helmCmd(concat([]string{"repo", "add", repoName, repoLink}, basicAuthArgs), "Adding helm repository [ "+repoName+" ]")
+		cmd := helmCmd(concat([]string{"repo", "add", repoName, repoLink}, basicAuthArgs), "Adding helm repository [ "+repoName+" ]").withTimeout(timeout)
 		// check current repository against existing repositories map in order to make sure it's missing and needs to be added
 		if existingRepoUrl, ok := existingRepos[repoName]; ok {
 			if repoLink == existingRepoUrl {
 				continue
 			}
 		}
-		if result := cmd.exec(); result.code != 0 {
+		if result := cmd.retryExec(ctx, s.getRetryPolicy(nil), s.retries); result.code != 0 {
 			return fmt.Errorf("While adding helm repository ["+repoName+"]: %s", result.errors)
 		}
 	}
 
 	if len(repos) > 0 {
-		cmd := helmCmd([]string{"repo", "update"}, "Updating helm repositories")
+		cmd := helmCmd([]string{"repo", "update"}, "Updating helm repositories").withTimeout(timeout)
 
-		if result := cmd.exec(); result.code != 0 {
+		if result := cmd.retryExec(ctx, s.getRetryPolicy(nil), s.retries); result.code != 0 {
 			return errors.New("While updating helm repos : " + result.errors)
 		}
 	}
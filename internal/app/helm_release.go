@@ -2,6 +2,7 @@ package app
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"regexp"
 	"strconv"
@@ -9,11 +10,16 @@ import (
 	"sync"
 )
 
+// statuses of helm 3 releases
 const (
-	helmStatusDeployed = "deployed"
-	helmStatusDeleted  = "deleted"
-	helmStatusFailed   = "failed"
-	helmStatusPending  = "pending-upgrade"
+	helmStatusDeployed        = "deployed"
+	helmStatusUninstalled     = "uninstalled"
+	helmStatusUninstalling    = "uninstalling"
+	helmStatusSuperseded      = "superseded"
+	helmStatusFailed          = "failed"
+	helmStatusPendingInstall  = "pending-install"
+	helmStatusPendingUpgrade  = "pending-upgrade"
+	helmStatusPendingRollback = "pending-rollback"
 )
 
 // helmRelease represents the current state of a release
@@ -26,34 +32,42 @@ type helmRelease struct {
 	Chart           string   `json:"Chart"`
 	AppVersion      string   `json:"AppVersion,omitempty"`
 	HelmsmanContext string
+	kubeContext     string
 }
 
 // getHelmReleases fetches a list of all releases in a k8s cluster
-func getHelmReleases(s *state) []helmRelease {
+func getHelmReleases(s *state) ([]helmRelease, error) {
 	var (
 		allReleases []helmRelease
 		wg          sync.WaitGroup
 		mutex       = &sync.Mutex{}
 		namespaces  map[string]namespace
+		errs        errorList
 	)
 	if len(s.TargetMap) > 0 {
 		namespaces = s.TargetNamespaces
 	} else {
 		namespaces = s.Namespaces
 	}
+	ctx := s.context()
 	for ns := range namespaces {
 		wg.Add(1)
 		go func(ns string) {
 			var releases []helmRelease
 			var targetReleases []helmRelease
 			defer wg.Done()
-			cmd := helmCmd([]string{"list", "--all", "--max", "0", "--output", "json", "-n", ns}, "Listing all existing releases in [ "+ns+" ] namespace...")
-			result := cmd.exec()
+			cmd := helmCmd([]string{"list", "--all", "--max", "0", "--output", "json", "-n", ns}, "Listing all existing releases in [ "+ns+" ] namespace...").inCluster(s, s.Settings.KubeContext).withTimeout(s.commandTimeout(statePhase))
+			result := cmd.retryExec(ctx, s.getRetryPolicy(nil), s.retries)
 			if result.code != 0 {
-				log.Fatal("Failed to list all releases: " + result.errors)
+				errs.add(errors.New("failed to list all releases of namespace [ " + ns + " ]: " + result.errors))
+				return
 			}
 			if err := json.Unmarshal([]byte(result.output), &releases); err != nil {
-				log.Fatal(fmt.Sprintf("failed to unmarshal Helm CLI output: %s", err))
+				errs.add(fmt.Errorf("failed to unmarshal Helm CLI output: %w", err))
+				return
+			}
+			for i := range releases {
+				releases[i].kubeContext = s.Settings.KubeContext
 			}
 			if len(s.TargetMap) > 0 {
 				for _, r := range releases {
@@ -70,7 +84,10 @@ func getHelmReleases(s *state) []helmRelease {
 		}(ns)
 	}
 	wg.Wait()
-	return allReleases
+	if err := errs.err(); err != nil {
+		return nil, err
+	}
+	return allReleases, nil
 }
 
 func (r *helmRelease) key() string {
@@ -78,10 +95,11 @@ func (r *helmRelease) key() string {
 }
 
 // uninstall creates the helm command to uninstall an untracked release
-func (r *helmRelease) uninstall(p *plan) {
-	cmd := helmCmd(concat([]string{"uninstall", r.Name, "--namespace", r.Namespace}, flags.getDryRunFlags()), "Delete untracked release [ "+r.Name+" ] in namespace [ "+r.Namespace+" ]")
+func (r *helmRelease) uninstall(s *state, p *plan) {
+	cmd := helmCmd(concat([]string{"uninstall", r.Name, "--namespace", r.Namespace}, s.opts.getDryRunFlags()), "Delete untracked release [ "+r.Name+" ] in namespace [ "+r.Namespace+" ]").inCluster(s, r.kubeContext)
 
 	p.addCommand(cmd, -800, nil)
+	s.diffs.add(s.cluster, r.Name, r.Namespace, actionDelete, "")
 }
 
 // getRevision returns the revision number for an existing helm release
@@ -91,11 +109,12 @@ func (r *helmRelease) getRevision() string {
 
 // getChartName extracts and returns the Helm chart name from the chart info in a release state.
 // example: chart in release state is "jenkins-0.9.0" and this function will extract "jenkins" from it.
+// Chart names containing dots or hyphens followed by digits (e.g. "k8s.io-agent-1.0.0") are handled as well.
 func (r *helmRelease) getChartName() string {
-
-	chart := r.Chart
-	runes := []rune(chart)
-	return string(runes[0:strings.LastIndexByte(chart[0:strings.IndexByte(chart, '.')], '-')])
+	if version := r.getChartVersion(); version != "" {
+		return strings.TrimSuffix(r.Chart, "-"+version)
+	}
+	return r.Chart
 }
 
 // getChartVersion extracts and returns the Helm chart version from the chart info in a release state.
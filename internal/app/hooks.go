@@ -0,0 +1,216 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// hook types which can be defined for apps
+const (
+	preInstall  = "preInstall"
+	postInstall = "postInstall"
+	preUpgrade  = "preUpgrade"
+	postUpgrade = "postUpgrade"
+	preDelete   = "preDelete"
+	postDelete  = "postDelete"
+)
+
+// hook types which can be defined for a whole Helmsman run
+const (
+	preApply  = "preApply"
+	postApply = "postApply"
+)
+
+var (
+	releaseHookTypes = []string{preInstall, postInstall, preUpgrade, postUpgrade, preDelete, postDelete}
+	stateHookTypes   = []string{preApply, postApply}
+	hookHTTPMethods  = []string{"GET", "POST", "PUT", "PATCH", "DELETE"}
+)
+
+// hook type represents an action to run before or after a release operation or a Helmsman run.
+// Exactly one of command (run with sh -c), manifest (applied with kubectl) or url (called with curl) must be set.
+type hook struct {
+	Command  string `yaml:"command"`
+	Manifest string `yaml:"manifest"`
+	URL      string `yaml:"url"`
+	Method   string `yaml:"method"`
+}
+
+// hookEvent describes the operation a hook runs for.
+// It is passed to command hooks as env variables and to HTTP hooks as a JSON request body.
+type hookEvent struct {
+	Hook      string `json:"hook"`
+	Action    string `json:"action"`
+	Context   string `json:"context"`
+	Release   string `json:"release,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	Version   string `json:"version,omitempty"`
+}
+
+// env returns the event as env variables
+func (e hookEvent) env() []string {
+	return []string{
+		"HELMSMAN_HOOK=" + e.Hook,
+		"HELMSMAN_ACTION=" + e.Action,
+		"HELMSMAN_CONTEXT=" + e.Context,
+		"HELMSMAN_RELEASE_NAME=" + e.Release,
+		"HELMSMAN_RELEASE_NAMESPACE=" + e.Namespace,
+		"HELMSMAN_RELEASE_VERSION=" + e.Version,
+	}
+}
+
+// validateHooks checks that the hooks are of the allowed types and are valid
+func validateHooks(hooks map[string]hook, allowed []string) error {
+	for hookType, h := range hooks {
+		if !stringInSlice(hookType, allowed) {
+			return errors.New("hook type [ " + hookType + " ] is invalid, valid types are: " + strings.Join(allowed, ", "))
+		}
+		if err := h.validate(); err != nil {
+			return fmt.Errorf("hook [ %s ] is invalid: %w", hookType, err)
+		}
+	}
+	return nil
+}
+
+// validate checks that exactly one action is defined for a hook and that it is valid
+func (h hook) validate() error {
+	actions := 0
+	for _, a := range []string{h.Command, h.Manifest, h.URL} {
+		if a != "" {
+			actions++
+		}
+	}
+	if actions != 1 {
+		return errors.New("exactly one of command, manifest or url must be set")
+	}
+	if h.Manifest != "" {
+		if _, err := os.Stat(h.Manifest); err != nil || !isOfType(h.Manifest, []string{".yaml", ".yml", ".json"}) {
+			return fmt.Errorf("manifest must be a valid relative (from dsf file) file path for a yaml file (provided path resolved to %q)", h.Manifest)
+		}
+	}
+	if h.URL != "" {
+		if u, err := url.ParseRequestURI(h.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return errors.New("url must be a valid HTTP(S) URL")
+		}
+	}
+	if h.Method != "" {
+		if h.URL == "" {
+			return errors.New("method can only be used with url")
+		}
+		if !stringInSlice(strings.ToUpper(h.Method), hookHTTPMethods) {
+			return errors.New("method must be one of: " + strings.Join(hookHTTPMethods, ", "))
+		}
+	}
+	return nil
+}
+
+// getCommand returns the command running the hook for an event.
+// Manifests of release hooks are applied in the release namespace unless they define their own.
+func (h hook) getCommand(e hookEvent, desc string) command {
+	switch {
+	case h.Manifest != "":
+		args := []string{"apply", "-f", h.Manifest}
+		if e.Namespace != "" {
+			args = append(args, "-n", e.Namespace)
+		}
+		return kubectl(args, desc)
+	case h.URL != "":
+		method := "POST"
+		if h.Method != "" {
+			method = strings.ToUpper(h.Method)
+		}
+		body, _ := json.Marshal(e)
+		return command{
+			Cmd:         "curl",
+			Args:        []string{"--silent", "--show-error", "--fail", "--request", method, "--header", "Content-Type: application/json", "--data", string(body), h.URL},
+			Description: desc,
+		}
+	default:
+		return command{
+			Cmd:         "sh",
+			Args:        []string{"-c", h.Command},
+			Env:         e.env(),
+			Description: desc,
+		}
+	}
+}
+
+// describe returns a short description of what the hook does
+func (h hook) describe() string {
+	switch {
+	case h.Manifest != "":
+		return "apply manifest [ " + h.Manifest + " ]"
+	case h.URL != "":
+		method := "POST"
+		if h.Method != "" {
+			method = strings.ToUpper(h.Method)
+		}
+		return "call [ " + method + " " + h.URL + " ]"
+	default:
+		return "run command [ " + h.Command + " ]"
+	}
+}
+
+// addHook adds the hook of the given type defined for a release (if any) to the plan.
+// Hooks are not run in dry-run mode as they may have side effects helm can't simulate.
+func (r *release) addHook(s *state, p *plan, hookType string, action string, priority int) {
+	h, ok := r.Hooks[hookType]
+	if !ok {
+		return
+	}
+	desc := "Hook [ " + hookType + " ] of release [ " + r.Name + " ] in namespace [ " + r.Namespace + " ]: " + h.describe()
+	if s.opts.DryRun {
+		p.addDecision(desc+" -- skipped in dry-run mode", priority, noop)
+		return
+	}
+	e := hookEvent{
+		Hook:      hookType,
+		Action:    action,
+		Context:   s.Context,
+		Release:   r.Name,
+		Namespace: r.Namespace,
+		Version:   r.Version,
+	}
+	p.addHook(h.getCommand(e, desc).inCluster(s, r.kubeContext), priority)
+	p.addDecisionFor(r.Namespace, r.Name, desc, priority, change)
+}
+
+// addApplyHooks adds the preApply and postApply hooks of the desired state to the plan.
+// They get priorities lower and higher than all the planned commands so they run first and last.
+// Nothing is added when the plan has no commands to execute.
+func (s *state) addApplyHooks(p *plan) {
+	if len(s.Hooks) == 0 || len(p.Commands) == 0 {
+		return
+	}
+	first, last := p.priorityRange()
+
+	for _, hookType := range stateHookTypes {
+		h, ok := s.Hooks[hookType]
+		if !ok {
+			continue
+		}
+		priority := first
+		if hookType == postApply {
+			priority = last
+		}
+		desc := "Hook [ " + hookType + " ]: " + h.describe()
+		if s.opts.DryRun {
+			p.addDecision(desc+" -- skipped in dry-run mode", priority, noop)
+			continue
+		}
+		e := hookEvent{
+			Hook:    hookType,
+			Action:  "apply",
+			Context: s.Context,
+		}
+		if s.opts.Destroy {
+			e.Action = "destroy"
+		}
+		p.addHook(h.getCommand(e, desc).inCluster(s, s.Settings.KubeContext), priority)
+		p.addDecision(desc, priority, change)
+	}
+}
@@ -0,0 +1,181 @@
+package app
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_hook_validate(t *testing.T) {
+	tests := []struct {
+		name string
+		h    hook
+		want string
+	}{
+		{
+			name: "command hook",
+			h:    hook{Command: "./migrate.sh"},
+			want: "",
+		}, {
+			name: "manifest hook",
+			h:    hook{Manifest: "../../tests/values.yaml"},
+			want: "",
+		}, {
+			name: "url hook with method",
+			h:    hook{URL: "https://example.com/deploys", Method: "put"},
+			want: "",
+		}, {
+			name: "no action",
+			h:    hook{},
+			want: "exactly one of command, manifest or url must be set",
+		}, {
+			name: "several actions",
+			h:    hook{Command: "true", URL: "https://example.com"},
+			want: "exactly one of command, manifest or url must be set",
+		}, {
+			name: "missing manifest",
+			h:    hook{Manifest: "missing.yaml"},
+			want: "manifest must be a valid relative (from dsf file) file path for a yaml file (provided path resolved to \"missing.yaml\")",
+		}, {
+			name: "non HTTP url",
+			h:    hook{URL: "s3://bucket/hook"},
+			want: "url must be a valid HTTP(S) URL",
+		}, {
+			name: "method without url",
+			h:    hook{Command: "true", Method: "POST"},
+			want: "method can only be used with url",
+		}, {
+			name: "invalid method",
+			h:    hook{URL: "https://example.com", Method: "TRACE"},
+			want: "method must be one of: GET, POST, PUT, PATCH, DELETE",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ""
+			if err := tt.h.validate(); err != nil {
+				got = err.Error()
+			}
+			if got != tt.want {
+				t.Errorf("validate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_validateHooks(t *testing.T) {
+	hooks := map[string]hook{preApply: {Command: "true"}}
+	if err := validateHooks(hooks, stateHookTypes); err != nil {
+		t.Errorf("validateHooks() unexpected error: %v", err)
+	}
+	if err := validateHooks(hooks, releaseHookTypes); err == nil {
+		t.Errorf("validateHooks() expected an error for a preApply hook on a release")
+	}
+}
+
+func Test_hook_getCommand(t *testing.T) {
+	e := hookEvent{Hook: preUpgrade, Action: "upgrade", Context: "ctx", Release: "app", Namespace: "staging", Version: "1.0.0"}
+	tests := []struct {
+		name string
+		h    hook
+		want command
+	}{
+		{
+			name: "command hook gets the event as env variables",
+			h:    hook{Command: "./migrate.sh"},
+			want: command{
+				Cmd:  "sh",
+				Args: []string{"-c", "./migrate.sh"},
+				Env: []string{"HELMSMAN_HOOK=preUpgrade", "HELMSMAN_ACTION=upgrade", "HELMSMAN_CONTEXT=ctx",
+					"HELMSMAN_RELEASE_NAME=app", "HELMSMAN_RELEASE_NAMESPACE=staging", "HELMSMAN_RELEASE_VERSION=1.0.0"},
+				Description: "desc",
+			},
+		}, {
+			name: "manifest hook is applied in the release namespace",
+			h:    hook{Manifest: "job.yaml"},
+			want: command{
+				Cmd:         "kubectl",
+				Args:        []string{"apply", "-f", "job.yaml", "-n", "staging"},
+				Description: "desc",
+			},
+		}, {
+			name: "url hook gets the event as JSON body",
+			h:    hook{URL: "https://example.com/deploys"},
+			want: command{
+				Cmd: "curl",
+				Args: []string{"--silent", "--show-error", "--fail", "--request", "POST", "--header", "Content-Type: application/json", "--data",
+					`{"hook":"preUpgrade","action":"upgrade","context":"ctx","release":"app","namespace":"staging","version":"1.0.0"}`, "https://example.com/deploys"},
+				Description: "desc",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.h.getCommand(e, "desc"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getCommand() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_release_hooksAroundUpgrade(t *testing.T) {
+	r := &release{
+		Name:      "app",
+		Namespace: "staging",
+		Chart:     "repo/app",
+		Version:   "1.0.0",
+		Priority:  -2,
+		Hooks: map[string]hook{
+			preUpgrade:  {Command: "./migrate.sh"},
+			postUpgrade: {URL: "https://example.com/deploys"},
+		},
+	}
+	p := createPlan()
+	r.upgrade(&state{}, p)
+
+	var got []string
+	for _, c := range p.Commands {
+		got = append(got, c.Command.Cmd)
+		if c.Priority != r.Priority {
+			t.Errorf("upgrade() command [ %s ] has priority %d, want %d", c.Command.Cmd, c.Priority, r.Priority)
+		}
+		if c.hook != (c.Command.Cmd != helmBin) {
+			t.Errorf("upgrade() command [ %s ] is a hook: %v", c.Command.Cmd, c.hook)
+		}
+	}
+	want := []string{"sh", helmBin, "curl"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("upgrade() commands = %v, want %v", got, want)
+	}
+	if len(p.Decisions) != 2 {
+		t.Errorf("upgrade() added %d decisions, want 2", len(p.Decisions))
+	}
+}
+
+func Test_state_addApplyHooks(t *testing.T) {
+	s := &state{
+		Hooks: map[string]hook{
+			preApply:  {Command: "echo start"},
+			postApply: {Command: "echo done"},
+		},
+	}
+
+	p := createPlan()
+	s.addApplyHooks(p)
+	if len(p.Commands) != 0 {
+		t.Errorf("addApplyHooks() added hooks to an empty plan")
+	}
+
+	p.addCommand(command{Cmd: helmBin, Description: "first"}, -5, nil)
+	p.addCommand(command{Cmd: helmBin, Description: "last"}, 3, nil)
+	s.addApplyHooks(p)
+	p.sort()
+
+	var got []string
+	for _, c := range p.Commands {
+		got = append(got, c.Command.Description)
+	}
+	want := []string{"Hook [ preApply ]: run command [ echo start ]", "first", "last", "Hook [ postApply ]: run command [ echo done ]"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("addApplyHooks() commands = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,187 @@
+package app
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// withKubeconfig returns a copy of the command using the given kubeconfig rather than the one of the user:
+// helm and kubectl get the --kubeconfig flag, and other commands such as hooks get the KUBECONFIG env variable.
+// An empty kubeconfig leaves the command as it is.
+func (c command) withKubeconfig(kubeconfig string) command {
+	if kubeconfig == "" {
+		return c
+	}
+	switch c.Cmd {
+	case helmBin, "kubectl":
+		c.Args = concat(c.Args, []string{"--kubeconfig", kubeconfig})
+	default:
+		c.Env = concat(c.Env, []string{"KUBECONFIG=" + kubeconfig})
+	}
+	return c
+}
+
+// kubeconfigCredentials are the paths of the certificates and token used to connect to a cluster
+type kubeconfigCredentials struct {
+	caCrt    string
+	caKey    string
+	caClient string
+	token    string
+}
+
+// kubeconfigManifest returns a kubeconfig with a single context connecting to the cluster of the desired state
+func kubeconfigManifest(s *state, creds kubeconfigCredentials) (string, error) {
+	type cluster struct {
+		Server               string `yaml:"server"`
+		CertificateAuthority string `yaml:"certificate-authority,omitempty"`
+	}
+	type user struct {
+		Token             string      `yaml:"token,omitempty"`
+		Username          string      `yaml:"username,omitempty"`
+		Password          string      `yaml:"password,omitempty"`
+		ClientKey         string      `yaml:"client-key,omitempty"`
+		ClientCertificate string      `yaml:"client-certificate,omitempty"`
+		Exec              *execConfig `yaml:"exec,omitempty"`
+	}
+	type kubeContext struct {
+		Cluster string `yaml:"cluster"`
+		User    string `yaml:"user"`
+	}
+	type namedCluster struct {
+		Name    string  `yaml:"name"`
+		Cluster cluster `yaml:"cluster"`
+	}
+	type namedUser struct {
+		Name string `yaml:"name"`
+		User user   `yaml:"user"`
+	}
+	type namedContext struct {
+		Name    string      `yaml:"name"`
+		Context kubeContext `yaml:"context"`
+	}
+
+	kctx := s.Settings.KubeContext
+	u := user{}
+	if s.Settings.Auth != nil {
+		u.Exec = s.Settings.Auth.execConfig()
+	} else if s.Settings.BearerToken {
+		u.Token = creds.token
+	} else {
+		u.Username = s.Settings.Username
+		u.Password = s.Settings.Password
+		u.ClientKey = creds.caKey
+		u.ClientCertificate = creds.caClient
+	}
+	d, err := yaml.Marshal(struct {
+		APIVersion     string         `yaml:"apiVersion"`
+		Kind           string         `yaml:"kind"`
+		Clusters       []namedCluster `yaml:"clusters"`
+		Users          []namedUser    `yaml:"users"`
+		Contexts       []namedContext `yaml:"contexts"`
+		CurrentContext string         `yaml:"current-context"`
+	}{
+		APIVersion:     "v1",
+		Kind:           "Config",
+		Clusters:       []namedCluster{{kctx, cluster{s.Settings.ClusterURI, creds.caCrt}}},
+		Users:          []namedUser{{s.Settings.Username, u}},
+		Contexts:       []namedContext{{kctx, kubeContext{kctx, s.Settings.Username}}},
+		CurrentContext: kctx,
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(d), nil
+}
+
+// createContext creates a kubeconfig connecting to the k8s cluster of the desired state, with its kube context.
+// The kubeconfig and the certificates it uses are written to a private directory inside the temp dir of the state,
+// which is deleted on exit. Its path is kept on the state, and all the commands run against the cluster
+// of the state get it from then on, so that the kubeconfig of the user is never changed.
+func createContext(s *state) error {
+	if s.Settings.Auth != nil {
+		s.log.Info("Creating kube context with credentials from the " + s.Settings.Auth.Provider + " provider.")
+	} else if s.Settings.BearerToken && s.Settings.BearerTokenPath == "" {
+		s.log.Info("Creating kube context with bearer token from K8S service account.")
+		s.Settings.BearerTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	} else if s.Settings.BearerToken && s.Settings.BearerTokenPath != "" {
+		s.log.Info("Creating kube context with bearer token from " + s.Settings.BearerTokenPath)
+	} else if s.Settings.Password == "" || s.Settings.Username == "" || s.Settings.ClusterURI == "" {
+		return errors.New("missing information to create context [ " + s.Settings.KubeContext + " ] " +
+			"you are either missing PASSWORD, USERNAME or CLUSTERURI in the Settings section of your desired state file.")
+	} else if !s.Settings.BearerToken && (s.Certificates == nil || s.Certificates["caCrt"] == "" || s.Certificates["caKey"] == "") {
+		return errors.New("missing information to create context [ " + s.Settings.KubeContext + " ] " +
+			"you are either missing caCrt or caKey or both in the Certifications section of your desired state file.")
+	} else if s.Settings.BearerToken && (s.Certificates == nil || s.Certificates["caCrt"] == "") {
+		return errors.New("missing information to create context [ " + s.Settings.KubeContext + " ] " +
+			"caCrt is missing in the Certifications section of your desired state file.")
+	}
+
+	// ioutil.TempDir creates the directory readable by the current user only
+	dir, err := ioutil.TempDir(s.tempDir, "kube-")
+	if err != nil {
+		return errors.New("failed to create context [ " + s.Settings.KubeContext + " ]: " + err.Error())
+	}
+	if err := os.Chmod(dir, 0700); err != nil {
+		os.RemoveAll(dir)
+		return errors.New("failed to create context [ " + s.Settings.KubeContext + " ]: " + err.Error())
+	}
+
+	// download certs and keys
+	// GCS bucket+file format should be: gs://bucket-name/dir.../filename.ext
+	// S3 bucket+file format should be: s3://bucket-name/dir.../filename.ext
+	ctx := s.context()
+	files := map[string]string{
+		"ca.crt":     s.Certificates["caCrt"],
+		"ca.key":     s.Certificates["caKey"],
+		"client.crt": s.Certificates["caClient"],
+	}
+	if s.Settings.BearerToken {
+		files["bearer.token"] = s.Settings.BearerTokenPath
+	}
+	local := map[string]string{}
+	for name, file := range files {
+		if file == "" {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		if _, err := downloadFile(ctx, file, path); err != nil {
+			return err
+		}
+		if err := os.Chmod(path, 0600); err != nil {
+			return err
+		}
+		local[name] = path
+	}
+
+	creds := kubeconfigCredentials{caCrt: local["ca.crt"], caKey: local["ca.key"], caClient: local["client.crt"]}
+	if s.Settings.BearerToken {
+		token, err := readFile(local["bearer.token"])
+		if err != nil {
+			return err
+		}
+		creds.token = strings.TrimSpace(token)
+	}
+	if (s.Settings.BearerToken || s.Settings.Auth != nil) && s.Settings.Username == "" {
+		s.Settings.Username = "helmsman"
+	}
+	definition, err := kubeconfigManifest(s, creds)
+	if err != nil {
+		return errors.New("failed to create context [ " + s.Settings.KubeContext + " ]: " + err.Error())
+	}
+	path := filepath.Join(dir, "kubeconfig")
+	if err := ioutil.WriteFile(path, []byte(definition), 0600); err != nil {
+		return errors.New("failed to create context [ " + s.Settings.KubeContext + " ]: " + err.Error())
+	}
+	s.kubeconfig = path
+	s.log.Info("Created kube context [ " + s.Settings.KubeContext + " ] in a temporary kubeconfig")
+
+	if !kubeContextExists(s, s.Settings.KubeContext) {
+		return errors.New("something went wrong while creating the kube context [ " + s.Settings.KubeContext + " ]")
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package app
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_command_withKubeconfig(t *testing.T) {
+	tests := []struct {
+		name       string
+		cmd        command
+		kubeconfig string
+		wantArgs   []string
+		wantEnv    []string
+	}{
+		{
+			name:     "no isolated kubeconfig",
+			cmd:      kubectl([]string{"get", "namespaces"}, ""),
+			wantArgs: []string{"get", "namespaces"},
+		}, {
+			name:       "kubectl",
+			cmd:        kubectl([]string{"get", "namespaces"}, ""),
+			kubeconfig: "/tmp/helmsman-kube-1/kubeconfig",
+			wantArgs:   []string{"get", "namespaces", "--kubeconfig", "/tmp/helmsman-kube-1/kubeconfig"},
+		}, {
+			name:       "helm",
+			cmd:        helmCmd([]string{"list"}, ""),
+			kubeconfig: "/tmp/helmsman-kube-1/kubeconfig",
+			wantArgs:   []string{"list", "--kubeconfig", "/tmp/helmsman-kube-1/kubeconfig"},
+		}, {
+			name:       "hook",
+			cmd:        command{Cmd: "sh", Args: []string{"-c", "kubectl get pods"}, Env: []string{"HELMSMAN_HOOK=preApply"}},
+			kubeconfig: "/tmp/helmsman-kube-1/kubeconfig",
+			wantArgs:   []string{"-c", "kubectl get pods"},
+			wantEnv:    []string{"HELMSMAN_HOOK=preApply", "KUBECONFIG=/tmp/helmsman-kube-1/kubeconfig"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.cmd.withKubeconfig(tt.kubeconfig)
+			if !reflect.DeepEqual(got.Args, tt.wantArgs) || !reflect.DeepEqual(got.Env, tt.wantEnv) {
+				t.Errorf("withKubeconfig() = %q, %q, want %q, %q", got.Args, got.Env, tt.wantArgs, tt.wantEnv)
+			}
+		})
+	}
+}
+
+func Test_command_inCluster_kubeconfig(t *testing.T) {
+	created := &state{kubeconfig: "/tmp/helmsman-1/kube-1/kubeconfig"}
+	user := &state{}
+	cmd := kubectl([]string{"get", "namespaces"}, "")
+	if got := cmd.inCluster(created, "").Args; !reflect.DeepEqual(got, []string{"get", "namespaces", "--kubeconfig", "/tmp/helmsman-1/kube-1/kubeconfig"}) {
+		t.Errorf("inCluster() = %q, want the kubeconfig of the state", got)
+	}
+	if got := cmd.inCluster(user, "").Args; !reflect.DeepEqual(got, []string{"get", "namespaces"}) {
+		t.Errorf("inCluster() = %q, want the kubeconfig of the user", got)
+	}
+}
+
+func Test_kubeconfigManifest(t *testing.T) {
+	s := &state{}
+	s.Settings.KubeContext = "test"
+	s.Settings.ClusterURI = "https://kubernetes.default"
+	s.Settings.Username = "admin"
+	s.Settings.Password = "secret"
+
+	got, err := kubeconfigManifest(s, kubeconfigCredentials{caCrt: "/tmp/k/ca.crt", caKey: "/tmp/k/ca.key"})
+	if err != nil {
+		t.Fatalf("kubeconfigManifest() error = %v", err)
+	}
+	for _, want := range []string{"kind: Config", "current-context: test", "server: https://kubernetes.default", "certificate-authority: /tmp/k/ca.crt",
+		"username: admin", "password: secret", "client-key: /tmp/k/ca.key", "context:\n    cluster: test\n    user: admin\n"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("kubeconfigManifest() does not contain %q:\n%s", want, got)
+		}
+	}
+
+	s.Settings.BearerToken = true
+	got, err = kubeconfigManifest(s, kubeconfigCredentials{caCrt: "/tmp/k/ca.crt", token: "abc"})
+	if err != nil {
+		t.Fatalf("kubeconfigManifest() error = %v", err)
+	}
+	if !strings.Contains(got, "token: abc") || strings.Contains(got, "password:") {
+		t.Errorf("kubeconfigManifest() with a bearer token = \n%s", got)
+	}
+}
@@ -1,58 +1,104 @@
 package app
 
 import (
+	"context"
 	"net/url"
 	"os"
 
 	"github.com/apsdehal/go-logger"
 )
 
+// Logger writes the logs of Helmsman. Errors are also sent to the Slack webhook of the desired state, if any.
+// Each engine has its own logger, configured from its options. A nil logger logs with the default options.
 type Logger struct {
-	*logger.Logger
+	base         *logger.Logger
+	debug        bool
+	verbose      bool
+	slackWebhook string
+	applying     bool
+	// atExit is run by Fatal before exiting
+	atExit func()
 }
 
-var log *Logger
-var baseLogger *logger.Logger
+// newLogger returns a logger with the verbosity and the colors of the options of a run
+func newLogger(opts Options) *Logger {
+	return &Logger{
+		base:     newBaseLogger(opts.Verbose || opts.Debug, opts.NoColors),
+		debug:    opts.Debug,
+		verbose:  opts.Verbose,
+		applying: opts.Apply,
+	}
+}
+
+// loggerKey is the key of the logger of a run in its contexts
+type loggerKey struct{}
+
+// withLogger returns a copy of a context carrying a logger, for the functions which get the context of a run but not its state
+func withLogger(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// loggerFrom returns the logger carried by a context, or nil if it has none
+func loggerFrom(ctx context.Context) *Logger {
+	l, _ := ctx.Value(loggerKey{}).(*Logger)
+	return l
+}
+
+func (l *Logger) logger() *logger.Logger {
+	if l == nil || l.base == nil {
+		return newBaseLogger(false, false)
+	}
+	return l.base
+}
 
 func (l *Logger) Info(message string) {
-	baseLogger.Info(message)
+	l.logger().Info(message)
 }
 
 func (l *Logger) Debug(message string) {
-	if flags.debug {
-		baseLogger.Debug(message)
+	if l != nil && l.debug {
+		l.logger().Debug(message)
 	}
 }
 
 func (l *Logger) Verbose(message string) {
-	if flags.verbose {
-		baseLogger.Info(message)
+	if l != nil && l.verbose {
+		l.logger().Info(message)
 	}
 }
 
 func (l *Logger) Error(message string) {
-	if _, err := url.ParseRequestURI(settings.SlackWebhook); err == nil {
-		notifySlack(message, settings.SlackWebhook, true, flags.apply)
-	}
-	baseLogger.Error(message)
+	l.notifyFailure(message)
+	l.logger().Error(message)
 }
 
 func (l *Logger) Warning(message string) {
-	baseLogger.Warning(message)
+	l.logger().Warning(message)
 }
 
 func (l *Logger) Notice(message string) {
-	baseLogger.Notice(message)
+	l.logger().Notice(message)
 }
 
 func (l *Logger) Fatal(message string) {
-	if _, err := url.ParseRequestURI(settings.SlackWebhook); err == nil {
-		notifySlack(message, settings.SlackWebhook, true, flags.apply)
+	l.notifyFailure(message)
+	if l != nil && l.atExit != nil {
+		l.atExit()
+	}
+	l.logger().Fatal(message)
+}
+
+// notifyFailure sends an error to the Slack webhook of the desired state, if any
+func (l *Logger) notifyFailure(message string) {
+	if l == nil {
+		return
+	}
+	if _, err := url.ParseRequestURI(l.slackWebhook); err == nil {
+		l.notifySlack(message, l.slackWebhook, true, l.applying)
 	}
-	baseLogger.Fatal(message)
 }
 
-func initLogs(verbose bool, noColors bool) {
+func newBaseLogger(verbose bool, noColors bool) *logger.Logger {
 	logger.SetDefaultFormat("%{time:2006-01-02 15:04:05} %{level}: %{message}")
 	logLevel := logger.InfoLevel
 	if verbose {
@@ -62,5 +108,6 @@ func initLogs(verbose bool, noColors bool) {
 	if noColors {
 		colors = 0
 	}
-	baseLogger, _ = logger.New("logger", colors, os.Stdout, logLevel)
+	l, _ := logger.New("logger", colors, os.Stdout, logLevel)
+	return l
 }
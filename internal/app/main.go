@@ -1,151 +1,253 @@
 package app
 
 import (
+	"context"
+	"errors"
 	"os"
+	"sync"
 )
 
 const (
 	helmBin            = "helm"
 	appVersion         = "v3.1.0"
-	tempFilesDir       = ".helmsman-tmp"
 	defaultContextName = "default"
 	resourcePool       = 10
 )
 
-var (
-	flags      cli
-	settings   config
-	curContext string
-)
-
-func init() {
-	// Parse cli flags and read config files
-	flags.parse()
-}
+// planOutputMutex keeps the plans of clusters deployed to at the same time from being printed mixed together
+var planOutputMutex sync.Mutex
 
 // Main is the app main function
 func Main() {
-	var s state
-
-	// delete temp files with substituted env vars when the program terminates
-	defer os.RemoveAll(tempFilesDir)
-	if !flags.noCleanup {
-		defer s.cleanup()
+	var c cli
+	c.parse(os.Args[1:])
+	c.loadEnvFiles()
+
+	// sum up the run and delete temp files with substituted env vars and decrypted secrets however the program terminates
+	var (
+		e           *Engine
+		s           *state
+		err         error
+		cleanupOnce sync.Once
+	)
+	cleanup := func() {
+		cleanupOnce.Do(func() {
+			if s != nil {
+				s.retries.print(c.log)
+				if !c.noCleanup {
+					s.cleanup()
+				}
+			}
+			if e != nil {
+				os.RemoveAll(e.tempDir)
+			}
+		})
 	}
+	c.log.atExit = cleanup
+	defer cleanup()
+
+	ctx, force, stop := handleSignals(c.log)
+	defer stop()
 
-	flags.readState(&s)
-	if len(s.GroupMap) > 0 {
-		s.TargetMap = s.getAppsInGroupsAsTargetMap()
-		if len(s.TargetMap) == 0 {
-			log.Info("No apps defined with -group flag were found, exiting...")
-			os.Exit(0)
+	if c.secretsAction != "" {
+		// the secrets command does not deploy anything, so it does not need the tools checked by NewEngine
+		if e, err = newEngine(ctx, c.Options, c.log); err != nil {
+			c.log.Fatal(err.Error())
 		}
-	}
-	if len(s.TargetMap) > 0 {
-		s.TargetApps = s.getAppsInTargetsOnly()
-		s.TargetNamespaces = s.getNamespacesInTargetsOnly()
-		if len(s.TargetApps) == 0 {
-			log.Info("No apps defined with -target flag were found, exiting...")
-			os.Exit(0)
+		if s, err = secretsState(e); err != nil {
+			c.log.Fatal(err.Error())
+		}
+		if err := runSecretsCommand(c.secretsAction, c.secretsFile, s); err != nil {
+			c.log.Fatal(err.Error())
 		}
+		return
 	}
-	settings = s.Settings
-	curContext = s.Context
 
-	// set the kubecontext to be used Or create it if it does not exist
-	log.Info("Setting up kubectl...")
-	if !setKubeContext(settings.KubeContext) {
-		if err := createContext(&s); err != nil {
-			log.Fatal(err.Error())
-		}
+	if e, err = NewEngine(ctx, c.Options); err != nil {
+		c.log.Fatal(err.Error())
 	}
+	e.force = force
+	// the engine logs with the logger of the command line tool, which cleans up when the run fails
+	e.log = c.log
 
-	// add repos -- fails if they are not valid
-	log.Info("Setting up helm...")
-	if err := addHelmRepos(s.HelmRepos); err != nil && !flags.destroy {
-		log.Fatal(err.Error())
+	ds, err := e.LoadState()
+	if err != nil {
+		c.log.Fatal(err.Error())
 	}
+	s = ds.s
+	ctx = s.context()
 
-	if flags.apply || flags.dryRun || flags.destroy {
-		// add/validate namespaces
-		if !flags.noNs {
-			log.Info("Setting up namespaces...")
-			if flags.nsOverride == "" {
-				addNamespaces(&s)
-			} else {
-				createNamespace(flags.nsOverride)
-				s.overrideAppsNamespace(flags.nsOverride)
-			}
+	if s.Apps == nil && !c.SkipValidation {
+		c.log.Info("No apps specified. Nothing to be executed.")
+		return
+	}
+	if len(s.GroupMap) > 0 && len(s.TargetMap) == 0 {
+		c.log.Info("No apps defined with -group flag were found, exiting...")
+		return
+	}
+	if len(s.TargetMap) > 0 && len(s.TargetApps) == 0 {
+		c.log.Info("No apps defined with -target flag were found, exiting...")
+		return
+	}
+
+	if err := setupHelm(s); err != nil {
+		c.log.Fatal(err.Error())
+	}
+
+	if c.outdated {
+		c.log.Info("Checking charts for newer versions...")
+		if err := printOutdatedCharts(s, c.output); err != nil {
+			c.log.Fatal(err.Error())
 		}
+		return
 	}
 
-	if !flags.skipValidation {
-		log.Info("Validating charts...")
-		// validate charts-versions exist in defined repos
-		if err := validateReleaseCharts(&s); err != nil {
-			log.Fatal(err.Error())
+	if len(s.Clusters) == 0 {
+		if err := deploy(ctx, s, ""); err != nil {
+			c.log.Fatal(err.Error())
 		}
-	} else {
-		log.Info("Skipping charts' validation.")
+		return
 	}
 
-	if flags.destroy {
-		log.Warning("Destroy flag is enabled. Your releases will be deleted!")
+	if err := deployToClusters(ctx, s); err != nil {
+		c.log.Fatal(err.Error())
 	}
+}
 
-	if flags.migrateContext {
-		log.Warning("migrate-context flag is enabled. Context will be changed to [ " + s.Context + " ] and Helmsman labels will be applied.")
-		s.updateContextLabels()
+// setupHelm adds the helm repositories of the desired state and logs in to its OCI registries.
+// Errors are ignored when destroying as the charts are not needed to uninstall releases.
+func setupHelm(s *state) error {
+	s.log.Info("Setting up helm...")
+	if err := addHelmRepos(s); err != nil && !s.opts.Destroy {
+		return err
 	}
+	if err := loginOCIRegistries(s); err != nil && !s.opts.Destroy {
+		return err
+	}
+	return nil
+}
 
-	log.Info("Preparing plan...")
-	cs := buildState(&s)
-	p := cs.makePlan(&s)
-	if !flags.keepUntrackedReleases {
-		cs.cleanUntrackedReleases(&s, p)
+// deploy makes the plan for a desired state targeting a single kube context, prints it and applies it.
+// cluster is the name of the cluster the state targets, if clusters are defined in the desired state.
+// Nothing is applied once the context is done.
+func deploy(ctx context.Context, s *state, cluster string) error {
+	if err := prepare(s, cluster); err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		return errors.New(stopReason(ctx, s) + " before making the plan, nothing was changed")
 	}
 
-	p.sort()
+	s.log.Info("Preparing plan...")
+	cs, err := buildState(s)
+	if err != nil {
+		return err
+	}
+	p, err := makePlan(s, cs, cluster)
+	if err != nil {
+		return err
+	}
+	if err := s.writeDiffReport(); err != nil {
+		return err
+	}
+
+	planOutputMutex.Lock()
 	p.print()
-	if flags.debug {
+	if s.opts.Debug {
 		p.printCmds()
 	}
-	p.sendToSlack()
+	planOutputMutex.Unlock()
+	p.sendToSlack(s.Settings.SlackWebhook)
 
-	if flags.apply || flags.dryRun || flags.destroy {
-		p.exec()
+	if err := s.enforcePolicies(p, s.opts.Apply || s.opts.Destroy); err != nil {
+		return err
+	}
+	if s.opts.Apply || s.opts.DryRun || s.opts.Destroy {
+		if err := p.exec(ctx, s); err != nil {
+			return err
+		}
+		return s.writeLockFile()
 	}
+	return nil
 }
 
-// cleanup deletes the k8s certificates and keys files
-// It also deletes any Tiller TLS certs and keys
-// and secret files
-func (s *state) cleanup() {
-	log.Verbose("Cleaning up sensitive and temp files")
-	if _, err := os.Stat("ca.crt"); err == nil {
-		deleteFile("ca.crt")
+// prepare gets the cluster targeted by a desired state ready for its current state to be read and compared with the desired one:
+// it sets up the kube context, and resolves and validates the charts of the apps.
+// Nothing is changed in the cluster: namespaces are set up by the plan.
+// The kube context is passed to every helm and kubectl command instead of being switched to,
+// so that the kubeconfig of the user is never changed and several clusters can be deployed to at the same time.
+func prepare(s *state, cluster string) error {
+	if cluster != "" {
+		if !kubeContextExists(s, s.Settings.KubeContext) {
+			return errors.New("kube context [ " + s.Settings.KubeContext + " ] of cluster [ " + cluster + " ] does not exist")
+		}
+	} else if s.Settings.KubeContext != "" && !kubeContextExists(s, s.Settings.KubeContext) {
+		// create the kube context if it does not exist, without changing the kubeconfig of the user
+		s.log.Info("Kube context [ " + s.Settings.KubeContext + " ] does not exist. Attempting to create it...")
+		if err := createContext(s); err != nil {
+			return err
+		}
 	}
 
-	if _, err := os.Stat("ca.key"); err == nil {
-		deleteFile("ca.key")
+	if s.opts.NsOverride != "" {
+		s.overrideAppsNamespace(s.opts.NsOverride)
 	}
 
-	if _, err := os.Stat("client.crt"); err == nil {
-		deleteFile("client.crt")
+	s.log.Info("Resolving charts' versions...")
+	if err := resolveChartVersions(s); err != nil && !s.opts.Destroy {
+		return err
 	}
 
-	if _, err := os.Stat("bearer.token"); err == nil {
-		deleteFile("bearer.token")
+	if !s.opts.SkipValidation {
+		s.log.Info("Validating charts...")
+		// validate charts-versions exist in defined repos
+		if err := validateReleaseCharts(s); err != nil {
+			return err
+		}
+	} else {
+		s.log.Info("Skipping charts' validation.")
 	}
 
-	for _, app := range s.Apps {
-		if _, err := os.Stat(app.SecretsFile + ".dec"); err == nil {
-			deleteFile(app.SecretsFile + ".dec")
+	if s.opts.Destroy {
+		s.log.Warning("Destroy flag is enabled. Your releases will be deleted!")
+	}
+
+	if s.opts.MigrateContext {
+		s.log.Warning("migrate-context flag is enabled. Context will be changed to [ " + s.Context + " ] and Helmsman labels will be applied.")
+		if err := s.updateContextLabels(); err != nil {
+			return err
 		}
-		for _, secret := range app.SecretsFiles {
-			if _, err := os.Stat(secret + ".dec"); err == nil {
-				deleteFile(secret + ".dec")
-			}
+	}
+	return nil
+}
+
+// makePlan makes the sorted plan of the commands bringing the current state to the desired state
+func makePlan(s *state, cs *currentState, cluster string) (*plan, error) {
+	p, err := cs.makePlan(s)
+	if err != nil {
+		return nil, err
+	}
+	p.Cluster = cluster
+	if !s.opts.KeepUntrackedReleases {
+		if err := cs.cleanUntrackedReleases(s, p); err != nil {
+			return nil, err
 		}
 	}
+	if err := cs.planNamespaces(s, p); err != nil {
+		return nil, err
+	}
+	s.addApplyHooks(p)
+	p.sort()
+	return p, nil
+}
+
+// cleanup stops the run of the state and deletes the decrypted secrets files of the releases
+func (s *state) cleanup() {
+	if s.stopRun != nil {
+		s.stopRun()
+	}
+	s.log.Verbose("Cleaning up sensitive and temp files")
+	for _, app := range s.Apps {
+		s.decrypted.remove(app.secretsFiles())
+	}
 }
@@ -28,6 +28,7 @@ type limits []struct {
 
 // quota type
 type quotas struct {
+	Name           string           `yaml:"name,omitempty"`
 	Pods           string           `yaml:"pods,omitempty"`
 	CPULimits      string           `yaml:"limits.cpu,omitempty"`
 	CPURequests    string           `yaml:"requests.cpu,omitempty"`
@@ -40,9 +41,17 @@ type quotas struct {
 type namespace struct {
 	Protected   bool              `yaml:"protected"`
 	Limits      limits            `yaml:"limits,omitempty"`
+	LimitRanges []limitRange      `yaml:"limitRanges,omitempty"`
 	Labels      map[string]string `yaml:"labels"`
 	Annotations map[string]string `yaml:"annotations"`
 	Quotas      *quotas           `yaml:"quotas,omitempty"`
+	Cluster     string            `yaml:"cluster,omitempty"`
+	// NetworkPolicies, RoleBindings and ServiceAccounts are a baseline of resources created in the namespace
+	NetworkPolicies *networkPolicies `yaml:"networkPolicies,omitempty"`
+	RoleBindings    []roleBinding    `yaml:"roleBindings,omitempty"`
+	ServiceAccounts []serviceAccount `yaml:"serviceAccounts,omitempty"`
+	// Secrets are created in the namespace before the releases are installed in it
+	Secrets []namespaceSecret `yaml:"secrets,omitempty"`
 }
 
 // print prints the namespace
@@ -0,0 +1,380 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// annotations recording on each namespace what Helmsman manages on it, so that what is dropped from the desired state can be removed
+const (
+	managedLabelsAnnotation      = "helmsman/managed-labels"
+	managedAnnotationsAnnotation = "helmsman/managed-annotations"
+	protectedAnnotation          = "helmsman/protected"
+)
+
+// systemNamespaces are never deleted by Helmsman
+var systemNamespaces = map[string]bool{
+	"default":         true,
+	"kube-system":     true,
+	"kube-public":     true,
+	"kube-node-lease": true,
+}
+
+// namespaceState represents the current state of a namespace
+type namespaceState struct {
+	Name        string
+	Labels      map[string]string
+	Annotations map[string]string
+}
+
+// managedNamespaceLabels returns the labels of the namespaces created by Helmsman for the given context
+func managedNamespaceLabels(context string) map[string]string {
+	return map[string]string{"MANAGED-BY": "HELMSMAN", "HELMSMAN_CONTEXT": context}
+}
+
+// isManagedBy checks if a namespace was created by Helmsman for the given context
+func (ns namespaceState) isManagedBy(context string) bool {
+	return ns.Labels["MANAGED-BY"] == "HELMSMAN" && ns.Labels["HELMSMAN_CONTEXT"] == context
+}
+
+// trackedKeys returns the keys recorded in one of the tracking annotations of a namespace
+func (ns namespaceState) trackedKeys(annotation string) []string {
+	if ns.Annotations[annotation] == "" {
+		return nil
+	}
+	return strings.Split(ns.Annotations[annotation], ",")
+}
+
+// staleKeys returns the tracked keys which are not desired anymore, sorted
+func staleKeys(tracked []string, desired map[string]string) []string {
+	var stale []string
+	for _, k := range tracked {
+		if _, ok := desired[k]; !ok {
+			stale = append(stale, k)
+		}
+	}
+	sort.Strings(stale)
+	return stale
+}
+
+// sortedKeys returns the keys of a map, sorted
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// joinKeys returns the sorted keys of a map, separated by commas
+func joinKeys(m map[string]string) string {
+	return strings.Join(sortedKeys(m), ",")
+}
+
+// getNamespaces fetches the labels and annotations of all the namespaces of the cluster
+func getNamespaces(s *state) (map[string]namespaceState, error) {
+	var list struct {
+		Items []struct {
+			Metadata struct {
+				Name        string            `json:"name"`
+				Labels      map[string]string `json:"labels"`
+				Annotations map[string]string `json:"annotations"`
+			} `json:"metadata"`
+		} `json:"items"`
+	}
+	cmd := kubectl([]string{"get", "namespaces", "-o", "json"}, "Listing namespaces").inCluster(s, s.Settings.KubeContext).withTimeout(s.commandTimeout(statePhase))
+	ctx := s.context()
+	result := cmd.retryExec(ctx, s.getRetryPolicy(nil), s.retries)
+	if result.code != 0 {
+		return nil, errors.New("failed to list namespaces: " + result.errors)
+	}
+	if err := json.Unmarshal([]byte(result.output), &list); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal kubectl output: %w", err)
+	}
+	namespaces := make(map[string]namespaceState, len(list.Items))
+	for _, item := range list.Items {
+		namespaces[item.Metadata.Name] = namespaceState{
+			Name:        item.Metadata.Name,
+			Labels:      item.Metadata.Labels,
+			Annotations: item.Metadata.Annotations,
+		}
+	}
+	return namespaces, nil
+}
+
+// planNamespaces adds to the plan the changes bringing the namespaces of the cluster to the desired state:
+// creating the missing namespaces, updating their labels and annotations (and removing the ones Helmsman set which
+// were dropped from the desired state), applying their LimitRanges and ResourceQuota, and deleting the namespaces
+// created by Helmsman which were removed from the desired state when the pruneNamespaces setting is on.
+// Namespaces are set up before any other command of the plan runs, and deleted after all of them.
+func (cs *currentState) planNamespaces(s *state, p *plan) error {
+	if cs.namespaces == nil || s.opts.Destroy {
+		return nil
+	}
+	first, last := p.priorityRange()
+	if s.opts.NsOverride != "" {
+		if _, ok := cs.namespaces[s.opts.NsOverride]; !ok {
+			return cs.planNamespaceCreation(s.opts.NsOverride, namespace{}, nil, s, p, first)
+		}
+		return nil
+	}
+
+	namespaces := s.Namespaces
+	if len(s.TargetMap) > 0 {
+		namespaces = s.TargetNamespaces
+	}
+	for _, name := range sortedNamespaces(namespaces) {
+		var err error
+		if current, ok := cs.namespaces[name]; ok {
+			err = cs.planNamespaceUpdate(current, namespaces[name], s, p, first)
+		} else {
+			err = cs.planNamespaceCreation(name, namespaces[name], managedNamespaceLabels(s.Context), s, p, first)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	// a run limited to some apps does not know about all the namespaces of the desired state
+	if s.Settings.PruneNamespaces && len(s.TargetMap) == 0 {
+		var removed []string
+		for name, current := range cs.namespaces {
+			if _, ok := s.Namespaces[name]; !ok && current.isManagedBy(s.Context) && !systemNamespaces[name] {
+				removed = append(removed, name)
+			}
+		}
+		sort.Strings(removed)
+		for _, name := range removed {
+			if err := cs.pruneNamespace(cs.namespaces[name], s, p, last); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// sortedNamespaces returns the names of the namespaces, sorted, so that their changes are planned in the same order on every run
+func sortedNamespaces(namespaces map[string]namespace) []string {
+	names := make([]string, 0, len(namespaces))
+	for name := range namespaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// trackingAnnotations returns the annotations recording what Helmsman manages on a namespace
+func trackingAnnotations(ns namespace) map[string]string {
+	return map[string]string{
+		managedLabelsAnnotation:      joinKeys(ns.Labels),
+		managedAnnotationsAnnotation: joinKeys(ns.Annotations),
+		protectedAnnotation:          fmt.Sprint(ns.Protected),
+	}
+}
+
+// planNamespaceCreation plans the creation of a namespace with its labels, annotations and resources.
+// managedLabels are added to the labels of the desired state to mark the namespace as created by Helmsman.
+// In dry-run mode, the resources can't be checked as the namespace is not actually created.
+func (cs *currentState) planNamespaceCreation(name string, ns namespace, managedLabels map[string]string, s *state, p *plan, priority int) error {
+	labels := map[string]string{}
+	for k, v := range ns.Labels {
+		labels[k] = v
+	}
+	for k, v := range managedLabels {
+		labels[k] = v
+	}
+	annotations := trackingAnnotations(ns)
+	for k, v := range ns.Annotations {
+		annotations[k] = v
+	}
+	definition, err := namespaceManifest(name, labels, annotations)
+	if err != nil {
+		return err
+	}
+	file, err := writeTempFile(s.tempDir, "Namespace-*.yaml", definition)
+	if err != nil {
+		return err
+	}
+	cmd := kubectl(concat([]string{"create", "-f", file}, s.opts.getKubectlDryRunFlags()), "Creating namespace [ "+name+" ]").inCluster(s, s.Settings.KubeContext)
+	p.addCommand(cmd, priority, nil)
+	p.addDecisionFor(name, "", "Namespace [ "+name+" ] will be created", priority, create)
+
+	if s.opts.DryRun && ns.hasResources() {
+		p.addDecision("Resources of namespace [ "+name+" ] -- skipped in dry-run mode as the namespace does not exist yet", priority, noop)
+		return nil
+	}
+	return cs.planNamespaceResources(name, ns, s, p, priority)
+}
+
+// planNamespaceUpdate plans the changes to the labels and annotations of an existing namespace, and applies its resources.
+// Labels and annotations which are not in the desired state are only removed if Helmsman set them.
+func (cs *currentState) planNamespaceUpdate(current namespaceState, ns namespace, s *state, p *plan, priority int) error {
+	kctx := s.Settings.KubeContext
+	var changes []string
+
+	labelArgs := metadataChanges(ns.Labels, current.Labels, current.trackedKeys(managedLabelsAnnotation))
+	if len(labelArgs) > 0 {
+		cmd := kubectl(concat([]string{"label", "--overwrite", "namespace", current.Name}, labelArgs, s.opts.getKubectlDryRunFlags()), "Labeling namespace [ "+current.Name+" ]").inCluster(s, kctx)
+		p.addCommand(cmd, priority, nil)
+		changes = append(changes, "labels [ "+strings.Join(labelArgs, ", ")+" ]")
+	}
+
+	annotationArgs := metadataChanges(ns.Annotations, current.Annotations, current.trackedKeys(managedAnnotationsAnnotation))
+	if len(annotationArgs) > 0 {
+		changes = append(changes, "annotations [ "+strings.Join(annotationArgs, ", ")+" ]")
+	}
+	// the tracking annotations are updated along with the others, but are not worth a decision on their own
+	annotationArgs = append(annotationArgs, metadataChanges(trackingAnnotations(ns), current.Annotations, nil)...)
+	if len(annotationArgs) > 0 {
+		cmd := kubectl(concat([]string{"annotate", "--overwrite", "namespace", current.Name}, annotationArgs, s.opts.getKubectlDryRunFlags()), "Annotating namespace [ "+current.Name+" ]").inCluster(s, kctx)
+		p.addCommand(cmd, priority, nil)
+	}
+
+	if len(changes) > 0 {
+		p.addDecisionFor(current.Name, "", "Namespace [ "+current.Name+" ] will be updated with "+strings.Join(changes, " and ")+
+			" (a key ending with - is removed)", priority, change)
+	} else if !ns.hasResources() && len(cs.namespaceResources[current.Name]) == 0 && len(cs.namespaceSecrets[current.Name]) == 0 {
+		p.addDecision("Namespace [ "+current.Name+" ] exists and is up-to-date", priority, noop)
+	}
+	return cs.planNamespaceResources(current.Name, ns, s, p, priority)
+}
+
+// metadataChanges returns the kubectl label or annotate arguments bringing the current labels or annotations of a namespace
+// to the desired ones: key=value for each key to set, key- for each tracked key to remove, sorted by key
+func metadataChanges(desired map[string]string, current map[string]string, tracked []string) []string {
+	var args []string
+	for _, k := range sortedKeys(desired) {
+		if v, ok := current[k]; !ok || v != desired[k] {
+			args = append(args, k+"="+desired[k])
+		}
+	}
+	for _, k := range staleKeys(tracked, desired) {
+		if _, ok := current[k]; ok {
+			args = append(args, k+"-")
+		}
+	}
+	return args
+}
+
+// planNamespaceResources plans applying the resources of a namespace, if it has any.
+// The LimitRanges and ResourceQuota of an existing namespace are only applied when they drifted from the desired state.
+func (cs *currentState) planNamespaceResources(name string, ns namespace, s *state, p *plan, priority int) error {
+	var resources []string
+	labels := managedNamespaceLabels(s.Context)
+	for _, lr := range ns.limitRanges() {
+		definition, err := limitRangeManifest(name, lr, labels)
+		if err != nil {
+			return err
+		}
+		reconciled, err := cs.reconcileManifest(name, limitRangeKind, lr.Name, definition, s, p, priority)
+		if err != nil {
+			return err
+		}
+		if !reconciled {
+			if err := cs.planManifest(name, limitRangeKind, definition, s, p, priority); err != nil {
+				return err
+			}
+			resources = append(resources, "LimitRange [ "+lr.Name+" ]")
+		}
+	}
+	if ns.Quotas != nil {
+		definition, err := resourceQuotaManifest(name, ns.Quotas, labels)
+		if err != nil {
+			return err
+		}
+		reconciled, err := cs.reconcileManifest(name, resourceQuotaKind, ns.Quotas.getName(), definition, s, p, priority)
+		if err != nil {
+			return err
+		}
+		if !reconciled {
+			if err := cs.planManifest(name, resourceQuotaKind, definition, s, p, priority); err != nil {
+				return err
+			}
+			resources = append(resources, "ResourceQuota [ "+ns.Quotas.getName()+" ]")
+		}
+	}
+	baseline, err := cs.planBaselineResources(name, ns, s, p, priority)
+	if err != nil {
+		return err
+	}
+	resources = append(resources, baseline...)
+	if len(resources) > 0 {
+		p.addDecisionFor(name, "", strings.Join(resources, ", ")+" of namespace [ "+name+" ] will be applied", priority, change)
+	}
+	return cs.planNamespaceSecrets(name, ns, s, p, priority)
+}
+
+// planManifest plans applying a definition in a namespace. Each definition gets its own file
+// as the plans of several clusters can be made at the same time.
+func (cs *currentState) planManifest(ns string, kind string, definition string, s *state, p *plan, priority int) error {
+	file, err := writeTempFile(s.tempDir, kind+"-*.yaml", definition)
+	if err != nil {
+		return err
+	}
+	cmd := kubectl(concat([]string{"apply", "-f", file, "-n", ns}, s.opts.getKubectlDryRunFlags()), "Applying "+kind+" in namespace [ "+ns+" ]").inCluster(s, s.Settings.KubeContext)
+	p.addCommand(cmd, priority, nil)
+	return nil
+}
+
+// unmanagedRelease returns the first of the releases which is not managed by Helmsman with the given context,
+// according to the contexts of the Helmsman releases of their namespace. It returns false if all of them are.
+func unmanagedRelease(releases []helmRelease, contexts map[string]string, context string) (string, bool) {
+	for _, r := range releases {
+		if rctx, managed := contexts[r.Name]; !managed || rctx != context {
+			return r.Name, true
+		}
+	}
+	return "", false
+}
+
+// pruneNamespace deletes a namespace created by Helmsman which is not in the desired state anymore, along with its releases.
+// The namespace is kept if it was protected when it was last in the desired state, if it has releases
+// which were not installed by Helmsman for the current context, or if it has releases and untracked releases are kept.
+func (cs *currentState) pruneNamespace(current namespaceState, s *state, p *plan, priority int) error {
+	name := current.Name
+	if current.Annotations[protectedAnnotation] == "true" {
+		p.addDecision("Namespace [ "+name+" ] was removed from the desired state but is PROTECTED. "+
+			"Add it back without its protection, then remove it again to delete it.", priority, noop)
+		return nil
+	}
+
+	var releases []helmRelease
+	cmd := helmCmd([]string{"list", "--all", "--max", "0", "--output", "json", "-n", name}, "Listing all existing releases in [ "+name+" ] namespace...").inCluster(s, s.Settings.KubeContext).withTimeout(s.commandTimeout(statePhase))
+	ctx := s.context()
+	result := cmd.retryExec(ctx, s.getRetryPolicy(nil), s.retries)
+	if result.code != 0 {
+		return errors.New("failed to list all releases of namespace [ " + name + " ]: " + result.errors)
+	}
+	if err := json.Unmarshal([]byte(result.output), &releases); err != nil {
+		return fmt.Errorf("failed to unmarshal Helm CLI output: %w", err)
+	}
+	if len(releases) > 0 && s.opts.KeepUntrackedReleases {
+		p.addDecision("Namespace [ "+name+" ] was removed from the desired state but has releases, which are kept "+
+			"with --keep-untracked-releases. The namespace will not be deleted.", priority, noop)
+		return nil
+	}
+	contexts, err := getHelmsmanReleaseContexts(name, s)
+	if err != nil {
+		return err
+	}
+	if r, found := unmanagedRelease(releases, contexts, s.Context); found {
+		p.addDecision("Namespace [ "+name+" ] was removed from the desired state but release [ "+r+" ] in it "+
+			"is not managed by Helmsman with the current context [ "+s.Context+" ]. The namespace will not be deleted.", priority, noop)
+		return nil
+	}
+	// the releases are uninstalled first so that their hooks run and their cluster-wide resources are deleted too
+	for _, r := range releases {
+		cmd := helmCmd(concat([]string{"uninstall", r.Name, "--namespace", name}, s.opts.getDryRunFlags()), "Deleting release [ "+r.Name+" ] in namespace [ "+name+" ]").inCluster(s, s.Settings.KubeContext)
+		p.addCommand(cmd, priority, nil)
+		p.addDecisionFor(name, r.Name, "Release [ "+r.Name+" ] in namespace [ "+name+" ] will be deleted along with its namespace", priority, delete)
+	}
+
+	del := kubectl(concat([]string{"delete", "namespace", name}, s.opts.getKubectlDryRunFlags()), "Deleting namespace [ "+name+" ]").inCluster(s, s.Settings.KubeContext)
+	p.addCommand(del, priority, nil)
+	p.addDecisionFor(name, "", "Namespace [ "+name+" ] was created by Helmsman and removed from the desired state, it will be DELETED "+
+		"with everything it contains, including its LimitRanges and ResourceQuota.", priority, delete)
+	return nil
+}
@@ -0,0 +1,245 @@
+package app
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var tempFileName = regexp.MustCompile(`\S*/(\w+)-\d+\.yaml`)
+
+func Test_staleKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		tracked []string
+		desired map[string]string
+		want    []string
+	}{
+		{
+			name:    "nothing tracked",
+			tracked: nil,
+			desired: map[string]string{"team": "a"},
+			want:    nil,
+		}, {
+			name:    "all still desired",
+			tracked: []string{"team"},
+			desired: map[string]string{"team": "b"},
+			want:    nil,
+		}, {
+			name:    "dropped keys",
+			tracked: []string{"team", "env", "cost-center"},
+			desired: map[string]string{"env": "prod"},
+			want:    []string{"cost-center", "team"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := staleKeys(tt.tracked, tt.desired); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("staleKeys() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_plan_priorityRange(t *testing.T) {
+	p := createPlan()
+	if first, last := p.priorityRange(); first != 0 || last != 0 {
+		t.Errorf("priorityRange() of an empty plan = %d, %d, want 0, 0", first, last)
+	}
+	p.addCommand(command{Cmd: "a"}, -5, nil)
+	p.addCommand(command{Cmd: "b"}, 3, nil)
+	if first, last := p.priorityRange(); first != -6 || last != 4 {
+		t.Errorf("priorityRange() = %d, %d, want -6, 4", first, last)
+	}
+}
+
+func Test_currentState_planNamespaces(t *testing.T) {
+	tests := []struct {
+		name          string
+		prune         bool
+		dryRun        bool
+		desired       map[string]namespace
+		current       namespaceState
+		wantCommands  []string
+		wantDecisions []decisionType
+	}{
+		{
+			name:    "tracking recorded",
+			desired: map[string]namespace{"staging": {Labels: map[string]string{"team": "a"}}},
+			current: namespaceState{Name: "staging", Labels: map[string]string{"team": "a"}},
+			wantCommands: []string{
+				"kubectl annotate --overwrite namespace staging helmsman/managed-annotations= helmsman/managed-labels=team helmsman/protected=false",
+			},
+			wantDecisions: []decisionType{noop},
+		}, {
+			name:    "up to date",
+			desired: map[string]namespace{"staging": {Labels: map[string]string{"team": "a"}}},
+			current: namespaceState{Name: "staging", Labels: map[string]string{"team": "a", "other": "b"}, Annotations: map[string]string{
+				managedLabelsAnnotation: "team", managedAnnotationsAnnotation: "", protectedAnnotation: "false",
+			}},
+			wantDecisions: []decisionType{noop},
+		}, {
+			name:    "changed and stale labels and annotations",
+			desired: map[string]namespace{"staging": {Labels: map[string]string{"team": "b"}}},
+			current: namespaceState{Name: "staging", Labels: map[string]string{"team": "a", "env": "prod"}, Annotations: map[string]string{
+				"owner": "me", managedLabelsAnnotation: "env,team", managedAnnotationsAnnotation: "owner", protectedAnnotation: "false",
+			}},
+			wantCommands: []string{
+				"kubectl label --overwrite namespace staging team=b env-",
+				"kubectl annotate --overwrite namespace staging owner- helmsman/managed-annotations= helmsman/managed-labels=team",
+			},
+			wantDecisions: []decisionType{change},
+		}, {
+			name: "limits and quotas",
+			desired: map[string]namespace{"staging": {
+				Limits: limits{{Type: "Container", Max: resources{CPU: "1"}}},
+				Quotas: &quotas{Pods: "10"},
+			}},
+			current: namespaceState{Name: "staging", Annotations: map[string]string{
+				managedLabelsAnnotation: "", managedAnnotationsAnnotation: "", protectedAnnotation: "false",
+			}},
+			wantCommands:  []string{"kubectl apply -f LimitRange.yaml -n staging", "kubectl apply -f ResourceQuota.yaml -n staging"},
+			wantDecisions: []decisionType{change},
+		}, {
+			name:    "missing namespace",
+			desired: map[string]namespace{"staging": {Labels: map[string]string{"team": "a"}, Quotas: &quotas{Pods: "10"}}},
+			current: namespaceState{Name: "other"},
+			wantCommands: []string{
+				"kubectl create -f Namespace.yaml",
+				"kubectl apply -f ResourceQuota.yaml -n staging",
+			},
+			wantDecisions: []decisionType{create, change},
+		}, {
+			name:    "missing namespace in dry-run mode",
+			dryRun:  true,
+			desired: map[string]namespace{"staging": {Quotas: &quotas{Pods: "10"}}},
+			current: namespaceState{Name: "other"},
+			wantCommands: []string{
+				"kubectl create -f Namespace.yaml --dry-run=server",
+			},
+			wantDecisions: []decisionType{create, noop},
+		}, {
+			name:    "removed namespace without pruning",
+			desired: map[string]namespace{},
+			current: namespaceState{Name: "staging", Labels: managedNamespaceLabels("default")},
+		}, {
+			name:    "removed namespace not created by Helmsman",
+			prune:   true,
+			desired: map[string]namespace{},
+			current: namespaceState{Name: "staging"},
+		}, {
+			name:    "removed namespace created for another context",
+			prune:   true,
+			desired: map[string]namespace{},
+			current: namespaceState{Name: "staging", Labels: managedNamespaceLabels("other")},
+		}, {
+			name:    "removed protected namespace",
+			prune:   true,
+			desired: map[string]namespace{},
+			current: namespaceState{Name: "staging", Labels: managedNamespaceLabels("default"),
+				Annotations: map[string]string{protectedAnnotation: "true"}},
+			wantDecisions: []decisionType{noop},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &state{Context: "default", Namespaces: tt.desired, tempDir: t.TempDir()}
+			s.Settings.PruneNamespaces = tt.prune
+			s.opts.DryRun = tt.dryRun
+			cs := newCurrentState()
+			cs.namespaces = map[string]namespaceState{tt.current.Name: tt.current}
+			p := createPlan()
+
+			cs.planNamespaces(s, p)
+
+			var commands []string
+			for _, c := range p.Commands {
+				// the definitions are written to temporary files with random names
+				commands = append(commands, tempFileName.ReplaceAllString(c.Command.String(), "$1.yaml"))
+			}
+			if !reflect.DeepEqual(commands, tt.wantCommands) {
+				t.Errorf("planNamespaces() commands = %q, want %q", commands, tt.wantCommands)
+			}
+			var decisions []decisionType
+			for _, d := range p.Decisions {
+				decisions = append(decisions, d.Type)
+			}
+			if !reflect.DeepEqual(decisions, tt.wantDecisions) {
+				t.Errorf("planNamespaces() decisions = %v, want %v", decisions, tt.wantDecisions)
+			}
+		})
+	}
+}
+
+func Test_namespaceManifest(t *testing.T) {
+	got, err := namespaceManifest("staging", map[string]string{"team": "a"}, map[string]string{managedLabelsAnnotation: "team"})
+	if err != nil {
+		t.Fatalf("namespaceManifest() error = %v", err)
+	}
+	want := "apiVersion: v1\nkind: Namespace\nmetadata:\n  name: staging\n  labels:\n    team: a\n  annotations:\n    helmsman/managed-labels: team\n"
+	if got != want {
+		t.Errorf("namespaceManifest() = %q, want %q", got, want)
+	}
+}
+
+func Test_currentState_planNamespaces_created(t *testing.T) {
+	s := &state{Context: "default", Namespaces: map[string]namespace{"staging": {Labels: map[string]string{"team": "a"}}}, tempDir: t.TempDir()}
+	cs := newCurrentState()
+	cs.namespaces = map[string]namespaceState{}
+	p := createPlan()
+
+	cs.planNamespaces(s, p)
+
+	if len(p.Commands) != 1 {
+		t.Fatalf("planNamespaces() planned %d commands, want 1", len(p.Commands))
+	}
+	file := p.Commands[0].Command.Args[2]
+	if filepath.Dir(file) != s.tempDir {
+		t.Errorf("namespace definition written to %s, want it in %s", file, s.tempDir)
+	}
+	definition, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"MANAGED-BY: HELMSMAN", "HELMSMAN_CONTEXT: default", "team: a", "helmsman/managed-labels: team", "helmsman/protected: \"false\""} {
+		if !strings.Contains(string(definition), want) {
+			t.Errorf("namespace definition does not contain %q:\n%s", want, definition)
+		}
+	}
+}
+
+func Test_unmanagedRelease(t *testing.T) {
+	releases := []helmRelease{{Name: "api"}, {Name: "web"}}
+	tests := []struct {
+		name      string
+		contexts  map[string]string
+		want      string
+		wantFound bool
+	}{
+		{
+			name:     "all managed by the context",
+			contexts: map[string]string{"api": "default", "web": "default"},
+		}, {
+			name:      "release installed without Helmsman",
+			contexts:  map[string]string{"api": "default"},
+			want:      "web",
+			wantFound: true,
+		}, {
+			name:      "release managed by another context",
+			contexts:  map[string]string{"api": "other", "web": "default"},
+			want:      "api",
+			wantFound: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, found := unmanagedRelease(releases, tt.contexts, "default")
+			if got != tt.want || found != tt.wantFound {
+				t.Errorf("unmanagedRelease() = %q, %v, want %q, %v", got, found, tt.want, tt.wantFound)
+			}
+		})
+	}
+}
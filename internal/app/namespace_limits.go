@@ -0,0 +1,172 @@
+package app
+
+import (
+	"errors"
+	"strings"
+)
+
+// kinds of the LimitRanges and ResourceQuotas of the namespaces
+const (
+	limitRangeKind    = "LimitRange"
+	resourceQuotaKind = "ResourceQuota"
+)
+
+// default names of the LimitRange defined by the limits of a namespace and of its ResourceQuota
+const (
+	defaultLimitRangeName    = "limit-range"
+	defaultResourceQuotaName = "resource-quota"
+)
+
+// limitRange type represents a named LimitRange of a namespace, in addition to the one defined by its limits
+type limitRange struct {
+	Name   string `yaml:"name"`
+	Limits limits `yaml:"limits"`
+}
+
+// limitRangeObject is the definition of a LimitRange
+type limitRangeObject struct {
+	APIVersion string        `yaml:"apiVersion"`
+	Kind       string        `yaml:"kind"`
+	Metadata   k8sObjectMeta `yaml:"metadata"`
+	Spec       struct {
+		Limits limits `yaml:"limits"`
+	} `yaml:"spec"`
+}
+
+// resourceQuotaObject is the definition of a ResourceQuota
+type resourceQuotaObject struct {
+	APIVersion string        `yaml:"apiVersion"`
+	Kind       string        `yaml:"kind"`
+	Metadata   k8sObjectMeta `yaml:"metadata"`
+	Spec       struct {
+		Hard map[string]string `yaml:"hard"`
+	} `yaml:"spec"`
+}
+
+// getName returns the name of the ResourceQuota
+func (q *quotas) getName() string {
+	if q.Name == "" {
+		return defaultResourceQuotaName
+	}
+	return q.Name
+}
+
+// hard returns the hard limits of the quotas, keyed by resource name
+func (q *quotas) hard() map[string]string {
+	hard := map[string]string{}
+	for name, value := range map[string]string{
+		"pods":            q.Pods,
+		"limits.cpu":      q.CPULimits,
+		"requests.cpu":    q.CPURequests,
+		"limits.memory":   q.MemoryLimits,
+		"requests.memory": q.MemoryRequests,
+	} {
+		if value != "" {
+			hard[name] = value
+		}
+	}
+	for _, customQuota := range q.CustomQuotas {
+		hard[customQuota.Name] = customQuota.Value
+	}
+	return hard
+}
+
+// limitRanges returns the LimitRanges of a namespace: the one defined by its limits, if any, and the named ones
+func (n namespace) limitRanges() []limitRange {
+	var ranges []limitRange
+	if len(n.Limits) > 0 {
+		ranges = append(ranges, limitRange{defaultLimitRangeName, n.Limits})
+	}
+	return append(ranges, n.LimitRanges...)
+}
+
+// validateLimits checks the LimitRanges of a namespace
+func (n namespace) validateLimits() error {
+	names := map[string]bool{}
+	for _, lr := range n.limitRanges() {
+		if lr.Name == "" {
+			return errors.New("limitRanges must have a name")
+		}
+		if len(lr.Limits) == 0 {
+			return errors.New("limitRange [ " + lr.Name + " ] must have limits")
+		}
+		if names[lr.Name] {
+			return errors.New("limitRange [ " + lr.Name + " ] is defined more than once")
+		}
+		names[lr.Name] = true
+	}
+	return nil
+}
+
+// limitRangeManifest returns the definition of a LimitRange of a namespace, labeled with the given labels
+func limitRangeManifest(ns string, lr limitRange, labels map[string]string) (string, error) {
+	o := limitRangeObject{APIVersion: "v1", Kind: limitRangeKind, Metadata: k8sObjectMeta{lr.Name, ns, labels}}
+	o.Spec.Limits = lr.Limits
+	return marshalDocuments([]interface{}{o})
+}
+
+// resourceQuotaManifest returns the definition of the ResourceQuota of a namespace, labeled with the given labels
+func resourceQuotaManifest(ns string, q *quotas, labels map[string]string) (string, error) {
+	o := resourceQuotaObject{APIVersion: "v1", Kind: resourceQuotaKind, Metadata: k8sObjectMeta{q.getName(), ns, labels}}
+	o.Spec.Hard = q.hard()
+	return marshalDocuments([]interface{}{o})
+}
+
+// driftLines returns the lines added and removed by a kubectl diff, without the file headers
+// and the generation of the object which changes with every update.
+// It also tells whether the object is only added, i.e. it does not exist yet.
+func driftLines(diff string) ([]string, bool) {
+	var lines []string
+	created := true
+	for _, line := range strings.Split(diff, "\n") {
+		if strings.HasPrefix(line, "+++") || strings.HasPrefix(line, "---") {
+			continue
+		}
+		if !strings.HasPrefix(line, "+") && !strings.HasPrefix(line, "-") {
+			continue
+		}
+		if strings.HasPrefix(strings.TrimSpace(line[1:]), "generation:") {
+			continue
+		}
+		if strings.HasPrefix(line, "-") {
+			created = false
+		}
+		lines = append(lines, line)
+	}
+	return lines, created
+}
+
+// reconcileManifest plans applying a definition to an existing namespace if it differs from the live object,
+// with a decision showing the difference. It returns false if the difference can't be found, e.g. when the
+// namespace does not exist yet or kubectl can't diff it, in which case nothing is planned.
+func (cs *currentState) reconcileManifest(ns string, kind string, name string, definition string, s *state, p *plan, priority int) (bool, error) {
+	if _, exists := cs.namespaces[ns]; !exists {
+		return false, nil
+	}
+	file, err := writeTempFile(s.tempDir, kind+"-*.yaml", definition)
+	if err != nil {
+		return false, err
+	}
+	desc := kind + " [ " + name + " ] of namespace [ " + ns + " ]"
+	cmd := kubectl([]string{"diff", "-f", file, "-n", ns}, "Diffing "+desc).inCluster(s, s.Settings.KubeContext).withTimeout(s.commandTimeout(diffPhase))
+	ctx := s.context()
+	// kubectl diff exits with 1 when it finds differences and above when it fails
+	result := cmd.retryExec(ctx, s.getRetryPolicy(nil), s.retries)
+	if result.code == 0 {
+		p.addDecision(desc+" is up-to-date", priority, noop)
+		return true, nil
+	}
+	lines, created := driftLines(result.output)
+	if result.code != 1 || len(lines) == 0 {
+		s.log.Warning("Could not diff " + desc + ", it will be applied: " + firstLine(result.errors))
+		return false, nil
+	}
+	apply := kubectl(concat([]string{"apply", "-f", file, "-n", ns}, s.opts.getKubectlDryRunFlags()), "Applying "+desc).inCluster(s, s.Settings.KubeContext)
+	p.addCommand(apply, priority, nil)
+	if created {
+		p.addDecisionFor(ns, "", desc+" will be created", priority, create)
+	} else {
+		p.addDecisionFor(ns, "", desc+" has drifted from the desired state and will be applied:\n"+strings.Join(lines, "\n"), priority, change)
+	}
+	return true, nil
+}
@@ -0,0 +1,133 @@
+package app
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_namespace_validateLimits(t *testing.T) {
+	tests := []struct {
+		name string
+		ns   namespace
+		want string
+	}{
+		{
+			name: "limits and named limit ranges",
+			ns: namespace{
+				Limits:      limits{{Type: "Container", Max: resources{CPU: "1"}}},
+				LimitRanges: []limitRange{{Name: "pods", Limits: limits{{Type: "Pod", Max: resources{CPU: "2"}}}}},
+			},
+			want: "",
+		}, {
+			name: "limit range without a name",
+			ns:   namespace{LimitRanges: []limitRange{{Limits: limits{{Type: "Pod"}}}}},
+			want: "limitRanges must have a name",
+		}, {
+			name: "limit range without limits",
+			ns:   namespace{LimitRanges: []limitRange{{Name: "pods"}}},
+			want: "limitRange [ pods ] must have limits",
+		}, {
+			name: "limit range named like the one of the limits",
+			ns: namespace{
+				Limits:      limits{{Type: "Container"}},
+				LimitRanges: []limitRange{{Name: defaultLimitRangeName, Limits: limits{{Type: "Pod"}}}},
+			},
+			want: "limitRange [ limit-range ] is defined more than once",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ""
+			if err := tt.ns.validateLimits(); err != nil {
+				got = err.Error()
+			}
+			if got != tt.want {
+				t.Errorf("validateLimits() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_limitRangeManifest(t *testing.T) {
+	got, err := limitRangeManifest("staging", limitRange{"pods", limits{{Type: "Pod", Max: resources{CPU: "2"}}}}, map[string]string{"MANAGED-BY": "HELMSMAN"})
+	if err != nil {
+		t.Fatalf("limitRangeManifest() error = %v", err)
+	}
+	want := "---\napiVersion: v1\nkind: LimitRange\nmetadata:\n  name: pods\n  namespace: staging\n  labels:\n    MANAGED-BY: HELMSMAN\n" +
+		"spec:\n  limits:\n  - max:\n      cpu: \"2\"\n    type: Pod\n"
+	if got != want {
+		t.Errorf("limitRangeManifest() = %q, want %q", got, want)
+	}
+}
+
+func Test_resourceQuotaManifest(t *testing.T) {
+	q := &quotas{Pods: "10", MemoryLimits: "10Gi", CustomQuotas: []customResource{{Name: "requests.nvidia.com/gpu", Value: "2"}}}
+	for i := 0; i < 2; i++ {
+		got, err := resourceQuotaManifest("staging", q, nil)
+		if err != nil {
+			t.Fatalf("resourceQuotaManifest() error = %v", err)
+		}
+		want := "---\napiVersion: v1\nkind: ResourceQuota\nmetadata:\n  name: resource-quota\n  namespace: staging\n" +
+			"spec:\n  hard:\n    limits.memory: 10Gi\n    pods: \"10\"\n    requests.nvidia.com/gpu: \"2\"\n"
+		if got != want {
+			t.Errorf("resourceQuotaManifest() call %d = %q, want %q", i+1, got, want)
+		}
+	}
+	if len(q.CustomQuotas) != 1 {
+		t.Errorf("resourceQuotaManifest() changed the custom quotas to %v", q.CustomQuotas)
+	}
+
+	got, err := resourceQuotaManifest("staging", &quotas{Name: "compute", Pods: "10"}, nil)
+	if err != nil {
+		t.Fatalf("resourceQuotaManifest() error = %v", err)
+	}
+	if !strings.Contains(got, "name: compute\n") {
+		t.Errorf("resourceQuotaManifest() does not use the name of the quotas:\n%s", got)
+	}
+}
+
+func Test_driftLines(t *testing.T) {
+	tests := []struct {
+		name        string
+		diff        string
+		wantLines   []string
+		wantCreated bool
+	}{
+		{
+			name: "changed object",
+			diff: `diff -u -N /tmp/LIVE-1/v1.ResourceQuota.staging.resource-quota /tmp/MERGED-2/v1.ResourceQuota.staging.resource-quota
+--- /tmp/LIVE-1/v1.ResourceQuota.staging.resource-quota	2020-06-01 10:00:00.000000000 +0000
++++ /tmp/MERGED-2/v1.ResourceQuota.staging.resource-quota	2020-06-01 10:00:00.000000000 +0000
+@@ -5,12 +5,12 @@
+-  generation: 1
++  generation: 2
+   name: resource-quota
+ spec:
+   hard:
+-    pods: "10"
++    pods: "20"
+`,
+			wantLines:   []string{`-    pods: "10"`, `+    pods: "20"`},
+			wantCreated: false,
+		}, {
+			name: "new object",
+			diff: `--- /tmp/LIVE-1/v1.LimitRange.staging.pods	2020-06-01 10:00:00.000000000 +0000
++++ /tmp/MERGED-2/v1.LimitRange.staging.pods	2020-06-01 10:00:00.000000000 +0000
+@@ -0,0 +1,3 @@
++apiVersion: v1
++kind: LimitRange
+`,
+			wantLines:   []string{"+apiVersion: v1", "+kind: LimitRange"},
+			wantCreated: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines, created := driftLines(tt.diff)
+			if !reflect.DeepEqual(lines, tt.wantLines) || created != tt.wantCreated {
+				t.Errorf("driftLines() = %q, %v, want %q, %v", lines, created, tt.wantLines, tt.wantCreated)
+			}
+		})
+	}
+}
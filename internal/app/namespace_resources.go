@@ -0,0 +1,333 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// names of the network policies created by Helmsman
+const (
+	defaultDenyPolicy        = "helmsman-default-deny"
+	allowFromNamespacePolicy = "helmsman-allow-from-namespaces"
+)
+
+// kinds of the baseline resources of the namespaces
+const (
+	networkPolicyKind  = "NetworkPolicy"
+	roleBindingKind    = "RoleBinding"
+	serviceAccountKind = "ServiceAccount"
+)
+
+// networkPolicies type represents the network policies of a namespace: denying all the ingress traffic to its pods
+// and allowing the traffic from the namespaces matching any of the given label selectors
+type networkPolicies struct {
+	DefaultDeny         bool                `yaml:"defaultDeny"`
+	AllowFromNamespaces []map[string]string `yaml:"allowFromNamespaces"`
+}
+
+// roleBinding type represents the binding of a cluster role to groups and users in a namespace
+type roleBinding struct {
+	Name        string   `yaml:"name"`
+	ClusterRole string   `yaml:"clusterRole"`
+	Groups      []string `yaml:"groups"`
+	Users       []string `yaml:"users"`
+}
+
+// serviceAccount type represents a service account of a namespace
+type serviceAccount struct {
+	Name             string   `yaml:"name"`
+	ImagePullSecrets []string `yaml:"imagePullSecrets"`
+}
+
+// namespaceResource identifies a resource Helmsman creates in a namespace
+type namespaceResource struct {
+	Kind string
+	Name string
+}
+
+// k8sObjectMeta is the metadata of the resources Helmsman renders
+type k8sObjectMeta struct {
+	Name      string            `yaml:"name"`
+	Namespace string            `yaml:"namespace,omitempty"`
+	Labels    map[string]string `yaml:"labels,omitempty"`
+}
+
+// validate checks the resources of a namespace
+func (n namespace) validate() error {
+	if err := n.validateLimits(); err != nil {
+		return err
+	}
+	if n.NetworkPolicies != nil {
+		for _, selector := range n.NetworkPolicies.AllowFromNamespaces {
+			if len(selector) == 0 {
+				return errors.New("networkPolicies.allowFromNamespaces can't have an empty selector, it would allow all namespaces")
+			}
+		}
+	}
+	names := map[string]bool{}
+	for _, rb := range n.RoleBindings {
+		if rb.Name == "" || rb.ClusterRole == "" {
+			return errors.New("roleBindings must have a name and a clusterRole")
+		}
+		if len(rb.Groups) == 0 && len(rb.Users) == 0 {
+			return errors.New("roleBinding [ " + rb.Name + " ] must have at least one group or user")
+		}
+		if names[rb.Name] {
+			return errors.New("roleBinding [ " + rb.Name + " ] is defined more than once")
+		}
+		names[rb.Name] = true
+	}
+	names = map[string]bool{}
+	for _, sa := range n.ServiceAccounts {
+		if sa.Name == "" {
+			return errors.New("serviceAccounts must have a name")
+		}
+		if names[sa.Name] {
+			return errors.New("serviceAccount [ " + sa.Name + " ] is defined more than once")
+		}
+		names[sa.Name] = true
+	}
+	names = map[string]bool{}
+	for _, secret := range n.Secrets {
+		if err := secret.validate(); err != nil {
+			return err
+		}
+		if names[secret.Name] {
+			return errors.New("secret [ " + secret.Name + " ] is defined more than once")
+		}
+		names[secret.Name] = true
+	}
+	return nil
+}
+
+// hasResources checks if Helmsman has resources to create in a namespace
+func (n namespace) hasResources() bool {
+	return len(n.Limits) > 0 || len(n.LimitRanges) > 0 || n.Quotas != nil || n.NetworkPolicies != nil || len(n.RoleBindings) > 0 || len(n.ServiceAccounts) > 0 || len(n.Secrets) > 0
+}
+
+// baselineResources returns the LimitRanges, ResourceQuota and baseline resources of a namespace defined in the desired state
+func (n namespace) baselineResources() map[namespaceResource]bool {
+	resources := map[namespaceResource]bool{}
+	for _, lr := range n.limitRanges() {
+		resources[namespaceResource{limitRangeKind, lr.Name}] = true
+	}
+	if n.Quotas != nil {
+		resources[namespaceResource{resourceQuotaKind, n.Quotas.getName()}] = true
+	}
+	if n.NetworkPolicies != nil {
+		if n.NetworkPolicies.DefaultDeny {
+			resources[namespaceResource{networkPolicyKind, defaultDenyPolicy}] = true
+		}
+		if len(n.NetworkPolicies.AllowFromNamespaces) > 0 {
+			resources[namespaceResource{networkPolicyKind, allowFromNamespacePolicy}] = true
+		}
+	}
+	for _, rb := range n.RoleBindings {
+		resources[namespaceResource{roleBindingKind, rb.Name}] = true
+	}
+	for _, sa := range n.ServiceAccounts {
+		resources[namespaceResource{serviceAccountKind, sa.Name}] = true
+	}
+	return resources
+}
+
+// resourceNames returns the sorted names of the resources of a kind, separated by commas
+func resourceNames(resources map[namespaceResource]bool, kind string) string {
+	var names []string
+	for r := range resources {
+		if r.Kind == kind {
+			names = append(names, r.Name)
+		}
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// marshalDocuments returns the YAML definitions of several resources in a single multi-document definition
+func marshalDocuments(resources []interface{}) (string, error) {
+	var docs []string
+	for _, r := range resources {
+		d, err := yaml.Marshal(r)
+		if err != nil {
+			return "", err
+		}
+		docs = append(docs, "---\n"+string(d))
+	}
+	return strings.Join(docs, ""), nil
+}
+
+// networkPolicyManifest returns the definitions of the network policies of a namespace, labeled with the given labels
+func networkPolicyManifest(ns string, np *networkPolicies, labels map[string]string) (string, error) {
+	type policy struct {
+		APIVersion string                 `yaml:"apiVersion"`
+		Kind       string                 `yaml:"kind"`
+		Metadata   k8sObjectMeta          `yaml:"metadata"`
+		Spec       map[string]interface{} `yaml:"spec"`
+	}
+	var policies []interface{}
+	if np.DefaultDeny {
+		policies = append(policies, policy{"networking.k8s.io/v1", networkPolicyKind, k8sObjectMeta{defaultDenyPolicy, ns, labels}, map[string]interface{}{
+			"podSelector": map[string]interface{}{},
+			"policyTypes": []string{"Ingress"},
+		}})
+	}
+	if len(np.AllowFromNamespaces) > 0 {
+		var from []interface{}
+		for _, selector := range np.AllowFromNamespaces {
+			from = append(from, map[string]interface{}{"namespaceSelector": map[string]interface{}{"matchLabels": selector}})
+		}
+		policies = append(policies, policy{"networking.k8s.io/v1", networkPolicyKind, k8sObjectMeta{allowFromNamespacePolicy, ns, labels}, map[string]interface{}{
+			"podSelector": map[string]interface{}{},
+			"policyTypes": []string{"Ingress"},
+			"ingress":     []interface{}{map[string]interface{}{"from": from}},
+		}})
+	}
+	return marshalDocuments(policies)
+}
+
+// roleBindingManifest returns the definitions of the role bindings of a namespace, labeled with the given labels
+func roleBindingManifest(ns string, rbs []roleBinding, labels map[string]string) (string, error) {
+	type subject struct {
+		Kind     string `yaml:"kind"`
+		Name     string `yaml:"name"`
+		APIGroup string `yaml:"apiGroup"`
+	}
+	type binding struct {
+		APIVersion string            `yaml:"apiVersion"`
+		Kind       string            `yaml:"kind"`
+		Metadata   k8sObjectMeta     `yaml:"metadata"`
+		RoleRef    map[string]string `yaml:"roleRef"`
+		Subjects   []subject         `yaml:"subjects"`
+	}
+	var bindings []interface{}
+	for _, rb := range rbs {
+		b := binding{
+			APIVersion: "rbac.authorization.k8s.io/v1",
+			Kind:       roleBindingKind,
+			Metadata:   k8sObjectMeta{rb.Name, ns, labels},
+			RoleRef:    map[string]string{"apiGroup": "rbac.authorization.k8s.io", "kind": "ClusterRole", "name": rb.ClusterRole},
+		}
+		for _, g := range rb.Groups {
+			b.Subjects = append(b.Subjects, subject{"Group", g, "rbac.authorization.k8s.io"})
+		}
+		for _, u := range rb.Users {
+			b.Subjects = append(b.Subjects, subject{"User", u, "rbac.authorization.k8s.io"})
+		}
+		bindings = append(bindings, b)
+	}
+	return marshalDocuments(bindings)
+}
+
+// serviceAccountManifest returns the definitions of the service accounts of a namespace, labeled with the given labels
+func serviceAccountManifest(ns string, sas []serviceAccount, labels map[string]string) (string, error) {
+	type account struct {
+		APIVersion       string              `yaml:"apiVersion"`
+		Kind             string              `yaml:"kind"`
+		Metadata         k8sObjectMeta       `yaml:"metadata"`
+		ImagePullSecrets []map[string]string `yaml:"imagePullSecrets,omitempty"`
+	}
+	var accounts []interface{}
+	for _, sa := range sas {
+		a := account{APIVersion: "v1", Kind: serviceAccountKind, Metadata: k8sObjectMeta{sa.Name, ns, labels}}
+		for _, secret := range sa.ImagePullSecrets {
+			a.ImagePullSecrets = append(a.ImagePullSecrets, map[string]string{"name": secret})
+		}
+		accounts = append(accounts, a)
+	}
+	return marshalDocuments(accounts)
+}
+
+// getNamespaceResources fetches the LimitRanges, ResourceQuotas and baseline resources created by Helmsman for the given context in all the namespaces.
+// It returns nil if they can't be listed, e.g. when Helmsman is not allowed to, in which case they are not pruned.
+func getNamespaceResources(s *state) map[string]map[namespaceResource]bool {
+	var list struct {
+		Items []struct {
+			Kind     string `json:"kind"`
+			Metadata struct {
+				Name      string `json:"name"`
+				Namespace string `json:"namespace"`
+			} `json:"metadata"`
+		} `json:"items"`
+	}
+	cmd := kubectl([]string{"get", "limitranges,resourcequotas,networkpolicies,rolebindings,serviceaccounts", "--all-namespaces", "-l", "MANAGED-BY=HELMSMAN,HELMSMAN_CONTEXT=" + s.Context, "-o", "json"},
+		"Listing the resources created by Helmsman in the namespaces").inCluster(s, s.Settings.KubeContext).withTimeout(s.commandTimeout(statePhase))
+	ctx := s.context()
+	result := cmd.retryExec(ctx, s.getRetryPolicy(nil), s.retries)
+	if result.code != 0 {
+		s.log.Warning("Could not list the resources created by Helmsman in the namespaces, " +
+			"the ones removed from the desired state will not be deleted: " + firstLine(result.errors))
+		return nil
+	}
+	if err := json.Unmarshal([]byte(result.output), &list); err != nil {
+		s.log.Warning("Could not read the resources created by Helmsman in the namespaces: " + err.Error())
+		return nil
+	}
+	resources := map[string]map[namespaceResource]bool{}
+	for _, item := range list.Items {
+		if resources[item.Metadata.Namespace] == nil {
+			resources[item.Metadata.Namespace] = map[namespaceResource]bool{}
+		}
+		resources[item.Metadata.Namespace][namespaceResource{item.Kind, item.Metadata.Name}] = true
+	}
+	return resources
+}
+
+// planBaselineResources plans applying the network policies, role bindings and service accounts of a namespace
+// when they drifted from the desired state, and deleting the resources Helmsman created which were removed from
+// the desired state, LimitRanges and ResourceQuotas included.
+// It returns a description of the resources which are applied without being diffed, if any.
+func (cs *currentState) planBaselineResources(name string, ns namespace, s *state, p *plan, priority int) ([]string, error) {
+	var applied []string
+	labels := managedNamespaceLabels(s.Context)
+	desired := ns.baselineResources()
+	reconcile := func(kind string, description string, definition string, err error) error {
+		if err != nil {
+			return err
+		}
+		reconciled, err := cs.reconcileManifest(name, kind, resourceNames(desired, kind), definition, s, p, priority)
+		if err != nil || reconciled {
+			return err
+		}
+		applied = append(applied, description)
+		return cs.planManifest(name, kind, definition, s, p, priority)
+	}
+	if ns.NetworkPolicies != nil && (ns.NetworkPolicies.DefaultDeny || len(ns.NetworkPolicies.AllowFromNamespaces) > 0) {
+		definition, err := networkPolicyManifest(name, ns.NetworkPolicies, labels)
+		if err := reconcile(networkPolicyKind, "NetworkPolicies", definition, err); err != nil {
+			return nil, err
+		}
+	}
+	if len(ns.RoleBindings) > 0 {
+		definition, err := roleBindingManifest(name, ns.RoleBindings, labels)
+		if err := reconcile(roleBindingKind, "RoleBindings", definition, err); err != nil {
+			return nil, err
+		}
+	}
+	if len(ns.ServiceAccounts) > 0 {
+		definition, err := serviceAccountManifest(name, ns.ServiceAccounts, labels)
+		if err := reconcile(serviceAccountKind, "ServiceAccounts", definition, err); err != nil {
+			return nil, err
+		}
+	}
+
+	var removed []namespaceResource
+	for r := range cs.namespaceResources[name] {
+		if !desired[r] {
+			removed = append(removed, r)
+		}
+	}
+	sort.Slice(removed, func(i, j int) bool {
+		return removed[i].Kind+"/"+removed[i].Name < removed[j].Kind+"/"+removed[j].Name
+	})
+	for _, r := range removed {
+		cmd := kubectl(concat([]string{"delete", strings.ToLower(r.Kind), r.Name, "-n", name}, s.opts.getKubectlDryRunFlags()),
+			"Deleting "+r.Kind+" [ "+r.Name+" ] in namespace [ "+name+" ]").inCluster(s, s.Settings.KubeContext)
+		p.addCommand(cmd, priority, nil)
+		p.addDecisionFor(name, "", r.Kind+" [ "+r.Name+" ] was removed from namespace [ "+name+" ] in the desired state and will be DELETED", priority, delete)
+	}
+	return applied, nil
+}
@@ -0,0 +1,145 @@
+package app
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_namespace_validate(t *testing.T) {
+	tests := []struct {
+		name string
+		ns   namespace
+		want string
+	}{
+		{
+			name: "no resources",
+			ns:   namespace{},
+			want: "",
+		}, {
+			name: "valid resources",
+			ns: namespace{
+				NetworkPolicies: &networkPolicies{DefaultDeny: true, AllowFromNamespaces: []map[string]string{{"team": "a"}}},
+				RoleBindings:    []roleBinding{{Name: "devs", ClusterRole: "edit", Groups: []string{"devs"}}},
+				ServiceAccounts: []serviceAccount{{Name: "deployer", ImagePullSecrets: []string{"registry"}}},
+			},
+			want: "",
+		}, {
+			name: "empty namespace selector",
+			ns:   namespace{NetworkPolicies: &networkPolicies{AllowFromNamespaces: []map[string]string{{}}}},
+			want: "networkPolicies.allowFromNamespaces can't have an empty selector, it would allow all namespaces",
+		}, {
+			name: "role binding without cluster role",
+			ns:   namespace{RoleBindings: []roleBinding{{Name: "devs", Groups: []string{"devs"}}}},
+			want: "roleBindings must have a name and a clusterRole",
+		}, {
+			name: "role binding without subjects",
+			ns:   namespace{RoleBindings: []roleBinding{{Name: "devs", ClusterRole: "edit"}}},
+			want: "roleBinding [ devs ] must have at least one group or user",
+		}, {
+			name: "duplicated service account",
+			ns:   namespace{ServiceAccounts: []serviceAccount{{Name: "deployer"}, {Name: "deployer"}}},
+			want: "serviceAccount [ deployer ] is defined more than once",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ""
+			if err := tt.ns.validate(); err != nil {
+				got = err.Error()
+			}
+			if got != tt.want {
+				t.Errorf("validate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_networkPolicyManifest(t *testing.T) {
+	got, err := networkPolicyManifest("staging", &networkPolicies{DefaultDeny: true, AllowFromNamespaces: []map[string]string{{"team": "a"}}}, map[string]string{"MANAGED-BY": "HELMSMAN"})
+	if err != nil {
+		t.Fatalf("networkPolicyManifest() error = %v", err)
+	}
+	if strings.Count(got, "---\n") != 2 || strings.Count(got, "kind: NetworkPolicy") != 2 {
+		t.Errorf("networkPolicyManifest() does not define 2 network policies:\n%s", got)
+	}
+	for _, want := range []string{"name: helmsman-default-deny", "name: helmsman-allow-from-namespaces", "namespace: staging",
+		"MANAGED-BY: HELMSMAN", "    - namespaceSelector:\n        matchLabels:\n          team: a\n"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("networkPolicyManifest() does not contain %q:\n%s", want, got)
+		}
+	}
+}
+
+func Test_roleBindingManifest(t *testing.T) {
+	got, err := roleBindingManifest("staging", []roleBinding{{Name: "devs", ClusterRole: "edit", Groups: []string{"devs"}, Users: []string{"jane"}}}, nil)
+	if err != nil {
+		t.Fatalf("roleBindingManifest() error = %v", err)
+	}
+	for _, want := range []string{"kind: RoleBinding", "  kind: ClusterRole\n  name: edit\n", "- kind: Group\n  name: devs\n", "- kind: User\n  name: jane\n"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("roleBindingManifest() does not contain %q:\n%s", want, got)
+		}
+	}
+}
+
+func Test_serviceAccountManifest(t *testing.T) {
+	got, err := serviceAccountManifest("staging", []serviceAccount{{Name: "deployer", ImagePullSecrets: []string{"registry"}}, {Name: "reader"}}, nil)
+	if err != nil {
+		t.Fatalf("serviceAccountManifest() error = %v", err)
+	}
+	if strings.Count(got, "kind: ServiceAccount") != 2 || strings.Count(got, "imagePullSecrets") != 1 || !strings.Contains(got, "- name: registry") {
+		t.Errorf("serviceAccountManifest() = \n%s", got)
+	}
+}
+
+func Test_currentState_planBaselineResources(t *testing.T) {
+	s := &state{Context: "default", tempDir: t.TempDir()}
+	cs := newCurrentState()
+	cs.namespaceResources = map[string]map[namespaceResource]bool{"staging": {
+		{networkPolicyKind, defaultDenyPolicy}: true,
+		{roleBindingKind, "devs"}:              true,
+		{roleBindingKind, "ops"}:               true,
+		{serviceAccountKind, "deployer"}:       true,
+	}}
+	ns := namespace{
+		NetworkPolicies: &networkPolicies{DefaultDeny: true},
+		RoleBindings:    []roleBinding{{Name: "devs", ClusterRole: "edit", Groups: []string{"devs"}}},
+	}
+	p := createPlan()
+
+	applied, err := cs.planBaselineResources("staging", ns, s, p, 0)
+	if err != nil {
+		t.Fatalf("planBaselineResources() error = %v", err)
+	}
+
+	if want := []string{"NetworkPolicies", "RoleBindings"}; !reflect.DeepEqual(applied, want) {
+		t.Errorf("planBaselineResources() applied = %v, want %v", applied, want)
+	}
+	var commands []string
+	for _, c := range p.Commands {
+		commands = append(commands, tempFileName.ReplaceAllString(c.Command.String(), "$1.yaml"))
+	}
+	want := []string{
+		"kubectl apply -f NetworkPolicy.yaml -n staging",
+		"kubectl apply -f RoleBinding.yaml -n staging",
+		"kubectl delete rolebinding ops -n staging",
+		"kubectl delete serviceaccount deployer -n staging",
+	}
+	if !reflect.DeepEqual(commands, want) {
+		t.Errorf("planBaselineResources() commands = %q, want %q", commands, want)
+	}
+	if len(p.Decisions) != 2 || p.Decisions[0].Type != delete || p.Decisions[1].Type != delete {
+		t.Errorf("planBaselineResources() decisions = %v, want 2 deletions", p.Decisions)
+	}
+}
+
+func Test_resourceNames(t *testing.T) {
+	resources := namespace{
+		RoleBindings:    []roleBinding{{Name: "ops"}, {Name: "devs"}},
+		ServiceAccounts: []serviceAccount{{Name: "deployer"}},
+	}.baselineResources()
+	if got, want := resourceNames(resources, roleBindingKind), "devs, ops"; got != want {
+		t.Errorf("resourceNames() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,261 @@
+package app
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// dockerConfigJSONType is the type of the secrets holding credentials to pull images from a registry
+	dockerConfigJSONType = "kubernetes.io/dockerconfigjson"
+	// namespaceSecretLabel tells the secrets created by Helmsman apart from the storage secrets of the releases, which are labeled by Helmsman too
+	namespaceSecretLabel = "HELMSMAN_NAMESPACE_SECRET"
+)
+
+// namespaceSecret type represents a secret created in a namespace.
+// The values of data are set in the desired state, where env variables and SSM parameters are substituted as usual,
+// and the ones of files are read from local or remote files. A dockerconfigjson secret is built from the credentials of its registry.
+type namespaceSecret struct {
+	Name     string               `yaml:"name"`
+	Type     string               `yaml:"type"`
+	Data     map[string]string    `yaml:"data"`
+	Files    map[string]string    `yaml:"files"`
+	Registry *registryCredentials `yaml:"registry"`
+}
+
+// registryCredentials type represents the credentials of an image registry
+type registryCredentials struct {
+	Server   string `yaml:"server"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	Email    string `yaml:"email"`
+}
+
+// validate checks a secret of a namespace
+func (ns namespaceSecret) validate() error {
+	if ns.Name == "" {
+		return errors.New("secrets must have a name")
+	}
+	if ns.Type == dockerConfigJSONType {
+		if ns.Registry == nil || ns.Registry.Server == "" || ns.Registry.Username == "" || ns.Registry.Password == "" {
+			return errors.New("secret [ " + ns.Name + " ] of type " + dockerConfigJSONType + " must have a registry with a server, a username and a password")
+		}
+		if len(ns.Data) > 0 || len(ns.Files) > 0 {
+			return errors.New("secret [ " + ns.Name + " ] of type " + dockerConfigJSONType + " can't have data or files")
+		}
+		return nil
+	}
+	if ns.Registry != nil {
+		return errors.New("secret [ " + ns.Name + " ] can only have a registry if its type is " + dockerConfigJSONType)
+	}
+	if len(ns.Data) == 0 && len(ns.Files) == 0 {
+		return errors.New("secret [ " + ns.Name + " ] must have data or files")
+	}
+	for key, f := range ns.Files {
+		if _, ok := ns.Data[key]; ok {
+			return errors.New("key [ " + key + " ] of secret [ " + ns.Name + " ] is set in both data and files")
+		}
+		if !isRemoteFile(f) {
+			if _, err := os.Stat(f); err != nil {
+				return errors.New("file [ " + f + " ] of secret [ " + ns.Name + " ] can't be read: " + err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+// getType returns the type of the secret, Opaque by default
+func (ns namespaceSecret) getType() string {
+	if ns.Type == "" {
+		return "Opaque"
+	}
+	return ns.Type
+}
+
+// resolve returns the content of the secret, reading its files. Remote files are downloaded to the temp dir of the state.
+func (ns namespaceSecret) resolve(s *state) (map[string]string, error) {
+	data := map[string]string{}
+	if ns.Type == dockerConfigJSONType {
+		auth := base64.StdEncoding.EncodeToString([]byte(ns.Registry.Username + ":" + ns.Registry.Password))
+		config, err := json.Marshal(map[string]interface{}{
+			"auths": map[string]interface{}{
+				ns.Registry.Server: map[string]string{
+					"username": ns.Registry.Username,
+					"password": ns.Registry.Password,
+					"email":    ns.Registry.Email,
+					"auth":     auth,
+				},
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		data[".dockerconfigjson"] = string(config)
+		return data, nil
+	}
+	for k, v := range ns.Data {
+		data[k] = v
+	}
+	for k, f := range ns.Files {
+		local, err := fetchFile(s.context(), s.tempDir, f)
+		if err != nil {
+			return nil, err
+		}
+		content, err := ioutil.ReadFile(local)
+		if err != nil {
+			return nil, err
+		}
+		data[k] = string(content)
+	}
+	return data, nil
+}
+
+// liveSecret is a secret created by Helmsman, as it is in the cluster
+type liveSecret struct {
+	Type string
+	// Data is decoded, it is nil if it can't be
+	Data map[string]string
+}
+
+// isUpToDate checks if the live secret has the given type and content
+func (ls liveSecret) isUpToDate(secretType string, data map[string]string) bool {
+	if ls.Data == nil || ls.Type != secretType || len(ls.Data) != len(data) {
+		return false
+	}
+	for k, v := range data {
+		if live, ok := ls.Data[k]; !ok || live != v {
+			return false
+		}
+	}
+	return true
+}
+
+// secretManifest returns the definition of a secret, with the given labels
+func secretManifest(ns string, name string, secretType string, data map[string]string, labels map[string]string) (string, error) {
+	type metadata struct {
+		Name      string            `yaml:"name"`
+		Namespace string            `yaml:"namespace"`
+		Labels    map[string]string `yaml:"labels,omitempty"`
+	}
+	encoded := make(map[string]string, len(data))
+	for k, v := range data {
+		encoded[k] = base64.StdEncoding.EncodeToString([]byte(v))
+	}
+	d, err := yaml.Marshal(struct {
+		APIVersion string            `yaml:"apiVersion"`
+		Kind       string            `yaml:"kind"`
+		Metadata   metadata          `yaml:"metadata"`
+		Type       string            `yaml:"type"`
+		Data       map[string]string `yaml:"data"`
+	}{"v1", "Secret", metadata{name, ns, labels}, secretType, encoded})
+	if err != nil {
+		return "", err
+	}
+	return string(d), nil
+}
+
+// getNamespaceSecrets fetches the secrets created by Helmsman for the given context in all the namespaces.
+// It returns nil if they can't be listed, in which case all the secrets are applied and none is deleted.
+func getNamespaceSecrets(s *state) map[string]map[string]liveSecret {
+	var list struct {
+		Items []struct {
+			Metadata struct {
+				Name      string `json:"name"`
+				Namespace string `json:"namespace"`
+			} `json:"metadata"`
+			Type string            `json:"type"`
+			Data map[string]string `json:"data"`
+		} `json:"items"`
+	}
+	cmd := kubectl([]string{"get", "secrets", "--all-namespaces", "-l", "MANAGED-BY=HELMSMAN,HELMSMAN_CONTEXT=" + s.Context + "," + namespaceSecretLabel + "=true", "-o", "json"},
+		"Listing the secrets created by Helmsman").inCluster(s, s.Settings.KubeContext).withTimeout(s.commandTimeout(statePhase))
+	ctx := s.context()
+	result := cmd.retryExec(ctx, s.getRetryPolicy(nil), s.retries)
+	if result.code != 0 {
+		s.log.Warning("Could not list the secrets created by Helmsman, they will all be applied " +
+			"and the ones removed from the desired state will not be deleted: " + firstLine(result.errors))
+		return nil
+	}
+	if err := json.Unmarshal([]byte(result.output), &list); err != nil {
+		s.log.Warning("Could not read the secrets created by Helmsman: " + err.Error())
+		return nil
+	}
+	secrets := map[string]map[string]liveSecret{}
+	for _, item := range list.Items {
+		if secrets[item.Metadata.Namespace] == nil {
+			secrets[item.Metadata.Namespace] = map[string]liveSecret{}
+		}
+		secrets[item.Metadata.Namespace][item.Metadata.Name] = liveSecret{Type: item.Type, Data: decodeSecretData(item.Data)}
+	}
+	return secrets
+}
+
+// decodeSecretData decodes the base64 values of the data of a secret. It returns nil if one of them can't be decoded.
+func decodeSecretData(encoded map[string]string) map[string]string {
+	data := make(map[string]string, len(encoded))
+	for k, v := range encoded {
+		d, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil
+		}
+		data[k] = string(d)
+	}
+	return data
+}
+
+// planNamespaceSecrets plans creating the secrets of a namespace, refreshing the ones whose live content differs
+// and deleting the ones Helmsman created which were removed from the desired state.
+// The definitions of the secrets are passed to kubectl on its standard input rather than written to files.
+// Their remote files are still downloaded to the temp dir of the state by resolve.
+func (cs *currentState) planNamespaceSecrets(name string, ns namespace, s *state, p *plan, priority int) error {
+	labels := managedNamespaceLabels(s.Context)
+	labels[namespaceSecretLabel] = "true"
+
+	current := cs.namespaceSecrets[name]
+	desired := map[string]bool{}
+	for _, secret := range ns.Secrets {
+		desired[secret.Name] = true
+		data, err := secret.resolve(s)
+		if err != nil {
+			return errors.New("failed to read secret [ " + secret.Name + " ] of namespace [ " + name + " ]: " + err.Error())
+		}
+		live, exists := current[secret.Name]
+		if exists && live.isUpToDate(secret.getType(), data) {
+			p.addDecision("Secret [ "+secret.Name+" ] in namespace [ "+name+" ] is up-to-date", priority, noop)
+			continue
+		}
+		definition, err := secretManifest(name, secret.Name, secret.getType(), data, labels)
+		if err != nil {
+			return err
+		}
+		cmd := kubectl(concat([]string{"apply", "-f", "-", "-n", name}, s.opts.getKubectlDryRunFlags()), "Applying Secret [ "+secret.Name+" ] in namespace [ "+name+" ]").inCluster(s, s.Settings.KubeContext)
+		cmd.Stdin = definition
+		p.addCommand(cmd, priority, nil)
+		if exists {
+			p.addDecisionFor(name, "", "Secret [ "+secret.Name+" ] in namespace [ "+name+" ] has changed and will be refreshed", priority, change)
+		} else {
+			p.addDecisionFor(name, "", "Secret [ "+secret.Name+" ] will be created in namespace [ "+name+" ]", priority, create)
+		}
+	}
+
+	var removed []string
+	for secret := range current {
+		if !desired[secret] {
+			removed = append(removed, secret)
+		}
+	}
+	sort.Strings(removed)
+	for _, secret := range removed {
+		cmd := kubectl(concat([]string{"delete", "secret", secret, "-n", name}, s.opts.getKubectlDryRunFlags()),
+			"Deleting Secret [ "+secret+" ] in namespace [ "+name+" ]").inCluster(s, s.Settings.KubeContext)
+		p.addCommand(cmd, priority, nil)
+		p.addDecisionFor(name, "", "Secret [ "+secret+" ] was removed from namespace [ "+name+" ] in the desired state and will be DELETED", priority, delete)
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package app
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_namespaceSecret_validate(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret namespaceSecret
+		want   string
+	}{
+		{
+			name:   "valid opaque secret",
+			secret: namespaceSecret{Name: "db", Data: map[string]string{"password": "secret"}},
+			want:   "",
+		}, {
+			name:   "valid pull secret",
+			secret: namespaceSecret{Name: "registry", Type: dockerConfigJSONType, Registry: &registryCredentials{Server: "registry.example.com", Username: "bot", Password: "secret"}},
+			want:   "",
+		}, {
+			name:   "no name",
+			secret: namespaceSecret{Data: map[string]string{"password": "secret"}},
+			want:   "secrets must have a name",
+		}, {
+			name:   "no content",
+			secret: namespaceSecret{Name: "db"},
+			want:   "secret [ db ] must have data or files",
+		}, {
+			name:   "pull secret without password",
+			secret: namespaceSecret{Name: "registry", Type: dockerConfigJSONType, Registry: &registryCredentials{Server: "registry.example.com", Username: "bot"}},
+			want:   "secret [ registry ] of type kubernetes.io/dockerconfigjson must have a registry with a server, a username and a password",
+		}, {
+			name:   "registry of an opaque secret",
+			secret: namespaceSecret{Name: "registry", Registry: &registryCredentials{Server: "registry.example.com"}},
+			want:   "secret [ registry ] can only have a registry if its type is kubernetes.io/dockerconfigjson",
+		}, {
+			name:   "key in data and files",
+			secret: namespaceSecret{Name: "db", Data: map[string]string{"password": "secret"}, Files: map[string]string{"password": "password.txt"}},
+			want:   "key [ password ] of secret [ db ] is set in both data and files",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ""
+			if err := tt.secret.validate(); err != nil {
+				got = err.Error()
+			}
+			if got != tt.want {
+				t.Errorf("validate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_namespaceSecret_resolve(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "ca.crt")
+	if err := ioutil.WriteFile(file, []byte("certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := namespaceSecret{Name: "db", Data: map[string]string{"password": "secret"}, Files: map[string]string{"ca.crt": file}}.resolve(&state{})
+	if err != nil {
+		t.Fatalf("resolve() error = %v", err)
+	}
+	if want := map[string]string{"password": "secret", "ca.crt": "certificate"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("resolve() = %v, want %v", got, want)
+	}
+
+	got, err = namespaceSecret{Name: "registry", Type: dockerConfigJSONType, Registry: &registryCredentials{Server: "registry.example.com", Username: "bot", Password: "secret"}}.resolve(&state{})
+	if err != nil {
+		t.Fatalf("resolve() error = %v", err)
+	}
+	auth := base64.StdEncoding.EncodeToString([]byte("bot:secret"))
+	want := `{"auths":{"registry.example.com":{"auth":"` + auth + `","email":"","password":"secret","username":"bot"}}}`
+	if got[".dockerconfigjson"] != want {
+		t.Errorf("resolve() = %v, want %v", got[".dockerconfigjson"], want)
+	}
+}
+
+func Test_secretManifest(t *testing.T) {
+	got, err := secretManifest("staging", "db", "Opaque", map[string]string{"password": "secret"}, map[string]string{namespaceSecretLabel: "true"})
+	if err != nil {
+		t.Fatalf("secretManifest() error = %v", err)
+	}
+	for _, want := range []string{"kind: Secret", "name: db", "namespace: staging", "type: Opaque", "HELMSMAN_NAMESPACE_SECRET: \"true\"",
+		"password: " + base64.StdEncoding.EncodeToString([]byte("secret"))} {
+		if !strings.Contains(got, want) {
+			t.Errorf("secretManifest() does not contain %q:\n%s", want, got)
+		}
+	}
+}
+
+func Test_liveSecret_isUpToDate(t *testing.T) {
+	data := map[string]string{"password": "secret"}
+	tests := []struct {
+		name string
+		live liveSecret
+		want bool
+	}{
+		{name: "same content", live: liveSecret{Type: "Opaque", Data: map[string]string{"password": "secret"}}, want: true},
+		{name: "changed value", live: liveSecret{Type: "Opaque", Data: map[string]string{"password": "old"}}},
+		{name: "extra key", live: liveSecret{Type: "Opaque", Data: map[string]string{"password": "secret", "user": "admin"}}},
+		{name: "changed type", live: liveSecret{Type: dockerConfigJSONType, Data: map[string]string{"password": "secret"}}},
+		{name: "undecodable data", live: liveSecret{Type: "Opaque"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.live.isUpToDate("Opaque", data); got != tt.want {
+				t.Errorf("isUpToDate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_decodeSecretData(t *testing.T) {
+	if got, want := decodeSecretData(map[string]string{"password": base64.StdEncoding.EncodeToString([]byte("secret"))}), map[string]string{"password": "secret"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("decodeSecretData() = %v, want %v", got, want)
+	}
+	if got := decodeSecretData(map[string]string{"password": "not base64!"}); got != nil {
+		t.Errorf("decodeSecretData() of invalid data = %v, want nil", got)
+	}
+}
+
+func Test_currentState_planNamespaceSecrets(t *testing.T) {
+	s := &state{Context: "default", tempDir: t.TempDir()}
+	cs := newCurrentState()
+	cs.namespaceSecrets = map[string]map[string]liveSecret{"staging": {
+		"unchanged": {Type: "Opaque", Data: map[string]string{"key": "value"}},
+		"changed":   {Type: "Opaque", Data: map[string]string{"key": "old"}},
+		"removed":   {Type: "Opaque", Data: map[string]string{}},
+	}}
+	ns := namespace{Secrets: []namespaceSecret{
+		{Name: "unchanged", Data: map[string]string{"key": "value"}},
+		{Name: "changed", Data: map[string]string{"key": "new"}},
+		{Name: "created", Data: map[string]string{"key": "value"}},
+	}}
+	p := createPlan()
+
+	cs.planNamespaceSecrets("staging", ns, s, p, 0)
+
+	var commands []string
+	for _, c := range p.Commands {
+		commands = append(commands, tempFileName.ReplaceAllString(c.Command.String(), "$1.yaml"))
+	}
+	wantCommands := []string{
+		"kubectl apply -f - -n staging",
+		"kubectl apply -f - -n staging",
+		"kubectl delete secret removed -n staging",
+	}
+	if !reflect.DeepEqual(commands, wantCommands) {
+		t.Errorf("planNamespaceSecrets() commands = %q, want %q", commands, wantCommands)
+	}
+	if !strings.Contains(p.Commands[0].Command.Stdin, "name: changed") || !strings.Contains(p.Commands[1].Command.Stdin, "name: created") {
+		t.Errorf("planNamespaceSecrets() did not pass the definitions of the secrets on stdin")
+	}
+	if files, _ := ioutil.ReadDir(s.tempDir); len(files) != 0 {
+		t.Errorf("planNamespaceSecrets() wrote %d files, want the secrets kept off the disk", len(files))
+	}
+	var types []decisionType
+	for _, d := range p.Decisions {
+		types = append(types, d.Type)
+	}
+	if wantTypes := []decisionType{noop, change, create, delete}; !reflect.DeepEqual(types, wantTypes) {
+		t.Errorf("planNamespaceSecrets() decisions = %v, want %v", types, wantTypes)
+	}
+}
@@ -0,0 +1,115 @@
+package app
+
+import (
+	"errors"
+	"time"
+)
+
+// Options holds the settings of a Helmsman run which are not part of the desired state files.
+// The command line flags of Helmsman map to these options. Use DefaultOptions to get the options
+// of a run without any flag set.
+type Options struct {
+	// Files are the desired state files, merged in the given order
+	Files []string
+	// Targets limits the run to these apps
+	Targets []string
+	// Groups limits the run to the apps of these groups
+	Groups []string
+
+	Apply   bool
+	DryRun  bool
+	Destroy bool
+
+	Debug    bool
+	Verbose  bool
+	NoColors bool
+
+	// NoNs skips the creation of the namespaces
+	NoNs bool
+	// NsOverride deploys all the apps to this namespace instead of the ones defined in the desired state
+	NsOverride string
+	// ContextOverride is used as the Helmsman context of all the deployed releases
+	ContextOverride string
+	// MigrateContext relabels the releases of the apps with the context of the desired state
+	MigrateContext        bool
+	SkipValidation        bool
+	KeepUntrackedReleases bool
+
+	ShowDiff bool
+	// DiffContext is the number of lines of context shown around the changes in diffs, -1 uses the helm diff default
+	DiffContext int
+	// DiffReport is the directory where the diff of each release and a summary index are written, empty means no report
+	DiffReport string
+	// DiffReportFormat is the format of the index of the diff report: markdown or html
+	DiffReportFormat string
+
+	NoEnvSubst     bool
+	SubstEnvValues bool
+	NoSSMSubst     bool
+	SubstSSMValues bool
+
+	UpdateDeps    bool
+	ForceUpgrades bool
+
+	// AllowPolicyViolations applies the plans which violate the policies of the desired state
+	AllowPolicyViolations bool
+
+	// LockFile is where the chart versions resolved from version constraints are recorded
+	LockFile   string
+	UpdateLock bool
+
+	// Timeout stops the whole run once it has been going on for longer, 0 means no timeout
+	Timeout time.Duration
+	// RepoTimeout, StateTimeout, DiffTimeout and ApplyTimeout stop any command running for longer in each phase of a run:
+	// setting up the helm repos, reading the current state of the cluster, diffing the releases and applying the plan.
+	// 0 means no timeout.
+	RepoTimeout  time.Duration
+	StateTimeout time.Duration
+	DiffTimeout  time.Duration
+	ApplyTimeout time.Duration
+}
+
+// DefaultOptions returns the options of a run without any command line flag
+func DefaultOptions() Options {
+	return Options{
+		DiffContext:      -1,
+		DiffReportFormat: diffReportMarkdown,
+		LockFile:         "helmsman.lock",
+	}
+}
+
+// validate checks that the options can be used together
+func (o Options) validate() error {
+	if o.DryRun && o.Apply {
+		return errors.New("--apply and --dry-run can't be used together.")
+	}
+	if o.Destroy && o.Apply {
+		return errors.New("--destroy and --apply can't be used together.")
+	}
+	if len(o.Targets) > 0 && len(o.Groups) > 0 {
+		return errors.New("--target and --group can't be used together.")
+	}
+	if o.Timeout < 0 || o.RepoTimeout < 0 || o.StateTimeout < 0 || o.DiffTimeout < 0 || o.ApplyTimeout < 0 {
+		return errors.New("timeouts can't be negative.")
+	}
+	if o.DiffReportFormat != "" && o.DiffReportFormat != diffReportMarkdown && o.DiffReportFormat != diffReportHTML {
+		return errors.New("--diff-report-format must be markdown or html.")
+	}
+	return nil
+}
+
+// getKubectlDryRunFlags returns the dry-run flag of the kubectl commands changing the cluster
+func (o Options) getKubectlDryRunFlags() []string {
+	if o.DryRun {
+		return []string{"--dry-run=server"}
+	}
+	return []string{}
+}
+
+// getDryRunFlags returns dry-run flag
+func (o Options) getDryRunFlags() []string {
+	if o.DryRun {
+		return []string{"--dry-run", "--debug"}
+	}
+	return []string{}
+}
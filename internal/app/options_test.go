@@ -0,0 +1,81 @@
+package app
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_Options_validate(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+		want string
+	}{
+		{
+			name: "apply",
+			opts: Options{Apply: true, Targets: []string{"api"}},
+			want: "",
+		}, {
+			name: "apply and dry-run",
+			opts: Options{Apply: true, DryRun: true},
+			want: "--apply and --dry-run can't be used together.",
+		}, {
+			name: "apply and destroy",
+			opts: Options{Apply: true, Destroy: true},
+			want: "--destroy and --apply can't be used together.",
+		}, {
+			name: "target and group",
+			opts: Options{Targets: []string{"api"}, Groups: []string{"backend"}},
+			want: "--target and --group can't be used together.",
+		}, {
+			name: "timeouts",
+			opts: Options{Timeout: time.Hour, DiffTimeout: time.Minute},
+			want: "",
+		}, {
+			name: "negative timeout",
+			opts: Options{ApplyTimeout: -time.Second},
+			want: "timeouts can't be negative.",
+		}, {
+			name: "html diff report",
+			opts: Options{DiffReport: "out", DiffReportFormat: "html"},
+			want: "",
+		}, {
+			name: "unknown diff report format",
+			opts: Options{DiffReport: "out", DiffReportFormat: "pdf"},
+			want: "--diff-report-format must be markdown or html.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ""
+			if err := tt.opts.validate(); err != nil {
+				got = err.Error()
+			}
+			if got != tt.want {
+				t.Errorf("validate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_cli_parse(t *testing.T) {
+	var c cli
+	c.parse([]string{"outdated", "-f", "a.yaml", "-f", "b.yaml", "--target", "api", "--no-fancy", "--output", "json", "--timeout", "30m", "--diff-timeout", "90s"})
+
+	if !c.outdated || c.output != "json" {
+		t.Errorf("parse() outdated = %v, output = %s", c.outdated, c.output)
+	}
+	if !reflect.DeepEqual(c.Files, []string{"a.yaml", "b.yaml"}) || !reflect.DeepEqual(c.Targets, []string{"api"}) {
+		t.Errorf("parse() files = %v, targets = %v", c.Files, c.Targets)
+	}
+	if !c.NoColors || !c.noBanner {
+		t.Errorf("parse() --no-fancy must disable the colors and the banner")
+	}
+	if c.DiffContext != -1 || c.LockFile != "helmsman.lock" || c.DiffReportFormat != "markdown" {
+		t.Errorf("parse() diff context = %d, lock file = %s, diff report format = %s, want the default options", c.DiffContext, c.LockFile, c.DiffReportFormat)
+	}
+	if c.Timeout != 30*time.Minute || c.DiffTimeout != 90*time.Second || c.ApplyTimeout != 0 {
+		t.Errorf("parse() timeout = %s, diff timeout = %s, apply timeout = %s", c.Timeout, c.DiffTimeout, c.ApplyTimeout)
+	}
+}
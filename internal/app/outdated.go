@@ -0,0 +1,204 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"text/tabwriter"
+
+	version "github.com/hashicorp/go-version"
+)
+
+// outdatedChart describes the chart versions available for an app compared to the one it uses
+type outdatedChart struct {
+	App     string `json:"app"`
+	Chart   string `json:"chart"`
+	Current string `json:"current"`
+	// Checked is false if the versions available can't be listed, as for OCI charts, the latest versions are then empty
+	Checked     bool   `json:"checked"`
+	LatestPatch string `json:"latestPatch,omitempty"`
+	LatestMinor string `json:"latestMinor,omitempty"`
+	LatestMajor string `json:"latestMajor,omitempty"`
+}
+
+// isOutdated checks if a newer version of the chart is available
+func (o outdatedChart) isOutdated() bool {
+	return o.Checked && o.LatestMajor != o.Current
+}
+
+// latest returns the latest patch, minor and major versions to print in the outdated table
+func (o outdatedChart) latest() (string, string, string) {
+	if !o.Checked {
+		return "unknown", "unknown", "unknown"
+	}
+	return o.LatestPatch, o.LatestMinor, o.LatestMajor
+}
+
+// printOutdatedCharts compares the chart version of every app to run with the versions available
+// in the helm repositories (or the Chart.yaml of local charts) and prints the result as a table or as JSON,
+// depending on the output format.
+func printOutdatedCharts(s *state, output string) error {
+	report, err := getOutdatedCharts(s)
+	if err != nil {
+		return err
+	}
+
+	if output == "json" {
+		out, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+		return nil
+	}
+
+	outdated, unchecked := 0, 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "APP\tCHART\tCURRENT\tLATEST PATCH\tLATEST MINOR\tLATEST MAJOR")
+	for _, o := range report {
+		if o.isOutdated() {
+			outdated++
+		}
+		if !o.Checked {
+			unchecked++
+		}
+		patch, minor, major := o.latest()
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", o.App, o.Chart, o.Current, patch, minor, major)
+	}
+	w.Flush()
+	s.log.Info(fmt.Sprintf("%d of %d charts have newer versions available", outdated, len(report)))
+	if unchecked > 0 {
+		s.log.Info(fmt.Sprintf("%d charts were not checked as their newer versions can't be listed", unchecked))
+	}
+	return nil
+}
+
+// getOutdatedCharts builds the outdated report of the apps to run, sorted by app name
+func getOutdatedCharts(s *state) ([]outdatedChart, error) {
+	lock, err := readLockFile(s.opts.LockFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		fail   bool
+		report []outdatedChart
+		mutex  = &sync.Mutex{}
+		wg     = sync.WaitGroup{}
+		sem    = make(chan struct{}, resourcePool)
+	)
+	for app, r := range s.Apps {
+		if !r.isConsideredToRun(s) {
+			continue
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(r *release, app string) {
+			defer func() {
+				wg.Done()
+				<-sem
+			}()
+			o, err := r.getOutdatedChart(s.context(), app, lock)
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err != nil {
+				fail = true
+				s.log.Error(err.Error())
+				return
+			}
+			report = append(report, o)
+		}(r, app)
+	}
+	wg.Wait()
+
+	if fail {
+		return nil, errors.New("failed to check charts for newer versions")
+	}
+	sort.Slice(report, func(i, j int) bool {
+		return report[i].App < report[j].App
+	})
+	return report, nil
+}
+
+// getOutdatedChart compares the chart version of a release with the available ones.
+// Version constraints are compared using their locked version if there is one, or the version they resolve to otherwise.
+func (r *release) getOutdatedChart(ctx context.Context, app string, lock *lockFile) (outdatedChart, error) {
+	if isOCIChart(r.Chart) {
+		// OCI registries can't be searched for newer versions
+		loggerFrom(ctx).Verbose("Newer versions of OCI chart [ " + r.Chart + " ] can't be listed")
+		return outdatedChart{App: app, Chart: r.Chart, Current: r.Version}, nil
+	}
+
+	var available []string
+	if isLocalChart(r.Chart) {
+		v, err := getLocalChartVersion(ctx, r.Chart)
+		if err != nil {
+			return outdatedChart{}, fmt.Errorf("chart [ %s ] for app [ %s ] can't be inspected: %w", r.Chart, app, err)
+		}
+		available = []string{v}
+	} else {
+		var err error
+		if available, err = searchChartVersions(ctx, r.Chart); err != nil || len(available) == 0 {
+			return outdatedChart{}, fmt.Errorf("chart [ %s ] for app [ %s ] was not found in the helm repositories", r.Chart, app)
+		}
+	}
+
+	current := r.Version
+	if !isExactVersion(current) {
+		if locked, ok := lock.Apps[app]; ok && locked.Chart == r.Chart && locked.Constraint == r.Version {
+			current = locked.Version
+		} else {
+			resolved, err := latestMatchingVersion(available, r.Version)
+			if err != nil {
+				return outdatedChart{}, err
+			}
+			current = resolved
+		}
+	}
+
+	patch, minor, major := latestVersions(current, available)
+	return outdatedChart{
+		App:         app,
+		Chart:       r.Chart,
+		Current:     current,
+		Checked:     true,
+		LatestPatch: patch,
+		LatestMinor: minor,
+		LatestMajor: major,
+	}, nil
+}
+
+// latestVersions returns the latest of the available versions sharing the major and minor version of current (patch),
+// sharing its major version (minor) and overall (major).
+// Pre-release versions are ignored. Current is returned for a category with no newer version.
+func latestVersions(current string, available []string) (string, string, string) {
+	patch, minor, major := current, current, current
+	cur, err := version.NewVersion(current)
+	if err != nil {
+		return patch, minor, major
+	}
+	latestPatch, latestMinor, latestMajor := cur, cur, cur
+	c := cur.Segments()
+
+	for _, raw := range available {
+		v, err := version.NewVersion(raw)
+		if err != nil || v.Prerelease() != "" {
+			continue
+		}
+		s := v.Segments()
+		if v.GreaterThan(latestMajor) {
+			latestMajor, major = v, raw
+		}
+		if s[0] == c[0] && v.GreaterThan(latestMinor) {
+			latestMinor, minor = v, raw
+		}
+		if s[0] == c[0] && s[1] == c[1] && v.GreaterThan(latestPatch) {
+			latestPatch, patch = v, raw
+		}
+	}
+	return patch, minor, major
+}
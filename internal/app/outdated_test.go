@@ -0,0 +1,85 @@
+package app
+
+import (
+	"context"
+	"testing"
+)
+
+func Test_latestVersions(t *testing.T) {
+	available := []string{"1.2.0", "1.2.3", "1.2.9", "1.3.0", "1.5.2", "2.0.0-rc.1", "2.1.0", "3.0.1"}
+	tests := []struct {
+		name      string
+		current   string
+		wantPatch string
+		wantMinor string
+		wantMajor string
+	}{
+		{
+			name:      "test case 1 -- newer patch, minor and major",
+			current:   "1.2.3",
+			wantPatch: "1.2.9",
+			wantMinor: "1.5.2",
+			wantMajor: "3.0.1",
+		}, {
+			name:      "test case 2 -- only a newer major",
+			current:   "2.1.0",
+			wantPatch: "2.1.0",
+			wantMinor: "2.1.0",
+			wantMajor: "3.0.1",
+		}, {
+			name:      "test case 3 -- up-to-date",
+			current:   "3.0.1",
+			wantPatch: "3.0.1",
+			wantMinor: "3.0.1",
+			wantMajor: "3.0.1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patch, minor, major := latestVersions(tt.current, available)
+			if patch != tt.wantPatch || minor != tt.wantMinor || major != tt.wantMajor {
+				t.Errorf("latestVersions() = %v, %v, %v, want %v, %v, %v", patch, minor, major, tt.wantPatch, tt.wantMinor, tt.wantMajor)
+			}
+		})
+	}
+}
+
+func Test_getOutdatedChart(t *testing.T) {
+	// the charts of the helm repos, listed by helm search repo <chart> -l -o json
+	fakeTool(t, "helm", `echo '[{"name":"repo/web","version":"1.2.0"},{"name":"repo/web","version":"1.2.3"},{"name":"repo/web","version":"2.0.0"}]'`)
+	tests := []struct {
+		name       string
+		r          *release
+		want       outdatedChart
+		wantLatest string
+	}{
+		{
+			name:       "repo chart",
+			r:          &release{Chart: "repo/web", Version: "1.2.0"},
+			want:       outdatedChart{App: "web", Chart: "repo/web", Current: "1.2.0", Checked: true, LatestPatch: "1.2.3", LatestMinor: "1.2.3", LatestMajor: "2.0.0"},
+			wantLatest: "2.0.0",
+		}, {
+			name:       "OCI chart",
+			r:          &release{Chart: "oci://registry.example.com/web", Version: "1.2.0"},
+			want:       outdatedChart{App: "web", Chart: "oci://registry.example.com/web", Current: "1.2.0"},
+			wantLatest: "unknown",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.r.getOutdatedChart(context.Background(), "web", &lockFile{})
+			if err != nil {
+				t.Fatalf("getOutdatedChart() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("getOutdatedChart() = %+v, want %+v", got, tt.want)
+			}
+			if got.isOutdated() != tt.want.Checked {
+				t.Errorf("isOutdated() = %v, want %v", got.isOutdated(), tt.want.Checked)
+			}
+			if _, _, major := got.latest(); major != tt.wantLatest {
+				t.Errorf("latest() major = %v, want %v", major, tt.wantLatest)
+			}
+		})
+	}
+}
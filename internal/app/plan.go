@@ -1,6 +1,8 @@
 package app
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/url"
 	"sort"
@@ -21,18 +23,42 @@ const (
 	ignored
 )
 
+// String allows for pretty printing decisionType const
+func (dt decisionType) String() string {
+	switch dt {
+	case create:
+		return "create"
+	case change:
+		return "change"
+	case delete:
+		return "delete"
+	case noop:
+		return "noop"
+	case ignored:
+		return "ignored"
+	}
+	return "unknown"
+}
+
 // orderedDecision type representing a Decision and it's priority weight
 type orderedDecision struct {
 	Description string
 	Priority    int
 	Type        decisionType
+	// namespace and release are what the decision is about, for the policies to be checked against it.
+	// release is empty for the decisions about a namespace and its resources, and both are empty for the other decisions.
+	namespace string
+	release   string
 }
 
 // orderedCommand type representing a Command and it's priority weight and the targeted release from the desired state
+// Commands of health checks are retried until the check passes or times out.
 type orderedCommand struct {
 	Command       command
 	Priority      int
 	targetRelease *release
+	healthCheck   *healthCheck
+	hook          bool
 }
 
 // plan type representing the plan of actions to make the desired state come true.
@@ -41,6 +67,9 @@ type plan struct {
 	Commands  []orderedCommand
 	Decisions []orderedDecision
 	Created   time.Time
+	Cluster   string
+	// log is the logger of the run the plan is made for
+	log *Logger
 }
 
 // createPlan initializes an empty plan
@@ -65,56 +94,171 @@ func (p *plan) addCommand(cmd command, priority int, r *release) {
 	p.Commands = append(p.Commands, oc)
 }
 
+// addHook adds the command of a lifecycle hook to the plan. Hooks are never retried.
+func (p *plan) addHook(cmd command, priority int) {
+	p.Lock()
+	defer p.Unlock()
+	oc := orderedCommand{
+		Command:  cmd,
+		Priority: priority,
+		hook:     true,
+	}
+
+	p.Commands = append(p.Commands, oc)
+}
+
+// addHealthCheck adds the command of a health check to the plan
+func (p *plan) addHealthCheck(cmd command, priority int, hc healthCheck) {
+	p.Lock()
+	defer p.Unlock()
+	oc := orderedCommand{
+		Command:     cmd,
+		Priority:    priority,
+		healthCheck: &hc,
+	}
+
+	p.Commands = append(p.Commands, oc)
+}
+
 // addDecision adds a decision type to the plan
 func (p *plan) addDecision(decision string, priority int, decisionType decisionType) {
+	p.addDecisionFor("", "", decision, priority, decisionType)
+}
+
+// addDecisionFor adds a decision about a release, or a namespace when release is empty, to the plan
+func (p *plan) addDecisionFor(namespace string, release string, decision string, priority int, decisionType decisionType) {
 	p.Lock()
 	defer p.Unlock()
 	od := orderedDecision{
 		Description: decision,
 		Priority:    priority,
 		Type:        decisionType,
+		namespace:   namespace,
+		release:     release,
 	}
 	p.Decisions = append(p.Decisions, od)
 }
 
-// execPlan executes the commands (actions) which were added to the plan.
-func (p *plan) exec() {
+// execPlan executes the commands (actions) which were added to the plan for the given desired state.
+// It stops at the first command which fails and returns its error.
+// Once the context is done, no new command is started and what was executed is reported.
+// The running command is left to finish: it is only stopped if it runs for longer than the apply timeout,
+// or when the apply context of the state is done. It is retried if it fails with a transient error and its retry policy allows it.
+func (p *plan) exec(ctx context.Context, s *state) error {
 	p.sort()
 	if len(p.Commands) > 0 {
-		log.Info("Executing plan... ")
+		s.log.Info("Executing plan... ")
 	} else {
-		log.Info("Nothing to execute")
+		s.log.Info("Nothing to execute")
 	}
 
-	for _, cmd := range p.Commands {
-		log.Notice(cmd.Command.Description)
-		result := cmd.Command.exec()
-		if cmd.targetRelease != nil && !flags.dryRun && !flags.destroy {
-			cmd.targetRelease.label()
+	for i, cmd := range p.Commands {
+		if ctx.Err() != nil {
+			p.reportProgress(i)
+			return errors.New("plan execution stopped: " + stopReason(ctx, s))
+		}
+		s.log.Notice(cmd.Command.Description)
+		cmd.Command = cmd.Command.withTimeout(s.commandTimeout(applyPhase))
+		var result exitStatus
+		if cmd.healthCheck != nil {
+			result = cmd.healthCheck.await(ctx, cmd.Command)
+		} else if cmd.retried(s) {
+			result = cmd.Command.retryExec(s.applyContext(), s.getRetryPolicy(cmd.targetRelease), s.retries)
+		} else {
+			result = cmd.Command.exec(s.applyContext())
+		}
+		// the release is labelled even if the command failed, so that Helmsman keeps managing it
+		var labelErr error
+		if cmd.targetRelease != nil && !s.opts.DryRun && !s.opts.Destroy {
+			labelErr = cmd.targetRelease.label(s)
 		}
 		if result.code != 0 {
 			errorMsg := result.errors
-			if !flags.verbose {
+			if !s.opts.Verbose {
 				errorMsg = strings.Split(result.errors, "---")[0]
 			}
-			log.Fatal(fmt.Sprintf("Command returned [ %d ] exit code and error message [ %s ]", result.code, strings.TrimSpace(errorMsg)))
+			if ctx.Err() != nil {
+				p.reportProgress(i)
+			}
+			if labelErr != nil {
+				s.log.Error(labelErr.Error())
+			}
+			return fmt.Errorf("command returned [ %d ] exit code and error message [ %s ]", result.code, strings.TrimSpace(errorMsg))
+		} else if labelErr != nil {
+			return labelErr
 		} else {
-			log.Notice(result.output)
-			log.Notice("Finished: " + cmd.Command.Description)
-			if _, err := url.ParseRequestURI(settings.SlackWebhook); err == nil {
-				notifySlack(cmd.Command.Description+" ... SUCCESS!", settings.SlackWebhook, false, true)
+			s.log.Notice(result.output)
+			s.log.Notice("Finished: " + cmd.Command.Description)
+			if _, err := url.ParseRequestURI(s.Settings.SlackWebhook); err == nil {
+				s.log.notifySlack(cmd.Command.Description+" ... SUCCESS!", s.Settings.SlackWebhook, false, true)
 			}
 		}
 	}
 
 	if len(p.Commands) > 0 {
-		log.Info("Plan applied")
+		s.log.Info("Plan applied")
+	}
+	return nil
+}
+
+// retried checks if a command of the plan is retried when it fails with a transient error.
+// The commands of the plan change the cluster: they are only retried when the retry policy of their release opts in. Hooks never are.
+func (oc orderedCommand) retried(s *state) bool {
+	return !oc.hook && s.getRetryPolicy(oc.targetRelease).Mutating
+}
+
+// reportProgress logs which commands of the plan were executed when its execution stopped before the end,
+// and in which state this leaves the releases they target: the commands before the given index were executed successfully.
+func (p *plan) reportProgress(executed int) {
+	p.log.Warning(fmt.Sprintf("Plan execution stopped after [ %d ] of [ %d ] commands", executed, len(p.Commands)))
+
+	type progress struct {
+		release     *release
+		done, total int
+	}
+	var releases []*progress
+	byRelease := map[*release]*progress{}
+	for i, cmd := range p.Commands {
+		if i < executed {
+			p.log.Info("Executed: " + cmd.Command.Description)
+		} else {
+			p.log.Warning("Not executed: " + cmd.Command.Description)
+		}
+		if cmd.targetRelease == nil {
+			continue
+		}
+		rp, ok := byRelease[cmd.targetRelease]
+		if !ok {
+			rp = &progress{release: cmd.targetRelease}
+			byRelease[cmd.targetRelease] = rp
+			releases = append(releases, rp)
+		}
+		rp.total++
+		if i < executed {
+			rp.done++
+		}
+	}
+
+	for _, rp := range releases {
+		r := "Release [ " + rp.release.Name + " ] in namespace [ " + rp.release.Namespace + " ]"
+		switch rp.done {
+		case rp.total:
+			p.log.Info(r + " is in its desired state")
+		case 0:
+			p.log.Warning(r + " was not changed")
+		default:
+			p.log.Warning(r + " was partially changed: check its status with helm before running Helmsman again")
+		}
 	}
 }
 
 // printPlanCmds prints the actual commands that will be executed as part of a plan.
 func (p *plan) printCmds() {
-	log.Info("Printing the commands of the current plan ...")
+	if p.Cluster != "" {
+		p.log.Info("Printing the commands of the current plan for cluster [ " + p.Cluster + " ] ...")
+	} else {
+		p.log.Info("Printing the commands of the current plan ...")
+	}
 	for _, cmd := range p.Commands {
 		fmt.Println(cmd.Command.String())
 	}
@@ -122,34 +266,60 @@ func (p *plan) printCmds() {
 
 // printPlan prints the decisions made in a plan.
 func (p *plan) print() {
-	log.Notice("-------- PLAN starts here --------------")
+	if p.Cluster != "" {
+		p.log.Notice("-------- PLAN for cluster [ " + p.Cluster + " ] starts here --------------")
+	} else {
+		p.log.Notice("-------- PLAN starts here --------------")
+	}
 	for _, decision := range p.Decisions {
 		if decision.Type == ignored || decision.Type == noop {
-			log.Info(decision.Description + " -- priority: " + strconv.Itoa(decision.Priority))
+			p.log.Info(decision.Description + " -- priority: " + strconv.Itoa(decision.Priority))
 		} else if decision.Type == delete {
-			log.Warning(decision.Description + " -- priority: " + strconv.Itoa(decision.Priority))
+			p.log.Warning(decision.Description + " -- priority: " + strconv.Itoa(decision.Priority))
 		} else {
-			log.Notice(decision.Description + " -- priority: " + strconv.Itoa(decision.Priority))
+			p.log.Notice(decision.Description + " -- priority: " + strconv.Itoa(decision.Priority))
 		}
 	}
-	log.Notice("-------- PLAN ends here --------------")
+	if p.Cluster != "" {
+		p.log.Notice("-------- PLAN for cluster [ " + p.Cluster + " ] ends here --------------")
+	} else {
+		p.log.Notice("-------- PLAN ends here --------------")
+	}
 }
 
 // sendPlanToSlack sends the description of plan commands to slack if a webhook is provided.
-func (p *plan) sendToSlack() {
-	if _, err := url.ParseRequestURI(settings.SlackWebhook); err == nil {
+func (p *plan) sendToSlack(webhook string) {
+	if _, err := url.ParseRequestURI(webhook); err == nil {
 		str := ""
 		for _, c := range p.Commands {
 			str = str + c.Command.Description + "\n"
 		}
-		notifySlack(strings.TrimRight(str, "\n"), settings.SlackWebhook, false, false)
+		p.log.notifySlack(strings.TrimRight(str, "\n"), webhook, false, false)
+	}
+}
+
+// priorityRange returns the priorities coming right before and right after the ones of the commands of the plan.
+// Both are 0 if the plan has no command.
+func (p *plan) priorityRange() (first, last int) {
+	if len(p.Commands) == 0 {
+		return 0, 0
+	}
+	first, last = p.Commands[0].Priority, p.Commands[0].Priority
+	for _, c := range p.Commands {
+		if c.Priority < first {
+			first = c.Priority
+		}
+		if c.Priority > last {
+			last = c.Priority
+		}
 	}
+	return first - 1, last + 1
 }
 
 // sortPlan sorts the slices of commands and decisions based on priorities
 // the lower the priority value the earlier a command should be attempted
 func (p *plan) sort() {
-	log.Verbose("Sorting the commands in the plan based on priorities (order flags) ... ")
+	p.log.Verbose("Sorting the commands in the plan based on priorities (order flags) ... ")
 
 	sort.SliceStable(p.Commands, func(i, j int) bool {
 		return p.Commands[i].Priority < p.Commands[j].Priority
@@ -1,6 +1,7 @@
 package app
 
 import (
+	"context"
 	"reflect"
 	"testing"
 	"time"
@@ -208,3 +209,37 @@ func Test_plan_addDecision(t *testing.T) {
 // 		})
 // 	}
 // }
+
+func Test_plan_exec_cancelled(t *testing.T) {
+	r := &release{Name: "app", Namespace: "ns"}
+	p := createPlan()
+	p.addCommand(command{Cmd: "sh", Args: []string{"-c", "exit 1"}, Description: "a command which must not run"}, 0, r)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := p.exec(ctx, &state{opts: DefaultOptions()}); err == nil || err.Error() != "plan execution stopped: the run was interrupted" {
+		t.Errorf("exec() with a cancelled context returned %v, want the interruption error", err)
+	}
+}
+
+func Test_orderedCommand_retried(t *testing.T) {
+	s := &state{}
+	optedIn := &release{Name: "api", Retry: &retryPolicy{Mutating: true}}
+	p := createPlan()
+	p.addCommand(command{Description: "Upgrading release [ web ]"}, 0, &release{Name: "web"})
+	p.addCommand(command{Description: "Upgrading release [ api ]"}, 0, optedIn)
+	p.addHook(command{Description: "postUpgrade hook of release [ api ]"}, 0)
+	for i, want := range []bool{false, true, false} {
+		if got := p.Commands[i].retried(s); got != want {
+			t.Errorf("retried() of [ %s ] = %v, want %v", p.Commands[i].Command.Description, got, want)
+		}
+	}
+
+	s.Settings.Retry.Mutating = true
+	if !p.Commands[0].retried(s) {
+		t.Errorf("retried() of a plan command = false, want true when the settings opt in")
+	}
+	if p.Commands[2].retried(s) {
+		t.Errorf("retried() of a hook = true, want hooks never retried")
+	}
+}
@@ -0,0 +1,217 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// policy is a set of rules the plans and the apps of the desired state must comply with.
+// The rules of a policy only apply to the namespaces matching its namespaces patterns, or to all of them if it has none.
+type policy struct {
+	Name       string   `yaml:"name"`
+	Namespaces []string `yaml:"namespaces"`
+	// Deny lists the types of decisions the plan may not have: create, change or delete
+	Deny []string `yaml:"deny"`
+	// AllowedRepos are the repos, OCI registries or local directories the charts of the apps must come from
+	AllowedRepos []string `yaml:"allowedRepos"`
+	// DeniedHelmFlags are the helm flags the apps may not be deployed with
+	DeniedHelmFlags []string `yaml:"deniedHelmFlags"`
+	// Require lists the fields the apps must set, see requiredAppFields
+	Require []string `yaml:"require"`
+	// ProtectNamespaces requires the namespaces to be protected
+	ProtectNamespaces bool `yaml:"protectNamespaces"`
+}
+
+// policyViolation is a rule of a policy which a decision of the plan, an app or a namespace does not comply with
+type policyViolation struct {
+	policy    string
+	namespace string
+	// app is the app or the release the violation is about, it is empty for the violations about a namespace
+	app     string
+	message string
+}
+
+// requiredAppFields are the fields of the apps a policy can require, with the checks that they are set
+var requiredAppFields = map[string]func(r *release, s *state) bool{
+	"description":  func(r *release, s *state) bool { return r.Description != "" },
+	"group":        func(r *release, s *state) bool { return r.Group != "" },
+	"version":      func(r *release, s *state) bool { return r.Version != "" },
+	"timeout":      func(r *release, s *state) bool { return r.Timeout != 0 },
+	"wait":         func(r *release, s *state) bool { return r.Wait },
+	"protected":    func(r *release, s *state) bool { return r.Protected || s.Namespaces[r.Namespace].Protected },
+	"healthChecks": func(r *release, s *state) bool { return len(r.HealthChecks) > 0 },
+}
+
+var deniableDecisionTypes = []string{create.String(), change.String(), delete.String()}
+
+func (v policyViolation) String() string {
+	subject := "namespace [ " + v.namespace + " ]"
+	if v.app != "" {
+		subject = "app [ " + v.app + " ] in " + subject
+	}
+	return "Policy [ " + v.policy + " ] is violated by " + subject + ": " + v.message
+}
+
+// validatePolicies validates the policies of the desired state
+func validatePolicies(policies []policy) error {
+	names := map[string]bool{}
+	for i, pol := range policies {
+		if pol.Name == "" {
+			return fmt.Errorf("policy #%d has no name", i+1)
+		}
+		if names[pol.Name] {
+			return errors.New("policy [ " + pol.Name + " ] is defined more than once")
+		}
+		names[pol.Name] = true
+		if len(pol.Deny) == 0 && len(pol.AllowedRepos) == 0 && len(pol.DeniedHelmFlags) == 0 && len(pol.Require) == 0 && !pol.ProtectNamespaces {
+			return errors.New("policy [ " + pol.Name + " ] has no rule")
+		}
+		for _, ns := range pol.Namespaces {
+			if _, err := path.Match(ns, ""); err != nil {
+				return errors.New("policy [ " + pol.Name + " ] has an invalid namespaces pattern [ " + ns + " ]")
+			}
+		}
+		for _, d := range pol.Deny {
+			if !stringInSlice(d, deniableDecisionTypes) {
+				return errors.New("policy [ " + pol.Name + " ] can only deny " + strings.Join(deniableDecisionTypes, ", ") + " decisions, not [ " + d + " ]")
+			}
+		}
+		for _, flag := range pol.DeniedHelmFlags {
+			if !strings.HasPrefix(flag, "-") {
+				return errors.New("policy [ " + pol.Name + " ] denies [ " + flag + " ] which is not a helm flag")
+			}
+		}
+		for _, field := range pol.Require {
+			if _, ok := requiredAppFields[field]; !ok {
+				fields := make([]string, 0, len(requiredAppFields))
+				for f := range requiredAppFields {
+					fields = append(fields, f)
+				}
+				sort.Strings(fields)
+				return errors.New("policy [ " + pol.Name + " ] requires [ " + field + " ] which is not one of: " + strings.Join(fields, ", "))
+			}
+		}
+	}
+	return nil
+}
+
+// appliesTo checks if a namespace matches the namespaces patterns of a policy
+func (pol policy) appliesTo(namespace string) bool {
+	if len(pol.Namespaces) == 0 {
+		return true
+	}
+	for _, pattern := range pol.Namespaces {
+		if ok, _ := path.Match(pattern, namespace); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPolicies returns the violations of the policies of the desired state by the decisions of a plan,
+// the apps deployed by it and the namespaces of the desired state
+func (s *state) checkPolicies(p *plan) []policyViolation {
+	var violations []policyViolation
+	if len(s.Policies) == 0 {
+		return violations
+	}
+
+	var apps []string
+	for name, r := range s.Apps {
+		// disabled apps and the apps of a destroy are deleted, the rules about their definitions do not apply
+		if r.Enabled && r.isConsideredToRun(s) && !s.opts.Destroy {
+			apps = append(apps, name)
+		}
+	}
+	sort.Strings(apps)
+	namespaces := s.Namespaces
+	if len(s.TargetMap) > 0 {
+		namespaces = s.TargetNamespaces
+	}
+
+	for _, pol := range s.Policies {
+		for _, d := range p.Decisions {
+			if d.namespace != "" && pol.appliesTo(d.namespace) && stringInSlice(d.Type.String(), pol.Deny) {
+				violations = append(violations, policyViolation{pol.Name, d.namespace, d.release, d.Type.String() + " decisions are denied: " + d.Description})
+			}
+		}
+		for _, name := range apps {
+			r := s.Apps[name]
+			if !pol.appliesTo(r.Namespace) {
+				continue
+			}
+			if len(pol.AllowedRepos) > 0 && !chartFromRepos(r.Chart, pol.AllowedRepos) {
+				violations = append(violations, policyViolation{pol.Name, r.Namespace, name, "chart [ " + r.Chart + " ] does not come from the allowed repos [ " + strings.Join(pol.AllowedRepos, ", ") + " ]"})
+			}
+			for _, flag := range r.deployFlags(s) {
+				for _, denied := range pol.DeniedHelmFlags {
+					if flag == denied || strings.HasPrefix(flag, denied+"=") {
+						violations = append(violations, policyViolation{pol.Name, r.Namespace, name, "helm flag [ " + flag + " ] is denied"})
+					}
+				}
+			}
+			for _, field := range pol.Require {
+				if !requiredAppFields[field](r, s) {
+					violations = append(violations, policyViolation{pol.Name, r.Namespace, name, "[ " + field + " ] must be set"})
+				}
+			}
+		}
+		if pol.ProtectNamespaces {
+			for _, name := range sortedNamespaces(namespaces) {
+				if pol.appliesTo(name) && !namespaces[name].Protected {
+					violations = append(violations, policyViolation{pol.Name, name, "", "the namespace must be protected"})
+				}
+			}
+		}
+	}
+	return violations
+}
+
+// chartFromRepos checks if a chart comes from one of the given repos, OCI registries or local directories
+func chartFromRepos(chart string, repos []string) bool {
+	for _, repo := range repos {
+		if strings.HasPrefix(chart, strings.TrimSuffix(repo, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// deployFlags returns the helm flags an app is installed or upgraded with, --force included if upgrades are forced
+func (r *release) deployFlags(s *state) []string {
+	if s.opts.ForceUpgrades {
+		return concat(r.HelmFlags, []string{"--force"})
+	}
+	return r.HelmFlags
+}
+
+// enforcePolicies logs the violations of the policies by a plan. When the plan is about to be applied,
+// the violations block it unless they are allowed with the options.
+func (s *state) enforcePolicies(p *plan, applying bool) error {
+	if len(s.Policies) == 0 {
+		return nil
+	}
+	violations := s.checkPolicies(p)
+	if len(violations) == 0 {
+		s.log.Info(fmt.Sprintf("The plan complies with the %d policies", len(s.Policies)))
+		return nil
+	}
+	blocking := applying && !s.opts.AllowPolicyViolations
+	for _, v := range violations {
+		if blocking {
+			s.log.Error(v.String())
+		} else {
+			s.log.Warning(v.String())
+		}
+	}
+	if blocking {
+		return fmt.Errorf("the plan has %d policy violation(s) and was not applied, fix them or use --allow-policy-violations to apply it anyway", len(violations))
+	}
+	if applying {
+		s.log.Warning(fmt.Sprintf("The plan is applied despite its %d policy violation(s) as they are allowed", len(violations)))
+	}
+	return nil
+}
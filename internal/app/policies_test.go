@@ -0,0 +1,206 @@
+package app
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_validatePolicies(t *testing.T) {
+	tests := []struct {
+		name     string
+		policies []policy
+		want     string
+	}{
+		{
+			name: "valid policies",
+			policies: []policy{
+				{Name: "no-prod-deletes", Namespaces: []string{"prod", "team-*"}, Deny: []string{"delete"}},
+				{Name: "apps", AllowedRepos: []string{"stable"}, DeniedHelmFlags: []string{"--force"}, Require: []string{"timeout", "protected"}},
+			},
+		}, {
+			name:     "no name",
+			policies: []policy{{Deny: []string{"delete"}}},
+			want:     "policy #1 has no name",
+		}, {
+			name:     "duplicated name",
+			policies: []policy{{Name: "p", Deny: []string{"delete"}}, {Name: "p", ProtectNamespaces: true}},
+			want:     "policy [ p ] is defined more than once",
+		}, {
+			name:     "no rule",
+			policies: []policy{{Name: "p", Namespaces: []string{"prod"}}},
+			want:     "policy [ p ] has no rule",
+		}, {
+			name:     "invalid namespaces pattern",
+			policies: []policy{{Name: "p", Namespaces: []string{"prod-["}, Deny: []string{"delete"}}},
+			want:     "policy [ p ] has an invalid namespaces pattern [ prod-[ ]",
+		}, {
+			name:     "noop decisions",
+			policies: []policy{{Name: "p", Deny: []string{"noop"}}},
+			want:     "policy [ p ] can only deny create, change, delete decisions, not [ noop ]",
+		}, {
+			name:     "not a flag",
+			policies: []policy{{Name: "p", DeniedHelmFlags: []string{"force"}}},
+			want:     "policy [ p ] denies [ force ] which is not a helm flag",
+		}, {
+			name:     "unknown field",
+			policies: []policy{{Name: "p", Require: []string{"owner"}}},
+			want:     "policy [ p ] requires [ owner ] which is not one of: description, group, healthChecks, protected, timeout, version, wait",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ""
+			if err := validatePolicies(tt.policies); err != nil {
+				got = err.Error()
+			}
+			if got != tt.want {
+				t.Errorf("validatePolicies() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_checkPolicies(t *testing.T) {
+	apps := func() map[string]*release {
+		return map[string]*release{
+			"web": {Name: "web", Namespace: "prod", Enabled: true, Chart: "stable/nginx", Timeout: 300, HelmFlags: []string{"--atomic"}},
+			"api": {Name: "api", Namespace: "prod", Enabled: true, Chart: "myrepo/api", HelmFlags: []string{"--force"}},
+			"dev": {Name: "dev", Namespace: "staging", Enabled: true, Chart: "./charts/dev"},
+			"old": {Name: "old", Namespace: "prod", Enabled: false, Chart: "myrepo/old"},
+		}
+	}
+	namespaces := map[string]namespace{
+		"prod":    {Protected: true},
+		"staging": {},
+	}
+	p := createPlan()
+	p.addDecisionFor("prod", "old", "release [ old ] is desired to be DELETED.", 0, delete)
+	p.addDecisionFor("staging", "dev", "release [ dev ] is desired to be DELETED.", 0, delete)
+	p.addDecisionFor("prod", "web", "Release [ web ] will be updated", 0, change)
+	p.addDecision("Hook [ preApply ]: echo", 0, change)
+
+	tests := []struct {
+		name     string
+		policies []policy
+		opts     Options
+		targets  map[string]bool
+		want     []string
+	}{
+		{
+			name:     "no policies",
+			policies: nil,
+			want:     []string{},
+		}, {
+			name:     "denied deletes in a namespace",
+			policies: []policy{{Name: "no-prod-deletes", Namespaces: []string{"prod"}, Deny: []string{"delete"}}},
+			want: []string{
+				"Policy [ no-prod-deletes ] is violated by app [ old ] in namespace [ prod ]: delete decisions are denied: release [ old ] is desired to be DELETED.",
+			},
+		}, {
+			name:     "denied changes everywhere",
+			policies: []policy{{Name: "freeze", Deny: []string{"create", "change"}}},
+			want: []string{
+				"Policy [ freeze ] is violated by app [ web ] in namespace [ prod ]: change decisions are denied: Release [ web ] will be updated",
+			},
+		}, {
+			name:     "allowed repos",
+			policies: []policy{{Name: "repos", AllowedRepos: []string{"stable", "./charts/"}}},
+			want: []string{
+				"Policy [ repos ] is violated by app [ api ] in namespace [ prod ]: chart [ myrepo/api ] does not come from the allowed repos [ stable, ./charts/ ]",
+			},
+		}, {
+			name:     "denied helm flags",
+			policies: []policy{{Name: "no-force", DeniedHelmFlags: []string{"--force", "--atomic"}}},
+			want: []string{
+				"Policy [ no-force ] is violated by app [ api ] in namespace [ prod ]: helm flag [ --force ] is denied",
+				"Policy [ no-force ] is violated by app [ web ] in namespace [ prod ]: helm flag [ --atomic ] is denied",
+			},
+		}, {
+			name:     "forced upgrades",
+			policies: []policy{{Name: "no-force", Namespaces: []string{"staging"}, DeniedHelmFlags: []string{"--force"}}},
+			opts:     Options{ForceUpgrades: true},
+			want: []string{
+				"Policy [ no-force ] is violated by app [ dev ] in namespace [ staging ]: helm flag [ --force ] is denied",
+			},
+		}, {
+			name:     "required fields",
+			policies: []policy{{Name: "fields", Namespaces: []string{"prod"}, Require: []string{"timeout", "protected"}}},
+			want: []string{
+				"Policy [ fields ] is violated by app [ api ] in namespace [ prod ]: [ timeout ] must be set",
+			},
+		}, {
+			name:     "protected namespaces",
+			policies: []policy{{Name: "protected", Namespaces: []string{"prod", "stag*"}, ProtectNamespaces: true}},
+			want: []string{
+				"Policy [ protected ] is violated by namespace [ staging ]: the namespace must be protected",
+			},
+		}, {
+			name:     "targeted apps",
+			policies: []policy{{Name: "fields", Require: []string{"timeout"}}},
+			targets:  map[string]bool{"web": true},
+			want:     []string{},
+		}, {
+			name:     "destroy",
+			policies: []policy{{Name: "fields", Require: []string{"timeout"}}},
+			opts:     Options{Destroy: true},
+			want:     []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &state{Apps: apps(), Namespaces: namespaces, Policies: tt.policies, opts: tt.opts, TargetMap: tt.targets}
+			if len(tt.targets) > 0 {
+				s.TargetApps = s.getAppsInTargetsOnly()
+				s.TargetNamespaces = s.getNamespacesInTargetsOnly()
+			}
+			got := []string{}
+			for _, v := range s.checkPolicies(p) {
+				got = append(got, v.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("checkPolicies() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_enforcePolicies(t *testing.T) {
+	p := createPlan()
+	p.addDecisionFor("prod", "web", "release [ web ] is desired to be DELETED.", 0, delete)
+	policies := []policy{{Name: "no-prod-deletes", Namespaces: []string{"prod"}, Deny: []string{"delete"}}}
+
+	tests := []struct {
+		name     string
+		policies []policy
+		opts     Options
+		applying bool
+		wantErr  bool
+	}{
+		{
+			name:     "violations block the apply",
+			policies: policies,
+			applying: true,
+			wantErr:  true,
+		}, {
+			name:     "violations are allowed",
+			policies: policies,
+			opts:     Options{AllowPolicyViolations: true},
+			applying: true,
+		}, {
+			name:     "plan only",
+			policies: policies,
+		}, {
+			name:     "no violation",
+			policies: []policy{{Name: "no-staging-deletes", Namespaces: []string{"staging"}, Deny: []string{"delete"}}},
+			applying: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &state{Policies: tt.policies, opts: tt.opts}
+			if err := s.enforcePolicies(p, tt.applying); (err != nil) != tt.wantErr {
+				t.Errorf("enforcePolicies() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
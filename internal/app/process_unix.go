@@ -0,0 +1,22 @@
+//go:build !windows
+// +build !windows
+
+package app
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup starts the command in its own process group, so that it doesn't get the signals sent to Helmsman's one
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// killProcess kills a process started by setProcessGroup and the processes it started itself, e.g. helm plugins
+func killProcess(p *os.Process) {
+	if err := syscall.Kill(-p.Pid, syscall.SIGKILL); err != nil {
+		_ = p.Kill()
+	}
+}
@@ -0,0 +1,17 @@
+package app
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup starts the command in its own process group, so that it doesn't get the console interrupts sent to Helmsman
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+// killProcess kills a process started by setProcessGroup
+func killProcess(p *os.Process) {
+	_ = p.Kill()
+}
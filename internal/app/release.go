@@ -1,10 +1,11 @@
 package app
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
 	"strconv"
@@ -34,6 +35,11 @@ type release struct {
 	HelmFlags    []string          `yaml:"helmFlags"`
 	NoHooks      bool              `yaml:"noHooks"`
 	Timeout      int               `yaml:"timeout"`
+	Hooks        map[string]hook   `yaml:"hooks"`
+	HealthChecks []healthCheck     `yaml:"healthChecks"`
+	Cluster      string            `yaml:"cluster"`
+	Retry        *retryPolicy      `yaml:"retry"`
+	kubeContext  string
 }
 
 type chartVersion struct {
@@ -69,9 +75,9 @@ func (r *release) validate(appLabel string, names map[string]map[string]bool, s
 		return errors.New("release name must be unique within a given namespace")
 	}
 
-	if flags.nsOverride == "" && r.Namespace == "" {
+	if s.opts.NsOverride == "" && r.Namespace == "" {
 		return errors.New("release targeted namespace can't be empty")
-	} else if flags.nsOverride == "" && r.Namespace != "" && r.Namespace != "kube-system" && !s.isNamespaceDefined(r.Namespace) {
+	} else if s.opts.NsOverride == "" && r.Namespace != "" && r.Namespace != "kube-system" && !s.isNamespaceDefined(r.Namespace) {
 		return errors.New("release " + r.Name + " is using namespace [ " + r.Namespace + " ] which is not defined in the Namespaces section of your desired state file." +
 			" Release [ " + r.Name + " ] can't be installed in that Namespace until its defined.")
 	}
@@ -82,6 +88,14 @@ func (r *release) validate(appLabel string, names map[string]map[string]bool, s
 	if r.Version == "" {
 		return errors.New("version can't be empty")
 	}
+	if isOCIChart(r.Chart) {
+		if strings.Contains(path.Base(r.Chart), ":") {
+			return errors.New("OCI chart must not include a tag, use the version field instead")
+		}
+		if !isExactVersion(r.Version) {
+			return errors.New("version constraints are not supported for OCI charts, version must be an exact version")
+		}
+	}
 
 	_, err = os.Stat(r.ValuesFile)
 	if r.ValuesFile != "" && (!isOfType(r.ValuesFile, []string{".yaml", ".yml", ".json"}) || err != nil) {
@@ -113,6 +127,22 @@ func (r *release) validate(appLabel string, names map[string]map[string]bool, s
 		return errors.New("priority can only be 0 or negative value, positive values are not allowed")
 	}
 
+	if err := validateHooks(r.Hooks, releaseHookTypes); err != nil {
+		return err
+	}
+
+	for i, hc := range r.HealthChecks {
+		if err := hc.validate(); err != nil {
+			return fmt.Errorf("health check at index %d is invalid: %w", i, err)
+		}
+	}
+
+	if r.Retry != nil {
+		if err := r.Retry.validate(); err != nil {
+			return errors.New("retry is invalid: " + err.Error())
+		}
+	}
+
 	if names[r.Name] == nil {
 		names[r.Name] = make(map[string]bool)
 	}
@@ -161,7 +191,7 @@ func validateReleaseCharts(s *state) error {
 	for err := range c {
 		if err != "" {
 			fail = true
-			log.Error(err)
+			s.log.Error(err)
 		}
 	}
 	if fail {
@@ -179,10 +209,19 @@ func (r *release) validateChart(app string, s *state, c chan string) {
 		validateCurrentChart = false
 	}
 	if validateCurrentChart {
-		if isLocalChart(r.Chart) {
+		if isOCIChart(r.Chart) {
+			// OCI registries can't be searched, so the chart version is checked by fetching its metadata
+			cmd := helmCmd([]string{"show", "chart", r.Chart, "--version", r.Version}, "Validating [ "+r.Chart+" ] chart's version [ "+r.Version+" ] availability")
+
+			if result := cmd.exec(s.context()); result.code != 0 {
+				c <- "Chart [ " + r.Chart + " ] with version [ " + r.Version + " ] is specified for " +
+					"app [" + app + "] but was not found in the OCI registry: " + strings.TrimSpace(result.errors)
+				return
+			}
+		} else if isLocalChart(r.Chart) {
 			cmd := helmCmd([]string{"inspect", "chart", r.Chart}, "Validating [ "+r.Chart+" ] chart's availability")
 
-			result := cmd.exec()
+			result := cmd.exec(s.context())
 			if result.code != 0 {
 				maybeRepo := filepath.Base(filepath.Dir(r.Chart))
 				c <- "Chart [ " + r.Chart + " ] for app [" + app + "] can't be found. Inspection returned error: \"" +
@@ -206,7 +245,7 @@ func (r *release) validateChart(app string, s *state, c chan string) {
 			}
 			cmd := helmCmd([]string{"search", "repo", r.Chart, "--version", version, "-l"}, "Validating [ "+r.Chart+" ] chart's version [ "+r.Version+" ] availability")
 
-			if result := cmd.exec(); result.code != 0 || strings.Contains(result.output, "No results found") {
+			if result := cmd.exec(s.context()); result.code != 0 || strings.Contains(result.output, "No results found") {
 				c <- "Chart [ " + r.Chart + " ] with version [ " + r.Version + " ] is specified for " +
 					"app [" + app + "] but was not found. If this is not a local chart, define its helm repo in the helmRepo stanza in your DSF."
 				return
@@ -216,153 +255,198 @@ func (r *release) validateChart(app string, s *state, c chan string) {
 }
 
 // getChartVersion fetches the lastest chart version matching the semantic versioning constraints.
-// If chart is local, returns the given release version
-func (r *release) getChartVersion() (string, string) {
-	if isLocalChart(r.Chart) {
+// If chart is local, returns the given release version or, for constraints, the local chart version if it matches.
+// OCI registries can't be searched, so the versions of OCI charts must be exact.
+func (r *release) getChartVersion(ctx context.Context) (string, string) {
+	var versions []string
+	if isOCIChart(r.Chart) {
+		if !isExactVersion(r.Version) {
+			return "", "Chart [ " + r.Chart + " ] version constraint [ " + r.Version + " ] can't be resolved as OCI registries can't be searched, use an exact version"
+		}
 		return r.Version, ""
-	}
-	cmd := helmCmd([]string{"search", "repo", r.Chart, "--version", r.Version, "-o", "json"}, "Getting latest chart's version "+r.Chart+"-"+r.Version+"")
-
-	result := cmd.exec()
-	if result.code != 0 {
-		return "", "Chart [ " + r.Chart + " ] with version [ " + r.Version + " ] is specified but not found in the helm repositories"
-	}
-
-	chartVersions := make([]chartVersion, 0)
-	if err := json.Unmarshal([]byte(result.output), &chartVersions); err != nil {
-		log.Fatal(fmt.Sprint(err))
-	}
-
-	filteredChartVersions := make([]chartVersion, 0)
-	for _, chart := range chartVersions {
-		if chart.Name == r.Chart {
-			filteredChartVersions = append(filteredChartVersions, chart)
+	} else if isLocalChart(r.Chart) {
+		if isExactVersion(r.Version) {
+			return r.Version, ""
+		}
+		v, err := getLocalChartVersion(ctx, r.Chart)
+		if err != nil {
+			return "", "Chart [ " + r.Chart + " ] version can't be found: " + err.Error()
+		}
+		versions = []string{v}
+	} else {
+		var err error
+		if versions, err = searchChartVersions(ctx, r.Chart); err != nil {
+			return "", "Chart [ " + r.Chart + " ] with version [ " + r.Version + " ] is specified but not found in the helm repositories"
 		}
 	}
 
-	if len(filteredChartVersions) < 1 {
+	latest, err := latestMatchingVersion(versions, r.Version)
+	if err != nil {
+		return "", err.Error()
+	}
+	if latest == "" {
 		return "", "Chart [ " + r.Chart + " ] with version [ " + r.Version + " ] is specified but not found in the helm repositories"
-	} else if len(filteredChartVersions) > 1 {
-		return "", "Multiple versions of chart [ " + r.Chart + " ] with version [ " + r.Version + " ] found in the helm repositories"
 	}
-	return filteredChartVersions[0].Version, ""
+	return latest, ""
 }
 
 // testRelease creates a Helm command to test a particular release.
-func (r *release) test(p *plan) {
-	cmd := helmCmd(r.getHelmArgsFor("test"), "Running tests for release [ "+r.Name+" ] in namespace [ "+r.Namespace+" ]")
+func (r *release) test(s *state, p *plan) {
+	cmd := helmCmd([]string{"test", "--namespace", r.Namespace, r.Name}, "Running tests for release [ "+r.Name+" ] in namespace [ "+r.Namespace+" ]").inCluster(s, r.kubeContext)
 	p.addCommand(cmd, r.Priority, r)
 	p.addDecision("Release [ "+r.Name+" ] in namespace [ "+r.Namespace+" ] is required to be tested during installation", r.Priority, noop)
 }
 
 // installRelease creates a Helm command to install a particular release in a particular namespace using a particular Tiller.
-func (r *release) install(p *plan) {
-	cmd := helmCmd(r.getHelmArgsFor("install"), "Install release [ "+r.Name+" ] version [ "+r.Version+" ] in namespace [ "+r.Namespace+" ]")
+func (r *release) install(s *state, p *plan) error {
+	args, err := r.getHelmArgsFor("install", s)
+	if err != nil {
+		return err
+	}
+	cmd := helmCmd(args, "Install release [ "+r.Name+" ] version [ "+r.Version+" ] in namespace [ "+r.Namespace+" ]").inCluster(s, r.kubeContext)
+	r.addHook(s, p, preInstall, "install", r.Priority)
 	p.addCommand(cmd, r.Priority, r)
-	p.addDecision("Release [ "+r.Name+" ] version [ "+r.Version+" ] will be installed in [ "+r.Namespace+" ] namespace", r.Priority, create)
+	r.addHook(s, p, postInstall, "install", r.Priority)
+	r.addHealthChecks(s, p)
+	p.addDecisionFor(r.Namespace, r.Name, "Release [ "+r.Name+" ] version [ "+r.Version+" ] will be installed in [ "+r.Namespace+" ] namespace", r.Priority, create)
+	s.diffs.add(s.cluster, r.Name, r.Namespace, actionInstall, "")
 
 	if r.Test {
-		r.test(p)
+		r.test(s, p)
 	}
+	return nil
 }
 
 // uninstall deletes a release from a particular Tiller in a k8s cluster
-func (r *release) uninstall(p *plan) {
+func (r *release) uninstall(s *state, p *plan) {
 	priority := r.Priority
-	if settings.ReverseDelete {
+	if s.Settings.ReverseDelete {
 		priority = priority * -1
 	}
 
-	cmd := helmCmd(concat(r.getHelmArgsFor("uninstall"), flags.getDryRunFlags()), "Deleting release [ "+r.Name+" ] in namespace [ "+r.Namespace+" ]")
+	cmd := helmCmd(concat(r.uninstallArgs(), s.opts.getDryRunFlags()), "Deleting release [ "+r.Name+" ] in namespace [ "+r.Namespace+" ]").inCluster(s, r.kubeContext)
+	r.addHook(s, p, preDelete, "delete", priority)
 	p.addCommand(cmd, priority, r)
-	p.addDecision(fmt.Sprintf("release [ %s ] is desired to be DELETED.", r.Name), r.Priority, delete)
+	r.addHook(s, p, postDelete, "delete", priority)
+	p.addDecisionFor(r.Namespace, r.Name, fmt.Sprintf("release [ %s ] is desired to be DELETED.", r.Name), r.Priority, delete)
+	s.diffs.add(s.cluster, r.Name, r.Namespace, actionDelete, "")
 }
 
 // diffRelease diffs an existing release with the specified values.yaml
-func (r *release) diff() string {
+func (r *release) diff(s *state) (string, error) {
 	colorFlag := ""
 	diffContextFlag := []string{}
 	suppressDiffSecretsFlag := "--suppress-secrets"
-	if flags.noColors {
+	if s.opts.NoColors {
 		colorFlag = "--no-color"
 	}
-	if flags.diffContext != -1 {
-		diffContextFlag = []string{"--context", strconv.Itoa(flags.diffContext)}
+	if s.opts.DiffContext != -1 {
+		diffContextFlag = []string{"--context", strconv.Itoa(s.opts.DiffContext)}
 	}
 
-	cmd := helmCmd(concat([]string{"diff", colorFlag, suppressDiffSecretsFlag}, diffContextFlag, r.getHelmArgsFor("upgrade")), "Diffing release [ "+r.Name+" ] in namespace [ "+r.Namespace+" ]")
+	args, err := r.getHelmArgsFor("upgrade", s)
+	if err != nil {
+		return "", err
+	}
+	cmd := helmCmd(concat([]string{"diff", colorFlag, suppressDiffSecretsFlag}, diffContextFlag, args), "Diffing release [ "+r.Name+" ] in namespace [ "+r.Namespace+" ]").inCluster(s, r.kubeContext).withTimeout(s.commandTimeout(diffPhase))
 
-	result := cmd.exec()
+	result := cmd.exec(s.context())
 	if result.code != 0 {
-		log.Fatal(fmt.Sprintf("Command returned with exit code: %d. And error message: %s ", result.code, result.errors))
-	} else {
-		if (flags.verbose || flags.showDiff) && result.output != "" {
-			fmt.Println(result.output)
-		}
+		return "", fmt.Errorf("diffing release [ %s ] in namespace [ %s ] returned exit code [ %d ] and error message [ %s ]", r.Name, r.Namespace, result.code, strings.TrimSpace(result.errors))
 	}
+	if (s.opts.Verbose || s.opts.ShowDiff) && result.output != "" {
+		fmt.Println(result.output)
+	}
+	s.diffs.add(s.cluster, r.Name, r.Namespace, actionUpgrade, result.output)
 
-	return result.output
+	return result.output, nil
 }
 
 // upgradeRelease upgrades an existing release with the specified values.yaml
-func (r *release) upgrade(p *plan) {
+func (r *release) upgrade(s *state, p *plan) error {
 	var force string
-	if flags.forceUpgrades {
+	if s.opts.ForceUpgrades {
 		force = "--force"
 	}
-	cmd := helmCmd(concat(r.getHelmArgsFor("upgrade"), []string{force}, r.getWait(), r.getHelmFlags()), "Upgrade release [ "+r.Name+" ] to version [ "+r.Version+" ] in namespace [ "+r.Namespace+" ]")
+	args, err := r.getHelmArgsFor("upgrade", s)
+	if err != nil {
+		return err
+	}
+	cmd := helmCmd(concat(args, []string{force}, r.getWait(), r.getHelmFlags(s)), "Upgrade release [ "+r.Name+" ] to version [ "+r.Version+" ] in namespace [ "+r.Namespace+" ]").inCluster(s, r.kubeContext)
 
+	r.addHook(s, p, preUpgrade, "upgrade", r.Priority)
 	p.addCommand(cmd, r.Priority, r)
+	r.addHook(s, p, postUpgrade, "upgrade", r.Priority)
+	r.addHealthChecks(s, p)
+	return nil
 }
 
 // reInstall purge deletes a release and reinstalls it.
 // This is used when moving a release to another namespace or when changing the chart used for it.
-func (r *release) reInstall(p *plan) {
-	delCmd := helmCmd(concat(r.getHelmArgsFor("uninstall"), flags.getDryRunFlags()), "Delete release [ "+r.Name+" ] in namespace [ "+r.Namespace+" ]")
+func (r *release) reInstall(s *state, p *plan) error {
+	installArgs, err := r.getHelmArgsFor("install", s)
+	if err != nil {
+		return err
+	}
+	delCmd := helmCmd(concat(r.uninstallArgs(), s.opts.getDryRunFlags()), "Delete release [ "+r.Name+" ] in namespace [ "+r.Namespace+" ]").inCluster(s, r.kubeContext)
+	r.addHook(s, p, preDelete, "delete", r.Priority)
 	p.addCommand(delCmd, r.Priority, r)
+	r.addHook(s, p, postDelete, "delete", r.Priority)
 
-	installCmd := helmCmd(r.getHelmArgsFor("install"), "Install release [ "+r.Name+" ] version [ "+r.Version+" ] in namespace [ "+r.Namespace+" ]")
+	installCmd := helmCmd(installArgs, "Install release [ "+r.Name+" ] version [ "+r.Version+" ] in namespace [ "+r.Namespace+" ]").inCluster(s, r.kubeContext)
+	r.addHook(s, p, preInstall, "install", r.Priority)
 	p.addCommand(installCmd, r.Priority, r)
+	r.addHook(s, p, postInstall, "install", r.Priority)
+	r.addHealthChecks(s, p)
+	s.diffs.add(s.cluster, r.Name, r.Namespace, actionReinstall, "")
+	return nil
 }
 
 // rollbackRelease evaluates if a rollback action needs to be taken for a given release.
 // if the release is already deleted but from a different namespace than the one specified in input,
 // it purge deletes it and create it in the specified namespace.
-func (r *release) rollback(cs *currentState, p *plan) {
+func (r *release) rollback(cs *currentState, s *state, p *plan) error {
 	rs, ok := cs.releases[r.key()]
 	if !ok {
-		return
+		return nil
 	}
 
 	if r.Namespace == rs.Namespace {
 
-		cmd := helmCmd(concat([]string{"rollback", r.Name, rs.getRevision()}, r.getWait(), r.getTimeout(), r.getNoHooks(), flags.getDryRunFlags()), "Rolling back release [ "+r.Name+" ] in namespace [ "+r.Namespace+" ]")
+		cmd := helmCmd(concat([]string{"rollback", r.Name, rs.getRevision()}, r.getWait(), r.getTimeout(), r.getNoHooks(), s.opts.getDryRunFlags()), "Rolling back release [ "+r.Name+" ] in namespace [ "+r.Namespace+" ]").inCluster(s, r.kubeContext)
 		p.addCommand(cmd, r.Priority, r)
-		r.upgrade(p) // this is to reflect any changes in values file(s)
-		p.addDecision("Release [ "+r.Name+" ] was deleted and is desired to be rolled back to "+
+		// this is to reflect any changes in values file(s)
+		if err := r.upgrade(s, p); err != nil {
+			return err
+		}
+		p.addDecisionFor(r.Namespace, r.Name, "Release [ "+r.Name+" ] was deleted and is desired to be rolled back to "+
 			"namespace [ "+r.Namespace+" ]", r.Priority, create)
 	} else {
-		r.reInstall(p)
-		p.addDecision("Release [ "+r.Name+" ] is deleted BUT from namespace [ "+rs.Namespace+
+		if err := r.reInstall(s, p); err != nil {
+			return err
+		}
+		p.addDecisionFor(r.Namespace, r.Name, "Release [ "+r.Name+" ] is deleted BUT from namespace [ "+rs.Namespace+
 			" ]. Will purge delete it from there and install it in namespace [ "+r.Namespace+" ]", r.Priority, create)
-		p.addDecision("WARNING: rolling back release [ "+r.Name+" ] from [ "+rs.Namespace+" ] to [ "+r.Namespace+
+		p.addDecisionFor(r.Namespace, r.Name, "WARNING: rolling back release [ "+r.Name+" ] from [ "+rs.Namespace+" ] to [ "+r.Namespace+
 			" ] might not correctly connect to existing volumes. Check https://github.com/Praqma/helmsman/blob/master/docs/how_to/apps/moving_across_namespaces.md"+
 			" for details if this release uses PV and PVC.", r.Priority, create)
 	}
+	return nil
 }
 
 // label applies Helmsman specific labels to Helm's state resources (secrets/configmaps)
-func (r *release) label() {
+func (r *release) label(s *state) error {
 	if r.Enabled {
-		storageBackend := settings.StorageBackend
+		storageBackend := s.Settings.StorageBackend
 
-		cmd := kubectl([]string{"label", storageBackend, "-n", r.Namespace, "-l", "owner=helm,name=" + r.Name, "MANAGED-BY=HELMSMAN", "NAMESPACE=" + r.Namespace, "HELMSMAN_CONTEXT=" + curContext, "--overwrite"}, "Applying Helmsman labels to [ "+r.Name+" ] release")
+		cmd := kubectl([]string{"label", storageBackend, "-n", r.Namespace, "-l", "owner=helm,name=" + r.Name, "MANAGED-BY=HELMSMAN", "NAMESPACE=" + r.Namespace, "HELMSMAN_CONTEXT=" + s.Context, "--overwrite"}, "Applying Helmsman labels to [ "+r.Name+" ] release").inCluster(s, r.kubeContext).withTimeout(s.commandTimeout(applyPhase))
 
-		result := cmd.exec()
+		result := cmd.exec(s.applyContext())
 		if result.code != 0 {
-			log.Fatal(result.errors)
+			return errors.New("while labelling release [ " + r.Name + " ] in namespace [ " + r.Namespace + " ]: " + result.errors)
 		}
 	}
+	return nil
 }
 
 // isProtected checks if a release is protected or not.
@@ -397,7 +481,7 @@ func (r *release) getTimeout() []string {
 }
 
 // getValuesFiles return partial install/upgrade release command to substitute the -f flag in Helm.
-func (r *release) getValuesFiles() []string {
+func (r *release) getValuesFiles(s *state) ([]string, error) {
 	var fileList []string
 
 	if r.ValuesFile != "" {
@@ -406,41 +490,27 @@ func (r *release) getValuesFiles() []string {
 		fileList = append(fileList, r.ValuesFiles...)
 	}
 
-	if r.SecretsFile != "" || len(r.SecretsFiles) > 0 {
-		if settings.EyamlEnabled {
-			if !toolExists("eyaml") {
-				log.Fatal("hiera-eyaml is not installed/configured correctly. Aborting!")
-			}
-		} else {
-			if !helmPluginExists("secrets") {
-				log.Fatal("helm secrets plugin is not installed/configured correctly. Aborting!")
-			}
+	for _, secretsFile := range r.secretsFiles() {
+		decrypted, err := s.decrypted.decrypt(s.context(), secretsFile, s.Settings)
+		if err != nil {
+			return nil, err
 		}
-	}
-	if r.SecretsFile != "" {
-		if err := decryptSecret(r.SecretsFile); err != nil {
-			log.Fatal(err.Error())
-		}
-		fileList = append(fileList, r.SecretsFile+".dec")
-	} else if len(r.SecretsFiles) > 0 {
-		for i := 0; i < len(r.SecretsFiles); i++ {
-			if err := decryptSecret(r.SecretsFiles[i]); err != nil {
-				log.Fatal(err.Error())
-			}
-			// if .dec extension is added before to the secret filename, don't add it again.
-			// This happens at upgrade time (where diff and upgrade both call this function)
-			if !isOfType(r.SecretsFiles[i], []string{".dec"}) {
-				r.SecretsFiles[i] = r.SecretsFiles[i] + ".dec"
-			}
-		}
-		fileList = append(fileList, r.SecretsFiles...)
+		fileList = append(fileList, decrypted)
 	}
 
 	fileListArgs := []string{}
 	for _, file := range fileList {
 		fileListArgs = append(fileListArgs, "-f", file)
 	}
-	return fileListArgs
+	return fileListArgs, nil
+}
+
+// secretsFiles returns the secrets files of the release
+func (r *release) secretsFiles() []string {
+	if r.SecretsFile != "" {
+		return []string{r.SecretsFile}
+	}
+	return r.SecretsFiles
 }
 
 // getSetValues returns --set params to be used with helm install/upgrade commands
@@ -477,35 +547,41 @@ func (r *release) getDesiredNamespace() string {
 }
 
 // getHelmFlags returns helm flags
-func (r *release) getHelmFlags() []string {
+func (r *release) getHelmFlags(s *state) []string {
 	var flgs []string
 
 	flgs = append(flgs, r.HelmFlags...)
-	return concat(r.getNoHooks(), r.getTimeout(), flags.getDryRunFlags(), flgs)
+	return concat(r.getNoHooks(), r.getTimeout(), s.opts.getDryRunFlags(), flgs)
 }
 
-func (r *release) getHelmArgsFor(action string) []string {
-	switch action {
-	case "install":
-		return concat([]string{action, r.Name, r.Chart, "--version", r.Version, "--namespace", r.Namespace}, r.getValuesFiles(), r.getSetValues(), r.getSetStringValues(), r.getWait(), r.getHelmFlags())
-	case "upgrade":
-		return concat([]string{action, "--namespace", r.Namespace, r.Name, r.Chart}, r.getValuesFiles(), []string{"--version", r.Version}, r.getSetValues(), r.getSetStringValues())
-	default:
-		return []string{action, "--namespace", r.Namespace, r.Name}
+// getHelmArgsFor returns the helm arguments to install or upgrade the release, which include its decrypted secrets files.
+func (r *release) getHelmArgsFor(action string, s *state) ([]string, error) {
+	valuesFiles, err := r.getValuesFiles(s)
+	if err != nil {
+		return nil, err
+	}
+	if action == "install" {
+		return concat([]string{action, r.Name, r.Chart, "--version", r.Version, "--namespace", r.Namespace}, valuesFiles, r.getSetValues(), r.getSetStringValues(), r.getWait(), r.getHelmFlags(s)), nil
 	}
+	return concat([]string{action, "--namespace", r.Namespace, r.Name, r.Chart}, valuesFiles, []string{"--version", r.Version}, r.getSetValues(), r.getSetStringValues()), nil
+}
+
+// uninstallArgs returns the helm arguments to uninstall the release
+func (r *release) uninstallArgs() []string {
+	return []string{"uninstall", "--namespace", r.Namespace, r.Name}
 }
 
-func (r *release) checkChartDepUpdate() {
-	if flags.updateDeps && isLocalChart(r.Chart) {
-		if err := updateChartDep(r.Chart); err != nil {
-			log.Fatal("helm dependency update failed: " + err.Error())
+func (r *release) checkChartDepUpdate(s *state) error {
+	if s.opts.UpdateDeps && isLocalChart(r.Chart) {
+		if err := updateChartDep(s.context(), r.Chart); err != nil {
+			return errors.New("helm dependency update failed: " + err.Error())
 		}
 	}
+	return nil
 }
 
 // overrideNamespace overrides a release defined namespace with a new given one
 func (r *release) overrideNamespace(newNs string) {
-	log.Info("Overriding namespace for app:  " + r.Name)
 	r.Namespace = newNs
 }
 
@@ -526,6 +602,14 @@ func (r release) print() {
 	fmt.Println("\tpriority : ", r.Priority)
 	fmt.Println("\tno-hooks : ", r.NoHooks)
 	fmt.Println("\ttimeout : ", r.Timeout)
+	fmt.Println("\thealth checks : ")
+	for _, hc := range r.HealthChecks {
+		fmt.Println("\t\t" + hc.describe())
+	}
+	fmt.Println("\thooks : ")
+	for hookType, h := range r.Hooks {
+		fmt.Println("\t\t" + hookType + " : " + h.describe())
+	}
 	fmt.Println("\tvalues to override from env:")
 	printMap(r.Set, 2)
 	fmt.Println("------------------- ")
@@ -1,7 +1,7 @@
 package app
 
 import (
-	"fmt"
+	"context"
 	"os"
 	"testing"
 )
@@ -11,8 +11,8 @@ func setupTestCase(t *testing.T) func(t *testing.T) {
 	os.MkdirAll(os.TempDir()+"/helmsman-tests/myapp", os.ModePerm)
 	os.MkdirAll(os.TempDir()+"/helmsman-tests/dir-with space/myapp", os.ModePerm)
 	cmd := helmCmd([]string{"create", os.TempDir() + "/helmsman-tests/dir-with space/myapp"}, "creating an empty local chart directory")
-	if result := cmd.exec(); result.code != 0 {
-		log.Fatal(fmt.Sprintf("Command returned with exit code: %d. And error message: %s ", result.code, result.errors))
+	if result := cmd.exec(context.Background()); result.code != 0 {
+		t.Fatalf("Command returned with exit code: %d. And error message: %s ", result.code, result.errors)
 	}
 
 	return func(t *testing.T) {
@@ -26,7 +26,7 @@ func Test_validateRelease(t *testing.T) {
 		Metadata:     make(map[string]string),
 		Certificates: make(map[string]string),
 		Settings:     (config{}),
-		Namespaces:   map[string]namespace{"namespace": namespace{false, limits{}, make(map[string]string), make(map[string]string), &quotas{}}},
+		Namespaces:   map[string]namespace{"namespace": namespace{Protected: false, Limits: limits{}, Labels: make(map[string]string), Annotations: make(map[string]string), Quotas: &quotas{}}},
 		HelmRepos:    make(map[string]string),
 		Apps:         make(map[string]*release),
 	}
@@ -266,6 +266,45 @@ func Test_validateRelease(t *testing.T) {
 				s: st,
 			},
 			want: "env var [ $SOME_VAR ] is not set, but is wanted to be passed for [ some_var ] in [[ release14 ]]",
+		}, {
+			name: "test case 15 -- OCI chart",
+			args: args{
+				r: &release{
+					Name:      "release15",
+					Namespace: "namespace",
+					Enabled:   true,
+					Chart:     "oci://localhost:5000/helm-charts/chartX",
+					Version:   "1.0.0",
+				},
+				s: st,
+			},
+			want: "",
+		}, {
+			name: "test case 16 -- OCI chart with tag",
+			args: args{
+				r: &release{
+					Name:      "release16",
+					Namespace: "namespace",
+					Enabled:   true,
+					Chart:     "oci://localhost:5000/helm-charts/chartX:1.0.0",
+					Version:   "1.0.0",
+				},
+				s: st,
+			},
+			want: "OCI chart must not include a tag, use the version field instead",
+		}, {
+			name: "test case 17 -- OCI chart with version constraint",
+			args: args{
+				r: &release{
+					Name:      "release17",
+					Namespace: "namespace",
+					Enabled:   true,
+					Chart:     "oci://localhost:5000/helm-charts/chartX",
+					Version:   "~1.0",
+				},
+				s: st,
+			},
+			want: "version constraints are not supported for OCI charts, version must be an exact version",
 		},
 	}
 	names := make(map[string]map[string]bool)
@@ -600,6 +639,49 @@ func Test_getReleaseChartVersion(t *testing.T) {
 	}
 }
 
+func Test_getReleaseChartName(t *testing.T) {
+	tests := []struct {
+		name  string
+		chart string
+		want  string
+	}{
+		{
+			name:  "test case 1: normal case",
+			chart: "jenkins-0.9.0",
+			want:  "jenkins",
+		}, {
+			name:  "test case 2: there is a hypen in the name",
+			chart: "elastic-search-1.3.0-1",
+			want:  "elastic-search",
+		}, {
+			name:  "test case 3: there is a dot in the name",
+			chart: "k8s.io-agent-v1.0.0",
+			want:  "k8s.io-agent",
+		}, {
+			name:  "test case 4: there is no version",
+			chart: "foo",
+			want:  "foo",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := helmRelease{Chart: tt.chart}
+			if got := r.getChartName(); got != tt.want {
+				t.Errorf("getChartName() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_extractChartName_OCI(t *testing.T) {
+	if got, err := extractChartName(context.Background(), "oci://localhost:5000/helm-charts/chartX"); err != nil || got != "chartX" {
+		t.Errorf("extractChartName() = %v, want %v", got, "chartX")
+	}
+	if got := ociRegistryHost("oci://localhost:5000/helm-charts/chartX"); got != "localhost:5000" {
+		t.Errorf("ociRegistryHost() = %v, want %v", got, "localhost:5000")
+	}
+}
+
 func Test_getChartVersion(t *testing.T) {
 	// version string = the first semver-valid string after the last hypen in the chart string.
 	type args struct {
@@ -640,7 +722,7 @@ func Test_getChartVersion(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			t.Log(tt.want)
-			got, _ := tt.args.r.getChartVersion()
+			got, _ := tt.args.r.getChartVersion(context.Background())
 			if got != tt.want {
 				t.Errorf("getChartVersion() = %v, want %v", got, tt.want)
 			}
@@ -0,0 +1,230 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRetryAttempts   = 3
+	defaultRetryBackoff    = 2
+	defaultRetryMaxBackoff = 30
+)
+
+// transientErrors are found in the errors of helm and kubectl commands which failed for a reason
+// which is likely to go away by itself: a busy or unreachable API server, a slow chart repo or a release being changed by someone else.
+var transientErrors = []string{
+	"another operation (install/upgrade/rollback) is in progress",
+	"too many requests",
+	"the server is currently unable to handle the request",
+	"the object has been modified",
+	"etcdserver: request timed out",
+	"etcdserver: leader changed",
+	"i/o timeout",
+	"tls handshake timeout",
+	"net/http: request canceled",
+	"connection refused",
+	"connection reset by peer",
+	"unexpected eof",
+	"temporary failure in name resolution",
+	"502 bad gateway",
+	"503 service unavailable",
+	"504 gateway timeout",
+}
+
+// retryPolicy type represents how many times a command failing with a transient error is attempted,
+// and how long to wait between attempts. The wait starts at backoff seconds and doubles with every attempt,
+// up to maxBackoff seconds. Jitter randomizes each wait by up to this fraction of it, between 0 and 1.
+// Fields left to 0 use the policy of the settings, or the defaults, except attempts which is used as soon as it is set:
+// 0 or 1 attempt disables retries.
+// Only the commands reading the cluster and the repo commands are retried, unless mutating is set: the helm and kubectl
+// commands of the plan, which change the cluster, are then retried as well. Hooks are never retried.
+type retryPolicy struct {
+	Attempts   *int    `yaml:"attempts"`
+	Backoff    int     `yaml:"backoff"`
+	MaxBackoff int     `yaml:"maxBackoff"`
+	Jitter     float64 `yaml:"jitter"`
+	Mutating   bool    `yaml:"mutating"`
+}
+
+// validate checks the values of a retry policy
+func (rp retryPolicy) validate() error {
+	if (rp.Attempts != nil && *rp.Attempts < 0) || rp.Backoff < 0 || rp.MaxBackoff < 0 {
+		return errors.New("attempts, backoff and maxBackoff can't be negative")
+	}
+	if rp.Jitter < 0 || rp.Jitter > 1 {
+		return errors.New("jitter must be between 0 and 1")
+	}
+	return nil
+}
+
+// override returns the policy with the fields set in o replacing its own
+func (rp retryPolicy) override(o *retryPolicy) retryPolicy {
+	if o == nil {
+		return rp
+	}
+	if o.Attempts != nil {
+		rp.Attempts = o.Attempts
+	}
+	if o.Backoff != 0 {
+		rp.Backoff = o.Backoff
+	}
+	if o.MaxBackoff != 0 {
+		rp.MaxBackoff = o.MaxBackoff
+	}
+	if o.Jitter != 0 {
+		rp.Jitter = o.Jitter
+	}
+	if o.Mutating {
+		rp.Mutating = true
+	}
+	return rp
+}
+
+// getAttempts returns how many times a command is attempted in total, it is attempted at least once
+func (rp retryPolicy) getAttempts() int {
+	if rp.Attempts == nil {
+		return defaultRetryAttempts
+	}
+	if *rp.Attempts < 1 {
+		return 1
+	}
+	return *rp.Attempts
+}
+
+// getWait returns how long to wait before the given retry, the first retry being 1
+func (rp retryPolicy) getWait(retry int) time.Duration {
+	backoff, maxBackoff := rp.Backoff, rp.MaxBackoff
+	if backoff == 0 {
+		backoff = defaultRetryBackoff
+	}
+	if maxBackoff == 0 {
+		maxBackoff = defaultRetryMaxBackoff
+	}
+	wait := time.Duration(backoff) * time.Second
+	for i := 1; i < retry && wait < time.Duration(maxBackoff)*time.Second; i++ {
+		wait *= 2
+	}
+	if wait > time.Duration(maxBackoff)*time.Second {
+		wait = time.Duration(maxBackoff) * time.Second
+	}
+	if rp.Jitter > 0 {
+		wait += time.Duration((rand.Float64()*2 - 1) * rp.Jitter * float64(wait))
+	}
+	return wait
+}
+
+// getRetryPolicy returns the retry policy of the commands of a release, which is the policy of the settings
+// overridden by the one of the release. r can be nil for the commands which are not specific to a release.
+func (s *state) getRetryPolicy(r *release) retryPolicy {
+	if r == nil {
+		return s.Settings.Retry
+	}
+	return s.Settings.Retry.override(r.Retry)
+}
+
+// isTransient checks if a command failed for a reason which is worth retrying.
+// Commands stopped by Helmsman, at their timeout or at the end of the run, are not retried.
+func (es exitStatus) isTransient() bool {
+	if es.code == 0 || es.stopped {
+		return false
+	}
+	errs := strings.ToLower(es.errors)
+	for _, e := range transientErrors {
+		if strings.Contains(errs, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// retryExec executes the command and attempts it again as long as it fails with a transient error,
+// following the given policy. The context stops the command, and the waits for the next attempt.
+// Every failed attempt is logged and recorded for the run summary.
+func (c *command) retryExec(ctx context.Context, rp retryPolicy, rec *retryRecord) exitStatus {
+	attempts := rp.getAttempts()
+	for attempt := 1; ; attempt++ {
+		result := c.exec(ctx)
+		if attempt > 1 {
+			rec.add(c.Description, attempt, result.code == 0)
+		}
+		if !result.isTransient() || attempt >= attempts {
+			return result
+		}
+		wait := rp.getWait(attempt)
+		loggerFrom(ctx).Warning(fmt.Sprintf("Attempt [ %d/%d ] of [ %s ] failed with a transient error, retrying in [ %s ]: %s",
+			attempt, attempts, c.Description, wait.Round(time.Millisecond), firstLine(result.errors)))
+		select {
+		case <-ctx.Done():
+			return result
+		case <-time.After(wait):
+		}
+	}
+}
+
+// firstLine returns the first non-empty line of a command output
+func firstLine(output string) string {
+	for _, line := range strings.Split(output, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line
+		}
+	}
+	return ""
+}
+
+// retryRecord keeps track of the commands which were retried during a run, for the run summary.
+// The desired states of all the clusters of a run share the same record.
+type retryRecord struct {
+	sync.Mutex
+	retried []retriedCommand
+}
+
+// retriedCommand is a command which needed more than one attempt
+type retriedCommand struct {
+	description string
+	attempts    int
+	succeeded   bool
+}
+
+// add records the outcome of the latest attempt of a command. Nothing is recorded in a nil record.
+func (rec *retryRecord) add(description string, attempts int, succeeded bool) {
+	if rec == nil {
+		return
+	}
+	rec.Lock()
+	defer rec.Unlock()
+	for i := range rec.retried {
+		if rec.retried[i].description == description {
+			rec.retried[i].attempts = attempts
+			rec.retried[i].succeeded = succeeded
+			return
+		}
+	}
+	rec.retried = append(rec.retried, retriedCommand{description: description, attempts: attempts, succeeded: succeeded})
+}
+
+// print logs the commands which were retried during the run, if any
+func (rec *retryRecord) print(log *Logger) {
+	if rec == nil {
+		return
+	}
+	rec.Lock()
+	defer rec.Unlock()
+	if len(rec.retried) == 0 {
+		return
+	}
+	log.Info("Run summary: [ " + strconv.Itoa(len(rec.retried)) + " ] commands failed with transient errors and were retried")
+	for _, rc := range rec.retried {
+		if rc.succeeded {
+			log.Info(fmt.Sprintf("%s -- succeeded after [ %d ] attempts", rc.description, rc.attempts))
+		} else {
+			log.Warning(fmt.Sprintf("%s -- still failing after [ %d ] attempts", rc.description, rc.attempts))
+		}
+	}
+}
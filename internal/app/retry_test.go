@@ -0,0 +1,154 @@
+package app
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_exitStatus_isTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		es   exitStatus
+		want bool
+	}{
+		{
+			name: "success",
+			es:   exitStatus{code: 0, errors: "connection refused"},
+			want: false,
+		}, {
+			name: "helm operation in progress",
+			es:   exitStatus{code: 1, errors: "Error: UPGRADE FAILED: another operation (install/upgrade/rollback) is in progress"},
+			want: true,
+		}, {
+			name: "api server throttling",
+			es:   exitStatus{code: 1, errors: "Error from server (TooManyRequests): the server has received Too Many Requests"},
+			want: true,
+		}, {
+			name: "repo index timeout",
+			es:   exitStatus{code: 1, errors: `Error: looks like "https://charts.example.com" is not a valid chart repository or cannot be reached: Get "https://charts.example.com/index.yaml": net/http: TLS handshake timeout`},
+			want: true,
+		}, {
+			name: "invalid chart",
+			es:   exitStatus{code: 1, errors: "Error: chart \"ingress\" matching 1.0.0 not found in stable index"},
+			want: false,
+		}, {
+			name: "helm timing out",
+			es:   exitStatus{code: 1, errors: "Error: UPGRADE FAILED: context deadline exceeded"},
+			want: false,
+		}, {
+			name: "stopped by Helmsman at its timeout",
+			es:   exitStatus{code: 1, errors: "command [ helm diff ] timed out after [ 1m0s ] and was stopped while: i/o timeout", stopped: true},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.es.isTransient(); got != tt.want {
+				t.Errorf("isTransient() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_retryPolicy_getWait(t *testing.T) {
+	rp := retryPolicy{Backoff: 1, MaxBackoff: 5}
+	for retry, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 10: 5 * time.Second} {
+		if got := rp.getWait(retry); got != want {
+			t.Errorf("getWait(%d) = %s, want %s", retry, got, want)
+		}
+	}
+
+	rp.Jitter = 0.5
+	for i := 0; i < 20; i++ {
+		if got := rp.getWait(2); got < time.Second || got > 3*time.Second {
+			t.Errorf("getWait(2) with a jitter of 0.5 = %s, want between 1s and 3s", got)
+		}
+	}
+}
+
+// attempts returns a pointer to the given number of attempts of a retry policy
+func attempts(n int) *int {
+	return &n
+}
+
+func Test_retryPolicy_getAttempts(t *testing.T) {
+	tests := []struct {
+		name string
+		rp   retryPolicy
+		want int
+	}{
+		{name: "not set", rp: retryPolicy{}, want: defaultRetryAttempts},
+		{name: "set", rp: retryPolicy{Attempts: attempts(5)}, want: 5},
+		{name: "one attempt", rp: retryPolicy{Attempts: attempts(1)}, want: 1},
+		{name: "zero attempts", rp: retryPolicy{Attempts: attempts(0)}, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rp.getAttempts(); got != tt.want {
+				t.Errorf("getAttempts() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_state_getRetryPolicy(t *testing.T) {
+	s := &state{Settings: config{Retry: retryPolicy{Attempts: attempts(5), Backoff: 3}}}
+	if got, want := s.getRetryPolicy(nil), (retryPolicy{Attempts: attempts(5), Backoff: 3}); !reflect.DeepEqual(got, want) {
+		t.Errorf("getRetryPolicy(nil) = %+v, want %+v", got, want)
+	}
+	r := &release{Retry: &retryPolicy{Attempts: attempts(1), Jitter: 0.1}}
+	if got, want := s.getRetryPolicy(r), (retryPolicy{Attempts: attempts(1), Backoff: 3, Jitter: 0.1}); !reflect.DeepEqual(got, want) {
+		t.Errorf("getRetryPolicy(r) = %+v, want %+v", got, want)
+	}
+	r = &release{Retry: &retryPolicy{Attempts: attempts(0)}}
+	if got := s.getRetryPolicy(r).getAttempts(); got != 1 {
+		t.Errorf("getRetryPolicy(r) with 0 attempts is attempted %d times, want 1", got)
+	}
+	r = &release{Retry: &retryPolicy{Mutating: true}}
+	if got, want := s.getRetryPolicy(r), (retryPolicy{Attempts: attempts(5), Backoff: 3, Mutating: true}); !reflect.DeepEqual(got, want) {
+		t.Errorf("getRetryPolicy(r) = %+v, want %+v", got, want)
+	}
+}
+
+func Test_retryPolicy_validate(t *testing.T) {
+	for _, rp := range []retryPolicy{{Attempts: attempts(-1)}, {MaxBackoff: -5}, {Jitter: 1.5}} {
+		if err := rp.validate(); err == nil {
+			t.Errorf("validate() of %+v did not fail", rp)
+		}
+	}
+	if err := (retryPolicy{Attempts: attempts(4), Backoff: 1, MaxBackoff: 10, Jitter: 0.2}).validate(); err != nil {
+		t.Errorf("validate() = %v", err)
+	}
+}
+
+func Test_command_retryExec(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helmsman-retry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	marker := filepath.Join(dir, "attempted")
+
+	cmd := command{
+		Cmd:         "sh",
+		Args:        []string{"-c", "[ -f " + marker + " ] || { touch " + marker + "; echo 'Error: another operation (install/upgrade/rollback) is in progress' >&2; exit 1; }"},
+		Description: "Upgrading release [ api ]",
+	}
+	rec := &retryRecord{}
+	if result := cmd.retryExec(context.Background(), retryPolicy{Attempts: attempts(3), Backoff: 1}, rec); result.code != 0 {
+		t.Errorf("retryExec() of a command failing once with a transient error returned [ %d ] %s", result.code, result.errors)
+	}
+	if len(rec.retried) != 1 || rec.retried[0].attempts != 2 || !rec.retried[0].succeeded {
+		t.Errorf("retryExec() recorded %+v, want one command succeeding after 2 attempts", rec.retried)
+	}
+
+	failing := command{Cmd: "sh", Args: []string{"-c", "echo 'Error: release not found' >&2; exit 1"}, Description: "Deleting release [ api ]"}
+	if result := failing.retryExec(context.Background(), retryPolicy{Attempts: attempts(3), Backoff: 1}, rec); result.code == 0 || len(rec.retried) != 1 {
+		t.Errorf("retryExec() retried a command failing with a permanent error")
+	}
+}
@@ -0,0 +1,275 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// backends decrypting the secrets files of the releases
+const (
+	sopsBackend        = "sops"
+	helmSecretsBackend = "helm-secrets"
+	eyamlBackend       = "eyaml"
+)
+
+var secretsBackendNames = []string{sopsBackend, helmSecretsBackend, eyamlBackend}
+
+// secretsBackend decrypts the secrets files of the releases
+type secretsBackend interface {
+	// check returns an error if the backend can't be used in the environment
+	check() error
+	// decrypt returns the decrypted content of a secrets file
+	decrypt(ctx context.Context, file string) ([]byte, error)
+	// encrypt encrypts a plain secrets file in place
+	encrypt(ctx context.Context, file string) error
+	// edit opens a secrets file decrypted in an editor, and encrypts it again when the editor is closed
+	edit(ctx context.Context, file string) error
+	// rotate encrypts a secrets file again, with a new data key for the SOPS backends and the current keys for eyaml
+	rotate(ctx context.Context, file string) error
+	// encrypted returns an error telling why the content of a secrets file is not encrypted, if it is not
+	encrypted(content []byte) error
+}
+
+// eyamlPlainRegexp matches the values of eyaml files waiting to be encrypted
+var eyamlPlainRegexp = regexp.MustCompile(`DEC(\(\d+\))?::`)
+
+// sopsSecrets decrypts SOPS files in process, and manages them with the sops tool
+type sopsSecrets struct{}
+
+// helmSecrets decrypts SOPS files with the helm-secrets plugin, which supports the cloud KMS keys
+type helmSecrets struct{}
+
+// eyamlSecrets decrypts hiera-eyaml files with the eyaml tool
+type eyamlSecrets struct {
+	privateKeyPath string
+	publicKeyPath  string
+}
+
+// getSecretsBackend returns the backend decrypting the secrets files with the given settings, the native SOPS one by default
+func getSecretsBackend(settings config) secretsBackend {
+	switch {
+	case settings.EyamlEnabled || settings.SecretsBackend == eyamlBackend:
+		return eyamlSecrets{settings.EyamlPrivateKeyPath, settings.EyamlPublicKeyPath}
+	case settings.SecretsBackend == helmSecretsBackend:
+		return helmSecrets{}
+	default:
+		return sopsSecrets{}
+	}
+}
+
+// check succeeds as the files are decrypted in process, the sops tool is only needed to manage them
+func (sopsSecrets) check() error {
+	return nil
+}
+
+func (sopsSecrets) decrypt(_ context.Context, file string) ([]byte, error) {
+	return sopsDecrypt(file)
+}
+
+func (sopsSecrets) encrypt(ctx context.Context, file string) error {
+	return runSops(ctx, []string{"--encrypt", "--in-place", file}, "Encrypting "+file)
+}
+
+// edit lets sops decrypt the file for the editor, so that it is only decrypted in a temporary file of sops
+func (sopsSecrets) edit(ctx context.Context, file string) error {
+	if !sopsExists() {
+		return errors.New("sops is not installed/configured correctly. Aborting!")
+	}
+	cmd := command{Cmd: "sops", Args: []string{file}, Description: "Editing " + file}
+	return cmd.execInteractive(ctx)
+}
+
+func (sopsSecrets) rotate(ctx context.Context, file string) error {
+	return runSops(ctx, []string{"--rotate", "--in-place", file}, "Rotating the data key of "+file)
+}
+
+func (sopsSecrets) encrypted(content []byte) error {
+	return sopsEncrypted(content)
+}
+
+// runSops runs the sops tool, which encrypts the files with the keys of its .sops.yaml creation rules or its env variables
+func runSops(ctx context.Context, args []string, desc string) error {
+	if !sopsExists() {
+		return errors.New("sops is not installed/configured correctly. Aborting!")
+	}
+	cmd := command{Cmd: "sops", Args: args, Description: desc}
+	if result := cmd.exec(ctx); result.code != 0 {
+		return errors.New(result.errors)
+	}
+	return nil
+}
+
+func (helmSecrets) check() error {
+	if !helmPluginExists("secrets") {
+		return errors.New("helm secrets plugin is not installed/configured correctly. Aborting!")
+	}
+	return nil
+}
+
+func (helmSecrets) decrypt(ctx context.Context, file string) ([]byte, error) {
+	cmd := helmCmd([]string{"secrets", "view", file}, "Decrypting "+file)
+	result := cmd.exec(ctx)
+	if result.code != 0 {
+		return nil, errors.New(result.errors)
+	}
+	return []byte(result.output), nil
+}
+
+func (helmSecrets) encrypt(ctx context.Context, file string) error {
+	cmd := helmCmd([]string{"secrets", "enc", file}, "Encrypting "+file)
+	if result := cmd.exec(ctx); result.code != 0 {
+		return errors.New(result.errors)
+	}
+	return nil
+}
+
+func (helmSecrets) edit(ctx context.Context, file string) error {
+	cmd := helmCmd([]string{"secrets", "edit", file}, "Editing "+file)
+	return cmd.execInteractive(ctx)
+}
+
+// rotate uses sops directly, as the files of helm-secrets are SOPS files and the plugin can't rotate them
+func (helmSecrets) rotate(ctx context.Context, file string) error {
+	return runSops(ctx, []string{"--rotate", "--in-place", file}, "Rotating the data key of "+file)
+}
+
+func (helmSecrets) encrypted(content []byte) error {
+	return sopsEncrypted(content)
+}
+
+func (eyamlSecrets) check() error {
+	if !toolExists("eyaml") {
+		return errors.New("hiera-eyaml is not installed/configured correctly. Aborting!")
+	}
+	return nil
+}
+
+// command returns an eyaml command using the keys of the settings, if any
+func (e eyamlSecrets) command(args []string, desc string) command {
+	if e.privateKeyPath != "" && e.publicKeyPath != "" {
+		args = concat(args, []string{"--pkcs7-private-key", e.privateKeyPath, "--pkcs7-public-key", e.publicKeyPath})
+	}
+	return command{
+		Cmd:         "eyaml",
+		Args:        args,
+		Description: desc,
+	}
+}
+
+func (e eyamlSecrets) decrypt(ctx context.Context, file string) ([]byte, error) {
+	cmd := e.command([]string{"decrypt", "-f", file}, "Decrypting "+file)
+	result := cmd.exec(ctx)
+	if result.code != 0 || result.errors != "" {
+		return nil, errors.New(result.errors)
+	}
+	return []byte(result.output), nil
+}
+
+// encrypt encrypts the DEC::PKCS7[...]! values of an eyaml file
+func (e eyamlSecrets) encrypt(ctx context.Context, file string) error {
+	info, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	cmd := e.command([]string{"encrypt", "--eyaml", file}, "Encrypting "+file)
+	result := cmd.exec(ctx)
+	if result.code != 0 {
+		return errors.New(result.errors)
+	}
+	return ioutil.WriteFile(file, []byte(result.output), info.Mode())
+}
+
+func (e eyamlSecrets) edit(ctx context.Context, file string) error {
+	cmd := e.command([]string{"edit", file}, "Editing "+file)
+	return cmd.execInteractive(ctx)
+}
+
+func (e eyamlSecrets) rotate(ctx context.Context, file string) error {
+	cmd := e.command([]string{"recrypt", file}, "Encrypting "+file+" again")
+	if result := cmd.exec(ctx); result.code != 0 {
+		return errors.New(result.errors)
+	}
+	return nil
+}
+
+// encrypted checks that an eyaml file has encrypted values and no value waiting to be encrypted
+func (eyamlSecrets) encrypted(content []byte) error {
+	if eyamlPlainRegexp.Match(content) {
+		return errors.New("it has DEC:: values which are not encrypted")
+	}
+	if !strings.Contains(string(content), "ENC[") {
+		return errors.New("it has no encrypted value")
+	}
+	return nil
+}
+
+// decryptedSecrets records the decrypted files of the secrets files of a run, keyed by the path of the secrets files.
+// The same secrets files can be used by the releases of several clusters deployed to concurrently,
+// they are only decrypted once to not overwrite a decrypted file while it is in use.
+type decryptedSecrets struct {
+	sync.Mutex
+	// dir is where the decrypted files are written, the default temp dir of the system is used if it is empty
+	dir   string
+	files map[string]string
+}
+
+// newDecryptedSecrets returns an empty record of decrypted files, which are written to the given dir
+func newDecryptedSecrets(dir string) *decryptedSecrets {
+	return &decryptedSecrets{dir: dir, files: map[string]string{}}
+}
+
+// decrypt decrypts a secrets file with the backend of the settings and returns the path of the decrypted file.
+// The decrypted file is only readable by the current user.
+func (d *decryptedSecrets) decrypt(ctx context.Context, name string, settings config) (string, error) {
+	d.Lock()
+	defer d.Unlock()
+	if path, ok := d.files[name]; ok {
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+
+	backend := getSecretsBackend(settings)
+	if err := backend.check(); err != nil {
+		return "", err
+	}
+	content, err := backend.decrypt(ctx, name)
+	if err != nil {
+		return "", errors.New("failed to decrypt secrets file [ " + name + " ]: " + err.Error())
+	}
+	base := filepath.Base(name)
+	path, err := writeTempFile(d.dir, strings.TrimSuffix(base, filepath.Ext(base))+"-*.yaml", string(content))
+	if err != nil {
+		return "", err
+	}
+	d.files[name] = path
+	return path, nil
+}
+
+// remove deletes the decrypted files of the given secrets files
+func (d *decryptedSecrets) remove(names []string) {
+	if d == nil {
+		return
+	}
+	d.Lock()
+	defer d.Unlock()
+	for _, name := range names {
+		if path, ok := d.files[name]; ok {
+			os.Remove(path)
+		}
+	}
+	// the builtin delete is shadowed by the delete decision type in this package
+	files := map[string]string{}
+	for name, path := range d.files {
+		if !stringInSlice(name, names) {
+			files[name] = path
+		}
+	}
+	d.files = files
+}
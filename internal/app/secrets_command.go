@@ -0,0 +1,134 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// actions of the secrets command
+const (
+	secretsEdit    = "edit"
+	secretsEncrypt = "encrypt"
+	secretsDecrypt = "decrypt"
+	secretsRotate  = "rotate"
+	secretsCheck   = "check"
+)
+
+var secretsActions = []string{secretsEdit, secretsEncrypt, secretsDecrypt, secretsRotate, secretsCheck}
+
+// secretsState returns the desired state whose settings choose the secrets backend of the secrets command.
+// The desired state files are optional, except for the check action, and the default backend is used without them.
+func secretsState(e *Engine) (*state, error) {
+	if len(e.opts.Files) == 0 {
+		ctx := withLogger(e.ctx, e.log)
+		return &state{
+			opts:     e.opts,
+			retries:  &retryRecord{},
+			diffs:    &diffReport{},
+			ctx:      ctx,
+			applyCtx: ctx,
+			tempDir:  e.tempDir,
+			log:      e.log,
+		}, nil
+	}
+	return e.loadState()
+}
+
+// runSecretsCommand runs an action of the secrets command with the secrets backend of the desired state:
+// edit, encrypt, decrypt or rotate a secrets file, or check that the secrets files of all the apps are encrypted.
+// The decrypt action prints the decrypted file rather than writing it.
+func runSecretsCommand(action string, file string, s *state) error {
+	backend := getSecretsBackend(s.Settings)
+	if action == secretsCheck {
+		return checkSecretsFiles(s, backend)
+	}
+	if err := backend.check(); err != nil {
+		return err
+	}
+
+	switch action {
+	case secretsDecrypt:
+		content, err := backend.decrypt(s.context(), file)
+		if err != nil {
+			return errors.New("failed to decrypt secrets file [ " + file + " ]: " + err.Error())
+		}
+		fmt.Print(string(content))
+	case secretsEncrypt:
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if backend.encrypted(content) == nil {
+			return errors.New("secrets file [ " + file + " ] is already encrypted, use secrets edit to change it")
+		}
+		if err := backend.encrypt(s.context(), file); err != nil {
+			return errors.New("failed to encrypt secrets file [ " + file + " ]: " + err.Error())
+		}
+		s.log.Info("Encrypted secrets file [ " + file + " ]")
+	case secretsEdit:
+		if err := backend.edit(s.context(), file); err != nil {
+			return errors.New("failed to edit secrets file [ " + file + " ]: " + err.Error())
+		}
+	case secretsRotate:
+		if err := backend.rotate(s.context(), file); err != nil {
+			return errors.New("failed to rotate secrets file [ " + file + " ]: " + err.Error())
+		}
+		s.log.Info("Rotated secrets file [ " + file + " ]")
+	default:
+		return errors.New("unknown secrets action [ " + action + " ]")
+	}
+	return nil
+}
+
+// checkSecretsFiles checks that the secrets files of all the apps are encrypted, without decrypting them.
+// It logs the ones which are not and fails, so that it can keep plaintext secrets from being committed.
+func checkSecretsFiles(s *state, backend secretsBackend) error {
+	apps := secretsFilesOfApps(s)
+	files := make([]string, 0, len(apps))
+	for f := range apps {
+		files = append(files, f)
+	}
+	sort.Strings(files)
+
+	ctx := s.context()
+	plain := 0
+	for _, f := range files {
+		sort.Strings(apps[f])
+		desc := "secrets file [ " + f + " ] of app(s) [ " + strings.Join(apps[f], ", ") + " ]"
+		local, err := fetchFile(ctx, s.tempDir, f)
+		if err != nil {
+			return errors.New("failed to read " + desc + ": " + err.Error())
+		}
+		content, err := ioutil.ReadFile(local)
+		if err != nil {
+			return errors.New("failed to read " + desc + ": " + err.Error())
+		}
+		if err := backend.encrypted(content); err != nil {
+			s.log.Error(strings.ToUpper(desc[:1]) + desc[1:] + " is not encrypted: " + err.Error())
+			plain++
+			continue
+		}
+		s.log.Verbose(strings.ToUpper(desc[:1]) + desc[1:] + " is encrypted")
+	}
+	if plain > 0 {
+		return fmt.Errorf("%d of %d secrets files are not encrypted", plain, len(files))
+	}
+	s.log.Info(fmt.Sprintf("All the %d secrets files are encrypted", len(files)))
+	return nil
+}
+
+// secretsFilesOfApps returns the apps using each secrets file, keyed by the path of the file in the desired state
+// rather than the one of its copy with the variables substituted.
+func secretsFilesOfApps(s *state) map[string][]string {
+	apps := map[string][]string{}
+	for name, r := range s.Apps {
+		for _, f := range r.secretsFiles() {
+			source := s.sourceFile(f)
+			apps[source] = append(apps[source], name)
+		}
+	}
+	return apps
+}
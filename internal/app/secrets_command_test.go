@@ -0,0 +1,137 @@
+package app
+
+import (
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func Test_secretsBackend_encrypted(t *testing.T) {
+	sopsFile, err := ioutil.ReadFile("./../../tests/secrets/sops_age_secrets.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		backend secretsBackend
+		content string
+		want    string
+	}{
+		{
+			name:    "sops file",
+			backend: sopsSecrets{},
+			content: string(sopsFile),
+		}, {
+			name:    "sops file with a plain value",
+			backend: helmSecrets{},
+			content: "password: hunter2\n" + string(sopsFile),
+			want:    "the value of [ password ] is not encrypted",
+		}, {
+			name:    "sops file with an unencrypted suffix",
+			backend: sopsSecrets{},
+			content: strings.Replace(string(sopsFile), "region: eu-west-1", "region: eu-west-1\n    zone_unencrypted: a", 1),
+		}, {
+			name:    "plain file",
+			backend: sopsSecrets{},
+			content: "password: hunter2\n",
+			want:    "it is not encrypted with SOPS",
+		}, {
+			name:    "eyaml file",
+			backend: eyamlSecrets{},
+			content: "password: ENC[PKCS7,MIIBiQYJKoZIhvcNAQcDoIIBejCCAXYCAQAxggEhMIIBHQIBADAFMAACAQEw]\nuser: app\n",
+		}, {
+			name:    "eyaml file with a value to encrypt",
+			backend: eyamlSecrets{},
+			content: "password: ENC[PKCS7,MIIBiQYJKoZIhvcNAQcDoIIBejCCAXYCAQAxggEhMIIBHQIBADAFMAACAQEw]\ntoken: DEC::PKCS7[secret]!\n",
+			want:    "it has DEC:: values which are not encrypted",
+		}, {
+			name:    "plain eyaml file",
+			backend: eyamlSecrets{},
+			content: "password: hunter2\n",
+			want:    "it has no encrypted value",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ""
+			if err := tt.backend.encrypted([]byte(tt.content)); err != nil {
+				got = err.Error()
+			}
+			if got != tt.want {
+				t.Errorf("encrypted() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_checkSecretsFiles(t *testing.T) {
+	tests := []struct {
+		name string
+		apps map[string]*release
+		want string
+	}{
+		{
+			name: "encrypted files",
+			apps: map[string]*release{
+				"app1": {SecretsFile: "./../../tests/secrets/sops_age_secrets.yaml"},
+				"app2": {SecretsFiles: []string{"./../../tests/secrets/sops_pgp_secrets.yaml", "./../../tests/secrets/sops_age_secrets.yaml"}},
+			},
+		}, {
+			name: "plain file",
+			apps: map[string]*release{
+				"app1": {SecretsFile: "./../../tests/secrets/sops_age_secrets.yaml"},
+				"app2": {SecretsFiles: []string{"./../../tests/values.yaml"}},
+			},
+			want: "1 of 2 secrets files are not encrypted",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &state{Apps: tt.apps}
+			got := ""
+			if err := runSecretsCommand(secretsCheck, "", s); err != nil {
+				got = err.Error()
+			}
+			if got != tt.want {
+				t.Errorf("runSecretsCommand() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_secretsFilesOfApps(t *testing.T) {
+	file := "./../../tests/secrets/sops_age_secrets.yaml"
+	s := &state{
+		tempDir:     t.TempDir(),
+		sourceFiles: map[string]string{},
+		Apps: map[string]*release{
+			"app1": {SecretsFile: file},
+			"app2": {SecretsFiles: []string{file}},
+		},
+	}
+	if err := substituteVarsInValuesFiles(s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Apps["app1"].SecretsFile == file {
+		t.Fatalf("substituteVarsInValuesFiles() did not copy %s", file)
+	}
+	want := map[string][]string{file: {"app1", "app2"}}
+	got := secretsFilesOfApps(s)
+	for _, apps := range got {
+		sort.Strings(apps)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("secretsFilesOfApps() = %v, want %v", got, want)
+	}
+}
+
+func Test_runSecretsCommand_encryptEncrypted(t *testing.T) {
+	file := "./../../tests/secrets/sops_age_secrets.yaml"
+	// the native sops backend tells encrypted files apart without the sops tool
+	err := runSecretsCommand(secretsEncrypt, file, &state{Settings: config{SecretsBackend: sopsBackend}})
+	if err == nil || !strings.Contains(err.Error(), "is already encrypted") {
+		t.Errorf("runSecretsCommand() error = %v, want the file to be already encrypted", err)
+	}
+}
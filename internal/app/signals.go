@@ -0,0 +1,45 @@
+package app
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// handleSignals returns two contexts cancelled when Helmsman gets a SIGINT or a SIGTERM.
+// The first one is cancelled by the first signal: no new command is started and the running ones are left to finish
+// (or to reach their timeout). The second one is cancelled by a second signal, which stops the running commands
+// so that Helmsman exits right away.
+// The signals are logged with the given logger. The returned function stops handling them.
+func handleSignals(log *Logger) (context.Context, context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	force, cancelForce := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	done := make(chan struct{})
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case sig := <-signals:
+			log.Warning("Received " + sig.String() + ": no new command will be started and the running ones are left to finish. " +
+				"Send it again to stop them and exit right away.")
+			cancel()
+		case <-done:
+			return
+		}
+		select {
+		case sig := <-signals:
+			log.Warning("Received " + sig.String() + " again: stopping the running commands.")
+			cancelForce()
+		case <-done:
+		}
+	}()
+
+	return ctx, force, func() {
+		signal.Stop(signals)
+		close(done)
+		cancel()
+		cancelForce()
+	}
+}
@@ -0,0 +1,42 @@
+package app
+
+import (
+	"os"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func Test_handleSignals(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("signals can't be sent to the current process on windows")
+	}
+	ctx, force, stop := handleSignals(nil)
+	defer stop()
+
+	p, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Skip(err)
+	}
+	if err := p.Signal(os.Interrupt); err != nil {
+		t.Skip(err)
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("handleSignals(nil) did not cancel the context on an interrupt")
+	}
+	if force.Err() != nil {
+		t.Errorf("handleSignals(nil) cancelled the context of the running commands on the first interrupt")
+	}
+
+	if err := p.Signal(os.Interrupt); err != nil {
+		t.Skip(err)
+	}
+	select {
+	case <-force.Done():
+	case <-time.After(5 * time.Second):
+		t.Errorf("handleSignals(nil) did not cancel the context of the running commands on a second interrupt")
+	}
+}
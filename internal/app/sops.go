@@ -0,0 +1,146 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"go.mozilla.org/sops/v3/decrypt"
+	"gopkg.in/yaml.v2"
+)
+
+// sopsValueRegexp matches the values encrypted by SOPS
+var sopsValueRegexp = regexp.MustCompile(`^ENC\[AES256_GCM,data:(.+),iv:(.+),tag:(.+),type:(.+)\]`)
+
+// sopsMetadata type represents the metadata added by SOPS to the files it encrypts, under their sops key.
+// Only the fields telling which values must be encrypted are parsed, the decryption is left to the sops library.
+type sopsMetadata struct {
+	MAC               string `yaml:"mac"`
+	UnencryptedSuffix string `yaml:"unencrypted_suffix"`
+	EncryptedSuffix   string `yaml:"encrypted_suffix"`
+	UnencryptedRegex  string `yaml:"unencrypted_regex"`
+	EncryptedRegex    string `yaml:"encrypted_regex"`
+}
+
+// sopsExists checks that the sops tool is installed, without running it as sops fails when it is given no file.
+// It is only needed to encrypt, edit and rotate secrets files: they are decrypted in process.
+func sopsExists() bool {
+	_, err := exec.LookPath("sops")
+	return err == nil
+}
+
+// sopsDecrypt decrypts a YAML or JSON file encrypted by SOPS in process, without the sops tool, and returns its content
+// in the format of the file. The keys are found the same way as the sops tool does: age keys from the SOPS_AGE_KEY_FILE
+// env variable or the default keys file, PGP keys of the gpg keyring and cloud KMS keys from the credentials of the environment.
+func sopsDecrypt(file string) ([]byte, error) {
+	return decrypt.File(file, "")
+}
+
+// parseSopsFile parses a YAML or JSON file encrypted by SOPS into its content and its metadata
+func parseSopsFile(content []byte) (yaml.MapSlice, *sopsMetadata, error) {
+	var tree, branch yaml.MapSlice
+	if err := yaml.Unmarshal(content, &tree); err != nil {
+		return nil, nil, err
+	}
+	var metadata *sopsMetadata
+	for _, item := range tree {
+		if item.Key != "sops" {
+			branch = append(branch, item)
+			continue
+		}
+		d, err := yaml.Marshal(item.Value)
+		if err != nil {
+			return nil, nil, err
+		}
+		metadata = &sopsMetadata{}
+		if err := yaml.Unmarshal(d, metadata); err != nil {
+			return nil, nil, errors.New("invalid sops metadata: " + err.Error())
+		}
+	}
+	if metadata == nil {
+		return nil, nil, errors.New("it is not encrypted with SOPS")
+	}
+	return branch, metadata, nil
+}
+
+// sopsEncrypted checks that a file is encrypted by SOPS, and that all the values which should be encrypted are,
+// so that the values added to an encrypted file without SOPS are found
+func sopsEncrypted(content []byte) error {
+	branch, metadata, err := parseSopsFile(content)
+	if err != nil {
+		return err
+	}
+	if metadata.MAC == "" {
+		return errors.New("it has no MAC")
+	}
+	_, err = sopsWalk(branch, nil, func(value interface{}, path []string) (interface{}, error) {
+		if !metadata.encrypted(path) {
+			return value, nil
+		}
+		// SOPS leaves the empty strings as they are
+		if s, ok := value.(string); ok && (s == "" || sopsValueRegexp.MatchString(s)) {
+			return value, nil
+		}
+		return nil, errors.New("the value of [ " + strings.Join(path, ".") + " ] is not encrypted")
+	})
+	return err
+}
+
+// sopsWalk calls leaf on the values of a branch of a SOPS file in the order they appear, replacing them with its results.
+// The path of a value is made of the keys leading to it, the items of lists having the path of their list.
+func sopsWalk(value interface{}, path []string, leaf func(interface{}, []string) (interface{}, error)) (interface{}, error) {
+	switch v := value.(type) {
+	case yaml.MapSlice:
+		out := make(yaml.MapSlice, 0, len(v))
+		for _, item := range v {
+			result, err := sopsWalk(item.Value, concat(path, []string{fmt.Sprint(item.Key)}), leaf)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, yaml.MapItem{Key: item.Key, Value: result})
+		}
+		return out, nil
+	case []interface{}:
+		out := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			result, err := sopsWalk(item, path, leaf)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, result)
+		}
+		return out, nil
+	case nil:
+		return nil, nil
+	default:
+		return leaf(v, path)
+	}
+}
+
+// encrypted tells whether the value of a path is encrypted, according to the suffixes and regexps of the file
+func (m *sopsMetadata) encrypted(path []string) bool {
+	matches := func(match func(string) bool) bool {
+		for _, p := range path {
+			if match(p) {
+				return true
+			}
+		}
+		return false
+	}
+	encrypted := true
+	if m.UnencryptedSuffix != "" && matches(func(p string) bool { return strings.HasSuffix(p, m.UnencryptedSuffix) }) {
+		encrypted = false
+	}
+	if m.EncryptedSuffix != "" {
+		encrypted = matches(func(p string) bool { return strings.HasSuffix(p, m.EncryptedSuffix) })
+	}
+	if m.UnencryptedRegex != "" && matches(func(p string) bool { ok, _ := regexp.MatchString(m.UnencryptedRegex, p); return ok }) {
+		encrypted = false
+	}
+	if m.EncryptedRegex != "" {
+		encrypted = matches(func(p string) bool { ok, _ := regexp.MatchString(m.EncryptedRegex, p); return ok })
+	}
+	return encrypted
+}
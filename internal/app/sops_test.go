@@ -0,0 +1,245 @@
+package app
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	sopsAgeKeyFile = "./../../tests/keys/sops_age_key.txt"
+	sopsPGPKeyFile = "./../../tests/keys/sops_pgp_key.asc"
+)
+
+// setSopsEnv sets the env variables of the SOPS keys for a test and returns a function restoring them
+func setSopsEnv(t *testing.T, env map[string]string) func() {
+	names := []string{"SOPS_AGE_KEY", "SOPS_AGE_KEY_FILE", "GNUPGHOME", "XDG_CONFIG_HOME"}
+	previous := map[string]string{}
+	for _, name := range names {
+		previous[name] = os.Getenv(name)
+		os.Unsetenv(name)
+	}
+	// keep the default age keys file and the gpg keyring of the user out of the tests
+	os.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	os.Setenv("GNUPGHOME", t.TempDir())
+	for name, value := range env {
+		os.Setenv(name, value)
+	}
+	return func() {
+		for _, name := range names {
+			os.Setenv(name, previous[name])
+		}
+	}
+}
+
+// importPGPKey imports the PGP key of the tests in a new gpg keyring, whose directory is returned
+func importPGPKey(t *testing.T) string {
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("gpg is not installed")
+	}
+	home, err := ioutil.TempDir("", "gpg-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(home) })
+	cmd := exec.Command("gpg", "--batch", "--import", sopsPGPKeyFile)
+	cmd.Env = append(os.Environ(), "GNUPGHOME="+home)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("could not import the PGP key: %v: %s", err, out)
+	}
+	return home
+}
+
+func Test_sopsDecrypt(t *testing.T) {
+	ageKey, _ := ioutil.ReadFile(sopsAgeKeyFile)
+	want := map[string]interface{}{
+		"database":           map[interface{}]interface{}{"user": "app", "password": "s3cr3t", "port": 5432},
+		"replicas":           2.5,
+		"debug":              true,
+		"hosts":              []interface{}{"db-1.example.com", "db-2.example.com"},
+		"config_unencrypted": map[interface{}]interface{}{"region": "eu-west-1"},
+	}
+	tests := []struct {
+		name    string
+		file    string
+		env     map[string]string
+		wantErr string
+	}{
+		{
+			name: "age key file",
+			file: "./../../tests/secrets/sops_age_secrets.yaml",
+			env:  map[string]string{"SOPS_AGE_KEY_FILE": sopsAgeKeyFile},
+		}, {
+			name: "age key",
+			file: "./../../tests/secrets/sops_age_secrets.yaml",
+			env:  map[string]string{"SOPS_AGE_KEY": string(ageKey)},
+		}, {
+			name:    "no age key",
+			file:    "./../../tests/secrets/sops_age_secrets.yaml",
+			wantErr: "Error getting data key",
+		}, {
+			name:    "wrong age key",
+			file:    "./../../tests/secrets/sops_age_secrets.yaml",
+			env:     map[string]string{"SOPS_AGE_KEY": "AGE-SECRET-KEY-1T0Z66WSXS6RMNCPSL7P2E8N4Q7SUD8VMG9ND27S08JL7Y2XAU9EQECHDS7"},
+			wantErr: "Error getting data key",
+		}, {
+			name:    "PGP key missing from the keyring",
+			file:    "./../../tests/secrets/sops_pgp_secrets.yaml",
+			env:     map[string]string{"SOPS_AGE_KEY_FILE": sopsAgeKeyFile},
+			wantErr: "Error getting data key",
+		}, {
+			name:    "not encrypted",
+			file:    "./../../tests/values.yaml",
+			env:     map[string]string{"SOPS_AGE_KEY_FILE": sopsAgeKeyFile},
+			wantErr: "sops metadata not found",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer setSopsEnv(t, tt.env)()
+			got, err := sopsDecrypt(tt.file)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("sopsDecrypt() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("sopsDecrypt() error = %v", err)
+			}
+			var values map[string]interface{}
+			if err := yaml.Unmarshal(got, &values); err != nil {
+				t.Fatal(err)
+			}
+			for k, v := range want {
+				if !equalYAML(t, values[k], v) {
+					t.Errorf("sopsDecrypt() %s = %v, want %v", k, values[k], v)
+				}
+			}
+		})
+	}
+}
+
+// equalYAML compares values by their YAML representation
+func equalYAML(t *testing.T, a interface{}, b interface{}) bool {
+	da, err := yaml.Marshal(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := yaml.Marshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(da) == string(db)
+}
+
+func Test_sopsDecrypt_pgp(t *testing.T) {
+	home := importPGPKey(t)
+	defer setSopsEnv(t, map[string]string{"GNUPGHOME": home})()
+	got, err := sopsDecrypt("./../../tests/secrets/sops_pgp_secrets.yaml")
+	if err != nil {
+		t.Fatalf("sopsDecrypt() error = %v", err)
+	}
+	if !strings.Contains(string(got), "password: s3cr3t") {
+		t.Errorf("sopsDecrypt() = %s, want the decrypted password", got)
+	}
+}
+
+func Test_sopsDecrypt_tampered(t *testing.T) {
+	defer setSopsEnv(t, map[string]string{"SOPS_AGE_KEY_FILE": sopsAgeKeyFile})()
+	content, err := ioutil.ReadFile("./../../tests/secrets/sops_age_secrets.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// unencrypted values are covered by the MAC too
+	tampered := filepath.Join(t.TempDir(), "tampered.yaml")
+	if err := ioutil.WriteFile(tampered, []byte(strings.Replace(string(content), "region: eu-west-1", "region: us-east-1", 1)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sopsDecrypt(tampered); err == nil || !strings.Contains(err.Error(), "Failed to verify data integrity") {
+		t.Errorf("sopsDecrypt() error = %v, want a MAC mismatch", err)
+	}
+}
+
+func Test_decryptSecret_sops(t *testing.T) {
+	defer setSopsEnv(t, map[string]string{"SOPS_AGE_KEY_FILE": sopsAgeKeyFile})()
+	file := "./../../tests/secrets/sops_age_secrets.yaml"
+	dir := t.TempDir()
+
+	r := &release{Name: "release1", Namespace: "namespace", SecretsFiles: []string{file}}
+	s := &state{Settings: config{SecretsBackend: sopsBackend}, decrypted: newDecryptedSecrets(dir)}
+	args, err := r.getValuesFiles(s)
+	if err != nil {
+		t.Fatalf("getValuesFiles() error = %v", err)
+	}
+	if len(args) != 2 || args[0] != "-f" {
+		t.Fatalf("getValuesFiles() = %v, want the decrypted file", args)
+	}
+	decrypted := args[1]
+	if r.SecretsFiles[0] != file {
+		t.Errorf("getValuesFiles() changed the secrets files to %v", r.SecretsFiles)
+	}
+	if filepath.Dir(decrypted) != dir {
+		t.Errorf("decrypted file %s is not in %s", decrypted, dir)
+	}
+	info, err := os.Stat(decrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("decrypted file mode = %v, want 0600", info.Mode().Perm())
+	}
+	if _, err := os.Stat(file + ".dec"); err == nil {
+		t.Errorf("a decrypted file was written next to %s", file)
+	}
+
+	// the file is decrypted once
+	if again, _ := r.getValuesFiles(s); again[1] != decrypted {
+		t.Errorf("getValuesFiles() = %v, want %s again", again, decrypted)
+	}
+	s.decrypted.remove([]string{file})
+	if _, err := os.Stat(decrypted); err == nil {
+		t.Errorf("remove() did not delete %s", decrypted)
+	}
+}
+
+func Test_getSecretsBackend(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings config
+		want     secretsBackend
+	}{
+		{
+			name: "native sops by default",
+			want: sopsSecrets{},
+		}, {
+			name:     "helm-secrets",
+			settings: config{SecretsBackend: helmSecretsBackend},
+			want:     helmSecrets{},
+		}, {
+			name:     "sops",
+			settings: config{SecretsBackend: sopsBackend},
+			want:     sopsSecrets{},
+		}, {
+			name:     "eyaml",
+			settings: config{SecretsBackend: eyamlBackend, EyamlPrivateKeyPath: "private.pem", EyamlPublicKeyPath: "public.pem"},
+			want:     eyamlSecrets{"private.pem", "public.pem"},
+		}, {
+			name:     "eyamlEnabled",
+			settings: config{EyamlEnabled: true},
+			want:     eyamlSecrets{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := getSecretsBackend(tt.settings); got != tt.want {
+				t.Errorf("getSecretsBackend() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,66 +1,232 @@
 package app
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/url"
 	"os"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/imdario/mergo"
 )
 
 // config type represents the settings fields
 type config struct {
-	KubeContext         string `yaml:"kubeContext"`
-	Username            string `yaml:"username"`
-	Password            string `yaml:"password"`
-	ClusterURI          string `yaml:"clusterURI"`
-	ServiceAccount      string `yaml:"serviceAccount"`
-	StorageBackend      string `yaml:"storageBackend"`
-	SlackWebhook        string `yaml:"slackWebhook"`
-	ReverseDelete       bool   `yaml:"reverseDelete"`
-	BearerToken         bool   `yaml:"bearerToken"`
-	BearerTokenPath     string `yaml:"bearerTokenPath"`
-	EyamlEnabled        bool   `yaml:"eyamlEnabled"`
-	EyamlPrivateKeyPath string `yaml:"eyamlPrivateKeyPath"`
-	EyamlPublicKeyPath  string `yaml:"eyamlPublicKeyPath"`
+	KubeContext     string `yaml:"kubeContext"`
+	Username        string `yaml:"username"`
+	Password        string `yaml:"password"`
+	ClusterURI      string `yaml:"clusterURI"`
+	ServiceAccount  string `yaml:"serviceAccount"`
+	StorageBackend  string `yaml:"storageBackend"`
+	SlackWebhook    string `yaml:"slackWebhook"`
+	ReverseDelete   bool   `yaml:"reverseDelete"`
+	BearerToken     bool   `yaml:"bearerToken"`
+	BearerTokenPath string `yaml:"bearerTokenPath"`
+	// Auth gets short-lived credentials from a cloud provider or a command to connect to the cluster
+	Auth                *clusterAuth `yaml:"auth"`
+	EyamlEnabled        bool         `yaml:"eyamlEnabled"`
+	EyamlPrivateKeyPath string       `yaml:"eyamlPrivateKeyPath"`
+	EyamlPublicKeyPath  string       `yaml:"eyamlPublicKeyPath"`
+	Retry               retryPolicy  `yaml:"retry"`
+	// SecretsBackend decrypts the secrets files of the releases: sops (default), helm-secrets or eyaml
+	SecretsBackend string `yaml:"secretsBackend"`
+	// StuckReleasePolicy is what to do with the releases stuck in a pending status: fail, wait or recover
+	StuckReleasePolicy    string `yaml:"stuckReleasePolicy"`
+	StuckReleaseTimeout   int    `yaml:"stuckReleaseTimeout"`
+	StuckReleaseThreshold int    `yaml:"stuckReleaseThreshold"`
+	// PruneNamespaces deletes the namespaces created by Helmsman once they are removed from the desired state
+	PruneNamespaces bool `yaml:"pruneNamespaces"`
+}
+
+// ociRegistry type represents the credentials for an OCI registry hosting charts
+type ociRegistry struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	Insecure bool   `yaml:"insecure"`
 }
 
 // state type represents the desired state of applications on a k8s cluster.
 type state struct {
-	Metadata               map[string]string    `yaml:"metadata"`
-	Certificates           map[string]string    `yaml:"certificates"`
-	Settings               config               `yaml:"settings"`
-	Context                string               `yaml:"context"`
-	Namespaces             map[string]namespace `yaml:"namespaces"`
-	HelmRepos              map[string]string    `yaml:"helmRepos"`
-	PreconfiguredHelmRepos []string             `yaml:"preconfiguredHelmRepos"`
-	Apps                   map[string]*release  `yaml:"apps"`
-	AppsTemplates          map[string]*release  `yaml:"appsTemplates,omitempty"`
+	Metadata               map[string]string      `yaml:"metadata"`
+	Certificates           map[string]string      `yaml:"certificates"`
+	Settings               config                 `yaml:"settings"`
+	Context                string                 `yaml:"context"`
+	Namespaces             map[string]namespace   `yaml:"namespaces"`
+	HelmRepos              map[string]string      `yaml:"helmRepos"`
+	PreconfiguredHelmRepos []string               `yaml:"preconfiguredHelmRepos"`
+	OCIRegistries          map[string]ociRegistry `yaml:"ociRegistries"`
+	Hooks                  map[string]hook        `yaml:"hooks"`
+	Clusters               []cluster              `yaml:"clusters"`
+	Policies               []policy               `yaml:"policies"`
+	Apps                   map[string]*release    `yaml:"apps"`
+	AppsTemplates          map[string]*release    `yaml:"appsTemplates,omitempty"`
 	TargetMap              map[string]bool
 	GroupMap               map[string]bool
 	TargetApps             map[string]*release
 	TargetNamespaces       map[string]namespace
+	opts                   Options
+	// deadline is when the run reaches its timeout, it is zero if the run has no timeout
+	deadline time.Time
+	// ctx is the context of the run, see context, and applyCtx the one of the commands applying the plan, see applyContext.
+	// stopRun cancels them once the run is over.
+	ctx      context.Context
+	applyCtx context.Context
+	stopRun  func()
+	// retries records the commands retried during the run
+	retries *retryRecord
+	// cluster is the name of the cluster targeted by the desired state, it is empty unless the desired state defines clusters
+	cluster string
+	// diffs records the diffs of the releases for the diff report
+	diffs *diffReport
+	// tempDir is where the files of the run are written, the default temp dir of the system is used if it is empty
+	tempDir string
+	// kubeconfig is the path of the kubeconfig Helmsman created to connect to the cluster of the desired state,
+	// it is empty if the kubeconfig of the user is used
+	kubeconfig string
+	// log is the logger of the engine running the desired state
+	log *Logger
+	// decrypted records the decrypted secrets files, and lockFileMutex serializes the resolution of chart versions
+	// of the clusters deployed to concurrently, as they share the same lock file
+	decrypted     *decryptedSecrets
+	lockFileMutex *sync.Mutex
+	// sourceFiles maps the copies of the values and secrets files with their variables substituted to the files of the desired state
+	sourceFiles map[string]string
+	// lockUpdate holds the changes to the lock file made by resolving the chart versions, it is written once the plan is applied
+	lockUpdate *lockUpdate
+}
+
+// loadState reads the desired state files of the engine, merges them and validates the resulting desired state.
+// The run stops when the context of the engine is done or when it reaches its timeout, and the commands applying
+// the plan are stopped when the force context of the engine is done or at the run timeout.
+// The files of the run are written to the temp dir of the engine, and its contexts carry the logger of the engine.
+func (e *Engine) loadState() (*state, error) {
+	opts := e.opts
+	s := &state{
+		opts:          opts,
+		retries:       &retryRecord{},
+		diffs:         &diffReport{},
+		tempDir:       e.tempDir,
+		log:           e.log,
+		decrypted:     newDecryptedSecrets(e.tempDir),
+		lockFileMutex: &sync.Mutex{},
+		sourceFiles:   map[string]string{},
+	}
+	if opts.Timeout > 0 {
+		s.deadline = time.Now().Add(opts.Timeout)
+	}
+	var cancel, cancelApply context.CancelFunc
+	s.ctx, cancel = s.runContext(withLogger(e.ctx, e.log))
+	s.applyCtx, cancelApply = s.runContext(withLogger(e.force, e.log))
+	s.stopRun = func() {
+		cancel()
+		cancelApply()
+	}
+
+	// read the TOML/YAML desired state file
+	fileState := state{opts: opts, deadline: s.deadline, ctx: s.ctx, tempDir: s.tempDir, log: s.log, sourceFiles: s.sourceFiles}
+	for _, f := range opts.Files {
+
+		result, msg := fileState.fromFile(f)
+		if !result {
+			return nil, errors.New(msg)
+		}
+		s.log.Info(msg)
+		// Merge Apps that already existed in the state
+		for appName, app := range fileState.Apps {
+			if _, ok := s.Apps[appName]; ok {
+				if err := mergo.Merge(s.Apps[appName], app, mergo.WithAppendSlice, mergo.WithOverride); err != nil {
+					return nil, errors.New("Failed to merge " + appName + " from desired state file" + f)
+				}
+			}
+		}
+
+		// Merge the remaining Apps
+		if err := mergo.Merge(&s.Apps, &fileState.Apps); err != nil {
+			return nil, errors.New("Failed to merge desired state file" + f)
+		}
+		// All the apps are already merged, make fileState.Apps empty to avoid conflicts in the final merge
+		fileState.Apps = make(map[string]*release)
+
+		if err := mergo.Merge(s, &fileState, mergo.WithAppendSlice, mergo.WithOverride); err != nil {
+			return nil, errors.New("Failed to merge desired state file" + f)
+		}
+	}
+
+	if len(opts.Targets) > 0 {
+		s.TargetMap = map[string]bool{}
+		for _, v := range opts.Targets {
+			s.TargetMap[v] = true
+		}
+	}
+
+	if len(opts.Groups) > 0 {
+		s.GroupMap = map[string]bool{}
+		for _, v := range opts.Groups {
+			s.GroupMap[v] = true
+		}
+	}
+
+	if opts.Debug {
+		s.print()
+	}
+
+	if !opts.SkipValidation {
+		// validate the desired state content
+		if len(opts.Files) > 0 {
+			s.log.Info("Validating desired state definition...")
+			if err := s.validate(); err != nil { // syntax validation
+				return nil, err
+			}
+		}
+	} else {
+		s.log.Info("Desired state validation is skipped.")
+	}
+
+	// set default storage background to secret if not set by user, it is passed to helm in its HELM_DRIVER env variable
+	if s.Settings.StorageBackend == "" {
+		s.Settings.StorageBackend = "secret"
+	}
+
+	// if there is no user-defined context name in the DSF(s), use the default context name
+	if s.Context == "" {
+		s.Context = defaultContextName
+	}
+
+	if len(s.GroupMap) > 0 {
+		s.TargetMap = s.getAppsInGroupsAsTargetMap()
+	}
+	if len(s.TargetMap) > 0 {
+		s.TargetApps = s.getAppsInTargetsOnly()
+		s.TargetNamespaces = s.getNamespacesInTargetsOnly()
+	}
+	return s, nil
 }
 
 // invokes either yaml or toml parser considering file extension
 func (s *state) fromFile(file string) (bool, string) {
-	if isOfType(file, []string{".toml"}) {
+	fileName := file
+	if isRemoteFile(file) {
+		fileName = remoteFileName(file)
+	}
+	if isOfType(fileName, []string{".toml"}) {
 		return fromTOML(file, s)
-	} else if isOfType(file, []string{".yaml", ".yml"}) {
+	} else if isOfType(fileName, []string{".yaml", ".yml"}) {
 		return fromYAML(file, s)
 	} else {
 		return false, "State file does not have toml/yaml extension."
 	}
 }
 
-func (s *state) toFile(file string) {
+func (s *state) toFile(file string) error {
 	if isOfType(file, []string{".toml"}) {
-		toTOML(file, s)
+		return toTOML(file, s)
 	} else if isOfType(file, []string{".yaml", ".yml"}) {
-		toYAML(file, s)
-	} else {
-		log.Fatal("State file does not have toml/yaml extension.")
+		return toYAML(file, s)
 	}
+	return errors.New("state file does not have toml/yaml extension")
 }
 
 // validate validates that the values specified in the desired state are valid according to the desired state spec.
@@ -69,16 +235,28 @@ func (s *state) validate() error {
 
 	// apps
 	if s.Apps == nil {
-		log.Info("No apps specified. Nothing to be executed.")
-		os.Exit(0)
+		// there is nothing to deploy
+		return nil
 	}
 
 	// settings
-	if (s.Settings == (config{}) || s.Settings.KubeContext == "") && !getKubeContext() {
+	if len(s.Clusters) == 0 && (s.Settings == (config{}) || s.Settings.KubeContext == "") && !getKubeContext(s.context()) {
 		return errors.New("settings validation failed -- you have not defined a " +
 			"kubeContext to use. Either define it in the desired state file or pass a kubeconfig with --kubeconfig to use an existing context")
 	}
 
+	if s.Settings.Auth != nil {
+		if err := s.Settings.Auth.validate(); err != nil {
+			return errors.New("settings validation failed -- auth: " + err.Error())
+		}
+		if s.Settings.BearerToken {
+			return errors.New("settings validation failed -- auth and bearerToken can't be used together")
+		}
+		if s.Settings.ClusterURI == "" {
+			return errors.New("settings validation failed -- auth is set but no cluster URI provided")
+		}
+	}
+
 	if s.Settings.ClusterURI != "" {
 		if _, err := url.ParseRequestURI(s.Settings.ClusterURI); err != nil {
 			return errors.New("settings validation failed -- clusterURI must have a valid URL set in an env variable or passed directly. Either the env var is missing/empty or the URL is invalid")
@@ -86,10 +264,10 @@ func (s *state) validate() error {
 		if s.Settings.KubeContext == "" {
 			return errors.New("settings validation failed -- KubeContext needs to be provided in the settings stanza")
 		}
-		if !s.Settings.BearerToken && s.Settings.Username == "" {
+		if !s.Settings.BearerToken && s.Settings.Auth == nil && s.Settings.Username == "" {
 			return errors.New("settings validation failed -- username needs to be provided in the settings stanza")
 		}
-		if !s.Settings.BearerToken && s.Settings.Password == "" {
+		if !s.Settings.BearerToken && s.Settings.Auth == nil && s.Settings.Password == "" {
 			return errors.New("settings validation failed -- password needs to be provided (directly or from env var) in the settings stanza")
 		}
 		if s.Settings.BearerToken && s.Settings.BearerTokenPath != "" {
@@ -101,6 +279,14 @@ func (s *state) validate() error {
 		return errors.New("settings validation failed -- bearer token is enabled but no cluster URI provided")
 	}
 
+	if err := s.Settings.Retry.validate(); err != nil {
+		return errors.New("settings validation failed -- retry: " + err.Error())
+	}
+
+	if err := s.Settings.validateStuckReleasePolicy(); err != nil {
+		return errors.New("settings validation failed -- " + err.Error())
+	}
+
 	// slack webhook validation (if provided)
 	if s.Settings.SlackWebhook != "" {
 		if _, err := url.ParseRequestURI(s.Settings.SlackWebhook); err != nil {
@@ -114,7 +300,7 @@ func (s *state) validate() error {
 		for key, value := range s.Certificates {
 			r, path := isValidCert(value)
 			if !r {
-				return errors.New("certifications validation failed -- [ " + key + " ] must be a valid S3, GCS, AZ bucket/container URL or a valid relative file path")
+				return errors.New("certifications validation failed -- [ " + key + " ] must be a valid S3, GCS, AZ bucket/container URL, HTTPS URL or a valid relative file path")
 			}
 			s.Certificates[key] = path
 		}
@@ -122,7 +308,7 @@ func (s *state) validate() error {
 		_, caCrt := s.Certificates["caCrt"]
 		_, caKey := s.Certificates["caKey"]
 
-		if s.Settings.ClusterURI != "" && !s.Settings.BearerToken {
+		if s.Settings.ClusterURI != "" && !s.Settings.BearerToken && s.Settings.Auth == nil {
 			if !caCrt || !caKey {
 				return errors.New("certificates validation failed -- connection to cluster is required " +
 					"but no cert/key was given. Please add [caCrt] and [caKey] under Certifications. You might also need to provide [clientCrt]")
@@ -136,22 +322,34 @@ func (s *state) validate() error {
 		}
 
 	} else {
-		if s.Settings.ClusterURI != "" {
+		if s.Settings.ClusterURI != "" && s.Settings.Auth == nil {
 			return errors.New("certificates validation failed -- kube context setup is required but no certificates stanza provided")
 		}
 	}
 
+	if s.Settings.SecretsBackend != "" && !stringInSlice(s.Settings.SecretsBackend, secretsBackendNames) {
+		return errors.New("settings validation failed -- secretsBackend must be one of: " + strings.Join(secretsBackendNames, ", "))
+	}
+	if s.Settings.EyamlEnabled && s.Settings.SecretsBackend != "" && s.Settings.SecretsBackend != eyamlBackend {
+		return errors.New("settings validation failed -- eyamlEnabled can't be used with the " + s.Settings.SecretsBackend + " secrets backend")
+	}
+
 	if (s.Settings.EyamlPrivateKeyPath != "" && s.Settings.EyamlPublicKeyPath == "") || (s.Settings.EyamlPrivateKeyPath == "" && s.Settings.EyamlPublicKeyPath != "") {
 		return errors.New("both EyamlPrivateKeyPath and EyamlPublicKeyPath are required")
 	}
 
 	// namespaces
-	if flags.nsOverride == "" {
+	if s.opts.NsOverride == "" {
 		if s.Namespaces == nil || len(s.Namespaces) == 0 {
 			return errors.New("namespaces validation failed -- at least one namespace is required")
 		}
+		for name, ns := range s.Namespaces {
+			if err := ns.validate(); err != nil {
+				return fmt.Errorf("namespaces validation failed -- namespace [ %s ] is invalid: %w", name, err)
+			}
+		}
 	} else {
-		log.Info("ns-override is used to override all namespaces with [ " + flags.nsOverride + " ] Skipping defined namespaces validation.")
+		s.log.Info("ns-override is used to override all namespaces with [ " + s.opts.NsOverride + " ] Skipping defined namespaces validation.")
 	}
 
 	// repos
@@ -163,6 +361,26 @@ func (s *state) validate() error {
 		}
 	}
 
+	// OCI registries
+	for host, reg := range s.OCIRegistries {
+		if strings.Contains(host, "/") {
+			return errors.New("OCI registries validation failed -- registry [ " + host + " ] must be a registry host without a scheme or path")
+		}
+		if (reg.Username == "") != (reg.Password == "") {
+			return errors.New("OCI registries validation failed -- registry [ " + host + " ] needs both a username and a password")
+		}
+	}
+
+	// hooks
+	if err := validateHooks(s.Hooks, stateHookTypes); err != nil {
+		return errors.New("hooks validation failed -- " + err.Error())
+	}
+
+	// policies
+	if err := validatePolicies(s.Policies); err != nil {
+		return errors.New("policies validation failed -- " + err.Error())
+	}
+
 	names := make(map[string]map[string]bool)
 	for appLabel, r := range s.Apps {
 		if err := r.validate(appLabel, names, s); err != nil {
@@ -170,6 +388,11 @@ func (s *state) validate() error {
 		}
 	}
 
+	// clusters
+	if err := s.validateClusters(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -177,7 +400,7 @@ func (s *state) validate() error {
 func isValidCert(value string) (bool, string) {
 	_, err1 := url.ParseRequestURI(value)
 	_, err2 := os.Stat(value)
-	if err2 != nil && (err1 != nil || (!strings.HasPrefix(value, "s3://") && !strings.HasPrefix(value, "gs://") && !strings.HasPrefix(value, "az://"))) {
+	if err2 != nil && (err1 != nil || !isRemoteFile(value)) {
 		return false, ""
 	}
 	return true, value
@@ -191,8 +414,9 @@ func (s *state) isNamespaceDefined(ns string) bool {
 
 // overrideAppsNamespace replaces all apps namespaces with one specific namespace
 func (s *state) overrideAppsNamespace(newNs string) {
-	log.Info("Overriding apps namespaces with [ " + newNs + " ] ...")
+	s.log.Info("Overriding apps namespaces with [ " + newNs + " ] ...")
 	for _, r := range s.Apps {
+		s.log.Info("Overriding namespace for app:  " + r.Name)
 		r.overrideNamespace(newNs)
 	}
 }
@@ -233,15 +457,18 @@ func (s *state) getNamespacesInTargetsOnly() map[string]namespace {
 }
 
 // updateContextLabels applies Helmsman labels including overriding any previously-set context with the one found in the DSF
-func (s *state) updateContextLabels() {
+func (s *state) updateContextLabels() error {
 	for _, r := range s.Apps {
 		if r.isConsideredToRun(s) {
-			log.Info("Updating context and reapplying Helmsman labels for release [ " + r.Name + " ]")
-			r.label()
+			s.log.Info("Updating context and reapplying Helmsman labels for release [ " + r.Name + " ]")
+			if err := r.label(s); err != nil {
+				return err
+			}
 		} else {
-			log.Warning(r.Name + " is not in the target group and therefore context and labels are not changed.")
+			s.log.Warning(r.Name + " is not in the target group and therefore context and labels are not changed.")
 		}
 	}
+	return nil
 }
 
 // print prints the desired state
@@ -265,6 +492,21 @@ func (s *state) print() {
 	fmt.Println("\nRepositories: ")
 	fmt.Println("------------- ")
 	printMap(s.HelmRepos, 0)
+	fmt.Println("\nOCI Registries: ")
+	fmt.Println("------------- ")
+	for host := range s.OCIRegistries {
+		fmt.Println(host)
+	}
+	fmt.Println("\nHooks: ")
+	fmt.Println("------------- ")
+	for hookType, h := range s.Hooks {
+		fmt.Println(hookType + " : " + h.describe())
+	}
+	fmt.Println("\nClusters: ")
+	fmt.Println("------------- ")
+	for _, c := range s.Clusters {
+		fmt.Println(c.Name + " : " + c.KubeContext)
+	}
 	fmt.Println("\nApplications: ")
 	fmt.Println("--------------- ")
 	for _, r := range s.Apps {
@@ -34,7 +34,7 @@ func Test_state_validate(t *testing.T) {
 					ClusterURI:  "https://192.168.99.100:8443",
 				},
 				Namespaces: map[string]namespace{
-					"staging": namespace{false, limits{}, make(map[string]string), make(map[string]string), &quotas{}},
+					"staging": namespace{Protected: false, Limits: limits{}, Labels: make(map[string]string), Annotations: make(map[string]string), Quotas: &quotas{}},
 				},
 				HelmRepos: map[string]string{
 					"stable": "https://kubernetes-charts.storage.googleapis.com",
@@ -43,6 +43,66 @@ func Test_state_validate(t *testing.T) {
 				Apps: make(map[string]*release),
 			},
 			want: true,
+		}, {
+			name: "test case -- settings/auth_without_certificates",
+			fields: fields{
+				Metadata: make(map[string]string),
+				Settings: config{
+					KubeContext: "prod",
+					ClusterURI:  "https://prod.eks.amazonaws.com",
+					Auth:        &clusterAuth{Provider: eksAuth, ClusterName: "prod"},
+				},
+				Namespaces: map[string]namespace{
+					"staging": namespace{Protected: false, Limits: limits{}, Labels: make(map[string]string), Annotations: make(map[string]string), Quotas: &quotas{}},
+				},
+				Apps: make(map[string]*release),
+			},
+			want: true,
+		}, {
+			name: "test case -- settings/auth_with_bearer_token",
+			fields: fields{
+				Metadata: make(map[string]string),
+				Settings: config{
+					KubeContext: "prod",
+					ClusterURI:  "https://prod.eks.amazonaws.com",
+					BearerToken: true,
+					Auth:        &clusterAuth{Provider: eksAuth, ClusterName: "prod"},
+				},
+				Namespaces: map[string]namespace{
+					"staging": namespace{Protected: false, Limits: limits{}, Labels: make(map[string]string), Annotations: make(map[string]string), Quotas: &quotas{}},
+				},
+				Apps: make(map[string]*release),
+			},
+			want: false,
+		}, {
+			name: "test case -- settings/unknown_secrets_backend",
+			fields: fields{
+				Metadata: make(map[string]string),
+				Settings: config{
+					KubeContext:    "minikube",
+					SecretsBackend: "vault",
+				},
+				Namespaces: map[string]namespace{
+					"staging": namespace{Protected: false, Limits: limits{}, Labels: make(map[string]string), Annotations: make(map[string]string), Quotas: &quotas{}},
+				},
+				Apps: make(map[string]*release),
+			},
+			want: false,
+		}, {
+			name: "test case -- settings/eyaml_with_helm_secrets_backend",
+			fields: fields{
+				Metadata: make(map[string]string),
+				Settings: config{
+					KubeContext:    "minikube",
+					SecretsBackend: helmSecretsBackend,
+					EyamlEnabled:   true,
+				},
+				Namespaces: map[string]namespace{
+					"staging": namespace{Protected: false, Limits: limits{}, Labels: make(map[string]string), Annotations: make(map[string]string), Quotas: &quotas{}},
+				},
+				Apps: make(map[string]*release),
+			},
+			want: false,
 		}, {
 			name: "test case 2 -- settings/empty_context",
 			fields: fields{
@@ -58,7 +118,7 @@ func Test_state_validate(t *testing.T) {
 					ClusterURI:  "https://192.168.99.100:8443",
 				},
 				Namespaces: map[string]namespace{
-					"staging": namespace{false, limits{}, make(map[string]string), make(map[string]string), &quotas{}},
+					"staging": namespace{Protected: false, Limits: limits{}, Labels: make(map[string]string), Annotations: make(map[string]string), Quotas: &quotas{}},
 				},
 				HelmRepos: map[string]string{
 					"stable": "https://kubernetes-charts.storage.googleapis.com",
@@ -79,7 +139,7 @@ func Test_state_validate(t *testing.T) {
 					KubeContext: "minikube",
 				},
 				Namespaces: map[string]namespace{
-					"staging": namespace{false, limits{}, make(map[string]string), make(map[string]string), &quotas{}},
+					"staging": namespace{Protected: false, Limits: limits{}, Labels: make(map[string]string), Annotations: make(map[string]string), Quotas: &quotas{}},
 				},
 				HelmRepos: map[string]string{
 					"stable": "https://kubernetes-charts.storage.googleapis.com",
@@ -103,7 +163,7 @@ func Test_state_validate(t *testing.T) {
 					ClusterURI:  "https://192.168.99.100:8443",
 				},
 				Namespaces: map[string]namespace{
-					"staging": namespace{false, limits{}, make(map[string]string), make(map[string]string), &quotas{}},
+					"staging": namespace{Protected: false, Limits: limits{}, Labels: make(map[string]string), Annotations: make(map[string]string), Quotas: &quotas{}},
 				},
 				HelmRepos: map[string]string{
 					"stable": "https://kubernetes-charts.storage.googleapis.com",
@@ -127,7 +187,7 @@ func Test_state_validate(t *testing.T) {
 					ClusterURI:  "$URI", // unset env
 				},
 				Namespaces: map[string]namespace{
-					"staging": namespace{false, limits{}, make(map[string]string), make(map[string]string), &quotas{}},
+					"staging": namespace{Protected: false, Limits: limits{}, Labels: make(map[string]string), Annotations: make(map[string]string), Quotas: &quotas{}},
 				},
 				HelmRepos: map[string]string{
 					"stable": "https://kubernetes-charts.storage.googleapis.com",
@@ -151,7 +211,7 @@ func Test_state_validate(t *testing.T) {
 					ClusterURI:  "https//192.168.99.100:8443", // invalid url
 				},
 				Namespaces: map[string]namespace{
-					"staging": namespace{false, limits{}, make(map[string]string), make(map[string]string), &quotas{}},
+					"staging": namespace{Protected: false, Limits: limits{}, Labels: make(map[string]string), Annotations: make(map[string]string), Quotas: &quotas{}},
 				},
 				HelmRepos: map[string]string{
 					"stable": "https://kubernetes-charts.storage.googleapis.com",
@@ -174,7 +234,7 @@ func Test_state_validate(t *testing.T) {
 					ClusterURI:  "https://192.168.99.100:8443",
 				},
 				Namespaces: map[string]namespace{
-					"staging": namespace{false, limits{}, make(map[string]string), make(map[string]string), &quotas{}},
+					"staging": namespace{Protected: false, Limits: limits{}, Labels: make(map[string]string), Annotations: make(map[string]string), Quotas: &quotas{}},
 				},
 				HelmRepos: map[string]string{
 					"stable": "https://kubernetes-charts.storage.googleapis.com",
@@ -195,7 +255,7 @@ func Test_state_validate(t *testing.T) {
 					ClusterURI:  "https://192.168.99.100:8443",
 				},
 				Namespaces: map[string]namespace{
-					"staging": namespace{false, limits{}, make(map[string]string), make(map[string]string), &quotas{}},
+					"staging": namespace{Protected: false, Limits: limits{}, Labels: make(map[string]string), Annotations: make(map[string]string), Quotas: &quotas{}},
 				},
 				HelmRepos: map[string]string{
 					"stable": "https://kubernetes-charts.storage.googleapis.com",
@@ -219,7 +279,7 @@ func Test_state_validate(t *testing.T) {
 					ClusterURI:  "https://192.168.99.100:8443",
 				},
 				Namespaces: map[string]namespace{
-					"staging": namespace{false, limits{}, make(map[string]string), make(map[string]string), &quotas{}},
+					"staging": namespace{Protected: false, Limits: limits{}, Labels: make(map[string]string), Annotations: make(map[string]string), Quotas: &quotas{}},
 				},
 				HelmRepos: map[string]string{
 					"stable": "https://kubernetes-charts.storage.googleapis.com",
@@ -237,7 +297,7 @@ func Test_state_validate(t *testing.T) {
 					KubeContext: "minikube",
 				},
 				Namespaces: map[string]namespace{
-					"staging": namespace{false, limits{}, make(map[string]string), make(map[string]string), &quotas{}},
+					"staging": namespace{Protected: false, Limits: limits{}, Labels: make(map[string]string), Annotations: make(map[string]string), Quotas: &quotas{}},
 				},
 				HelmRepos: map[string]string{
 					"stable": "https://kubernetes-charts.storage.googleapis.com",
@@ -287,7 +347,7 @@ func Test_state_validate(t *testing.T) {
 					KubeContext: "minikube",
 				},
 				Namespaces: map[string]namespace{
-					"staging": namespace{false, limits{}, make(map[string]string), make(map[string]string), &quotas{}},
+					"staging": namespace{Protected: false, Limits: limits{}, Labels: make(map[string]string), Annotations: make(map[string]string), Quotas: &quotas{}},
 				},
 				HelmRepos: nil,
 				Apps:      make(map[string]*release),
@@ -302,7 +362,7 @@ func Test_state_validate(t *testing.T) {
 					KubeContext: "minikube",
 				},
 				Namespaces: map[string]namespace{
-					"staging": namespace{false, limits{}, make(map[string]string), make(map[string]string), &quotas{}},
+					"staging": namespace{Protected: false, Limits: limits{}, Labels: make(map[string]string), Annotations: make(map[string]string), Quotas: &quotas{}},
 				},
 				HelmRepos: map[string]string{},
 				Apps:      make(map[string]*release),
@@ -317,7 +377,7 @@ func Test_state_validate(t *testing.T) {
 					KubeContext: "minikube",
 				},
 				Namespaces: map[string]namespace{
-					"staging": namespace{false, limits{}, make(map[string]string), make(map[string]string), &quotas{}},
+					"staging": namespace{Protected: false, Limits: limits{}, Labels: make(map[string]string), Annotations: make(map[string]string), Quotas: &quotas{}},
 				},
 				HelmRepos: map[string]string{
 					"stable": "https://kubernetes-charts.storage.googleapis.com",
@@ -335,7 +395,7 @@ func Test_state_validate(t *testing.T) {
 					KubeContext: "minikube",
 				},
 				Namespaces: map[string]namespace{
-					"staging": namespace{false, limits{}, make(map[string]string), make(map[string]string), &quotas{}},
+					"staging": namespace{Protected: false, Limits: limits{}, Labels: make(map[string]string), Annotations: make(map[string]string), Quotas: &quotas{}},
 				},
 				HelmRepos: map[string]string{
 					"stable": "https://kubernetes-charts.storage.googleapis.com",
@@ -0,0 +1,221 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// policies for the releases stuck in a pending status
+const (
+	stuckReleaseFail    = "fail"
+	stuckReleaseWait    = "wait"
+	stuckReleaseRecover = "recover"
+)
+
+const (
+	defaultStuckReleaseTimeout   = 300
+	defaultStuckReleaseThreshold = 900
+	stuckReleasePollInterval     = 10 * time.Second
+)
+
+// isPending checks if a release status means that a helm operation on the release did not complete (yet)
+func isPending(status string) bool {
+	switch status {
+	case helmStatusPendingInstall, helmStatusPendingUpgrade, helmStatusPendingRollback, helmStatusUninstalling:
+		return true
+	}
+	return false
+}
+
+// validateStuckReleasePolicy checks the stuck releases settings
+func (c config) validateStuckReleasePolicy() error {
+	switch c.StuckReleasePolicy {
+	case "", stuckReleaseFail, stuckReleaseWait, stuckReleaseRecover:
+	default:
+		return errors.New("stuckReleasePolicy must be one of: " + strings.Join([]string{stuckReleaseFail, stuckReleaseWait, stuckReleaseRecover}, ", "))
+	}
+	if c.StuckReleaseTimeout < 0 || c.StuckReleaseThreshold < 0 {
+		return errors.New("stuckReleaseTimeout and stuckReleaseThreshold can't be negative")
+	}
+	return nil
+}
+
+// getStuckReleaseTimeout returns how long to wait for a pending release with the wait policy
+func (c config) getStuckReleaseTimeout() time.Duration {
+	if c.StuckReleaseTimeout == 0 {
+		return defaultStuckReleaseTimeout * time.Second
+	}
+	return time.Duration(c.StuckReleaseTimeout) * time.Second
+}
+
+// getStuckReleaseThreshold returns how old the pending operation of a release must be for the recover policy to recover it
+func (c config) getStuckReleaseThreshold() time.Duration {
+	if c.StuckReleaseThreshold == 0 {
+		return defaultStuckReleaseThreshold * time.Second
+	}
+	return time.Duration(c.StuckReleaseThreshold) * time.Second
+}
+
+// waitForPendingReleases polls the pending releases of the apps to deploy until their status changes,
+// and updates the current state with their new status. It fails when a release is still pending after the stuck release timeout.
+// It must run before the decisions are made, as it changes the current state.
+func (cs *currentState) waitForPendingReleases(s *state) error {
+	pending := map[*release]helmRelease{}
+	for _, r := range s.Apps {
+		if r.isConsideredToRun(s) && cs.releaseExists(r, "") && isPending(cs.releases[r.key()].Status) {
+			pending[r] = cs.releases[r.key()]
+		}
+	}
+
+	var (
+		wg   sync.WaitGroup
+		errs errorList
+	)
+	for r, rs := range pending {
+		wg.Add(1)
+		go func(r *release, rs helmRelease) {
+			defer wg.Done()
+			s.log.Info("Release [ " + r.Name + " ] in namespace [ " + r.Namespace + " ] is in [ " + rs.Status + " ] state, waiting for it to change...")
+			deadline := time.Now().Add(s.Settings.getStuckReleaseTimeout())
+			for isPending(rs.Status) {
+				if time.Now().Add(stuckReleasePollInterval).After(deadline) {
+					errs.add(errors.New("release [ " + r.Name + " ] in namespace [ " + r.Namespace + " ] is still in [ " + rs.Status + " ] state after [ " +
+						s.Settings.getStuckReleaseTimeout().String() + " ]. Check it with helm or use the recover stuckReleasePolicy"))
+					return
+				}
+				time.Sleep(stuckReleasePollInterval)
+				current, found, err := getHelmRelease(r.Name, r.Namespace, rs.kubeContext, s)
+				if err != nil {
+					errs.add(err)
+					return
+				}
+				if !found {
+					s.log.Info("Release [ " + r.Name + " ] in namespace [ " + r.Namespace + " ] is gone")
+					cs.forgetRelease(rs.key())
+					return
+				}
+				current.HelmsmanContext = rs.HelmsmanContext
+				rs = current
+			}
+			s.log.Info("Release [ " + r.Name + " ] in namespace [ " + r.Namespace + " ] is now in [ " + rs.Status + " ] state")
+			cs.Lock()
+			cs.releases[rs.key()] = rs
+			cs.Unlock()
+		}(r, rs)
+	}
+	wg.Wait()
+	return errs.err()
+}
+
+// forgetRelease removes a release from the current state
+func (cs *currentState) forgetRelease(key string) {
+	cs.Lock()
+	defer cs.Unlock()
+	releases := make(map[string]helmRelease, len(cs.releases))
+	for k, v := range cs.releases {
+		if k != key {
+			releases[k] = v
+		}
+	}
+	cs.releases = releases
+}
+
+// getHelmRelease fetches the current state of a single release.
+// It returns false if the release does not exist in the given namespace.
+func getHelmRelease(name string, namespace string, kctx string, s *state) (helmRelease, bool, error) {
+	var releases []helmRelease
+	cmd := helmCmd([]string{"list", "--all", "--filter", "^" + name + "$", "--output", "json", "-n", namespace}, "Getting the status of release [ "+name+" ] in namespace [ "+namespace+" ]").inCluster(s, kctx).withTimeout(s.commandTimeout(statePhase))
+	ctx := s.context()
+	result := cmd.retryExec(ctx, s.getRetryPolicy(nil), s.retries)
+	if result.code != 0 {
+		return helmRelease{}, false, errors.New("failed to get the status of release [ " + name + " ]: " + result.errors)
+	}
+	if err := json.Unmarshal([]byte(result.output), &releases); err != nil {
+		return helmRelease{}, false, fmt.Errorf("failed to unmarshal Helm CLI output: %w", err)
+	}
+	for _, rs := range releases {
+		if rs.Name == name {
+			rs.kubeContext = kctx
+			return rs, true, nil
+		}
+	}
+	return helmRelease{}, false, nil
+}
+
+// getLastDeployedRevision returns the last revision of a release which was successfully deployed, or 0 if there is none
+func getLastDeployedRevision(rs helmRelease, s *state) (int, error) {
+	var history []struct {
+		Revision int    `json:"revision"`
+		Status   string `json:"status"`
+	}
+	cmd := helmCmd([]string{"history", rs.Name, "--output", "json", "-n", rs.Namespace}, "Getting the history of release [ "+rs.Name+" ] in namespace [ "+rs.Namespace+" ]").inCluster(s, rs.kubeContext).withTimeout(s.commandTimeout(statePhase))
+	ctx := s.context()
+	result := cmd.retryExec(ctx, s.getRetryPolicy(nil), s.retries)
+	if result.code != 0 {
+		return 0, errors.New("failed to get the history of release [ " + rs.Name + " ]: " + result.errors)
+	}
+	if err := json.Unmarshal([]byte(result.output), &history); err != nil {
+		return 0, fmt.Errorf("failed to unmarshal Helm CLI output: %w", err)
+	}
+	revision := 0
+	for _, h := range history {
+		if (h.Status == helmStatusDeployed || h.Status == helmStatusSuperseded) && h.Revision > revision && h.Revision < rs.Revision {
+			revision = h.Revision
+		}
+	}
+	return revision, nil
+}
+
+// recoverStuckRelease decides what to do with a release stuck in a pending status, according to the stuck release policy.
+// With the recover policy, a release whose pending operation is older than the threshold is rolled back to its
+// last deployed revision and then upgraded, or uninstalled and installed again if it was never deployed.
+func (cs *currentState) recoverStuckRelease(r *release, s *state, p *plan) error {
+	rs := cs.releases[r.key()]
+	stuck := "Release [ " + r.Name + " ] in namespace [ " + r.Namespace + " ] is in [ " + rs.Status + " ] state"
+	if !rs.Updated.IsZero() {
+		stuck += " since [ " + time.Since(rs.Updated.Time).Round(time.Second).String() + " ]"
+	}
+	stuck += ", this means a helm operation on it is still running or was interrupted."
+
+	if s.Settings.StuckReleasePolicy != stuckReleaseRecover {
+		return errors.New(stuck + " Stopping, as this may cause issues when continuing. " +
+			"Set the stuckReleasePolicy setting to wait or recover to let Helmsman handle it.")
+	}
+	threshold := s.Settings.getStuckReleaseThreshold()
+	if rs.Updated.IsZero() || time.Since(rs.Updated.Time) < threshold {
+		return errors.New(stuck + " It will only be recovered once the pending operation is older than [ " + threshold.String() + " ].")
+	}
+	if r.isProtected(cs, s) {
+		p.addDecision("Release [ "+r.Name+" ] in namespace [ "+r.Namespace+" ] is PROTECTED. Operations are not allowed on this release until "+
+			"you remove its protection.", r.Priority, noop)
+		return nil
+	}
+
+	revision := 0
+	if rs.Status == helmStatusPendingUpgrade || rs.Status == helmStatusPendingRollback {
+		var err error
+		if revision, err = getLastDeployedRevision(rs, s); err != nil {
+			return err
+		}
+	}
+	if revision == 0 {
+		if err := r.reInstall(s, p); err != nil {
+			return err
+		}
+		p.addDecisionFor(r.Namespace, r.Name, stuck+" It was never deployed: it will be deleted and installed again.", r.Priority, change)
+		return nil
+	}
+	cmd := helmCmd(concat([]string{"rollback", r.Name, strconv.Itoa(revision)}, []string{"--namespace", r.Namespace}, r.getWait(), r.getTimeout(), r.getNoHooks(), s.opts.getDryRunFlags()),
+		"Rolling back stuck release [ "+r.Name+" ] in namespace [ "+r.Namespace+" ] to revision [ "+strconv.Itoa(revision)+" ]").inCluster(s, r.kubeContext)
+	p.addCommand(cmd, r.Priority, r)
+	if err := r.upgrade(s, p); err != nil {
+		return err
+	}
+	p.addDecisionFor(r.Namespace, r.Name, stuck+" It will be rolled back to its last deployed revision [ "+strconv.Itoa(revision)+" ] and upgraded.", r.Priority, change)
+	return nil
+}
@@ -0,0 +1,104 @@
+package app
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_isPending(t *testing.T) {
+	for status, want := range map[string]bool{
+		helmStatusPendingInstall:  true,
+		helmStatusPendingUpgrade:  true,
+		helmStatusPendingRollback: true,
+		helmStatusUninstalling:    true,
+		helmStatusDeployed:        false,
+		helmStatusFailed:          false,
+		helmStatusUninstalled:     false,
+	} {
+		if got := isPending(status); got != want {
+			t.Errorf("isPending(%s) = %v, want %v", status, got, want)
+		}
+	}
+}
+
+func Test_config_validateStuckReleasePolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		c       config
+		wantErr bool
+	}{
+		{name: "default", c: config{}},
+		{name: "recover", c: config{StuckReleasePolicy: stuckReleaseRecover, StuckReleaseThreshold: 600}},
+		{name: "unknown policy", c: config{StuckReleasePolicy: "ignore"}, wantErr: true},
+		{name: "negative timeout", c: config{StuckReleasePolicy: stuckReleaseWait, StuckReleaseTimeout: -1}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.c.validateStuckReleasePolicy(); (err != nil) != tt.wantErr {
+				t.Errorf("validateStuckReleasePolicy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_decide_stuckReleaseRecovered(t *testing.T) {
+	r := &release{Name: "api", Namespace: "staging", Chart: "repo/api", Version: "1.0.0", Enabled: true}
+	s := &state{
+		Settings:   config{StuckReleasePolicy: stuckReleaseRecover, StuckReleaseThreshold: 60},
+		Namespaces: map[string]namespace{"staging": {}},
+		opts:       DefaultOptions(),
+	}
+	cs := newCurrentState()
+	cs.context = defaultContextName
+	cs.releases[r.key()] = helmRelease{
+		Name:            "api",
+		Namespace:       "staging",
+		Revision:        1,
+		Status:          helmStatusPendingInstall,
+		Chart:           "api-1.0.0",
+		Updated:         HelmTime{time.Now().Add(-time.Hour)},
+		HelmsmanContext: defaultContextName,
+	}
+
+	p := createPlan()
+	if err := cs.decide(r, s, p); err != nil {
+		t.Fatalf("decide() error = %v", err)
+	}
+
+	if len(p.Decisions) != 1 || p.Decisions[0].Type != change || !strings.Contains(p.Decisions[0].Description, "deleted and installed again") {
+		t.Fatalf("decide() = %+v, want the release to be installed again", p.Decisions)
+	}
+	var actions []string
+	for _, c := range p.Commands {
+		actions = append(actions, c.Command.Args[0])
+	}
+	if got := strings.Join(actions, " "); got != "uninstall install" {
+		t.Errorf("decide() planned [ %s ], want [ uninstall install ]", got)
+	}
+}
+
+func Test_decide_stuckReleaseFails(t *testing.T) {
+	r := &release{Name: "api", Namespace: "staging", Chart: "repo/api", Version: "1.0.0", Enabled: true}
+	s := &state{
+		Settings:   config{StuckReleasePolicy: stuckReleaseFail},
+		Namespaces: map[string]namespace{"staging": {}},
+		opts:       DefaultOptions(),
+	}
+	cs := newCurrentState()
+	cs.context = defaultContextName
+	cs.releases[r.key()] = helmRelease{
+		Name:            "api",
+		Namespace:       "staging",
+		Status:          helmStatusPendingUpgrade,
+		HelmsmanContext: defaultContextName,
+	}
+
+	p := createPlan()
+	if err := cs.decide(r, s, p); err == nil || !strings.Contains(err.Error(), "pending-upgrade") {
+		t.Errorf("decide() error = %v, want the stuck release to fail the plan", err)
+	}
+	if len(p.Commands) != 0 {
+		t.Errorf("decide() planned %d commands, want none", len(p.Commands))
+	}
+}
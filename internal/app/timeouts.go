@@ -0,0 +1,99 @@
+package app
+
+import (
+	"context"
+	"time"
+)
+
+// phase is a phase of a run, the commands of each phase have their own timeout
+type phase int
+
+const (
+	// reposPhase adds and updates the helm repos and logs in to the OCI registries
+	reposPhase phase = iota
+	// statePhase reads the namespaces and the releases currently deployed
+	statePhase
+	// diffPhase diffs the releases to upgrade
+	diffPhase
+	// applyPhase executes the commands of the plan
+	applyPhase
+)
+
+// String allows for pretty printing phase const
+func (ph phase) String() string {
+	switch ph {
+	case reposPhase:
+		return "repo"
+	case statePhase:
+		return "state"
+	case diffPhase:
+		return "diff"
+	case applyPhase:
+		return "apply"
+	}
+	return "unknown"
+}
+
+// commandTimeout returns the timeout of the commands of a phase: the timeout of the phase,
+// or what is left of the run timeout if that is shorter. It returns 0 if neither is set.
+func (s *state) commandTimeout(ph phase) time.Duration {
+	var timeout time.Duration
+	switch ph {
+	case reposPhase:
+		timeout = s.opts.RepoTimeout
+	case statePhase:
+		timeout = s.opts.StateTimeout
+	case diffPhase:
+		timeout = s.opts.DiffTimeout
+	case applyPhase:
+		timeout = s.opts.ApplyTimeout
+	}
+	if s.deadline.IsZero() {
+		return timeout
+	}
+	left := time.Until(s.deadline)
+	if left <= 0 {
+		// the run is over: the command is stopped as soon as it starts, which reports it as timed out
+		left = time.Nanosecond
+	}
+	if timeout == 0 || left < timeout {
+		return left
+	}
+	return timeout
+}
+
+// runContext returns a context which is done once the run timeout is reached, or when the parent context is done
+func (s *state) runContext(parent context.Context) (context.Context, context.CancelFunc) {
+	if s.deadline.IsZero() {
+		return context.WithCancel(parent)
+	}
+	return context.WithDeadline(parent, s.deadline)
+}
+
+// context returns the context of the run, which is done once the run timeout is reached or when the run is stopped,
+// e.g. by a signal. The commands and the downloads of the run derive from it.
+// States which were not loaded from desired state files, e.g. in tests, get a context which is never done.
+func (s *state) context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
+
+// applyContext returns the context of the commands applying the plan. Unlike the context of the run, it is not done
+// when the run is stopped gracefully, which leaves the running command to finish, but only at the run timeout
+// or when Helmsman is forced to stop. States which were not loaded from desired state files get a context which is never done.
+func (s *state) applyContext() context.Context {
+	if s.applyCtx == nil {
+		return context.Background()
+	}
+	return s.applyCtx
+}
+
+// stopReason describes why a run stopped early, given its context is done
+func stopReason(ctx context.Context, s *state) string {
+	if ctx.Err() == context.DeadlineExceeded {
+		return "the run did not complete within its timeout of [ " + s.opts.Timeout.String() + " ]"
+	}
+	return "the run was interrupted"
+}
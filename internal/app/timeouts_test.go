@@ -0,0 +1,99 @@
+package app
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func Test_state_commandTimeout(t *testing.T) {
+	tests := []struct {
+		name     string
+		s        state
+		ph       phase
+		min, max time.Duration
+	}{
+		{
+			name: "no timeout",
+			s:    state{opts: DefaultOptions()},
+			ph:   applyPhase,
+		}, {
+			name: "phase timeout",
+			s:    state{opts: Options{DiffTimeout: time.Minute, ApplyTimeout: time.Hour}},
+			ph:   diffPhase,
+			min:  time.Minute,
+			max:  time.Minute,
+		}, {
+			name: "run timeout shorter than the phase one",
+			s:    state{opts: Options{RepoTimeout: time.Hour}, deadline: time.Now().Add(time.Minute)},
+			ph:   reposPhase,
+			min:  50 * time.Second,
+			max:  time.Minute,
+		}, {
+			name: "run timeout only",
+			s:    state{deadline: time.Now().Add(time.Hour)},
+			ph:   statePhase,
+			min:  50 * time.Minute,
+			max:  time.Hour,
+		}, {
+			name: "run timeout reached",
+			s:    state{opts: Options{ApplyTimeout: time.Hour}, deadline: time.Now().Add(-time.Minute)},
+			ph:   applyPhase,
+			min:  time.Nanosecond,
+			max:  time.Nanosecond,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.s.commandTimeout(tt.ph); got < tt.min || got > tt.max {
+				t.Errorf("commandTimeout(%s) = %s, want between %s and %s", tt.ph, got, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func Test_state_runContext(t *testing.T) {
+	s := &state{opts: Options{Timeout: time.Minute}, deadline: time.Now().Add(-time.Second)}
+	ctx, cancel := s.runContext(context.Background())
+	defer cancel()
+	if ctx.Err() != context.DeadlineExceeded {
+		t.Errorf("runContext() of a run past its deadline returned a context which is not done")
+	}
+	if got, want := stopReason(ctx, s), "the run did not complete within its timeout of [ 1m0s ]"; got != want {
+		t.Errorf("stopReason() = %q, want %q", got, want)
+	}
+
+	s = &state{}
+	ctx, cancel = s.runContext(context.Background())
+	cancel()
+	if got, want := stopReason(ctx, s), "the run was interrupted"; got != want {
+		t.Errorf("stopReason() = %q, want %q", got, want)
+	}
+}
+
+func Test_loadState_context(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	e := &Engine{ctx: ctx, force: context.Background(), opts: Options{SkipValidation: true}, tempDir: t.TempDir()}
+	s, err := e.loadState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.cleanup()
+	if s.context().Err() != nil {
+		t.Fatalf("context() of a new run is done")
+	}
+	cs, err := s.forCluster(cluster{Name: "prod"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if s.context().Err() == nil || cs.context().Err() == nil {
+		t.Errorf("context() of a stopped run is not done")
+	}
+	if s.applyContext().Err() != nil {
+		t.Errorf("applyContext() of a run stopped gracefully is done")
+	}
+	if (&state{}).context().Err() != nil {
+		t.Errorf("context() of a state which was not loaded is done")
+	}
+}
@@ -2,6 +2,9 @@ package app
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -12,16 +15,21 @@ import (
 	"path"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"gopkg.in/yaml.v2"
 
 	"github.com/BurntSushi/toml"
 	"github.com/Praqma/helmsman/internal/aws"
-	"github.com/Praqma/helmsman/internal/azure"
-	"github.com/Praqma/helmsman/internal/gcs"
+	"github.com/Praqma/helmsman/internal/storage"
+
+	// register the remaining storage providers fetchers
+	_ "github.com/Praqma/helmsman/internal/azure"
+	_ "github.com/Praqma/helmsman/internal/gcs"
 )
 
 // printMap prints to the console any map of string keys and values.
@@ -41,150 +49,169 @@ func printNamespacesMap(m map[string]namespace) {
 // fromTOML reads a toml file and decodes it to a state type.
 // It uses the BurntSuchi TOML parser which throws an error if the TOML file is not valid.
 func fromTOML(file string, s *state) (bool, string) {
-	rawTomlFile, err := ioutil.ReadFile(file)
+	ctx := s.context()
+	localFile, err := fetchFile(ctx, s.tempDir, file)
+	if err != nil {
+		return false, err.Error()
+	}
+	rawTomlFile, err := ioutil.ReadFile(localFile)
 	if err != nil {
 		return false, err.Error()
 	}
 
 	tomlFile := string(rawTomlFile)
-	if !flags.noEnvSubst {
+	if !s.opts.NoEnvSubst {
 		tomlFile = substituteEnv(tomlFile)
 	}
-	if !flags.noSSMSubst {
-		tomlFile = substituteSSM(tomlFile)
+	if !s.opts.NoSSMSubst {
+		if tomlFile, err = substituteSSM(tomlFile); err != nil {
+			return false, err.Error()
+		}
 	}
 
 	if _, err := toml.Decode(tomlFile, s); err != nil {
 		return false, err.Error()
 	}
 	resolvePaths(file, s)
-	substituteVarsInValuesFiles(s)
+	if err := substituteVarsInValuesFiles(s); err != nil {
+		return false, err.Error()
+	}
 
 	return true, "Parsed TOML [[ " + file + " ]] successfully and found [ " + strconv.Itoa(len(s.Apps)) + " ] apps"
 }
 
 // toTOML encodes a state type into a TOML file.
 // It uses the BurntSuchi TOML parser.
-func toTOML(file string, s *state) {
-	log.Info("Printing generated toml ... ")
+func toTOML(file string, s *state) error {
+	s.log.Info("Printing generated toml ... ")
 	var buff bytes.Buffer
-	var (
-		newFile *os.File
-		err     error
-	)
-
 	if err := toml.NewEncoder(&buff).Encode(s); err != nil {
-		log.Fatal(err.Error())
-		os.Exit(1)
-	}
-	newFile, err = os.Create(file)
-	if err != nil {
-		log.Fatal(err.Error())
+		return err
 	}
-	bytesWritten, err := newFile.Write(buff.Bytes())
-	if err != nil {
-		log.Fatal(err.Error())
+	if err := ioutil.WriteFile(file, buff.Bytes(), 0644); err != nil {
+		return err
 	}
-	log.Info(fmt.Sprintf("Wrote %d bytes.\n", bytesWritten))
-	newFile.Close()
+	s.log.Info(fmt.Sprintf("Wrote %d bytes.\n", buff.Len()))
+	return nil
 }
 
 // fromYAML reads a yaml file and decodes it to a state type.
 // parser which throws an error if the YAML file is not valid.
 func fromYAML(file string, s *state) (bool, string) {
-	rawYamlFile, err := ioutil.ReadFile(file)
+	ctx := s.context()
+	localFile, err := fetchFile(ctx, s.tempDir, file)
+	if err != nil {
+		return false, err.Error()
+	}
+	rawYamlFile, err := ioutil.ReadFile(localFile)
 	if err != nil {
 		return false, err.Error()
 	}
 
 	yamlFile := string(rawYamlFile)
-	if !flags.noEnvSubst {
+	if !s.opts.NoEnvSubst {
 		yamlFile = substituteEnv(yamlFile)
 	}
-	if !flags.noSSMSubst {
-		yamlFile = substituteSSM(yamlFile)
+	if !s.opts.NoSSMSubst {
+		if yamlFile, err = substituteSSM(yamlFile); err != nil {
+			return false, err.Error()
+		}
 	}
 
 	if err = yaml.UnmarshalStrict([]byte(yamlFile), s); err != nil {
 		return false, err.Error()
 	}
 	resolvePaths(file, s)
-	substituteVarsInValuesFiles(s)
+	if err := substituteVarsInValuesFiles(s); err != nil {
+		return false, err.Error()
+	}
 
 	return true, "Parsed YAML [[ " + file + " ]] successfully and found [ " + strconv.Itoa(len(s.Apps)) + " ] apps"
 }
 
 // toYaml encodes a state type into a YAML file
-func toYAML(file string, s *state) {
-	log.Info("Printing generated yaml ... ")
+func toYAML(file string, s *state) error {
+	s.log.Info("Printing generated yaml ... ")
 	var buff bytes.Buffer
-	var (
-		newFile *os.File
-		err     error
-	)
-
 	if err := yaml.NewEncoder(&buff).Encode(s); err != nil {
-		log.Fatal(err.Error())
-		os.Exit(1)
-	}
-	newFile, err = os.Create(file)
-	if err != nil {
-		log.Fatal(err.Error())
+		return err
 	}
-	bytesWritten, err := newFile.Write(buff.Bytes())
-	if err != nil {
-		log.Fatal(err.Error())
+	if err := ioutil.WriteFile(file, buff.Bytes(), 0644); err != nil {
+		return err
 	}
-	log.Info(fmt.Sprintf("Wrote %d bytes.\n", bytesWritten))
-	newFile.Close()
+	s.log.Info(fmt.Sprintf("Wrote %d bytes.\n", buff.Len()))
+	return nil
 }
 
 // substituteVarsInValuesFiles loops through the values/secrets files and substitutes variables into them.
-func substituteVarsInValuesFiles(s *state) {
-	for _, v := range s.Apps {
-		if v.ValuesFile != "" {
-			v.ValuesFile = substituteVarsInYaml(v.ValuesFile)
-		}
-		if v.SecretsFile != "" {
-			v.SecretsFile = substituteVarsInYaml(v.SecretsFile)
-		}
+func substituteVarsInValuesFiles(s *state) error {
+	for _, v := range s.getReleaseDefinitions() {
+		files := []*string{&v.ValuesFile, &v.SecretsFile}
 		for i := range v.ValuesFiles {
-			v.ValuesFiles[i] = substituteVarsInYaml(v.ValuesFiles[i])
+			files = append(files, &v.ValuesFiles[i])
 		}
 		for i := range v.SecretsFiles {
-			v.SecretsFiles[i] = substituteVarsInYaml(v.SecretsFiles[i])
+			files = append(files, &v.SecretsFiles[i])
+		}
+		for _, f := range files {
+			if *f == "" {
+				continue
+			}
+			substituted, err := substituteVarsInYaml(*f, s)
+			if err != nil {
+				return err
+			}
+			if s.sourceFiles != nil {
+				s.sourceFiles[substituted] = *f
+			}
+			*f = substituted
 		}
 	}
+	return nil
 }
 
-// substituteVarsInYaml substitutes variables in a Yaml file and creates a temp file with these values.
+// sourceFile returns the path of a values or secrets file in the desired state, before its variables were substituted.
+func (s *state) sourceFile(file string) string {
+	if source, ok := s.sourceFiles[file]; ok {
+		return source
+	}
+	return file
+}
+
+// substituteVarsInYaml substitutes variables in a Yaml file and creates a file with these values in the temp dir of the state.
+// Remote files are downloaded first, the download stops when the context of the state is done.
 // Returns the path for the temp file
-func substituteVarsInYaml(file string) string {
+func substituteVarsInYaml(file string, s *state) (string, error) {
+	file, err := fetchFile(s.context(), s.tempDir, file)
+	if err != nil {
+		return "", err
+	}
 	rawYamlFile, err := ioutil.ReadFile(file)
 	if err != nil {
-		log.Fatal(err.Error())
+		return "", err
 	}
 
 	yamlFile := string(rawYamlFile)
-	if !flags.noEnvSubst && flags.substEnvValues {
+	if !s.opts.NoEnvSubst && s.opts.SubstEnvValues {
 		yamlFile = substituteEnv(yamlFile)
 	}
-	if !flags.noSSMSubst && flags.substSSMValues {
-		yamlFile = substituteSSM(yamlFile)
+	if !s.opts.NoSSMSubst && s.opts.SubstSSMValues {
+		if yamlFile, err = substituteSSM(yamlFile); err != nil {
+			return "", err
+		}
 	}
 
-	dir, err := ioutil.TempDir(tempFilesDir, "tmp")
+	dir, err := ioutil.TempDir(s.tempDir, "tmp")
 	if err != nil {
-		log.Fatal(err.Error())
+		return "", err
 	}
 
 	// output file contents with env variables substituted into temp files
 	outFile := path.Join(dir, filepath.Base(file))
-	err = ioutil.WriteFile(outFile, []byte(yamlFile), 0644)
-	if err != nil {
-		log.Fatal(err.Error())
+	if err := ioutil.WriteFile(outFile, []byte(yamlFile), 0644); err != nil {
+		return "", err
 	}
-	return outFile
+	return outFile, nil
 }
 
 func stringInSlice(a string, list []string) bool {
@@ -197,57 +224,119 @@ func stringInSlice(a string, list []string) bool {
 }
 
 // resolvePaths resolves relative paths of certs/keys/chart and replace them with a absolute paths
+// If the desired state file itself is remote, relative values/secrets files and certs are resolved against its URL.
 func resolvePaths(relativeToFile string, s *state) {
 	dir := filepath.Dir(relativeToFile)
+	chartsDir := dir
+	if isRemoteFile(relativeToFile) {
+		dir = remoteFileDir(relativeToFile)
+		// local charts can't be fetched, so they stay relative to the current directory
+		chartsDir = "."
+	}
 	for ns, v := range s.Namespaces {
+		resolveSecretPaths(dir, v.Secrets)
 		s.Namespaces[ns] = v
 	}
-	for k, v := range s.Apps {
-		if v.ValuesFile != "" {
-			v.ValuesFile, _ = filepath.Abs(filepath.Join(dir, v.ValuesFile))
-		}
-		if v.SecretsFile != "" {
-			v.SecretsFile, _ = filepath.Abs(filepath.Join(dir, v.SecretsFile))
-		}
-		for i, f := range v.ValuesFiles {
-			v.ValuesFiles[i], _ = filepath.Abs(filepath.Join(dir, f))
-		}
-		for i, f := range v.SecretsFiles {
-			v.SecretsFiles[i], _ = filepath.Abs(filepath.Join(dir, f))
-		}
-
-		if v.Chart != "" {
-			var repoOrDir = filepath.Dir(v.Chart)
-			_, isRepo := s.HelmRepos[repoOrDir]
-			isRepo = isRepo || stringInSlice(repoOrDir, s.PreconfiguredHelmRepos)
-			if !isRepo {
-				// if there is no repo for the chart, we assume it's intended to be a local path
-
-				// support env vars in path
-				v.Chart = os.ExpandEnv(v.Chart)
-				// respect absolute paths to charts but resolve relative paths
-				if !filepath.IsAbs(v.Chart) {
-					v.Chart, _ = filepath.Abs(filepath.Join(dir, v.Chart))
-				}
-			}
-		}
-		s.Apps[k] = v
+	for _, v := range s.getReleaseDefinitions() {
+		resolveReleasePaths(v, dir, chartsDir, s)
 	}
+	resolveHookPaths(dir, s.Hooks)
 	// resolving paths for Bearer Token path in settings
 	if s.Settings.BearerTokenPath != "" {
 		if _, err := url.ParseRequestURI(s.Settings.BearerTokenPath); err != nil {
-			s.Settings.BearerTokenPath, _ = filepath.Abs(filepath.Join(dir, s.Settings.BearerTokenPath))
+			s.Settings.BearerTokenPath = resolvePath(dir, s.Settings.BearerTokenPath)
 		}
 	}
 	// resolving paths for k8s certificate files
 	for k, v := range s.Certificates {
 		if _, err := url.ParseRequestURI(v); err != nil {
-			v, _ = filepath.Abs(filepath.Join(dir, v))
+			v = resolvePath(dir, v)
 		}
 		s.Certificates[k] = v
 	}
 }
 
+// resolveReleasePaths resolves the paths of the files and local chart used by a release relative to the directory of the DSF.
+// Local charts are resolved relative to chartsDir.
+func resolveReleasePaths(v *release, dir string, chartsDir string, s *state) {
+	if v.ValuesFile != "" {
+		v.ValuesFile = resolvePath(dir, v.ValuesFile)
+	}
+	if v.SecretsFile != "" {
+		v.SecretsFile = resolvePath(dir, v.SecretsFile)
+	}
+	for i, f := range v.ValuesFiles {
+		v.ValuesFiles[i] = resolvePath(dir, f)
+	}
+	for i, f := range v.SecretsFiles {
+		v.SecretsFiles[i] = resolvePath(dir, f)
+	}
+	resolveHookPaths(dir, v.Hooks)
+
+	if v.Chart != "" && !isOCIChart(v.Chart) {
+		var repoOrDir = filepath.Dir(v.Chart)
+		_, isRepo := s.HelmRepos[repoOrDir]
+		isRepo = isRepo || stringInSlice(repoOrDir, s.PreconfiguredHelmRepos)
+		if !isRepo {
+			// if there is no repo for the chart, we assume it's intended to be a local path
+
+			// support env vars in path
+			v.Chart = os.ExpandEnv(v.Chart)
+			// respect absolute paths to charts but resolve relative paths
+			if !filepath.IsAbs(v.Chart) {
+				v.Chart, _ = filepath.Abs(filepath.Join(chartsDir, v.Chart))
+			}
+		}
+	}
+}
+
+// resolveHookPaths resolves the paths of the manifests used by hooks relative to the directory of the DSF
+func resolveHookPaths(dir string, hooks map[string]hook) {
+	for hookType, h := range hooks {
+		if h.Manifest != "" {
+			h.Manifest = resolvePath(dir, h.Manifest)
+			hooks[hookType] = h
+		}
+	}
+}
+
+// resolveSecretPaths resolves the paths of the files of namespace secrets relative to the directory of the DSF
+func resolveSecretPaths(dir string, secrets []namespaceSecret) {
+	for i, secret := range secrets {
+		files := make(map[string]string, len(secret.Files))
+		for k, f := range secret.Files {
+			files[k] = resolvePath(dir, f)
+		}
+		secrets[i].Files = files
+	}
+}
+
+// resolvePath resolves a file path defined in a DSF relative to the directory (or URL) of that DSF.
+// Remote file URLs are returned as they are.
+func resolvePath(dir string, file string) string {
+	if isRemoteFile(file) {
+		return file
+	}
+	if isRemoteFile(dir) && !filepath.IsAbs(file) {
+		query := ""
+		if i := strings.Index(dir, "?"); i != -1 {
+			dir, query = dir[:i], dir[i:]
+		}
+		link, checksum := splitChecksum(file)
+		// a file with its own query string does not need the one of the directory
+		if strings.Contains(link, "?") {
+			query = ""
+		}
+		resolved := strings.TrimSuffix(dir, "/") + "/" + strings.TrimPrefix(link, "./") + query
+		if checksum != "" {
+			resolved += checksumPinPrefix + checksum
+		}
+		return resolved
+	}
+	resolved, _ := filepath.Abs(filepath.Join(dir, file))
+	return resolved
+}
+
 // isOfType checks if the file extension of a filename/path is the same as "filetype".
 // isisOfType is case insensitive. filetype should contain the "." e.g. ".yaml"
 func isOfType(filename string, filetypes []string) bool {
@@ -260,13 +349,13 @@ func isOfType(filename string, filetypes []string) bool {
 }
 
 // readFile returns the content of a file as a string.
-// takes a file path as input. It throws an error and breaks the program execution if it fails to read the file.
-func readFile(filepath string) string {
+// takes a file path as input. It returns an error if it fails to read the file.
+func readFile(filepath string) (string, error) {
 	data, err := ioutil.ReadFile(filepath)
 	if err != nil {
-		log.Fatal("failed to read [ " + filepath + " ] file content: " + err.Error())
+		return "", errors.New("failed to read [ " + filepath + " ] file content: " + err.Error())
 	}
-	return string(data)
+	return string(data), nil
 }
 
 // substituteEnv checks if a string has an env variable (contains '$'), then it returns its value
@@ -284,7 +373,7 @@ func substituteEnv(name string) string {
 // substituteSSM checks if a string has an SSM parameter variable (contains '{{ssm: '), then it returns its value
 // if the env variable is empty or unset, an empty string is returned
 // if the string does not contain '$', it is returned as is.
-func substituteSSM(name string) string {
+func substituteSSM(name string) (string, error) {
 	if strings.Contains(name, "{{ssm: ") {
 		re := regexp.MustCompile(`{{ssm: ([^~}]+)(~(true))?}}`)
 		matches := re.FindAllSubmatch([]byte(name), -1)
@@ -295,11 +384,14 @@ func substituteSSM(name string) string {
 			if err != nil {
 				fmt.Printf("Invalid decryption argument %T \n", string(match[3]))
 			}
-			value := aws.ReadSSMParam(paramPath, withDecryption, flags.noColors)
+			value, err := aws.ReadSSMParam(paramPath, withDecryption)
+			if err != nil {
+				return "", err
+			}
 			name = strings.ReplaceAll(name, placeholder, value)
 		}
 	}
-	return name
+	return name, nil
 }
 
 // sliceContains checks if a string slice contains a given string
@@ -312,69 +404,152 @@ func sliceContains(slice []string, s string) bool {
 	return false
 }
 
-// downloadFile downloads a file from GCS or AWS buckets and name it with a given outfile
+// downloadFile downloads a file from a remote storage provider (S3, GCS, Azure or HTTPS) and name it with a given outfile
 // if downloaded, returns the outfile name. If the file path is local file system path, it is copied to current directory.
-func downloadFile(path string, outfile string) string {
-	if strings.HasPrefix(path, "s3") {
+// The download stops when the context is done.
+func downloadFile(ctx context.Context, file string, outfile string) (string, error) {
+	log := loggerFrom(ctx)
+	if isRemoteFile(file) {
+		if err := storage.Download(ctx, file, outfile, storage.DefaultRetry); err != nil {
+			return "", fmt.Errorf("while downloading [ %s ]: %w", file, err)
+		}
+		log.Verbose("Downloaded " + file + " as " + outfile)
+	} else {
+		log.Info("" + outfile + " will be used from local file system.")
+		if err := copyFile(file, outfile); err != nil {
+			return "", err
+		}
+	}
+	return outfile, nil
+}
 
-		tmp := getBucketElements(path)
-		aws.ReadFile(tmp["bucketName"], tmp["filePath"], outfile, flags.noColors)
+// isRemoteFile checks if a file path is a URL of a registered storage provider (S3, GCS, Azure or HTTPS)
+// which needs to be downloaded before use.
+func isRemoteFile(file string) bool {
+	if !strings.Contains(file, "://") {
+		return false
+	}
+	u, err := url.Parse(file)
+	if err != nil {
+		return false
+	}
+	_, ok := storage.Lookup(u.Scheme)
+	return ok
+}
 
-	} else if strings.HasPrefix(path, "gs") {
+// checksumPinPrefix separates a remote file URL from the sha256 checksum its content is pinned to
+const checksumPinPrefix = "#sha256="
 
-		tmp := getBucketElements(path)
-		msg, err := gcs.ReadFile(tmp["bucketName"], tmp["filePath"], outfile, flags.noColors)
-		if err != nil {
-			log.Fatal(msg)
-		}
+// splitChecksum splits a remote file URL from its optional checksum pin.
+// example: in: https://example.com/values.yaml#sha256=<hex>, out: https://example.com/values.yaml, <hex>
+func splitChecksum(link string) (string, string) {
+	if i := strings.LastIndex(link, checksumPinPrefix); i != -1 {
+		return link[:i], strings.ToLower(link[i+len(checksumPinPrefix):])
+	}
+	return link, ""
+}
 
-	} else if strings.HasPrefix(path, "az") {
+// remoteFileName returns the file name of a remote file URL without its checksum pin or query string.
+func remoteFileName(link string) string {
+	link, _ = splitChecksum(link)
+	if u, err := url.Parse(link); err == nil && u.Path != "" {
+		return path.Base(u.Path)
+	}
+	return path.Base(link)
+}
 
-		tmp := getBucketElements(path)
-		azure.ReadFile(tmp["bucketName"], tmp["filePath"], outfile, flags.noColors)
+// remoteFileDir returns the URL of the "directory" containing a remote file.
+// The query string of the file is kept, e.g. the signature of a signed URL, so that the files next to it
+// can be fetched with the same credentials, see resolvePath.
+func remoteFileDir(link string) string {
+	link, _ = splitChecksum(link)
+	query := ""
+	if i := strings.Index(link, "?"); i != -1 {
+		link, query = link[:i], link[i:]
+	}
+	return link[:strings.LastIndex(link, "/")] + query
+}
 
-	} else {
+// fetchFile returns a local path for the given file.
+// Remote files are downloaded into a new directory inside the given temp dir and, if the URL
+// is pinned with a checksum (#sha256=<hex>), verified against it. Local paths are returned as they are.
+func fetchFile(ctx context.Context, tempDir string, file string) (string, error) {
+	if !isRemoteFile(file) {
+		return file, nil
+	}
+	link, checksum := splitChecksum(file)
 
-		log.Info("" + outfile + " will be used from local file system.")
-		copyFile(path, outfile)
+	dir, err := ioutil.TempDir(tempDir, "remote")
+	if err != nil {
+		return "", err
+	}
+	outFile := path.Join(dir, remoteFileName(link))
+	loggerFrom(ctx).Verbose("Downloading [ " + link + " ]")
+	if _, err := downloadFile(ctx, link, outFile); err != nil {
+		return "", err
+	}
+
+	if checksum != "" {
+		if err := verifyChecksum(outFile, checksum); err != nil {
+			return "", fmt.Errorf("while fetching [ %s ]: %w", link, err)
+		}
+	}
+	return outFile, nil
+}
+
+// verifyChecksum checks that the sha256 checksum of a file matches the expected hex digest.
+func verifyChecksum(file string, expected string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if actual := hex.EncodeToString(h.Sum(nil)); actual != expected {
+		return fmt.Errorf("sha256 checksum mismatch: expected %s but got %s", expected, actual)
 	}
-	return outfile
+	return nil
 }
 
 // copyFile copies a file from source to destination
-func copyFile(source string, destination string) {
+func copyFile(source string, destination string) error {
 	from, err := os.Open(source)
 	if err != nil {
-		log.Fatal("while copying " + source + " to " + destination + " : " + err.Error())
+		return errors.New("while copying " + source + " to " + destination + " : " + err.Error())
 	}
 	defer from.Close()
 
 	to, err := os.OpenFile(destination, os.O_RDWR|os.O_CREATE, 0666)
 	if err != nil {
-		log.Fatal("while copying " + source + " to " + destination + " : " + err.Error())
+		return errors.New("while copying " + source + " to " + destination + " : " + err.Error())
 	}
 	defer to.Close()
 
 	_, err = io.Copy(to, from)
 	if err != nil {
-		log.Fatal("while copying " + source + " to " + destination + " : " + err.Error())
+		return errors.New("while copying " + source + " to " + destination + " : " + err.Error())
 	}
+	return nil
 }
 
 // deleteFile deletes a file
-func deleteFile(path string) {
-	log.Info("Cleaning up... deleting " + path)
+func deleteFile(path string) error {
 	if err := os.Remove(path); err != nil {
-		log.Fatal("Could not delete file: " + path)
+		return errors.New("Could not delete file: " + path)
 	}
+	return nil
 }
 
 // notifySlack sends a JSON formatted message to Slack over a webhook url
 // It takes the content of the message (what changes helmsman is going to do or have done separated by \n)
 // and the webhook URL as well as a flag specifying if this is a failure message or not
 // It returns true if the sending of the message is successful, otherwise returns false
-func notifySlack(content string, url string, failure bool, executing bool) bool {
-	log.Info("Posting notifications to Slack ... ")
+func (l *Logger) notifySlack(content string, url string, failure bool, executing bool) bool {
+	l.Info("Posting notifications to Slack ... ")
 
 	color := "#36a64f" // green
 	if failure {
@@ -419,43 +594,29 @@ func notifySlack(content string, url string, failure bool, executing bool) bool
 	}`)
 	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonStr))
 	if err != nil {
-		log.Errorf("Failed to send slack message: %v", err)
+		l.logger().Errorf("Failed to send slack message: %v", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
 
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		log.Errorf("Failed to send notification to slack: %v", err)
+		l.logger().Errorf("Failed to send notification to slack: %v", err)
 	}
 	defer resp.Body.Close()
 
 	return resp.StatusCode == 200
 }
 
-// getBucketElements returns a map containing the bucket name and the file path inside the bucket
-// this func works for S3, Azure and GCS bucket links of the format:
-// s3 or gs://bucketname/dir.../file.ext
-func getBucketElements(link string) map[string]string {
-
-	tmp := strings.SplitAfterN(link, "//", 2)[1]
-	m := make(map[string]string)
-	m["bucketName"] = strings.SplitN(tmp, "/", 2)[0]
-	m["filePath"] = strings.SplitN(tmp, "/", 2)[1]
-	return m
-}
-
 // replaceStringInFile takes a map of keys and values and replaces the keys with values within a given file.
 // It saves the modified content in a new file
-func replaceStringInFile(input []byte, outfile string, replacements map[string]string) {
+func replaceStringInFile(input []byte, outfile string, replacements map[string]string) error {
 	output := input
 	for k, v := range replacements {
 		output = bytes.Replace(output, []byte(k), []byte(v), -1)
 	}
 
-	if err := ioutil.WriteFile(outfile, output, 0666); err != nil {
-		log.Fatal(err.Error())
-	}
+	return ioutil.WriteFile(outfile, output, 0666)
 }
 
 // Indent inserts prefix at the beginning of each non-empty line of s. The
@@ -473,6 +634,16 @@ func Indent(s, prefix string) string {
 	return string(res)
 }
 
+// isOCIChart checks if a chart specified in the DSF is hosted in an OCI registry (oci://registry/path/chart)
+func isOCIChart(chart string) bool {
+	return strings.HasPrefix(chart, "oci://")
+}
+
+// ociRegistryHost returns the registry host of an OCI chart. example: in: oci://registry:5000/path/chart, out: registry:5000
+func ociRegistryHost(chart string) string {
+	return strings.SplitN(strings.TrimPrefix(chart, "oci://"), "/", 2)[0]
+}
+
 // isLocalChart checks if a chart specified in the DSF is a local directory or not
 func isLocalChart(chart string) bool {
 	_, err := os.Stat(chart)
@@ -488,6 +659,25 @@ func concat(slices ...[]string) []string {
 	return slice
 }
 
+// writeTempFile writes data to a new file in the given temp files dir, or in the default temp dir of the system
+// if it is empty. pattern is used as in ioutil.TempFile. It returns the path of the file.
+func writeTempFile(dir string, pattern string, data string) (string, error) {
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return "", err
+		}
+	}
+	f, err := ioutil.TempFile(dir, pattern)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := f.WriteString(data); err != nil {
+		return "", err
+	}
+	return f.Name(), nil
+}
+
 func writeStringToFile(filename string, data string) error {
 	file, err := os.Create(filename)
 	if err != nil {
@@ -502,50 +692,29 @@ func writeStringToFile(filename string, data string) error {
 	return file.Sync()
 }
 
-// decrypt a eyaml or helm secret file
-func decryptSecret(name string) error {
-	cmd := helmBin
-	args := []string{"secrets", "dec", name}
-
-	if settings.EyamlEnabled {
-		cmd = "eyaml"
-		args = []string{"decrypt", "-f", name}
-		if settings.EyamlPrivateKeyPath != "" && settings.EyamlPublicKeyPath != "" {
-			args = append(args, []string{"--pkcs7-private-key", settings.EyamlPrivateKeyPath, "--pkcs7-public-key", settings.EyamlPublicKeyPath}...)
-		}
-	}
-
-	command := command{
-		Cmd:         cmd,
-		Args:        args,
-		Description: "Decrypting " + name,
-	}
-
-	result := command.exec()
-	if !settings.EyamlEnabled {
-		_, fileNotFound := os.Stat(name + ".dec")
-		if fileNotFound != nil && !isOfType(name, []string{".dec"}) {
-			return errors.New(result.errors)
-		}
-	}
+// errorList collects the errors of operations running concurrently
+type errorList struct {
+	sync.Mutex
+	errs []string
+}
 
-	if result.code != 0 {
-		return errors.New(result.errors)
-	} else if result.errors != "" {
-		return errors.New(result.errors)
+// add adds an error to the list, nil errors are ignored
+func (el *errorList) add(err error) {
+	if err == nil {
+		return
 	}
+	el.Lock()
+	defer el.Unlock()
+	el.errs = append(el.errs, err.Error())
+}
 
-	if settings.EyamlEnabled {
-		var outfile string
-		if isOfType(name, []string{".dec"}) {
-			outfile = name
-		} else {
-			outfile = name + ".dec"
-		}
-		err := writeStringToFile(outfile, result.output)
-		if err != nil {
-			log.Fatal("Can't write [ " + outfile + " ] file")
-		}
+// err returns an error made of the collected errors, sorted, or nil if there are none
+func (el *errorList) err() error {
+	el.Lock()
+	defer el.Unlock()
+	if len(el.errs) == 0 {
+		return nil
 	}
-	return nil
+	sort.Strings(el.errs)
+	return errors.New(strings.Join(el.errs, "; "))
 }
@@ -1,11 +1,29 @@
 package app
 
 import (
+	"context"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"reflect"
+	"runtime"
 	"testing"
 )
 
+// fakeTool puts a shell script named after a tool first in the PATH for the duration of a test
+func fakeTool(t *testing.T, name string, script string) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake " + name + " is a shell script")
+	}
+	bin := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(bin, name), []byte("#!/bin/sh\n"+script+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := os.Getenv("PATH")
+	t.Cleanup(func() { os.Setenv("PATH", path) })
+	os.Setenv("PATH", bin+string(os.PathListSeparator)+path)
+}
+
 func Test_fromTOML(t *testing.T) {
 	type args struct {
 		file string
@@ -309,8 +327,8 @@ func Test_readFile(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := readFile(tt.args.filepath); got != tt.want {
-				t.Errorf("readFile() = %v, want %v", got, tt.want)
+			if got, err := readFile(tt.args.filepath); err != nil || got != tt.want {
+				t.Errorf("readFile() = %v, %v, want %v", got, err, tt.want)
 			}
 		})
 	}
@@ -384,24 +402,150 @@ func Test_eyamlSecrets(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			t.Log(tt.want)
-			defaultSettings := settings
-			defer func() { settings = defaultSettings }()
-			settings.EyamlEnabled = tt.args.s.EyamlEnabled
-			settings.EyamlPublicKeyPath = tt.args.s.EyamlPublicKeyPath
-			settings.EyamlPrivateKeyPath = tt.args.s.EyamlPrivateKeyPath
-			err := decryptSecret(tt.args.r.SecretsFile)
+			_, err := newDecryptedSecrets(t.TempDir()).decrypt(context.Background(), tt.args.r.SecretsFile, *tt.args.s)
 			switch err.(type) {
 			case nil:
 				if tt.want != true {
-					t.Errorf("decryptSecret() = %v, want error", err)
+					t.Errorf("decrypt() = %v, want error", err)
 				}
 			case error:
 				if tt.want != false {
-					t.Errorf("decryptSecret() = %v, want nil", err)
+					t.Errorf("decrypt() = %v, want nil", err)
 				}
 			}
-			if _, err := os.Stat(tt.args.r.SecretsFile + ".dec"); err == nil {
-				defer deleteFile(tt.args.r.SecretsFile + ".dec")
+		})
+	}
+}
+
+func Test_splitChecksum(t *testing.T) {
+	tests := []struct {
+		name         string
+		link         string
+		wantLink     string
+		wantChecksum string
+	}{
+		{
+			name:         "test case 1 -- url without checksum",
+			link:         "s3://my-bucket/values.yaml",
+			wantLink:     "s3://my-bucket/values.yaml",
+			wantChecksum: "",
+		}, {
+			name:         "test case 2 -- url with checksum",
+			link:         "https://example.com/values.yaml#sha256=ABCDEF",
+			wantLink:     "https://example.com/values.yaml",
+			wantChecksum: "abcdef",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotLink, gotChecksum := splitChecksum(tt.link)
+			if gotLink != tt.wantLink || gotChecksum != tt.wantChecksum {
+				t.Errorf("splitChecksum() = %v, %v, want %v, %v", gotLink, gotChecksum, tt.wantLink, tt.wantChecksum)
+			}
+		})
+	}
+}
+
+func Test_remoteFileName(t *testing.T) {
+	tests := []struct {
+		name string
+		link string
+		want string
+	}{
+		{
+			name: "test case 1 -- bucket url",
+			link: "gs://my-bucket/dir/values.yaml",
+			want: "values.yaml",
+		}, {
+			name: "test case 2 -- https url with query and checksum",
+			link: "https://example.com/dir/dsf.toml?ref=master#sha256=abcdef",
+			want: "dsf.toml",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := remoteFileName(tt.link); got != tt.want {
+				t.Errorf("remoteFileName() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_resolvePath(t *testing.T) {
+	tests := []struct {
+		name string
+		dir  string
+		file string
+		want string
+	}{
+		{
+			name: "test case 1 -- remote file is kept as is",
+			dir:  "/tmp",
+			file: "az://container/values.yaml",
+			want: "az://container/values.yaml",
+		}, {
+			name: "test case 2 -- relative file in a remote dsf",
+			dir:  remoteFileDir("s3://my-bucket/envs/dsf.yaml#sha256=abcdef"),
+			file: "./values/app.yaml",
+			want: "s3://my-bucket/envs/values/app.yaml",
+		}, {
+			name: "test case 3 -- relative file in a remote dsf with a signed URL",
+			dir:  remoteFileDir("https://example.blob.core.windows.net/envs/dsf.yaml?sv=2020-02-10&sig=abc%3D"),
+			file: "values/app.yaml",
+			want: "https://example.blob.core.windows.net/envs/values/app.yaml?sv=2020-02-10&sig=abc%3D",
+		}, {
+			name: "test case 4 -- pinned relative file in a remote dsf with a signed URL",
+			dir:  remoteFileDir("https://example.com/envs/dsf.yaml?token=abc"),
+			file: "./values/app.yaml#sha256=abcdef",
+			want: "https://example.com/envs/values/app.yaml?token=abc#sha256=abcdef",
+		}, {
+			name: "test case 5 -- relative file with its own query string",
+			dir:  remoteFileDir("https://example.com/envs/dsf.yaml?token=abc"),
+			file: "values/app.yaml?token=def",
+			want: "https://example.com/envs/values/app.yaml?token=def",
+		}, {
+			name: "test case 6 -- relative file in a local dsf",
+			dir:  "/tmp/envs",
+			file: "values/app.yaml",
+			want: "/tmp/envs/values/app.yaml",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := resolvePath(tt.dir, tt.file); got != tt.want {
+				t.Errorf("resolvePath() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_verifyChecksum(t *testing.T) {
+	file := "../../tests/values.yaml"
+	// sha256 of an empty file
+	if err := verifyChecksum(file, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"); err != nil {
+		t.Errorf("verifyChecksum() = %v, want nil", err)
+	}
+	if err := verifyChecksum(file, "abcdef"); err == nil {
+		t.Errorf("verifyChecksum() = nil, want error")
+	}
+}
+
+func Test_isRemoteFile(t *testing.T) {
+	tests := []struct {
+		file string
+		want bool
+	}{
+		{file: "s3://my-bucket/values.yaml", want: true},
+		{file: "gs://my-bucket/values.yaml", want: true},
+		{file: "az://my-container/values.yaml", want: true},
+		{file: "https://example.com/values.yaml", want: true},
+		{file: "ftp://example.com/values.yaml", want: false},
+		{file: "../../tests/values.yaml", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			if got := isRemoteFile(tt.file); got != tt.want {
+				t.Errorf("isRemoteFile() = %v, want %v", got, tt.want)
 			}
 		})
 	}
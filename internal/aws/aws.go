@@ -1,20 +1,22 @@
 package aws
 
 import (
+	"context"
+	"fmt"
+	"io"
 	"log"
+	"net/url"
 	"os"
+	"strings"
 
+	"github.com/Praqma/helmsman/internal/storage"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/aws/aws-sdk-go/service/ssm"
-	"github.com/logrusorgru/aurora"
 )
 
-// colorizer
-var style aurora.Aurora
-
 func checkCredentialsEnvVar() bool {
 
 	if os.Getenv("AWS_ACCESS_KEY_ID") == "" || os.Getenv("AWS_SECRET_ACCESS_KEY") == "" {
@@ -32,47 +34,59 @@ func checkCredentialsEnvVar() bool {
 	return true
 }
 
-// ReadFile reads a file from S3 bucket and saves it in a desired location.
-func ReadFile(bucketName string, filename string, outFile string, noColors bool) {
-	style = aurora.NewAurora(!noColors)
+// Fetcher downloads objects from S3 buckets.
+// The AWS_S3_ENDPOINT env variable can point it to an S3 compatible endpoint instead, e.g. a local MinIO server.
+type Fetcher struct {
+	// Endpoint overrides the S3 endpoint. Path style bucket addressing is used when it is set.
+	Endpoint string
+}
+
+func init() {
+	storage.Register("s3", &Fetcher{})
+}
+
+// Fetch downloads the object at s3://bucket/key and writes it to w
+func (f *Fetcher) Fetch(ctx context.Context, u *url.URL, w io.Writer) error {
 	// Checking env vars are set to configure AWS
 	if !checkCredentialsEnvVar() {
 		log.Println("WARN: Failed to find the AWS env vars needed to configure AWS. Please make sure they are set in the environment.")
 	}
 
-	// Create Session -- use config (credentials + region) from env vars or aws profile
-	sess, err := session.NewSession()
-
-	if err != nil {
-		log.Fatal(style.Bold(style.Red("ERROR: Can't create AWS session: " + err.Error())))
+	cfg := aws.NewConfig()
+	endpoint := f.Endpoint
+	if endpoint == "" {
+		endpoint = os.Getenv("AWS_S3_ENDPOINT")
+	}
+	if endpoint != "" {
+		cfg = cfg.WithEndpoint(endpoint).WithS3ForcePathStyle(true)
 	}
-	// create S3 download manger
-	downloader := s3manager.NewDownloader(sess)
 
-	file, err := os.Create(outFile)
+	// Create Session -- use config (credentials + region) from env vars or aws profile
+	sess, err := session.NewSession(cfg)
 	if err != nil {
-		log.Fatal(style.Bold(style.Red("ERROR: Failed to open file " + outFile + ": " + err.Error())))
+		return storage.Permanent(fmt.Errorf("can't create AWS session: %w", err))
 	}
 
-	defer file.Close()
-
-	_, err = downloader.Download(file,
-		&s3.GetObjectInput{
-			Bucket: aws.String(bucketName),
-			Key:    aws.String(filename),
-		})
+	obj, err := s3.New(sess).GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(u.Host),
+		Key:    aws.String(strings.TrimPrefix(u.Path, "/")),
+	})
 	if err != nil {
-		log.Fatal(style.Bold(style.Red("ERROR: Failed to download file  " + filename + " from S3: " + err.Error())))
+		if aerr, ok := err.(awserr.Error); ok && (aerr.Code() == s3.ErrCodeNoSuchKey || aerr.Code() == s3.ErrCodeNoSuchBucket) {
+			return storage.Permanent(fmt.Errorf("failed to download %s from S3: %w", u, err))
+		}
+		return fmt.Errorf("failed to download %s from S3: %w", u, err)
 	}
+	defer obj.Body.Close()
 
-	log.Println("Successfully downloaded " + filename + " from S3 as " + outFile)
-
+	if _, err := io.Copy(w, obj.Body); err != nil {
+		return fmt.Errorf("failed to download %s from S3: %w", u, err)
+	}
+	return nil
 }
 
 // ReadSSMParam reads a value from an SSM Parameter
-func ReadSSMParam(keyname string, withDecryption bool, noColors bool) string {
-	style = aurora.NewAurora(!noColors)
-
+func ReadSSMParam(keyname string, withDecryption bool) (string, error) {
 	// Checking env vars are set to configure AWS
 	if !checkCredentialsEnvVar() {
 		log.Println("WARN: Failed to find the AWS env vars needed to configure AWS. Please make sure they are set in the environment.")
@@ -82,7 +96,7 @@ func ReadSSMParam(keyname string, withDecryption bool, noColors bool) string {
 	sess, err := session.NewSession()
 
 	if err != nil {
-		log.Fatal(style.Bold(style.Red("ERROR: Can't create AWS session: " + err.Error())))
+		return "", fmt.Errorf("can't create AWS session: %w", err)
 	}
 
 	ssmsvc := ssm.New(sess, aws.NewConfig())
@@ -92,9 +106,8 @@ func ReadSSMParam(keyname string, withDecryption bool, noColors bool) string {
 	})
 
 	if err != nil {
-		log.Fatal(style.Bold(style.Red("ERROR: Can't find the SSM Parameter " + keyname + " : " + err.Error())))
+		return "", fmt.Errorf("can't find the SSM Parameter %s: %w", keyname, err)
 	}
 
-	value := *param.Parameter.Value
-	return value
+	return *param.Parameter.Value, nil
 }
@@ -1,83 +1,80 @@
 package azure
 
 import (
-	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"net/http"
 	"net/url"
 	"os"
+	"strings"
 
 	"github.com/Azure/azure-pipeline-go/pipeline"
 	"github.com/Azure/azure-storage-blob-go/azblob"
-	"github.com/logrusorgru/aurora"
+	"github.com/Praqma/helmsman/internal/storage"
 )
 
-// colorizer
-var style aurora.Aurora
-var accountName string
-var accountKey string
-var p pipeline.Pipeline
-
-// auth checks for AZURE_STORAGE_ACCOUNT and AZURE_STORAGE_ACCESS_KEY in the environment
-// if env vars are set, it will authenticate and create an azblob request pipeline
-// returns false and error message if credentials are not set or are invalid
-func auth() (bool, string) {
-	accountName, accountKey = os.Getenv("AZURE_STORAGE_ACCOUNT"), os.Getenv("AZURE_STORAGE_ACCESS_KEY")
-	if len(accountName) != 0 && len(accountKey) != 0 {
-		log.Println("AZURE_STORAGE_ACCOUNT and AZURE_STORAGE_ACCESS_KEY are set in the environment. They will be used to connect to Azure storage.")
-		// Create a default request pipeline
-		credential, err := azblob.NewSharedKeyCredential(accountName, accountKey)
-		if err == nil {
-			p = azblob.NewPipeline(credential, azblob.PipelineOptions{})
-			return true, ""
-		}
-		return false, err.Error()
+// Fetcher downloads blobs from Azure storage containers.
+// The AZURE_STORAGE_ENDPOINT env variable can point it to another blob service endpoint instead,
+// e.g. http://127.0.0.1:10000/devstoreaccount1 for a local Azurite server.
+type Fetcher struct {
+	// Endpoint overrides the blob service URL of the storage account.
+	Endpoint string
+}
 
-	}
-	return false, "either the AZURE_STORAGE_ACCOUNT or AZURE_STORAGE_ACCESS_KEY environment variable is not set"
+func init() {
+	storage.Register("az", &Fetcher{})
 }
 
-// ReadFile reads a file from storage container and saves it in a desired location.
-func ReadFile(containerName string, filename string, outFile string, noColors bool) {
-	style = aurora.NewAurora(!noColors)
-	if ok, err := auth(); !ok {
-		log.Fatal(style.Bold(style.Red("ERROR: " + err)))
+// auth checks for AZURE_STORAGE_ACCOUNT and AZURE_STORAGE_ACCESS_KEY in the environment
+// if env vars are set, it will authenticate and return the account name and an azblob request pipeline
+// returns an error if credentials are not set or are invalid
+func auth() (string, pipeline.Pipeline, error) {
+	accountName, accountKey := os.Getenv("AZURE_STORAGE_ACCOUNT"), os.Getenv("AZURE_STORAGE_ACCESS_KEY")
+	if len(accountName) == 0 || len(accountKey) == 0 {
+		return "", nil, errors.New("either the AZURE_STORAGE_ACCOUNT or AZURE_STORAGE_ACCESS_KEY environment variable is not set")
 	}
+	// Create a default request pipeline
+	credential, err := azblob.NewSharedKeyCredential(accountName, accountKey)
+	if err != nil {
+		return "", nil, err
+	}
+	return accountName, azblob.NewPipeline(credential, azblob.PipelineOptions{}), nil
+}
 
-	URL, _ := url.Parse(
-		fmt.Sprintf("https://%s.blob.core.windows.net/%s", accountName, containerName))
-
-	containerURL := azblob.NewContainerURL(*URL, p)
-
-	ctx := context.Background()
-
-	blobURL := containerURL.NewBlockBlobURL(filename)
-	downloadResponse, err := blobURL.Download(ctx, 0, azblob.CountToEnd, azblob.BlobAccessConditions{}, false)
+// Fetch downloads the blob at az://container/blob and writes it to w
+func (f *Fetcher) Fetch(ctx context.Context, u *url.URL, w io.Writer) error {
+	accountName, p, err := auth()
 	if err != nil {
-		log.Fatal(style.Bold(style.Red("ERROR: failed to download file " + filename + " with error: " + err.Error())))
+		return storage.Permanent(err)
 	}
-	bodyStream := downloadResponse.Body(azblob.RetryReaderOptions{MaxRetryRequests: 20})
 
-	// read the body into a buffer
-	downloadedData := bytes.Buffer{}
-	if _, err = downloadedData.ReadFrom(bodyStream); err != nil {
-		log.Fatal(style.Bold(style.Red("ERROR: failed to download file " + filename + " with error: " + err.Error())))
+	endpoint := f.Endpoint
+	if endpoint == "" {
+		endpoint = os.Getenv("AZURE_STORAGE_ENDPOINT")
+	}
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.blob.core.windows.net", accountName)
+	}
+	containerURL, err := url.Parse(strings.TrimSuffix(endpoint, "/") + "/" + u.Host)
+	if err != nil {
+		return storage.Permanent(fmt.Errorf("invalid Azure storage endpoint: %w", err))
 	}
 
-	// create output file and write to it
-	var writers []io.Writer
-	file, err := os.Create(outFile)
+	blobURL := azblob.NewContainerURL(*containerURL, p).NewBlockBlobURL(strings.TrimPrefix(u.Path, "/"))
+	downloadResponse, err := blobURL.Download(ctx, 0, azblob.CountToEnd, azblob.BlobAccessConditions{}, false)
 	if err != nil {
-		log.Fatal(style.Bold(style.Red("ERROR: Failed to create an output file: " + err.Error())))
+		if serr, ok := err.(azblob.StorageError); ok && serr.Response() != nil && serr.Response().StatusCode == http.StatusNotFound {
+			return storage.Permanent(fmt.Errorf("failed to download %s from Azure storage: %w", u, err))
+		}
+		return fmt.Errorf("failed to download %s from Azure storage: %w", u, err)
 	}
-	writers = append(writers, file)
-	defer file.Close()
+	bodyStream := downloadResponse.Body(azblob.RetryReaderOptions{MaxRetryRequests: 20})
+	defer bodyStream.Close()
 
-	dest := io.MultiWriter(writers...)
-	if _, err := downloadedData.WriteTo(dest); err != nil {
-		log.Fatal(style.Bold(style.Red("ERROR: Failed to read object content: " + err.Error())))
+	if _, err := io.Copy(w, bodyStream); err != nil {
+		return fmt.Errorf("failed to download %s from Azure storage: %w", u, err)
 	}
-	log.Println("INFO: Successfully downloaded " + filename + " from Azure storage as " + outFile)
+	return nil
 }
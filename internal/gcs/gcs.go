@@ -1,20 +1,20 @@
 package gcs
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/url"
 	"os"
+	"strings"
 
-	// Imports the Google Cloud Storage client package.
-	"cloud.google.com/go/storage"
-	"github.com/logrusorgru/aurora"
-	netContext "golang.org/x/net/context"
+	"github.com/Praqma/helmsman/internal/storage"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	gstorage "google.golang.org/api/storage/v1"
 )
 
-// colorizer
-var style aurora.Aurora
-
 // Auth checks for GCLOUD_CREDENTIALS in the environment
 // returns true if they exist and creates a json credentials file and sets the GOOGLE_APPLICATION_CREDENTIALS env var
 // returns false if credentials are not found
@@ -39,42 +39,47 @@ func Auth() (string, error) {
 	return "can't authenticate", fmt.Errorf("can't authenticate")
 }
 
-// ReadFile reads a file from storage bucket and saves it in a desired location.
-func ReadFile(bucketName string, filename string, outFile string, noColors bool) (string, error) {
-	style = aurora.NewAurora(!noColors)
-	if msg, err := Auth(); err != nil {
-		return msg, nil
-	}
+// Fetcher downloads objects from GCS buckets.
+// The STORAGE_EMULATOR_HOST env variable can point it to a GCS emulator instead, e.g. http://localhost:4443
+type Fetcher struct {
+	// Endpoint overrides the GCS endpoint. No authentication is done when it is set.
+	Endpoint string
+}
 
-	ctx := netContext.Background()
-	client, err := storage.NewClient(ctx)
-	if err != nil {
-		return "Failed to configure Storage bucket: ", err
-	}
-	storageBucket := client.Bucket(bucketName)
+func init() {
+	storage.Register("gs", &Fetcher{})
+}
 
-	// Creates an Object handler for our file
-	obj := storageBucket.Object(filename)
+// Fetch downloads the object at gs://bucket/object and writes it to w
+func (f *Fetcher) Fetch(ctx context.Context, u *url.URL, w io.Writer) error {
+	var opts []option.ClientOption
+	endpoint := f.Endpoint
+	if endpoint == "" {
+		endpoint = os.Getenv("STORAGE_EMULATOR_HOST")
+	}
+	if endpoint != "" {
+		opts = append(opts, option.WithEndpoint(strings.TrimSuffix(endpoint, "/")+"/storage/v1/"), option.WithoutAuthentication())
+	} else {
+		// failed auth is not an error here, the default application credentials may still be available
+		_, _ = Auth()
+	}
 
-	// Read the object.
-	r, err := obj.NewReader(ctx)
+	svc, err := gstorage.NewService(ctx, opts...)
 	if err != nil {
-		return fmt.Sprintf("Failed to create object reader: %s", err), err
+		return storage.Permanent(fmt.Errorf("failed to configure storage client: %w", err))
 	}
-	defer r.Close()
 
-	// create output file and write to it
-	var writers []io.Writer
-	file, err := os.Create(outFile)
+	resp, err := svc.Objects.Get(u.Host, strings.TrimPrefix(u.Path, "/")).Context(ctx).Download()
 	if err != nil {
-		return fmt.Sprintf("Failed to create an output file: %s", err), err
+		if gerr, ok := err.(*googleapi.Error); ok && gerr.Code >= 400 && gerr.Code < 500 && gerr.Code != 429 {
+			return storage.Permanent(fmt.Errorf("failed to download %s from GCS: %w", u, err))
+		}
+		return fmt.Errorf("failed to download %s from GCS: %w", u, err)
 	}
-	writers = append(writers, file)
-	defer file.Close()
+	defer resp.Body.Close()
 
-	dest := io.MultiWriter(writers...)
-	if _, err := io.Copy(dest, r); err != nil {
-		return fmt.Sprintf("Failed to read object content: %s", err), err
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return fmt.Errorf("failed to read object content: %w", err)
 	}
-	return "Successfully downloaded " + filename + " from GCS as " + outFile, nil
+	return nil
 }
@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// HTTPFetcher downloads files from HTTP(S) servers
+type HTTPFetcher struct {
+	// Client is the HTTP client used for requests. http.DefaultClient is used if it is nil.
+	Client *http.Client
+}
+
+func init() {
+	Register("https", &HTTPFetcher{})
+}
+
+// Fetch downloads the file at u. Client errors other than 429 (too many requests) are not retried.
+func (f *HTTPFetcher) Fetch(ctx context.Context, u *url.URL, w io.Writer) error {
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return Permanent(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("server returned %s", resp.Status)
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			return Permanent(err)
+		}
+		return err
+	}
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
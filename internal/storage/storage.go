@@ -0,0 +1,111 @@
+// Package storage provides a common way to download files from remote storage providers.
+// Providers implement the Fetcher interface and register it for the URL scheme they handle.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// Fetcher reads the object a URL points to and writes its content to w.
+type Fetcher interface {
+	Fetch(ctx context.Context, u *url.URL, w io.Writer) error
+}
+
+// Retry describes how many times a failed download is attempted and how long to wait between attempts.
+// The wait grows linearly with the number of failed attempts.
+type Retry struct {
+	Attempts int
+	Backoff  time.Duration
+}
+
+// DefaultRetry is the retry policy used for downloads unless another one is given
+var DefaultRetry = Retry{Attempts: 3, Backoff: 2 * time.Second}
+
+var (
+	mu       sync.RWMutex
+	fetchers = map[string]Fetcher{}
+)
+
+// Register makes a Fetcher available for URLs with the given scheme, e.g. "s3".
+// Registering a scheme twice replaces the previous Fetcher.
+func Register(scheme string, f Fetcher) {
+	mu.Lock()
+	defer mu.Unlock()
+	fetchers[scheme] = f
+}
+
+// Lookup returns the Fetcher registered for a URL scheme
+func Lookup(scheme string) (Fetcher, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	f, ok := fetchers[scheme]
+	return f, ok
+}
+
+// permanentError wraps errors which will not go away by retrying, e.g. a missing object
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks an error returned by a Fetcher as not retryable
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// IsPermanent checks if an error was marked as not retryable
+func IsPermanent(err error) bool {
+	var p *permanentError
+	return errors.As(err, &p)
+}
+
+// Download fetches the file a URL points to, using the Fetcher registered for its scheme, and saves it as outFile.
+// Failed attempts are retried according to retry until the attempts are exhausted,
+// the error is permanent or ctx is cancelled.
+func Download(ctx context.Context, link string, outFile string, retry Retry) error {
+	u, err := url.Parse(link)
+	if err != nil {
+		return err
+	}
+	f, ok := Lookup(u.Scheme)
+	if !ok {
+		return fmt.Errorf("no storage provider is registered for %s:// URLs", u.Scheme)
+	}
+
+	for attempt := 1; ; attempt++ {
+		err = fetchToFile(ctx, f, u, outFile)
+		if err == nil || IsPermanent(err) || attempt >= retry.Attempts {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(retry.Backoff * time.Duration(attempt)):
+		}
+	}
+}
+
+// fetchToFile runs a single download attempt, truncating any content left from a previous attempt
+func fetchToFile(ctx context.Context, f Fetcher, u *url.URL, outFile string) error {
+	file, err := os.Create(outFile)
+	if err != nil {
+		return Permanent(fmt.Errorf("failed to create output file: %w", err))
+	}
+	defer file.Close()
+
+	return f.Fetch(ctx, u, file)
+}
@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// flakyFetcher fails a given number of times before it succeeds
+type flakyFetcher struct {
+	failures int
+	err      error
+	calls    int
+}
+
+func (f *flakyFetcher) Fetch(ctx context.Context, u *url.URL, w io.Writer) error {
+	f.calls++
+	if f.calls <= f.failures {
+		return f.err
+	}
+	_, err := io.WriteString(w, "content of "+u.Path)
+	return err
+}
+
+// tempDir creates a directory for downloaded test files
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func Test_Download(t *testing.T) {
+	noWait := Retry{Attempts: 3}
+	tests := []struct {
+		name      string
+		fetcher   *flakyFetcher
+		retry     Retry
+		wantErr   bool
+		wantCalls int
+	}{
+		{
+			name:      "test case 1 -- succeeds at first attempt",
+			fetcher:   &flakyFetcher{},
+			retry:     noWait,
+			wantErr:   false,
+			wantCalls: 1,
+		}, {
+			name:      "test case 2 -- transient errors are retried",
+			fetcher:   &flakyFetcher{failures: 2, err: errors.New("timeout")},
+			retry:     noWait,
+			wantErr:   false,
+			wantCalls: 3,
+		}, {
+			name:      "test case 3 -- gives up after all attempts",
+			fetcher:   &flakyFetcher{failures: 5, err: errors.New("timeout")},
+			retry:     noWait,
+			wantErr:   true,
+			wantCalls: 3,
+		}, {
+			name:      "test case 4 -- permanent errors are not retried",
+			fetcher:   &flakyFetcher{failures: 5, err: Permanent(errors.New("not found"))},
+			retry:     noWait,
+			wantErr:   true,
+			wantCalls: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Register("test", tt.fetcher)
+			dir := tempDir(t)
+			defer os.RemoveAll(dir)
+			outFile := filepath.Join(dir, "out.yaml")
+			err := Download(context.Background(), "test://bucket/dir/values.yaml", outFile, tt.retry)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Download() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.fetcher.calls != tt.wantCalls {
+				t.Errorf("Download() attempts = %d, want %d", tt.fetcher.calls, tt.wantCalls)
+			}
+			if !tt.wantErr {
+				if got, _ := ioutil.ReadFile(outFile); string(got) != "content of /dir/values.yaml" {
+					t.Errorf("Download() content = %q", got)
+				}
+			}
+		})
+	}
+}
+
+func Test_Download_unknownScheme(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	if err := Download(context.Background(), "ftp://host/file.yaml", filepath.Join(dir, "out"), DefaultRetry); err == nil {
+		t.Errorf("Download() = nil, want error for unregistered scheme")
+	}
+}
+
+func Test_Download_cancelled(t *testing.T) {
+	Register("test", &flakyFetcher{failures: 5, err: errors.New("timeout")})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	err := Download(ctx, "test://bucket/file.yaml", filepath.Join(dir, "out"), Retry{Attempts: 3, Backoff: time.Hour})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Download() = %v, want %v", err, context.Canceled)
+	}
+}
+
+func Test_HTTPFetcher(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/values.yaml" {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, "replicas: 2")
+	}))
+	defer server.Close()
+	Register("http", &HTTPFetcher{Client: server.Client()})
+
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	outFile := filepath.Join(dir, "values.yaml")
+	if err := Download(context.Background(), server.URL+"/values.yaml", outFile, DefaultRetry); err != nil {
+		t.Fatalf("Download() = %v, want nil", err)
+	}
+	if got, _ := ioutil.ReadFile(outFile); string(got) != "replicas: 2" {
+		t.Errorf("Download() content = %q, want %q", got, "replicas: 2")
+	}
+
+	err := Download(context.Background(), server.URL+"/missing.yaml", outFile, DefaultRetry)
+	if err == nil || !IsPermanent(err) {
+		t.Errorf("Download() = %v, want a permanent error", err)
+	}
+}
@@ -0,0 +1,45 @@
+// Package helmsman makes Helmsman usable as a library.
+//
+// An Engine loads the desired state files given in its options, reads the current state of the targeted cluster,
+// makes the plan bringing the cluster to the desired state and applies it:
+//
+//	e, err := helmsman.New(ctx, opts)
+//	s, err := e.LoadState()
+//	cs, err := e.BuildCurrentState(s)
+//	p, err := e.MakePlan(s, cs)
+//	err = e.Apply(p)
+//
+// Desired states defining several clusters are handled one cluster at a time, with ForCluster.
+package helmsman
+
+import (
+	"context"
+
+	"github.com/Praqma/helmsman/internal/app"
+)
+
+type (
+	// Engine makes and applies the plans bringing a cluster to a desired state
+	Engine = app.Engine
+	// Options holds the settings of a run which are not part of the desired state files
+	Options = app.Options
+	// State is a desired state read from desired state files
+	State = app.State
+	// CurrentState holds the releases currently deployed to a cluster
+	CurrentState = app.CurrentState
+	// Plan holds the decisions made to bring a cluster to a desired state, and the commands carrying them out
+	Plan = app.Plan
+	// Decision is a change, or the absence of one, planned for an app or a release
+	Decision = app.Decision
+)
+
+// DefaultOptions returns the options of a run of the helmsman command without any flag
+func DefaultOptions() Options {
+	return app.DefaultOptions()
+}
+
+// New returns an engine using the given options.
+// It fails if the options can't be used together or if helm, kubectl or the helm diff plugin are missing.
+func New(ctx context.Context, opts Options) (*Engine, error) {
+	return app.NewEngine(ctx, opts)
+}
@@ -11,6 +11,9 @@ mv /tmp/linux-amd64/helm /usr/local/bin/helm
 rm -rf /tmp/linux-amd64
 chmod +x /usr/local/bin/helm
 
+curl -L https://github.com/mozilla/sops/releases/download/${SOPS_VERSION}/sops-${SOPS_VERSION}.linux -o /usr/local/bin/sops
+chmod +x /usr/local/bin/sops
+
 mkdir -p ~/.helm/plugins
 helm plugin install https://github.com/hypnoglow/helm-s3.git
 helm plugin install https://github.com/nouney/helm-gcs